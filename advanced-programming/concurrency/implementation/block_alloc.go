@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// blockAllocatingIdService hands out ids from blocks fetched from an
+// external allocator, such as a database sequence, so that only one id
+// per block costs a round trip. Ids are unique as long as the
+// allocator's blocks are disjoint, and increasing as long as each block
+// starts after the last.
+//
+// Once the current block runs low the next one is fetched in the
+// background, so with a large enough watermark getNext only blocks if
+// ids are drawn faster than the allocator can keep up.
+type blockAllocatingIdService struct {
+	sync.Mutex
+	fetchBlock   func() (start, end uint64, err error)
+	lowWatermark uint64
+	next, end    uint64        // the unissued part of the current block
+	fetched      *block        // the result of the last fetch, if unused
+	fetching     chan struct{} // closed when the fetch in progress ends
+}
+
+type block struct {
+	start, end uint64
+	err        error
+}
+
+// NewBlockAllocatingIdService returns a service that draws blocks of
+// ids from fetchBlock, each covering start up to but not including
+// end, and fetches the next block once lowWatermark or fewer ids are
+// left in the current one.
+func NewBlockAllocatingIdService(fetchBlock func() (start, end uint64, err error), lowWatermark uint64) *blockAllocatingIdService {
+	return &blockAllocatingIdService{fetchBlock: fetchBlock, lowWatermark: lowWatermark}
+}
+
+// getNext panics if a block can't be fetched; use tryGetNext to handle
+// the error instead.
+func (s *blockAllocatingIdService) getNext() uint64 {
+	id, err := s.tryGetNext()
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// tryGetNext is like getNext, but returns the error if the current block
+// is used up and fetching the next one failed. The following call tries
+// fetching again.
+func (s *blockAllocatingIdService) tryGetNext() (uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+	for s.next == s.end {
+		if s.fetched == nil {
+			if s.fetching == nil {
+				s.startFetch()
+			}
+			done := s.fetching
+			s.Unlock()
+			<-done
+			s.Lock()
+			continue
+		}
+		b := s.fetched
+		s.fetched = nil
+		if b.err != nil {
+			return 0, fmt.Errorf("fetching id block: %w", b.err)
+		}
+		if b.start >= b.end {
+			return 0, fmt.Errorf("fetching id block: allocator returned empty block [%d, %d)", b.start, b.end)
+		}
+		s.next, s.end = b.start, b.end
+	}
+
+	id := s.next
+	s.next++
+	if s.end-s.next <= s.lowWatermark && s.fetched == nil && s.fetching == nil {
+		s.startFetch()
+	}
+	return id, nil
+}
+
+// Fetch a block in the background. The lock must be held.
+func (s *blockAllocatingIdService) startFetch() {
+	done := make(chan struct{})
+	s.fetching = done
+	go func() {
+		start, end, err := s.fetchBlock()
+		s.Lock()
+		defer s.Unlock()
+		s.fetched = &block{start, end, err}
+		s.fetching = nil
+		close(done)
+	}()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// An allocator handing out successive blocks of the given size,
+// starting from 1
+type stubAllocator struct {
+	sync.Mutex
+	size, next uint64
+	calls      int
+	fail       map[int]bool // calls that should fail
+}
+
+func (a *stubAllocator) fetchBlock() (uint64, uint64, error) {
+	a.Lock()
+	defer a.Unlock()
+	a.calls++
+	if a.fail[a.calls] {
+		return 0, 0, errors.New("allocator unavailable")
+	}
+	if a.next == 0 {
+		a.next = 1
+	}
+	start := a.next
+	a.next += a.size
+	return start, a.next, nil
+}
+
+func TestBlockAllocatingIdService(t *testing.T) {
+	allocator := &stubAllocator{size: 10}
+	service := NewBlockAllocatingIdService(allocator.fetchBlock, 3)
+
+	// Blocks are contiguous, so the ids should be too
+	for want := uint64(1); want <= 95; want++ {
+		if id := service.getNext(); id != want {
+			t.Fatalf("Expected id %d, got %d", want, id)
+		}
+	}
+	if allocator.calls < 10 {
+		t.Fatalf("Expected at least 10 blocks fetched, got %d", allocator.calls)
+	}
+
+	RunService(t, NewBlockAllocatingIdService((&stubAllocator{size: 64}).fetchBlock, 16), 10, 1000)
+}
+
+func TestBlockAllocatingIdServiceFetchError(t *testing.T) {
+	allocator := &stubAllocator{size: 5, fail: map[int]bool{2: true}}
+	service := NewBlockAllocatingIdService(allocator.fetchBlock, 1)
+
+	var ids []uint64
+	var err error
+	for len(ids) < 10 {
+		var id uint64
+		if id, err = service.tryGetNext(); err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if err == nil || len(ids) != 5 {
+		t.Fatalf("Expected the failed fetch to surface after the first block, got %d ids and %v", len(ids), err)
+	}
+
+	// the next call fetches again, and issuance carries on
+	id, err := service.tryGetNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 6 {
+		t.Fatalf("Expected issuance to resume at 6, got %d", id)
+	}
+}
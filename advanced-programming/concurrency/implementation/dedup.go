@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// A BloomFilter is a probabilistic set of ids. It never forgets an id
+// that was added, but may claim to contain ids that never were.
+type BloomFilter struct {
+	words  []uint64
+	hashes uint32
+}
+
+// NewBloomFilter returns an empty filter of the given number of bits
+// (rounded up to a multiple of 64), setting hashes bits per id.
+func NewBloomFilter(bits uint64, hashes uint32) *BloomFilter {
+	if bits == 0 {
+		bits = 64
+	}
+	if hashes == 0 {
+		hashes = 1
+	}
+	return &BloomFilter{words: make([]uint64, (bits+63)/64), hashes: hashes}
+}
+
+// Derive the filter's bit positions for an id by double hashing two
+// rounds of the splitmix64 finalizer
+func (f *BloomFilter) positions(id uint64, fn func(word int, mask uint64) bool) bool {
+	h1 := mix(id)
+	h2 := mix(h1) | 1
+	bits := uint64(len(f.words)) * 64
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		bit := (h1 + i*h2) % bits
+		if !fn(int(bit/64), 1<<(bit%64)) {
+			return false
+		}
+	}
+	return true
+}
+
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// Add records id in the filter.
+func (f *BloomFilter) Add(id uint64) {
+	f.positions(id, func(word int, mask uint64) bool {
+		f.words[word] |= mask
+		return true
+	})
+}
+
+// MayContain reports whether id might have been added. A false result
+// is certain; a true one may be a false positive.
+func (f *BloomFilter) MayContain(id uint64) bool {
+	return f.positions(id, func(word int, mask uint64) bool {
+		return f.words[word]&mask != 0
+	})
+}
+
+// Save writes the filter to w in a form LoadBloomFilter can read.
+func (f *BloomFilter) Save(w io.Writer) error {
+	header := []uint64{uint64(f.hashes), uint64(len(f.words))}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, f.words)
+}
+
+// LoadBloomFilter reads a filter written by Save.
+func LoadBloomFilter(r io.Reader) (*BloomFilter, error) {
+	header := make([]uint64, 2)
+	if err := binary.Read(r, binary.LittleEndian, header); err != nil {
+		return nil, fmt.Errorf("reading bloom filter header: %w", err)
+	}
+	if header[0] == 0 || header[0] > 64 || header[1] == 0 || header[1] > 1<<30 {
+		return nil, fmt.Errorf("corrupt bloom filter header %v", header)
+	}
+	f := &BloomFilter{words: make([]uint64, header[1]), hashes: uint32(header[0])}
+	if err := binary.Read(r, binary.LittleEndian, f.words); err != nil {
+		return nil, fmt.Errorf("reading bloom filter: %w", err)
+	}
+	return f, nil
+}
+
+// dedupingIdService refuses to hand out any id its filter may already
+// contain, drawing again from the inner service instead. Because the
+// filter has no false negatives an id is never reissued, even one that
+// came from outside the service, and false positives only cost an
+// unnecessary draw. Save the filter to carry this guarantee across
+// restarts.
+type dedupingIdService struct {
+	sync.Mutex
+	inner  idService
+	filter *BloomFilter
+	logger *log.Logger
+}
+
+// NewDedupingIdService returns a service that skips every id in filter
+// and adds each id it issues to it.
+func NewDedupingIdService(inner idService, filter *BloomFilter) *dedupingIdService {
+	return &dedupingIdService{
+		inner:  inner,
+		filter: filter,
+		logger: log.New(os.Stderr, "dedup: ", log.LstdFlags),
+	}
+}
+
+func (s *dedupingIdService) getNext() uint64 {
+	s.Lock()
+	defer s.Unlock()
+	for {
+		id := s.inner.getNext()
+		if !s.filter.MayContain(id) {
+			s.filter.Add(id)
+			return id
+		}
+		s.logger.Printf("skipping id %d, which may already have been issued", id)
+	}
+}
+
+// Save writes the service's filter to w.
+func (s *dedupingIdService) Save(w io.Writer) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.filter.Save(w)
+}
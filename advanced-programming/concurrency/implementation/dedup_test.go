@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	filter := NewBloomFilter(1<<16, 4)
+	for id := uint64(0); id < 1000; id += 2 {
+		filter.Add(id)
+	}
+	falsePositives := 0
+	for id := uint64(0); id < 1000; id++ {
+		if id%2 == 0 && !filter.MayContain(id) {
+			t.Fatalf("Filter forgot id %d", id)
+		}
+		if id%2 == 1 && filter.MayContain(id) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 5 {
+		t.Fatalf("Too many false positives: %d of 500", falsePositives)
+	}
+
+	var buf bytes.Buffer
+	if err := filter.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBloomFilter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := uint64(0); id < 1000; id++ {
+		if loaded.MayContain(id) != filter.MayContain(id) {
+			t.Fatalf("Loaded filter disagrees about id %d", id)
+		}
+	}
+
+	if _, err := LoadBloomFilter(bytes.NewReader([]byte{1, 2, 3})); err == nil {
+		t.Fatalf("Expected truncated filter to fail to load")
+	}
+}
+
+func TestDedupingIdService(t *testing.T) {
+	filter := NewBloomFilter(1<<16, 4)
+	seeded := map[uint64]bool{3: true, 5: true, 6: true, 10: true}
+	for id := range seeded {
+		filter.Add(id)
+	}
+
+	service := NewDedupingIdService(&mutexIdService{}, filter)
+	service.logger = log.New(io.Discard, "", 0)
+
+	var ids []uint64
+	for len(ids) < 20 {
+		id := service.getNext()
+		if seeded[id] {
+			t.Fatalf("Service issued seeded id %d", id)
+		}
+		ids = append(ids, id)
+	}
+	if ids[0] != 1 || ids[1] != 2 || ids[2] != 4 || ids[3] != 7 {
+		t.Fatalf("Expected to skip the seeded ids, got %v", ids)
+	}
+
+	// a service restarted from the saved filter won't reissue any of them
+	var buf bytes.Buffer
+	if err := service.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBloomFilter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewDedupingIdService(&mutexIdService{}, loaded)
+	restarted.logger = log.New(io.Discard, "", 0)
+	if id := restarted.getNext(); id <= ids[len(ids)-1] {
+		t.Fatalf("Restarted service reissued id %d", id)
+	}
+
+	RunService(t, NewDedupingIdService(&atomicIdService{}, NewBloomFilter(1<<22, 4)), 10, 1000)
+}
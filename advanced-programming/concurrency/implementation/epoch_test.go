@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func resettableServices() []struct {
+	name    string
+	service func() (resettableIdService, func())
+} {
+	return []struct {
+		name    string
+		service func() (resettableIdService, func())
+	}{
+		{"atomic", func() (resettableIdService, func()) {
+			return &atomicIdService{}, func() {}
+		}},
+		{"mutex", func() (resettableIdService, func()) {
+			return &mutexIdService{}, func() {}
+		}},
+		{"goroutines", func() (resettableIdService, func()) {
+			service := MakeGoroutineIdService()
+			return service, service.Stop
+		}},
+	}
+}
+
+func TestResetStartsNewEpoch(t *testing.T) {
+	for _, c := range resettableServices() {
+		t.Run(c.name, func(t *testing.T) {
+			service, teardown := c.service()
+			defer teardown()
+
+			service.getNext()
+			if id, epoch := service.getNextWithEpoch(); id != 2 || epoch != 0 {
+				t.Fatalf("Expected (2, 0), got (%d, %d)", id, epoch)
+			}
+			service.Reset()
+			if id, epoch := service.getNextWithEpoch(); id != 1 || epoch != 1 {
+				t.Fatalf("Expected (1, 1) after reset, got (%d, %d)", id, epoch)
+			}
+		})
+	}
+}
+
+func TestEpochIdsUniqueAcrossResets(t *testing.T) {
+	for _, c := range resettableServices() {
+		t.Run(c.name, func(t *testing.T) {
+			service, teardown := c.service()
+			defer teardown()
+
+			var mu sync.Mutex
+			seen := map[epochId]bool{}
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 2000; j++ {
+						id, epoch := service.getNextWithEpoch()
+						mu.Lock()
+						if seen[epochId{id, epoch}] {
+							mu.Unlock()
+							t.Errorf("(%d, %d) issued twice", id, epoch)
+							return
+						}
+						seen[epochId{id, epoch}] = true
+						mu.Unlock()
+					}
+				}()
+			}
+			for i := 0; i < 10; i++ {
+				service.Reset()
+			}
+			wg.Wait()
+
+			if len(seen) != 8*2000 {
+				t.Fatalf("Expected %d unique pairs, got %d", 8*2000, len(seen))
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"expvar"
+	"time"
+)
+
+// expvarIdService publishes counters about the ids it issues through
+// expvar, so they appear on /debug/vars.
+type expvarIdService struct {
+	inner  idService
+	issued expvar.Int
+	errors expvar.Int
+}
+
+// NewExpvarIdService wraps inner, publishing a map under name with
+//
+//	issued: the number of ids handed out
+//	errors: the number of calls to inner that panicked
+//	rate:   ids issued per second since the service was created
+//
+// Like expvar.Publish, it panics if name is already in use.
+func NewExpvarIdService(inner idService, name string) idService {
+	s := &expvarIdService{inner: inner}
+	start := time.Now()
+	vars := expvar.NewMap(name)
+	vars.Set("issued", &s.issued)
+	vars.Set("errors", &s.errors)
+	vars.Set("rate", expvar.Func(func() interface{} {
+		return float64(s.issued.Value()) / time.Since(start).Seconds()
+	}))
+	return s
+}
+
+func (s *expvarIdService) getNext() uint64 {
+	ok := false
+	defer func() {
+		if !ok {
+			s.errors.Add(1)
+		}
+	}()
+	id := s.inner.getNext()
+	ok = true
+	s.issued.Add(1)
+	return id
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"testing"
+)
+
+// expvar names can only be published once per process, so give each
+// run of a test its own
+var expvarRuns int
+
+func expvarName(base string) string {
+	expvarRuns++
+	return fmt.Sprintf("%s-%d", base, expvarRuns)
+}
+
+type panickingIdService struct{}
+
+func (panickingIdService) getNext() uint64 {
+	panic("out of ids")
+}
+
+func TestExpvarIdService(t *testing.T) {
+	name := expvarName("test-ids")
+	service := NewExpvarIdService(&atomicIdService{}, name)
+	RunService(t, service, 10, 1000)
+
+	vars := expvar.Get(name).(*expvar.Map)
+	if issued := vars.Get("issued").(*expvar.Int).Value(); issued != 10*1000 {
+		t.Fatalf("Expected %d ids issued, got %d", 10*1000, issued)
+	}
+	if errors := vars.Get("errors").(*expvar.Int).Value(); errors != 0 {
+		t.Fatalf("Expected no errors, got %d", errors)
+	}
+	if rate := vars.Get("rate").(expvar.Func)().(float64); rate <= 0 {
+		t.Fatalf("Expected a positive issuance rate, got %v", rate)
+	}
+}
+
+func TestExpvarIdServiceCountsPanics(t *testing.T) {
+	name := expvarName("test-panicking-ids")
+	service := NewExpvarIdService(panickingIdService{}, name)
+	func() {
+		defer func() { recover() }()
+		service.getNext()
+	}()
+
+	vars := expvar.Get(name).(*expvar.Map)
+	if errors := vars.Get("errors").(*expvar.Int).Value(); errors != 1 {
+		t.Fatalf("Expected 1 error, got %d", errors)
+	}
+	if issued := vars.Get("issued").(*expvar.Int).Value(); issued != 0 {
+		t.Fatalf("Expected no ids issued, got %d", issued)
+	}
+}
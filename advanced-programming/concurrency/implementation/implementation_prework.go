@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
+	"unsafe"
 )
 
 type idService interface {
@@ -10,6 +14,16 @@ type idService interface {
 	// getNext() concurrently without any additional synchronization.
 	getNext() uint64
 }
+
+// A resettableIdService can start counting again from the beginning.
+// Each Reset begins a new epoch, so although ids repeat across resets,
+// the (id, epoch) pairs from getNextWithEpoch never do.
+type resettableIdService interface {
+	idService
+	getNextWithEpoch() (id uint64, epoch uint64)
+	Reset()
+}
+
 type noSyncIdService struct {
 	id uint64
 }
@@ -19,17 +33,51 @@ func (i *noSyncIdService) getNext() uint64 {
 	return i.id
 }
 
+// An id counter for a single epoch of an atomicIdService
+type atomicEpoch struct {
+	id    uint64
+	epoch uint64
+}
+
 type atomicIdService struct {
-	id uint64
+	current unsafe.Pointer // *atomicEpoch, allocated on first use
+}
+
+// Resetting replaces the whole counter, as an id and its epoch can't be
+// updated together atomically. A draw racing with a Reset may finish
+// on the old counter, but is still unique within the old epoch.
+func (i *atomicIdService) counter() *atomicEpoch {
+	for {
+		if p := atomic.LoadPointer(&i.current); p != nil {
+			return (*atomicEpoch)(p)
+		}
+		atomic.CompareAndSwapPointer(&i.current, nil, unsafe.Pointer(&atomicEpoch{}))
+	}
 }
 
 func (i *atomicIdService) getNext() uint64 {
-	return atomic.AddUint64(&i.id, 1)
+	return atomic.AddUint64(&i.counter().id, 1)
+}
+
+func (i *atomicIdService) getNextWithEpoch() (uint64, uint64) {
+	c := i.counter()
+	return atomic.AddUint64(&c.id, 1), c.epoch
+}
+
+func (i *atomicIdService) Reset() {
+	for {
+		old := i.counter()
+		next := &atomicEpoch{epoch: old.epoch + 1}
+		if atomic.CompareAndSwapPointer(&i.current, unsafe.Pointer(old), unsafe.Pointer(next)) {
+			return
+		}
+	}
 }
 
 type mutexIdService struct {
 	sync.Mutex
-	id uint64
+	id    uint64
+	epoch uint64
 }
 
 func (i *mutexIdService) getNext() uint64 {
@@ -39,35 +87,116 @@ func (i *mutexIdService) getNext() uint64 {
 	return i.id
 }
 
+func (i *mutexIdService) getNextWithEpoch() (uint64, uint64) {
+	i.Lock()
+	defer i.Unlock()
+	i.id += 1
+	return i.id, i.epoch
+}
+
+func (i *mutexIdService) Reset() {
+	i.Lock()
+	defer i.Unlock()
+	i.id = 0
+	i.epoch++
+}
+
+type epochId struct {
+	id    uint64
+	epoch uint64
+}
+
 type goroutineIdService struct {
 	requests  chan struct{}
-	responses chan uint64
+	responses chan epochId
+	resets    chan struct{}
+	pings     chan chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
 }
 
 func MakeGoroutineIdService() *goroutineIdService {
 	service := goroutineIdService{
 		requests:  make(chan struct{}),
-		responses: make(chan uint64),
+		responses: make(chan epochId),
+		resets:    make(chan struct{}),
+		pings:     make(chan chan struct{}),
+		done:      make(chan struct{}),
 	}
 	service.Start()
 	return &service
 }
 
+// Start launches the worker goroutine. Only the first call has any
+// effect, so there is never more than one worker handing out ids.
 func (s *goroutineIdService) Start() {
-	go func() {
-		id := uint64(0)
-		for range s.requests {
-			id++
-			s.responses <- id
-		}
-	}()
+	s.startOnce.Do(func() {
+		go func() {
+			id, epoch := uint64(0), uint64(0)
+			for {
+				select {
+				case _, ok := <-s.requests:
+					if !ok {
+						return
+					}
+					id++
+					s.responses <- epochId{id, epoch}
+				case <-s.resets:
+					id = 0
+					epoch++
+				case ack := <-s.pings:
+					close(ack)
+				}
+			}
+		}()
+	})
 }
 
+// Stop shuts down the worker. It is safe to call more than once.
 func (s *goroutineIdService) Stop() {
-	close(s.requests)
+	s.stopOnce.Do(func() {
+		close(s.done)
+		close(s.requests)
+	})
 }
 
 func (s *goroutineIdService) getNext() uint64 {
 	s.requests <- struct{}{}
-	return <-s.responses
+	return (<-s.responses).id
+}
+
+func (s *goroutineIdService) getNextWithEpoch() (uint64, uint64) {
+	s.requests <- struct{}{}
+	r := <-s.responses
+	return r.id, r.epoch
+}
+
+// Reset returns once the worker has started the new epoch, so every
+// id drawn afterwards belongs to it.
+func (s *goroutineIdService) Reset() {
+	s.resets <- struct{}{}
+}
+
+// Ping checks that the worker goroutine is alive and responsive by
+// sending a probe through its loop. It does not consume an id.
+func (s *goroutineIdService) Ping(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	ack := make(chan struct{})
+	select {
+	case s.pings <- ack:
+	case <-s.done:
+		return errors.New("goroutine service is stopped")
+	case <-timer.C:
+		return fmt.Errorf("goroutine service did not accept ping within %v", timeout)
+	}
+
+	select {
+	case <-ack:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("goroutine service did not answer ping within %v", timeout)
+	}
 }
@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -13,9 +16,6 @@ type testCase struct {
 }
 
 func setup() []testCase {
-	goroutineService := MakeGoroutineIdService()
-	goroutineService.Start()
-
 	return []testCase{
 		// {"no-sync", func() (idService, func()) {
 		// 	service := &noSyncIdService{}
@@ -32,6 +32,16 @@ func setup() []testCase {
 			teardown := func() {}
 			return service, teardown
 		}},
+		{"cas", func() (idService, func()) {
+			service := &casIdService{}
+			teardown := func() {}
+			return service, teardown
+		}},
+		{"spinlock", func() (idService, func()) {
+			service := &tasIdService{}
+			teardown := func() {}
+			return service, teardown
+		}},
 		{"goroutines", func() (idService, func()) {
 			service := MakeGoroutineIdService()
 			service.Start()
@@ -41,9 +51,18 @@ func setup() []testCase {
 	}
 }
 
+// How long RunService waits for a service before declaring it
+// deadlocked
+var serviceTimeout = 5 * time.Second
+
 func RunService(t testing.TB, service idService, numWorkers, numCalls int) {
 	t.Helper()
+	if err := runService(service, numWorkers, numCalls, serviceTimeout); err != nil {
+		t.Fatalf(err.Error())
+	}
+}
 
+func runService(service idService, numWorkers, numCalls int, timeout time.Duration) error {
 	var eg errgroup.Group
 	idChan := make(chan uint64, numWorkers*numCalls)
 
@@ -61,9 +80,16 @@ func RunService(t testing.TB, service idService, numWorkers, numCalls int) {
 		})
 	}
 
-	err := eg.Wait()
-	if err != nil {
-		t.Fatalf(err.Error())
+	done := make(chan error, 1)
+	go func() { done <- eg.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+	case <-time.After(timeout):
+		return fmt.Errorf("Service appears deadlocked after %dms: collected %d of %d ids",
+			timeout.Milliseconds(), len(idChan), numWorkers*numCalls)
 	}
 
 	close(idChan)
@@ -76,7 +102,41 @@ func RunService(t testing.TB, service idService, numWorkers, numCalls int) {
 		}
 	}
 	if maxId != uint64(expectedMax) {
-		t.Fatalf("Max id across workers incorrect: expected %d, got %d", expectedMax, maxId)
+		return fmt.Errorf("Max id across workers incorrect: expected %d, got %d", expectedMax, maxId)
+	}
+	return nil
+}
+
+// A service that hands out a fixed number of ids and then blocks until
+// released
+type stuckIdService struct {
+	mutexIdService
+	limit   uint64
+	release chan struct{}
+}
+
+func (s *stuckIdService) getNext() uint64 {
+	id := s.mutexIdService.getNext()
+	if id > s.limit {
+		<-s.release
+	}
+	return id
+}
+
+func TestRunServiceDetectsDeadlock(t *testing.T) {
+	service := &stuckIdService{limit: 5, release: make(chan struct{})}
+	defer close(service.release)
+
+	start := time.Now()
+	err := runService(service, 2, 10, 50*time.Millisecond)
+	if err == nil {
+		t.Fatalf("Expected a deadlocked service to be reported")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Deadlock took %v to report", elapsed)
+	}
+	if !strings.Contains(err.Error(), "deadlocked after 50ms") || !strings.Contains(err.Error(), "collected 5 of 20") {
+		t.Fatalf("Unexpected deadlock report: %v", err)
 	}
 }
 
@@ -91,6 +151,111 @@ func TestServices(t *testing.T) {
 	}
 }
 
+// checkNoLeaks fails the test if fn leaves goroutines running. Workers
+// may take a moment to exit once told to stop, so the count is given a
+// short while to settle.
+func checkNoLeaks(t *testing.T, fn func()) {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	fn()
+
+	after := runtime.NumGoroutine()
+	for deadline := time.Now().Add(time.Second); after > before && time.Now().Before(deadline); {
+		runtime.Gosched()
+		time.Sleep(time.Millisecond)
+		after = runtime.NumGoroutine()
+	}
+	if after > before {
+		t.Fatalf("Leaked %d goroutines", after-before)
+	}
+}
+
+func TestServicesDontLeak(t *testing.T) {
+	for _, testCase := range setup() {
+		t.Run(testCase.name, func(t *testing.T) {
+			checkNoLeaks(t, func() {
+				service, teardown := testCase.service()
+				RunService(t, service, 10, 100)
+				teardown()
+			})
+		})
+	}
+
+	// Starting or stopping the goroutine service twice must not leave
+	// a second worker behind
+	checkNoLeaks(t, func() {
+		service := MakeGoroutineIdService()
+		service.Start()
+		service.getNext()
+		service.Stop()
+		service.Stop()
+	})
+}
+
+func TestGoroutinePing(t *testing.T) {
+	service := MakeGoroutineIdService()
+
+	if id := service.getNext(); id != 1 {
+		t.Fatalf("Expected first id to be 1, got %d", id)
+	}
+	if err := service.Ping(time.Second); err != nil {
+		t.Fatalf("Ping of running service failed: %v", err)
+	}
+	if id := service.getNext(); id != 2 {
+		t.Fatalf("Ping perturbed the id sequence: expected 2, got %d", id)
+	}
+
+	service.Stop()
+	if err := service.Ping(10 * time.Millisecond); err == nil {
+		t.Fatalf("Expected Ping of stopped service to fail")
+	}
+}
+
+// The goroutine service hands out ids in the order the worker accepts
+// requests, so a request that happens before another, here by A's
+// getNext returning before a barrier that B waits on, always gets the
+// smaller id, however many other callers are queued on the worker.
+// The atomic, mutex and CAS variants only promise this for calls that
+// don't overlap; they have no single point at which a request is
+// accepted, and two overlapping calls may be served in either order.
+func TestGoroutineFIFO(t *testing.T) {
+	service := MakeGoroutineIdService()
+	defer service.Stop()
+
+	// keep the worker busy with other callers throughout
+	stop := make(chan struct{})
+	var load errgroup.Group
+	for i := 0; i < 4; i++ {
+		load.Go(func() error {
+			for {
+				select {
+				case <-stop:
+					return nil
+				default:
+					service.getNext()
+				}
+			}
+		})
+	}
+
+	for i := 0; i < 200; i++ {
+		var a uint64
+		barrier := make(chan struct{})
+		go func() {
+			a = service.getNext()
+			close(barrier)
+		}()
+		<-barrier
+		if b := service.getNext(); a >= b {
+			close(stop)
+			load.Wait()
+			t.Fatalf("Request made first got id %d, later request got %d", a, b)
+		}
+	}
+	close(stop)
+	load.Wait()
+}
+
 func BenchmarkServices(b *testing.B) {
 	cases := setup()
 	for _, testCase := range cases {
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// persistentIdService keeps counting across restarts by checkpointing
+// to a file. Rather than record every id, it reserves them a batch at
+// a time: before issuing the first id of a batch it writes the last id
+// of the batch, and on restart it resumes after whatever was written.
+// A crash therefore skips the unissued remainder of the batch, but
+// never reissues an id that was handed out, provided the checkpoint
+// reached the disk.
+//
+// Whether it did depends on WithSync. With syncing on (the default),
+// each checkpoint is fsynced before its batch is issued, so the
+// guarantee survives power loss. With it off, checkpoints only reach
+// the OS, which is enough to survive the process crashing but not the
+// machine: losing the page cache can roll the file back by one or more
+// batches, and those ids will be issued again.
+type persistentIdService struct {
+	sync.Mutex
+	file        *os.File
+	batch       uint64
+	sync        bool
+	id          uint64 // the last id issued
+	limit       uint64 // the last id covered by the checkpoint on file
+	checkpoints int    // the number of checkpoints written
+}
+
+// A PersistOption configures a persistentIdService.
+type PersistOption func(*persistentIdService)
+
+// WithSync controls whether each checkpoint is fsynced.
+func WithSync(sync bool) PersistOption {
+	return func(s *persistentIdService) {
+		s.sync = sync
+	}
+}
+
+// NewPersistentIdService opens, or creates, the checkpoint file at
+// path and returns a service that resumes after the last id it
+// records, checkpointing every batch ids.
+func NewPersistentIdService(path string, batch uint64, opts ...PersistOption) (*persistentIdService, error) {
+	if batch == 0 {
+		return nil, fmt.Errorf("batch size must be positive")
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &persistentIdService{file: file, batch: batch, sync: true}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	buf := make([]byte, 8)
+	n, err := file.ReadAt(buf, 0)
+	switch {
+	case err == nil:
+		s.id = binary.LittleEndian.Uint64(buf)
+		s.limit = s.id
+	case err == io.EOF && n == 0:
+		// a new file, so start from zero
+	case err == io.EOF:
+		// starting from zero could reissue every id handed out
+		file.Close()
+		return nil, fmt.Errorf("checkpoint %s is truncated to %d bytes", path, n)
+	default:
+		file.Close()
+		return nil, fmt.Errorf("reading checkpoint %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// getNext panics if a checkpoint can't be written, since issuing an id
+// that isn't covered by one would break the service's guarantee.
+func (s *persistentIdService) getNext() uint64 {
+	s.Lock()
+	defer s.Unlock()
+	if s.id == s.limit {
+		if err := s.checkpoint(s.id + s.batch); err != nil {
+			panic(err)
+		}
+	}
+	s.id++
+	return s.id
+}
+
+// Flush records exactly the last id issued, syncing it regardless of
+// WithSync, so that a restart resumes without skipping the rest of the
+// batch. Call it before a clean shutdown.
+func (s *persistentIdService) Flush() error {
+	s.Lock()
+	defer s.Unlock()
+	if err := s.write(s.id); err != nil {
+		return err
+	}
+	s.limit = s.id
+	return s.file.Sync()
+}
+
+// Close flushes the service and closes its file.
+func (s *persistentIdService) Close() error {
+	if err := s.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// Reserve the ids up to limit
+func (s *persistentIdService) checkpoint(limit uint64) error {
+	if err := s.write(limit); err != nil {
+		return err
+	}
+	if s.sync {
+		if err := s.file.Sync(); err != nil {
+			return err
+		}
+	}
+	s.limit = limit
+	return nil
+}
+
+func (s *persistentIdService) write(id uint64) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, id)
+	if _, err := s.file.WriteAt(buf, 0); err != nil {
+		return err
+	}
+	s.checkpoints++
+	return nil
+}
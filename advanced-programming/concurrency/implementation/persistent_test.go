@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPersistentIdService(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ids")
+	service, err := NewPersistentIdService(path, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	RunService(t, service, 10, 1000)
+	if err := service.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A clean shutdown resumes right where it stopped
+	service, err = NewPersistentIdService(path, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	if id := service.getNext(); id != 10*1000+1 {
+		t.Fatalf("Expected to resume at %d, got %d", 10*1000+1, id)
+	}
+}
+
+func TestPersistentIdServiceBatching(t *testing.T) {
+	dir := t.TempDir()
+	checkpoints := func(batch uint64, opts ...PersistOption) int {
+		service, err := NewPersistentIdService(filepath.Join(dir, "ids"), batch, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer service.file.Close()
+		for i := 0; i < 10000; i++ {
+			service.getNext()
+		}
+		return service.checkpoints
+	}
+
+	every := checkpoints(1)
+	batched := checkpoints(1000, WithSync(false))
+	if every != 10000 {
+		t.Fatalf("Expected a checkpoint per id with a batch of 1, got %d", every)
+	}
+	if batched != 10 {
+		t.Fatalf("Expected 10 checkpoints with a batch of 1000, got %d", batched)
+	}
+}
+
+func TestPersistentIdServiceCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ids")
+	maxId := uint64(0)
+	for run := 0; run < 5; run++ {
+		service, err := NewPersistentIdService(path, 64, WithSync(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Crash partway through a batch, without flushing
+		for i := 0; i < 100+run*37; i++ {
+			id := service.getNext()
+			if id <= maxId {
+				t.Fatalf("Run %d reissued id %d, after %d was issued", run, id, maxId)
+			}
+			maxId = id
+		}
+		service.file.Close()
+	}
+}
+
+func TestPersistentIdServiceTornCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+
+	// An empty file, as a crash right after creating it leaves, is new
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewPersistentIdService(empty, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	if id := service.getNext(); id != 1 {
+		t.Fatalf("Expected an empty checkpoint to start at 1, got %d", id)
+	}
+
+	// But a torn one can't be trusted to start from zero
+	torn := filepath.Join(dir, "torn")
+	if err := os.WriteFile(torn, []byte{1, 2, 3}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPersistentIdService(torn, 100); err == nil {
+		t.Fatal("Expected an error for a 3-byte checkpoint")
+	}
+}
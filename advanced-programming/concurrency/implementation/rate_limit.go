@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimitedIdService spaces out the ids of another service so that no
+// more than a configured number are issued per second. Callers beyond
+// the rate block until their turn.
+type rateLimitedIdService struct {
+	sync.Mutex
+	inner    idService
+	interval time.Duration // zero when issuance is paused
+	next     time.Time     // the earliest time the next id may be issued
+	changed  chan struct{} // closed, and replaced, by SetRate
+}
+
+// NewRateLimitedIdService returns a service that issues ids from inner
+// at up to perSecond a second.
+func NewRateLimitedIdService(inner idService, perSecond int) *rateLimitedIdService {
+	s := &rateLimitedIdService{inner: inner, changed: make(chan struct{})}
+	s.SetRate(perSecond)
+	return s
+}
+
+// SetRate changes the rate to perSecond ids a second, taking effect
+// for callers that are already waiting. A rate of zero or less pauses
+// issuance until it is raised again.
+func (s *rateLimitedIdService) SetRate(perSecond int) {
+	s.Lock()
+	defer s.Unlock()
+	s.interval = 0
+	if perSecond > 0 {
+		s.interval = time.Second / time.Duration(perSecond)
+	}
+	s.next = time.Now()
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *rateLimitedIdService) getNext() uint64 {
+	for {
+		s.Lock()
+		changed := s.changed
+		if s.interval == 0 {
+			s.Unlock()
+			<-changed
+			continue
+		}
+		// claim the next free slot
+		now := time.Now()
+		slot := s.next
+		if slot.Before(now) {
+			slot = now
+		}
+		s.next = slot.Add(s.interval)
+		s.Unlock()
+
+		if wait := slot.Sub(now); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-changed:
+				// the slot was for the old rate, so queue up again
+				timer.Stop()
+				continue
+			}
+		}
+		return s.inner.getNext()
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedIdService(t *testing.T) {
+	service := NewRateLimitedIdService(&atomicIdService{}, 1000)
+
+	var issued int64
+	stop := make(chan struct{})
+	var mu sync.Mutex
+	seen := map[uint64]bool{}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lastId := uint64(0)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				id := service.getNext()
+				if id <= lastId {
+					t.Errorf("Ids not increasing: got %d after %d", id, lastId)
+				}
+				lastId = id
+				mu.Lock()
+				if seen[id] {
+					t.Errorf("Id %d issued twice", id)
+				}
+				seen[id] = true
+				mu.Unlock()
+				atomic.AddInt64(&issued, 1)
+			}
+		}()
+	}
+
+	measure := func(d time.Duration) int64 {
+		before := atomic.LoadInt64(&issued)
+		time.Sleep(d)
+		return atomic.LoadInt64(&issued) - before
+	}
+
+	fast := measure(200 * time.Millisecond)
+	service.SetRate(20)
+	slow := measure(500 * time.Millisecond)
+	close(stop)
+	service.SetRate(1000) // let the workers notice stop
+	wg.Wait()
+
+	// Expect about 200 and 10, leaving room for a slow machine
+	if fast < 50 {
+		t.Fatalf("Expected about 200 ids at 1000/s, got %d", fast)
+	}
+	if slow > 25 {
+		t.Fatalf("Expected about 10 ids at 20/s, got %d", slow)
+	}
+}
+
+func TestRateLimitedIdServicePause(t *testing.T) {
+	service := NewRateLimitedIdService(&mutexIdService{}, 0)
+
+	ids := make(chan uint64)
+	go func() { ids <- service.getNext() }()
+	select {
+	case id := <-ids:
+		t.Fatalf("Issued id %d with a rate of zero", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	service.SetRate(100)
+	select {
+	case id := <-ids:
+		if id != 1 {
+			t.Fatalf("Expected id 1, got %d", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Raising the rate did not resume issuance")
+	}
+}
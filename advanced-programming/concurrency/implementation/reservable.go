@@ -0,0 +1,51 @@
+package main
+
+import "sync"
+
+// reservableIdService lets callers reserve an id and later either
+// commit it, consuming it for good, or abort, returning it to be handed
+// out again. Aborted ids are reused before new ones are drawn from the
+// inner service, most recently aborted first, so ids are unique among
+// committed and outstanding reservations but are no longer issued in
+// increasing order.
+type reservableIdService struct {
+	sync.Mutex
+	inner idService
+	free  []uint64
+}
+
+// NewReservableIdService returns a service that draws new ids from
+// inner.
+func NewReservableIdService(inner idService) *reservableIdService {
+	return &reservableIdService{inner: inner}
+}
+
+// Reserve hands out an id along with functions to commit or abort the
+// reservation. Only the first call of either has any effect.
+func (s *reservableIdService) Reserve() (id uint64, commit func(), abort func()) {
+	s.Lock()
+	if n := len(s.free); n > 0 {
+		id = s.free[n-1]
+		s.free = s.free[:n-1]
+	} else {
+		id = s.inner.getNext()
+	}
+	s.Unlock()
+
+	var once sync.Once
+	commit = func() { once.Do(func() {}) }
+	abort = func() {
+		once.Do(func() {
+			s.Lock()
+			defer s.Unlock()
+			s.free = append(s.free, id)
+		})
+	}
+	return id, commit, abort
+}
+
+func (s *reservableIdService) getNext() uint64 {
+	id, commit, _ := s.Reserve()
+	commit()
+	return id
+}
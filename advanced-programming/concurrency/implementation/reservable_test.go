@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestReservableIdService(t *testing.T) {
+	service := NewReservableIdService(&mutexIdService{})
+
+	first, commit, _ := service.Reserve()
+	commit()
+	second, _, abort := service.Reserve()
+	abort()
+	abort() // a second abort must not free the id twice
+
+	if id, _, _ := service.Reserve(); id != second {
+		t.Fatalf("Expected aborted id %d to be reissued, got %d", second, id)
+	}
+	if id := service.getNext(); id == first || id == second {
+		t.Fatalf("Reissued id %d that is still held", id)
+	}
+}
+
+func TestReservableIdServiceConcurrent(t *testing.T) {
+	service := NewReservableIdService(&atomicIdService{})
+
+	var mu sync.Mutex
+	held := map[uint64]bool{}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				id, commit, abort := service.Reserve()
+				mu.Lock()
+				if held[id] {
+					mu.Unlock()
+					t.Errorf("Id %d handed to two callers", id)
+					return
+				}
+				held[id] = true
+				mu.Unlock()
+
+				if (worker+j)%3 == 0 {
+					mu.Lock()
+					delete(held, id)
+					mu.Unlock()
+					abort()
+				} else {
+					commit()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// casIdService is lock-free: the compare-and-swap on the counter is
+// itself the increment, retried whenever another goroutine wins.
+type casIdService struct {
+	id uint64
+}
+
+func (i *casIdService) getNext() uint64 {
+	for {
+		old := atomic.LoadUint64(&i.id)
+		if atomic.CompareAndSwapUint64(&i.id, old, old+1) {
+			return old + 1
+		}
+	}
+}
+
+// tasIdService uses compare-and-swap as a test-and-set spinlock around
+// a plain counter. Unlike casIdService, the atomic operation only
+// guards the critical section, which makes it a hand-rolled stand-in
+// for mutexIdService; waiters burn CPU spinning instead of parking.
+type tasIdService struct {
+	locked uint32
+	id     uint64
+}
+
+func (i *tasIdService) lock() {
+	for !atomic.CompareAndSwapUint32(&i.locked, 0, 1) {
+		runtime.Gosched()
+	}
+}
+
+func (i *tasIdService) unlock() {
+	atomic.StoreUint32(&i.locked, 0)
+}
+
+func (i *tasIdService) getNext() uint64 {
+	i.lock()
+	defer i.unlock()
+	i.id++
+	return i.id
+}
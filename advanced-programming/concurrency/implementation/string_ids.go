@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// An idEncoding renders ids as strings of digits drawn from an
+// alphabet. Every alphabet below is in ASCII order, but plain encodings
+// of increasing ids still don't sort as strings ("z" > "10" in base62);
+// use sortable() to zero-pad to a fixed width when that matters.
+type idEncoding struct {
+	alphabet string
+	padded   bool
+}
+
+var (
+	base62      = idEncoding{alphabet: "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"}
+	base36      = idEncoding{alphabet: "0123456789abcdefghijklmnopqrstuvwxyz"}
+	hexEncoding = idEncoding{alphabet: "0123456789abcdef"}
+)
+
+// sortable returns a copy of the encoding that pads every id to the
+// width of the largest uint64, so that string order matches numeric
+// order.
+func (e idEncoding) sortable() idEncoding {
+	e.padded = true
+	return e
+}
+
+func (e idEncoding) encode(id uint64) string {
+	base := uint64(len(e.alphabet))
+	var digits []byte
+	for id > 0 || len(digits) == 0 {
+		digits = append(digits, e.alphabet[id%base])
+		id /= base
+	}
+	if e.padded {
+		for len(digits) < e.width() {
+			digits = append(digits, e.alphabet[0])
+		}
+	}
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	return string(digits)
+}
+
+func (e idEncoding) decode(s string) (uint64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty id")
+	}
+	base := uint64(len(e.alphabet))
+	id := uint64(0)
+	for _, c := range s {
+		digit := strings.IndexRune(e.alphabet, c)
+		if digit < 0 {
+			return 0, fmt.Errorf("invalid digit %q in id %q", c, s)
+		}
+		if id > (math.MaxUint64-uint64(digit))/base {
+			return 0, fmt.Errorf("id %q overflows uint64", s)
+		}
+		id = id*base + uint64(digit)
+	}
+	return id, nil
+}
+
+// The number of digits needed to encode the largest uint64
+func (e idEncoding) width() int {
+	return len(idEncoding{alphabet: e.alphabet}.encode(math.MaxUint64))
+}
+
+// StringIdService hands out the ids of another service as strings.
+// Distinct ids always encode to distinct strings.
+type StringIdService struct {
+	inner    idService
+	encoding idEncoding
+}
+
+// NewStringIdService wraps inner, encoding its ids in base62.
+func NewStringIdService(inner idService) *StringIdService {
+	return &StringIdService{inner: inner, encoding: base62}
+}
+
+// WithEncoding returns a copy of the service that uses encoding e.
+func (s *StringIdService) WithEncoding(e idEncoding) *StringIdService {
+	return &StringIdService{inner: s.inner, encoding: e}
+}
+
+func (s *StringIdService) getNextString() string {
+	return s.encoding.encode(s.inner.getNext())
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"testing"
+)
+
+var encodings = []struct {
+	name     string
+	encoding idEncoding
+}{
+	{"base62", base62},
+	{"base36", base36},
+	{"hex", hexEncoding},
+	{"base62-sortable", base62.sortable()},
+	{"hex-sortable", hexEncoding.sortable()},
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	ids := []uint64{0, 1, 9, 10, 35, 36, 61, 62, 3843, 3844, 1 << 40, math.MaxUint64}
+	for _, e := range encodings {
+		t.Run(e.name, func(t *testing.T) {
+			for _, id := range ids {
+				s := e.encoding.encode(id)
+				decoded, err := e.encoding.decode(s)
+				if err != nil {
+					t.Fatalf("Failed to decode %q: %v", s, err)
+				}
+				if decoded != id {
+					t.Fatalf("Encoded %d as %q, which decoded to %d", id, s, decoded)
+				}
+			}
+		})
+	}
+
+	if hexEncoding.encode(255) != "ff" || base62.encode(61) != "z" || base36.encode(36) != "10" {
+		t.Fatalf("Unexpected encoding of known values")
+	}
+	if _, err := base62.decode("zzzzzzzzzzzz"); err == nil {
+		t.Fatalf("Expected overflowing id to fail to decode")
+	}
+}
+
+func TestStringIdServiceUnique(t *testing.T) {
+	service := NewStringIdService(&atomicIdService{}).WithEncoding(base62.sortable())
+
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				s := service.getNextString()
+				mu.Lock()
+				seen[s] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != 10000 {
+		t.Fatalf("Expected 10000 unique ids, got %d", len(seen))
+	}
+}
+
+func TestSortableEncoding(t *testing.T) {
+	service := NewStringIdService(&mutexIdService{}).WithEncoding(base62.sortable())
+	ids := make([]string, 200)
+	for i := range ids {
+		ids[i] = service.getNextString()
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Fatalf("Expected sortable ids to be in lexicographic order")
+	}
+
+	// without padding, 61 ("z") sorts after 62 ("10")
+	if base62.encode(61) < base62.encode(62) {
+		t.Fatalf("Expected unpadded base62 not to be sortable")
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+// teeIdService mirrors every id it hands out onto a channel.
+type teeIdService struct {
+	inner    idService
+	sink     chan<- uint64
+	blocking bool
+}
+
+// NewTeeIdService returns a service that delegates to inner and sends
+// each issued id on sink. Ids are dropped rather than sent if sink is
+// not ready, so a slow consumer never holds up issuance.
+func NewTeeIdService(inner idService, sink chan<- uint64) idService {
+	return &teeIdService{inner: inner, sink: sink}
+}
+
+// NewBlockingTeeIdService is like NewTeeIdService, but waits for sink
+// to accept every id, so the consumer sees all of them. If the
+// consumer stops receiving, getNext will block forever.
+func NewBlockingTeeIdService(inner idService, sink chan<- uint64) idService {
+	return &teeIdService{inner: inner, sink: sink, blocking: true}
+}
+
+func (s *teeIdService) getNext() uint64 {
+	id := s.inner.getNext()
+	if s.blocking {
+		s.sink <- id
+	} else {
+		select {
+		case s.sink <- id:
+		default:
+		}
+	}
+	return id
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBlockingTee(t *testing.T) {
+	sink := make(chan uint64)
+	service := NewBlockingTeeIdService(&atomicIdService{}, sink)
+
+	observed := map[uint64]bool{}
+	done := make(chan struct{})
+	go func() {
+		for id := range sink {
+			observed[id] = true
+		}
+		close(done)
+	}()
+
+	var mu sync.Mutex
+	issued := map[uint64]bool{}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				id := service.getNext()
+				mu.Lock()
+				issued[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	close(sink)
+	<-done
+
+	if len(issued) != 10000 || len(observed) != len(issued) {
+		t.Fatalf("Issued %d ids but the sink observed %d", len(issued), len(observed))
+	}
+	for id := range issued {
+		if !observed[id] {
+			t.Fatalf("Sink never observed id %d", id)
+		}
+	}
+}
+
+func TestDroppingTeeDoesNotBlock(t *testing.T) {
+	// nobody ever reads from the sink
+	sink := make(chan uint64, 1)
+	service := NewTeeIdService(&mutexIdService{}, sink)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			service.getNext()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("A full sink blocked id issuance")
+	}
+	if id := <-sink; id != 1 {
+		t.Fatalf("Expected the buffered id to be 1, got %d", id)
+	}
+}
@@ -0,0 +1,39 @@
+package vm
+
+// A MemoryAccess is a single data access made by a Load or Store.
+type MemoryAccess struct {
+	Addr    int
+	IsWrite bool
+	PC      int // the address of the instruction making the access
+}
+
+// An AccessLog records every data access a program makes, in order.
+type AccessLog struct {
+	Accesses []MemoryAccess
+}
+
+// WithAccessLog records the program's data accesses in log.
+func WithAccessLog(log *AccessLog) Option {
+	return func(m *Machine) {
+		m.accessLog = log
+	}
+}
+
+// WithAccessHook calls fn for every data access the program makes,
+// including those to the stack, as it is made. It is called before a
+// write changes memory, so the old value can still be read.
+func WithAccessHook(fn func(MemoryAccess)) Option {
+	return func(m *Machine) {
+		m.accessHook = fn
+	}
+}
+
+// Histogram counts the accesses, reads and writes alike, made to each
+// address.
+func (l *AccessLog) Histogram() map[int]int {
+	counts := map[int]int{}
+	for _, access := range l.Accesses {
+		counts[access.Addr]++
+	}
+	return counts
+}
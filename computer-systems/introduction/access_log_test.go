@@ -0,0 +1,77 @@
+package vm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	// Sum the array at 1..4, reading the first element twice
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+load r1 1
+load r2 2
+add r1 r2
+load r2 3
+add r1 r2
+load r2 4
+add r1 r2
+load r2 1
+add r1 r2
+store r1 0
+halt`))
+	copy(memory[1:], []byte{1, 2, 3, 4})
+
+	var log AccessLog
+	if err := NewMachine(memory, WithAccessLog(&log)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if memory[0] != 11 {
+		t.Fatalf("Expected sum of 11, got %d", memory[0])
+	}
+
+	expected := map[int]int{0: 1, 1: 2, 2: 1, 3: 1, 4: 1}
+	if histogram := log.Histogram(); !reflect.DeepEqual(histogram, expected) {
+		t.Fatalf("Expected histogram %v, got %v", expected, histogram)
+	}
+
+	last := log.Accesses[len(log.Accesses)-1]
+	if last != (MemoryAccess{Addr: 0, IsWrite: true, PC: 35}) {
+		t.Fatalf("Expected final access to be the store at pc 35, got %+v", last)
+	}
+	for _, access := range log.Accesses[:len(log.Accesses)-1] {
+		if access.IsWrite {
+			t.Fatalf("Unexpected write %+v", access)
+		}
+	}
+}
+
+func TestAccessHook(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+load r1 1
+push r1
+pop r2
+store r2 0
+halt`))
+	memory[0], memory[1] = 9, 5
+
+	var accesses []MemoryAccess
+	var old byte
+	hook := func(access MemoryAccess) {
+		accesses = append(accesses, access)
+		if access.IsWrite && access.Addr == 0 {
+			old = memory[0]
+		}
+	}
+	if err := NewMachine(memory, WithAccessHook(hook)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []MemoryAccess{{1, false, 8}, {255, true, 11}, {255, false, 13}, {0, true, 15}}
+	if !reflect.DeepEqual(accesses, expected) {
+		t.Fatalf("Expected accesses %v, got %v", expected, accesses)
+	}
+	if old != 9 || memory[0] != 5 {
+		t.Fatalf("Expected the hook to see 9 before the store of 5, got %d then %d", old, memory[0])
+	}
+}
@@ -0,0 +1,172 @@
+// Package asm translates assembly source into memory images for the
+// toy VM.
+//
+// Each line holds at most one instruction, optionally preceded by a
+// label and followed by a comment:
+//
+//	loop:  beqz r1, done   ; exit once r1 reaches zero
+//
+// Mnemonics and registers are case insensitive, and operands may be
+// separated by commas or whitespace. Numbers are decimal, or hex with a
+// 0x prefix. Anywhere an address or immediate is expected a label may
+// be used instead; as the operand of a branch, a label is converted to
+// the offset from the following instruction.
+//
+// Branch offsets are signed bytes, so a numeric offset may be written
+// from -128 to 127, as in "beqz r1, -5", or as the unsigned byte that
+// encodes it, as in "beqz r1, 251". The PC wraps around at the end of
+// memory, so a branch to a label always fits: one too far forward is
+// encoded as the equivalent branch backward.
+//
+// Immediates are stored as unsigned bytes, but addi and subi also
+// accept negative values: adding -n is encoded exactly as subtracting
+// n, and subtracting -n as adding n. The disassembler always renders
+// the unsigned form, so "addi r1, -3" comes back as "subi r1, 3".
+//
+// Directives may appear in place of an instruction. ".byte" lays out
+// the listed values in the instruction region, where they can be read
+// as constants, and ".entry" names the label execution starts at,
+// which Program.EntryPoint reports:
+//
+//	        .entry start
+//	table:  .byte 1, 2, 3, 4
+//	start:  load r1, table
+//
+// After ".data", .byte lays out values in the data region instead,
+// from address 0, and labels name data addresses; ".text" switches back
+// to the instructions. ".equ" defines a named constant, which may be
+// used anywhere a number may, before or after its definition:
+//
+//	        .equ step, 3
+//	        .data
+//	result: .byte 0
+//	count:  .byte 10
+//	        .text
+//	        load r1, count
+//	        subi r1, step
+//	        store r1, result
+//
+// Labels and constants share one namespace, and each name may only be
+// defined once.
+//
+// A macro names a sequence of lines, with parameters that are replaced
+// by the arguments it is invoked with. It must be defined before it is
+// used, and may invoke other macros:
+//
+//	.macro inc reg
+//	addi reg, 1
+//	.endm
+//	inc r1
+//
+// Problems in an expansion are reported at the line that invoked the
+// macro. A macro that defines a label can only be invoked once, since
+// the label would otherwise be defined twice.
+//
+// A program may also be split across several files, which are parsed
+// separately and combined with Link. ".global" exports a label, in code
+// or data, to the other files, and ".extern" declares a name that one
+// of them exports. Every other name is private to its file:
+//
+//	        .extern double
+//	        call double
+//
+//	        .global double
+//	double: add r1, r1
+//	        ret
+package asm
+
+import (
+	"fmt"
+	"strings"
+
+	"vm"
+)
+
+// An AssembleError describes a problem at a position in the source.
+// Lines and columns are numbered from 1.
+type AssembleError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *AssembleError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// AssembleErrors is the list of every problem found in a source file.
+type AssembleErrors []*AssembleError
+
+func (e AssembleErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Assemble translates src into a memory image laid out the way the VM
+// expects: the data region is zeroed and the instructions follow it.
+// It is equivalent to Parse followed by Emit. If there are problems,
+// the returned error is an AssembleErrors listing all of them.
+func Assemble(src string) ([]byte, error) {
+	return AssembleConfig(src, vm.DefaultConfig)
+}
+
+// AssembleConfig is like Assemble, but lays out the image for a machine
+// configured with vm.WithConfig(c), with the code from c.CodeStart.
+func AssembleConfig(src string, c vm.MachineConfig) ([]byte, error) {
+	p, err := ParseConfig(src, c)
+	if err != nil {
+		return nil, err
+	}
+	return Emit(p)
+}
+
+type token struct {
+	text string
+	line int
+	col  int
+}
+
+// Split a line into tokens, dropping any comment
+func tokenize(text string, line int) []token {
+	var toks []token
+	start := -1
+	for i := 0; i <= len(text); i++ {
+		end := i == len(text) || text[i] == ';'
+		if end || text[i] == ' ' || text[i] == '\t' || text[i] == ',' || text[i] == '\r' {
+			if start >= 0 {
+				toks = append(toks, token{text[start:i], line, start + 1})
+				start = -1
+			}
+			if end {
+				break
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	return toks
+}
+
+func isIdent(s string) bool {
+	for i, c := range s {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func (e *AssembleErrors) add(line, col int, format string, args ...interface{}) {
+	*e = append(*e, &AssembleError{line, col, fmt.Sprintf(format, args...)})
+}
+
+func (e AssembleErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
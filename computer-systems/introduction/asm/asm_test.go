@@ -0,0 +1,340 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"vm"
+)
+
+func TestAssemble(t *testing.T) {
+	image, err := Assemble(`
+; sum the numbers 1..n
+        load r1, 1
+loop:   beqz r1, done
+        add r2 r1
+        SUBI R1, 0x01
+        jump loop
+done:   store r2, 0
+        halt
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image) != vm.MemorySize {
+		t.Fatalf("Expected a %d byte image, got %d", vm.MemorySize, len(image))
+	}
+
+	expected := []byte{
+		vm.Load, 1, 1,
+		vm.Beqz, 1, 8,
+		vm.Add, 2, 1,
+		vm.Subi, 1, 1,
+		vm.Jump, 11,
+		vm.Store, 2, 0,
+		vm.Halt,
+	}
+	if !bytes.Equal(image[8:8+len(expected)], expected) {
+		t.Fatalf("Expected % x, got % x", expected, image[8:8+len(expected)])
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       string
+		line, col int
+		msg       string
+	}{
+		{"UnknownMnemonic", "halt\n  frob r1", 2, 3, "unknown mnemonic"},
+		{"OperandCount", "load r1", 1, 1, "takes 2 operands"},
+		{"BadRegister", "add r1, r8", 1, 9, "invalid register"},
+		{"BadNumber", "addi r1, 0xzz", 1, 10, "invalid number"},
+		{"OutOfRange", "\n\taddi r1, 256", 2, 11, "out of range"},
+		{"UndefinedLabel", "jump nowhere", 1, 6, "undefined label"},
+		{"DuplicateLabel", "a: halt\na: halt", 2, 1, "already defined on line 1"},
+		{"BadLabel", "1a: halt", 1, 1, "invalid label"},
+		{"BranchOutOfRange", "beqz r1, -129", 1, 10, "out of range"},
+		{"BranchPastByte", "beqz r1, 300", 1, 10, "out of range"},
+		{"TooLarge", strings.Repeat("halt\n", 248) + "halt", 249, 1, "does not fit"},
+		{"UnknownDirective", "halt\n.word 1", 2, 1, "unknown directive"},
+		{"BadByte", ".byte 1, 256", 1, 10, "invalid byte"},
+		{"EmptyByte", ".byte", 1, 1, "at least one value"},
+		{"UndefinedEntry", ".entry start\nhalt", 1, 8, "undefined label"},
+		{"DuplicateEntry", "a: .entry a\n.entry a\nhalt", 2, 1, "already set on line 1"},
+		{"DuplicateConstant", "a: halt\n.equ a, 1", 2, 6, "already defined on line 1"},
+		{"BadConstant", ".equ n, x", 1, 9, "invalid number"},
+		{"ConstantOperands", ".equ n", 1, 1, "takes a name and a value"},
+		{"DataInstruction", ".data\nhalt", 2, 1, "instruction in the data section"},
+		{"DataTooLarge", ".data\n.byte 1, 2, 3, 4\n.byte 5, 6, 7, 8, 9", 3, 1, "does not fit"},
+		{"MacroArguments", ".macro inc reg\naddi reg, 1\n.endm\ninc r1, r2", 4, 1, "takes 1 arguments, not 2"},
+		{"MacroBadArgument", ".macro inc reg\naddi reg, 1\n.endm\n  inc r9", 4, 3, "invalid register"},
+		{"MacroNoEnd", "halt\n.macro inc reg\naddi reg, 1", 2, 1, "has no .endm"},
+		{"MacroNested", ".macro a\n.macro b\n.endm", 2, 1, "can't be nested"},
+		{"MacroEnd", "halt\n.endm", 2, 1, ".endm without .macro"},
+		{"MacroMnemonic", ".macro add a b\n.endm", 1, 8, "name of an instruction"},
+		{"MacroDuplicate", ".macro a\n.endm\n.macro a\n.endm", 3, 8, "already defined on line 1"},
+		{"MacroRecursive", ".macro a\na\n.endm\na", 4, 1, "nested more than"},
+		{"SectionOperands", ".text 1\nhalt", 1, 1, "takes no operands"},
+		{"GlobalName", ".global 1a\nhalt", 1, 1, "takes a name"},
+		{"UndefinedGlobal", ".global f\nhalt", 1, 9, "undefined label"},
+		{"ExternDefined", "f: halt\n.extern f", 2, 9, "already defined on line 1"},
+		{"UnlinkedExtern", ".extern f\ncall f", 2, 6, "is external"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Assemble(test.src)
+			errs, ok := err.(AssembleErrors)
+			if !ok || len(errs) != 1 {
+				t.Fatalf("Expected a single AssembleError, got %v", err)
+			}
+			e := errs[0]
+			if e.Line != test.line || e.Column != test.col || !strings.Contains(e.Msg, test.msg) {
+				t.Fatalf("Expected %d:%d: %s..., got %v", test.line, test.col, test.msg, e)
+			}
+		})
+	}
+}
+
+func TestAssembleReportsAllErrors(t *testing.T) {
+	_, err := Assemble(`
+frob
+load r9, 1
+jump nowhere`)
+	errs, ok := err.(AssembleErrors)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("Expected 3 errors, got %v", err)
+	}
+	for i, line := range []int{2, 3, 4} {
+		if errs[i].Line != line {
+			t.Errorf("Expected error %d on line %d, got %v", i, line, errs[i])
+		}
+	}
+}
+
+func TestNegativeImmediate(t *testing.T) {
+	minus, err := Assemble("li r1, 10\naddi r1, -5\nstore r1, 0\nhalt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := Assemble("li r1, 10\nsubi r1, 5\nstore r1, 0\nhalt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(minus, sub) {
+		t.Fatalf("Expected addi -5 and subi 5 to assemble identically")
+	}
+
+	for _, image := range [][]byte{minus, sub} {
+		if err := vm.NewMachine(image).Run(); err != nil {
+			t.Fatal(err)
+		}
+		if image[0] != 5 {
+			t.Fatalf("Expected 10 - 5 = 5, got %d", image[0])
+		}
+	}
+
+	if _, err := Assemble("subi r1, -0x100"); err == nil {
+		t.Fatalf("Expected out of range negative immediate to fail")
+	}
+}
+
+func TestMacros(t *testing.T) {
+	p, err := Parse(`
+        .macro inc reg
+        addi reg, 1
+        .endm
+        .MACRO twice reg
+        inc reg
+        INC reg
+        .endm
+        load r1, 1
+start:  twice r1
+        store r1, 0
+        halt`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Labels["start"] != 1 {
+		t.Errorf("Expected the label to mark the first line of the expansion, got %d", p.Labels["start"])
+	}
+	image, err := Emit(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		vm.Load, 1, 1,
+		vm.Addi, 1, 1,
+		vm.Addi, 1, 1,
+		vm.Store, 1, 0,
+		vm.Halt,
+	}
+	if !bytes.Equal(image[8:8+len(expected)], expected) {
+		t.Errorf("Expected % x, got % x", expected, image[8:8+len(expected)])
+	}
+}
+
+func TestConstantsAndData(t *testing.T) {
+	image, err := Assemble(`
+        .equ step, 3
+        .equ skip, 1
+        .data
+result: .byte 0
+count:  .byte 10, 20
+        .text
+        load r1, count
+        subi r1, step
+        beqz r1, skip
+        store r1, result
+        halt`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(image[:3], []byte{0, 10, 20}) {
+		t.Errorf("Expected the data region to start 00 0a 14, got % x", image[:3])
+	}
+	expected := []byte{
+		vm.Load, 1, 1,
+		vm.Subi, 1, 3,
+		vm.Beqz, 1, 1,
+		vm.Store, 1, 0,
+		vm.Halt,
+	}
+	if !bytes.Equal(image[8:8+len(expected)], expected) {
+		t.Errorf("Expected % x, got % x", expected, image[8:8+len(expected)])
+	}
+	if err := vm.NewMachine(image).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if image[0] != 7 {
+		t.Errorf("Expected 10 - 3 = 7, got %d", image[0])
+	}
+}
+
+// Count down the value at address 1 with a loop that branches backward,
+// written with a label and with the equivalent numeric offsets
+func TestBackwardBranch(t *testing.T) {
+	var images [][]byte
+	for _, offset := range []string{"loop", "-9", "247"} {
+		image, err := Assemble(fmt.Sprintf(`
+        load r1, 1
+loop:   addi r2, 2
+        subi r1, 1
+        bne r1, %s
+        store r2, 0
+        halt`, offset))
+		if err != nil {
+			t.Fatalf("%s: %v", offset, err)
+		}
+		images = append(images, image)
+	}
+	for i, image := range images {
+		if image[0x13] != 247 {
+			t.Errorf("Expected offset -9 encoded as 247, got %d", image[0x13])
+		}
+		if !bytes.Equal(image, images[0]) {
+			t.Errorf("Image %d differs from the one using a label", i)
+		}
+	}
+
+	memory := append([]byte(nil), images[0]...)
+	memory[1] = 4
+	if err := vm.NewMachine(memory).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if memory[0] != 8 {
+		t.Errorf("Expected 8, got %d", memory[0])
+	}
+	if src := Disassemble(images[0]); !strings.Contains(src, "bne r1, -9") {
+		t.Errorf("Expected the disassembly to show the offset as -9, got\n%s", src)
+	}
+}
+
+func ExampleAssemble() {
+	image, err := Assemble(`
+        load r1, 0x01
+        addi r1, 5
+        store r1, 0
+        halt`)
+	if err != nil {
+		panic(err)
+	}
+	image[1] = 37
+	if err := vm.Compute(image); err != nil {
+		panic(err)
+	}
+	fmt.Printf("% x\n", image[8:19])
+	fmt.Println(image[0])
+	// Output:
+	// 01 01 01 05 01 05 02 01 00 ff 00
+	// 42
+}
+
+func TestCallRet(t *testing.T) {
+	// Sum 1..n recursively, saving n on the stack around each call
+	image, err := Assemble(`
+        load r1, 1
+        li r2, 0
+        call sum
+        store r2, 0
+        halt
+sum:    beqz r1, done
+        push r1
+        subi r1, 1
+        call sum
+        pop r1
+        add r2, r1
+done:   ret`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(n byte) ([]byte, error) {
+		memory := append([]byte(nil), image...)
+		memory[1] = n
+		return memory, vm.Compute(memory)
+	}
+	memory, err := run(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if memory[0] != 15 {
+		t.Fatalf("Expected 1 + ... + 5 = 15, got %d", memory[0])
+	}
+	// each level of recursion takes two bytes of the 16 byte stack
+	if _, err := run(10); !errors.Is(err, vm.ErrStackOverflow) {
+		t.Fatalf("Expected deep recursion to overflow the stack, got %v", err)
+	}
+}
+
+func TestAssembleConfig(t *testing.T) {
+	// 128 bytes, with 16 bytes of data and the code from 0x14
+	config := vm.MachineConfig{MemSize: 128, CodeStart: 0x14, DataEnd: 0x10}
+	src := ".data\n.byte 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0\nin: .byte 42\n.text\nload r1, in\nstore r1, 0\nhalt"
+	image, err := AssembleConfig(src, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image) != 128 || image[0x14] != vm.Load || image[0x16] != 15 {
+		t.Fatalf("Expected a 128 byte image with the load of 15 at 0x14, got % x", image)
+	}
+	var c vm.Coverage
+	if err := vm.NewMachine(image, vm.WithConfig(config), vm.WithCoverage(&c)).Run(); err != nil || image[0] != 42 {
+		t.Fatalf("Expected 42 copied to 0, got %d (%v)", image[0], err)
+	}
+
+	text := DisassembleConfig(image, config)
+	if again, err := AssembleConfig(text, config); err != nil || !bytes.Equal(again[0x10:], image[0x10:]) {
+		t.Fatalf("Expected the disassembly to assemble back into the code, got %v\n%s", err, text)
+	}
+	if report := CoverageReportConfig(image, &c, config); !strings.HasPrefix(report, "    1   14: load r1, 15\n") {
+		t.Fatalf("Expected the report to start at 0x14, got\n%s", report)
+	}
+
+	if _, err := AssembleConfig(src, vm.MachineConfig{DataEnd: 15}); err == nil || !strings.Contains(err.Error(), "does not fit in the 15 byte data region") {
+		t.Fatalf("Expected the data not to fit a smaller region, got %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+
+	"vm"
+)
+
+// CoverageReport disassembles memory as Disassemble does, with each
+// instruction preceded by the number of times c recorded it executing,
+// or by ##### if it never did, and followed by a summary:
+//
+//	    1   08: load r1, 1
+//	    4   0b: beqz r1, 8
+//	    1   19: halt
+//	#####   1a: halt
+//	; 7 of 8 instructions executed (87.5%)
+//
+// Bytes laid out with .byte among the instructions are disassembled,
+// and reported as not executed, like the rest.
+func CoverageReport(memory []byte, c *vm.Coverage) string {
+	return CoverageReportConfig(memory, c, vm.DefaultConfig)
+}
+
+// CoverageReportConfig is like CoverageReport, but for an image laid
+// out for a machine configured with vm.WithConfig(config), starting
+// from config.CodeStart as DisassembleConfig does.
+func CoverageReportConfig(memory []byte, c *vm.Coverage, config vm.MachineConfig) string {
+	start := config.Complete().CodeStart
+	end := len(memory)
+	for end > start && memory[end-1] == 0 {
+		end--
+	}
+
+	var b strings.Builder
+	var total, executed int
+	for pc := start; pc < end; {
+		op, ok := vm.LookupOp(memory[pc])
+		width := op.Width()
+		text := ""
+		switch {
+		case !ok:
+			text, width = fmt.Sprintf(".byte %d", memory[pc]), 1
+		case pc+width > len(memory):
+			text, width = "truncated "+op.Mnemonic, len(memory)-pc
+		default:
+			text = formatInstruction(op, memory[pc+1:pc+width])
+		}
+		count := "#####"
+		if hits := c.Hits[pc]; hits > 0 {
+			count = fmt.Sprint(hits)
+			executed++
+		}
+		total++
+		fmt.Fprintf(&b, "%5s   %02x: %s\n", count, pc, text)
+		pc += width
+	}
+
+	percent := 0.0
+	if total > 0 {
+		percent = 100 * float64(executed) / float64(total)
+	}
+	fmt.Fprintf(&b, "; %d of %d instructions executed (%.1f%%)\n", executed, total, percent)
+	return b.String()
+}
@@ -0,0 +1,33 @@
+package asm
+
+import (
+	"testing"
+
+	"vm"
+)
+
+func TestCoverageReport(t *testing.T) {
+	// nothing reaches the second halt
+	image, err := Assemble(sumSrc + "\n        halt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image[1] = 3
+	var c vm.Coverage
+	if err := vm.NewMachine(image, vm.WithCoverage(&c)).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "    1   08: load r1, 1\n" +
+		"    4   0b: beqz r1, 8\n" +
+		"    3   0e: add r2, r1\n" +
+		"    3   11: subi r1, 1\n" +
+		"    3   14: jump 11\n" +
+		"    1   16: store r2, 0\n" +
+		"    1   19: halt\n" +
+		"#####   1a: halt\n" +
+		"; 7 of 8 instructions executed (87.5%)\n"
+	if got := CoverageReport(image, &c); got != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, got)
+	}
+}
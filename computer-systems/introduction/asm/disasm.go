@@ -0,0 +1,75 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+
+	"vm"
+)
+
+// Disassemble renders the instructions in a memory image as assembly
+// source that Assemble translates back into the same image. It starts
+// at the first instruction after the default data region and stops
+// after the last nonzero byte. DisassembleConfig does the same for
+// other layouts.
+//
+// The source is annotated with comments, which Assemble ignores: a hex
+// dump of the data region comes first, and each instruction is followed
+// by its address and encoding.
+//
+//	; data 00: 00 2a 00 00 00 00 00 00
+//		load r1, 1          ; 08: 01 01 01
+func Disassemble(memory []byte) string {
+	return DisassembleConfig(memory, vm.DefaultConfig)
+}
+
+// DisassembleConfig is like Disassemble, but for an image laid out for
+// a machine configured with vm.WithConfig(c), as AssembleConfig lays
+// it out: the data region is dumped up to c.DataEnd, and the code
+// starts at c.CodeStart.
+func DisassembleConfig(memory []byte, c vm.MachineConfig) string {
+	c = c.Complete()
+	end := len(memory)
+	for end > c.CodeStart && memory[end-1] == 0 {
+		end--
+	}
+
+	var b strings.Builder
+	if len(memory) >= c.DataEnd {
+		fmt.Fprintf(&b, "; data 00: % x\n", memory[:c.DataEnd])
+	}
+	for pc := c.CodeStart; pc < end; {
+		op, ok := vm.LookupOp(memory[pc])
+		if !ok {
+			fmt.Fprintf(&b, "\t; %02x: unknown opcode %#02x\n", pc, memory[pc])
+			pc++
+			continue
+		}
+		if pc+op.Width() > len(memory) {
+			fmt.Fprintf(&b, "\t; %02x: truncated %s\n", pc, op.Mnemonic)
+			break
+		}
+		text := formatInstruction(op, memory[pc+1:pc+op.Width()])
+		fmt.Fprintf(&b, "\t%-20s; %02x: % x\n", text, pc, memory[pc:pc+op.Width()])
+		pc += op.Width()
+	}
+	return b.String()
+}
+
+func formatInstruction(op vm.OpInfo, operands []byte) string {
+	args := make([]string, len(operands))
+	for i, kind := range op.Operands {
+		switch kind {
+		case vm.RegOperand:
+			args[i] = fmt.Sprintf("r%d", operands[i])
+		case vm.OffsetOperand:
+			args[i] = fmt.Sprintf("%d", int8(operands[i]))
+		default:
+			args[i] = fmt.Sprintf("%d", operands[i])
+		}
+	}
+	if len(args) == 0 {
+		return op.Mnemonic
+	}
+	return op.Mnemonic + " " + strings.Join(args, ", ")
+}
@@ -0,0 +1,122 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"vm"
+)
+
+func TestDisassemble(t *testing.T) {
+	image, err := Assemble(`
+        load r1, 1
+loop:   beqz r1, done
+        add r2, r1
+        addi r1, -1
+        jump loop
+done:   store r2, 0
+        halt`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image[1] = 5
+	expected := "; data 00: 00 05 00 00 00 00 00 00\n" +
+		"\tload r1, 1          ; 08: 01 01 01\n" +
+		"\tbeqz r1, 8          ; 0b: 08 01 08\n" +
+		"\tadd r2, r1          ; 0e: 03 02 01\n" +
+		"\tsubi r1, 1          ; 11: 06 01 01\n" +
+		"\tjump 11             ; 14: 07 0b\n" +
+		"\tstore r2, 0         ; 16: 02 02 00\n" +
+		"\thalt                ; 19: ff\n"
+	src := Disassemble(image)
+	if src != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, src)
+	}
+
+	again, err := Assemble(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(image[vm.DefaultDataSize:], again[vm.DefaultDataSize:]) {
+		t.Fatalf("Disassembly did not round trip")
+	}
+
+	// bytes that aren't instructions are still located
+	image[0x1a] = 0xee
+	if src := Disassemble(image); !strings.HasSuffix(src, "\t; 1a: unknown opcode 0xee\n") {
+		t.Fatalf("Expected the unknown opcode to be annotated, got\n%s", src)
+	}
+}
+
+// Generate a program using ops, in order then at random until the
+// image is full, as both source and the image it should assemble to.
+// Immediates of addi and subi are sometimes written as negative
+// numbers, which the assembler canonicalizes to the opposite op.
+func randomProgram(r *rand.Rand, ops []vm.OpInfo) (string, []byte) {
+	var src strings.Builder
+	image := make([]byte, vm.MemorySize)
+	pc := vm.DefaultDataSize
+	for i := 0; ; i++ {
+		op := ops[r.Intn(len(ops))]
+		if i < len(ops) {
+			op = ops[i]
+		}
+		if pc+op.Width() > len(image) {
+			break
+		}
+
+		code := op.Code
+		args := make([]string, len(op.Operands))
+		for j, kind := range op.Operands {
+			var val int
+			if kind == vm.RegOperand {
+				val = 1 + r.Intn(vm.MaxRegister)
+				args[j] = fmt.Sprintf("r%d", val)
+			} else {
+				val = r.Intn(256)
+				args[j] = fmt.Sprintf("%d", val)
+				if kind == vm.ImmOperand && (code == vm.Addi || code == vm.Subi) && val > 0 && r.Intn(2) == 0 {
+					args[j] = fmt.Sprintf("%d", -val)
+					code = negated(code)
+				}
+			}
+			image[pc+1+j] = byte(val)
+		}
+		image[pc] = code
+		fmt.Fprintf(&src, "%s %s\n", op.Mnemonic, strings.Join(args, ", "))
+		pc += op.Width()
+	}
+	return src.String(), image
+}
+
+// Assembling generated source, then disassembling and reassembling
+// the result, should reproduce the expected image both times
+func FuzzRoundTrip(f *testing.F) {
+	for seed := int64(0); seed < 8; seed++ {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		src, expected := randomProgram(rand.New(rand.NewSource(seed)), vm.Ops())
+
+		image, err := Assemble(src)
+		if err != nil {
+			t.Fatalf("%v\n%s", err, src)
+		}
+		if !bytes.Equal(image, expected) {
+			t.Fatalf("Source assembled incorrectly:\n%s", src)
+		}
+
+		text := Disassemble(image)
+		again, err := Assemble(text)
+		if err != nil {
+			t.Fatalf("Disassembly failed to assemble: %v\n%s", err, text)
+		}
+		if !bytes.Equal(again, expected) {
+			t.Fatalf("Disassembly did not round trip:\n%s", text)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Link combines separately parsed objects into one Program, for Emit to
+// lay out in a single memory image. Their instructions are placed one
+// after another in the order given, and their data sections likewise
+// from address 0. Labels exported with .global are visible to every
+// object, and resolve each name imported with .extern; all other names
+// stay local to the object that defines them. At most one object may
+// name an entry point, and without one execution starts at the first
+// object's first instruction. The objects must all be parsed for the
+// same machine layout, which the linked Program keeps.
+//
+// Only label operands are relocated: an object that refers to its own
+// code or data by a numeric address will find something else there once
+// linked.
+func Link(objects ...Program) (Program, error) {
+	linked := Program{Labels: map[string]int{}, Constants: map[string]int{}, DataLabels: map[string]int{}}
+	owner := map[string]int{}
+	for i, obj := range objects {
+		if i == 0 {
+			linked.Config = obj.Config
+		} else if obj.Config.Complete() != linked.Config.Complete() {
+			return Program{}, fmt.Errorf("object %d: laid out for a different machine than object 0", i)
+		}
+		for _, name := range obj.Globals {
+			if prev, ok := owner[name]; ok {
+				return Program{}, fmt.Errorf("global %q defined by objects %d and %d", name, prev, i)
+			}
+			owner[name] = i
+		}
+	}
+
+	for i, obj := range objects {
+		globals := map[string]bool{}
+		for _, name := range obj.Globals {
+			globals[name] = true
+		}
+		// Local names get a suffix that no identifier can contain, so
+		// that they can't collide with those of other objects
+		rename := func(name string) string {
+			if globals[name] {
+				return name
+			}
+			return name + "." + strconv.Itoa(i)
+		}
+		names := map[string]string{}
+		for name, index := range obj.Labels {
+			names[name] = rename(name)
+			linked.Labels[names[name]] = len(linked.Instructions) + index
+		}
+		for name, addr := range obj.DataLabels {
+			names[name] = rename(name)
+			linked.DataLabels[names[name]] = len(linked.Data) + addr
+		}
+		for name, val := range obj.Constants {
+			names[name] = rename(name)
+			linked.Constants[names[name]] = val
+		}
+		for _, name := range obj.Externs {
+			if _, ok := owner[name]; !ok {
+				return Program{}, fmt.Errorf("object %d: undefined external %q", i, name)
+			}
+			names[name] = name
+		}
+
+		if obj.Entry != "" {
+			if linked.Entry != "" {
+				return Program{}, fmt.Errorf("object %d: entry point already set by another object", i)
+			}
+			linked.Entry = names[obj.Entry]
+		}
+		for _, inst := range obj.Instructions {
+			inst.Operands = append([]Operand(nil), inst.Operands...)
+			for j := range inst.Operands {
+				if name, ok := names[inst.Operands[j].Label]; ok {
+					inst.Operands[j].Label = name
+				}
+			}
+			linked.Instructions = append(linked.Instructions, inst)
+		}
+		linked.Data = append(linked.Data, obj.Data...)
+	}
+	if dataEnd := linked.Config.Complete().DataEnd; len(linked.Data) > dataEnd {
+		return Program{}, fmt.Errorf("data does not fit in the %d byte data region", dataEnd)
+	}
+
+	var errs AssembleErrors
+	addrs := linked.layout()
+	for i := range linked.Instructions {
+		linked.Instructions[i].Addr = addrs[i]
+	}
+	linked.resolve(addrs, false, &errs)
+	return linked, errs.err()
+}
@@ -0,0 +1,100 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+
+	"vm"
+)
+
+// Double the value at address 1 in a library, with a result slot and a
+// loop label of its own that the main object mustn't see
+const (
+	mainObj = `
+        .extern double
+        .extern result
+        .entry start
+        .data
+        .byte 0, 0
+        .text
+loop:   halt
+start:  load r1, 1
+        call double
+        store r2, result
+        jump loop`
+	libObj = `
+        .global double
+        .global result
+        .equ step, 1
+        .data
+result: .byte 0
+        .text
+double: li r2, 0
+loop:   beqz r1, done
+        addi r2, 2
+        subi r1, step
+        jump loop
+done:   ret`
+)
+
+func parseObjects(t *testing.T, srcs ...string) []Program {
+	var objects []Program
+	for _, src := range srcs {
+		p, err := Parse(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, p)
+	}
+	return objects
+}
+
+func TestLink(t *testing.T) {
+	linked, err := Link(parseObjects(t, mainObj, libObj)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked.DataLabels["result"] != 2 {
+		t.Errorf("Expected the library's data after main's, at 2, got %d", linked.DataLabels["result"])
+	}
+	image, err := Emit(linked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image[1] = 21
+	m := vm.NewMachine(image, vm.WithEntryPoint(linked.EntryPoint()))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if image[2] != 42 {
+		t.Errorf("Expected 21 * 2 = 42, got %d", image[2])
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		srcs []string
+		msg  string
+	}{
+		{"Undefined", []string{mainObj}, `undefined external "double"`},
+		{"DuplicateGlobal", []string{libObj, libObj}, `global "double" defined by objects 0 and 1`},
+		{"DuplicateEntry", []string{mainObj, ".entry a\na: halt", libObj}, "entry point already set"},
+		{"DataTooLarge", []string{libObj, ".data\n.byte 1, 2, 3, 4, 5, 6, 7, 8"}, "does not fit"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Link(parseObjects(t, test.srcs...)...)
+			if err == nil || !strings.Contains(err.Error(), test.msg) {
+				t.Fatalf("Expected an error containing %q, got %v", test.msg, err)
+			}
+		})
+	}
+
+	// the objects must be parsed for the same layout
+	objects := parseObjects(t, mainObj, libObj)
+	objects[1].Config = vm.MachineConfig{DataEnd: 16}
+	if _, err := Link(objects...); err == nil || !strings.Contains(err.Error(), "different machine") {
+		t.Fatalf("Expected objects for different layouts to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package asm
+
+import (
+	"strings"
+
+	"vm"
+)
+
+// How deeply macros may invoke other macros, which stops a macro that
+// invokes itself
+const maxMacroDepth = 16
+
+type macro struct {
+	name   string
+	params []string
+	body   [][]token
+	line   int
+}
+
+// Split src into lines of tokens, with macro definitions removed and
+// every invocation replaced by the macro's body. Expanded tokens take
+// the position of the invocation, so that problems in them are reported
+// where the macro was used.
+func expandMacros(src string, errs *AssembleErrors) [][]token {
+	macros := map[string]*macro{}
+	var lines [][]token
+	var def *macro
+
+	for i, text := range strings.Split(src, "\n") {
+		toks := tokenize(text, i+1)
+		directive := ""
+		if len(toks) > 0 {
+			directive = strings.ToLower(toks[0].text)
+		}
+		switch {
+		case directive == ".macro" && def != nil:
+			errs.add(toks[0].line, toks[0].col, "macro definitions can't be nested; %q is still open from line %d", def.name, def.line)
+		case directive == ".macro":
+			def = defineMacro(toks, macros, errs)
+			if def == nil {
+				// skip the body, so as not to report errors in it
+				def = &macro{line: toks[0].line}
+			}
+		case directive == ".endm" && def == nil:
+			errs.add(toks[0].line, toks[0].col, ".endm without .macro")
+		case directive == ".endm":
+			if def.name != "" {
+				macros[strings.ToLower(def.name)] = def
+			}
+			def = nil
+		case def != nil:
+			if len(toks) > 0 {
+				def.body = append(def.body, toks)
+			}
+		default:
+			lines = expand(lines, toks, macros, 0, errs)
+		}
+	}
+	if def != nil {
+		errs.add(def.line, 1, "macro %q has no .endm", def.name)
+	}
+	return lines
+}
+
+// Parse the .macro line that starts a definition
+func defineMacro(toks []token, macros map[string]*macro, errs *AssembleErrors) *macro {
+	if len(toks) < 2 || !isIdent(toks[1].text) {
+		errs.add(toks[0].line, toks[0].col, ".macro takes a name and its parameters")
+		return nil
+	}
+	name := toks[1]
+	if _, ok := vm.LookupMnemonic(name.text); ok {
+		errs.add(name.line, name.col, "macro %q has the name of an instruction", name.text)
+		return nil
+	}
+	if prev, ok := macros[strings.ToLower(name.text)]; ok {
+		errs.add(name.line, name.col, "macro %q already defined on line %d", name.text, prev.line)
+		return nil
+	}
+	m := &macro{name: name.text, line: name.line}
+	for _, tok := range toks[2:] {
+		if !isIdent(tok.text) {
+			errs.add(tok.line, tok.col, "invalid macro parameter %q", tok.text)
+			return nil
+		}
+		m.params = append(m.params, tok.text)
+	}
+	return m
+}
+
+// Append the line toks to lines, expanding it if it invokes a macro
+func expand(lines [][]token, toks []token, macros map[string]*macro, depth int, errs *AssembleErrors) [][]token {
+	i := 0
+	if len(toks) > 0 && strings.HasSuffix(toks[0].text, ":") {
+		i = 1
+	}
+	if i == len(toks) {
+		return append(lines, toks)
+	}
+	m, ok := macros[strings.ToLower(toks[i].text)]
+	if !ok {
+		return append(lines, toks)
+	}
+
+	at := toks[i]
+	if depth == maxMacroDepth {
+		errs.add(at.line, at.col, "macros nested more than %d deep expanding %q", maxMacroDepth, m.name)
+		return lines
+	}
+	args := toks[i+1:]
+	if len(args) != len(m.params) {
+		errs.add(at.line, at.col, "macro %s takes %d arguments, not %d", m.name, len(m.params), len(args))
+		return lines
+	}
+	if i == 1 {
+		// the label marks the first line of the expansion
+		lines = append(lines, toks[:1])
+	}
+	for _, body := range m.body {
+		line := make([]token, len(body))
+		for j, tok := range body {
+			line[j] = token{tok.text, at.line, at.col}
+			for k, param := range m.params {
+				if tok.text == param {
+					line[j].text = args[k].text
+				}
+			}
+		}
+		lines = expand(lines, line, macros, depth+1, errs)
+	}
+	return lines
+}
@@ -0,0 +1,185 @@
+package asm
+
+import (
+	"fmt"
+
+	"vm"
+)
+
+// Optimize returns a copy of p with these peephole rules applied:
+//
+//   - adding or subtracting 0 is removed
+//   - consecutive addi/subi of the same register are combined, as long
+//     as the total fits in a byte and nothing branches between them
+//   - instructions that can never execute are removed
+//
+// The first two rules change the flags that addi and subi leave behind,
+// so they are skipped for programs that read the flags, by branching
+// on them or with adc or sbb.
+//
+// Jump and branch targets given as numbers are converted to labels so
+// that they follow their instructions as code is removed. If a target
+// doesn't fall on an instruction, the program is returned unchanged,
+// since there is no way to tell where it should move to. Programs with
+// an .entry or .byte directive are also returned unchanged, as the
+// reachability analysis assumes execution starts at the first
+// instruction and that everything after it is code. Nor is dead code
+// removed from programs with sti or iret, since their interrupt
+// handlers are entered through the vector table rather than a jump,
+// and so look unreachable.
+func Optimize(p Program) Program {
+	if p.Entry != "" {
+		return p
+	}
+	for _, inst := range p.Instructions {
+		if inst.Data != nil {
+			return p
+		}
+	}
+	p, ok := labelTargets(p)
+	if !ok {
+		return p
+	}
+	p = foldImmediates(p)
+
+	if handlesInterrupts(p) {
+		return p
+	}
+	if image, err := Emit(p); err == nil {
+		dead := map[int]bool{}
+		for _, addr := range vm.AnalyzeReachability(image, vm.WithConfig(p.Config.Complete())) {
+			dead[addr] = true
+		}
+		addrs := p.layout()
+		keep := make([]bool, len(p.Instructions))
+		for i := range keep {
+			keep[i] = !dead[addrs[i]]
+		}
+		p = removeInstructions(p, keep)
+	}
+	return p
+}
+
+// Whether p enables interrupts or returns from a handler
+func handlesInterrupts(p Program) bool {
+	for _, inst := range p.Instructions {
+		if inst.Op.Code == vm.Sti || inst.Op.Code == vm.Iret {
+			return true
+		}
+	}
+	return false
+}
+
+// Copy p, replacing every numeric jump or branch target with a label
+// for the instruction it points at
+func labelTargets(p Program) (Program, bool) {
+	addrs := p.layout()
+	index := map[int]int{}
+	for i, addr := range addrs {
+		index[addr] = i
+	}
+
+	q := Program{Labels: map[string]int{}, Constants: p.Constants, DataLabels: p.DataLabels,
+		Data: p.Data, Globals: p.Globals, Externs: p.Externs}
+	for name, i := range p.Labels {
+		q.Labels[name] = i
+	}
+	for i, inst := range p.Instructions {
+		inst.Operands = append([]Operand(nil), inst.Operands...)
+		for j, kind := range inst.Op.Operands {
+			operand := &inst.Operands[j]
+			isTarget := ((inst.Op.Flow == vm.FlowJump || inst.Op.Flow == vm.FlowCall) && kind == vm.AddrOperand) ||
+				(inst.Op.Flow == vm.FlowBranch && kind == vm.OffsetOperand)
+			// a constant or data address target is a number like any other
+			_, isConstant := p.Constants[operand.Label]
+			_, isData := p.DataLabels[operand.Label]
+			if !isTarget || operand.Label != "" && !isConstant && !isData {
+				continue
+			}
+			target := operand.Value
+			if kind == vm.OffsetOperand {
+				target = int(byte(target + addrs[i+1]))
+			}
+			k, ok := index[target]
+			if !ok {
+				return p, false
+			}
+			// these names can't clash with labels from the source
+			operand.Label = fmt.Sprintf("@%d", target)
+			q.Labels[operand.Label] = k
+		}
+		q.Instructions = append(q.Instructions, inst)
+	}
+	return q, true
+}
+
+// The signed amount an addi or subi adds to its register
+func immediateDelta(inst Instruction) (int, bool) {
+	if inst.Op.Code != vm.Addi && inst.Op.Code != vm.Subi || inst.Operands[1].Label != "" {
+		return 0, false
+	}
+	if inst.Op.Code == vm.Subi {
+		return -inst.Operands[1].Value, true
+	}
+	return inst.Operands[1].Value, true
+}
+
+func foldImmediates(p Program) Program {
+	for _, inst := range p.Instructions {
+		if inst.Op.ReadsFlags() {
+			return p
+		}
+	}
+
+	targets := map[int]bool{}
+	for _, i := range p.Labels {
+		targets[i] = true
+	}
+
+	keep := make([]bool, len(p.Instructions))
+	for i := range p.Instructions {
+		keep[i] = true
+		inst := &p.Instructions[i]
+		delta, ok := immediateDelta(*inst)
+		if !ok {
+			continue
+		}
+		// absorb the previous instruction if it adjusted the same
+		// register and nothing can branch in between
+		if prev := i - 1; prev >= 0 && keep[prev] && !targets[i] {
+			before := p.Instructions[prev]
+			if d, ok := immediateDelta(before); ok && before.Operands[0].Value == inst.Operands[0].Value {
+				if sum := d + delta; sum >= -0xff && sum <= 0xff {
+					keep[prev] = false
+					*inst = before
+					inst.Op, _ = vm.LookupOp(vm.Addi)
+					inst.Operands = []Operand{before.Operands[0], {Value: sum, Line: before.Operands[1].Line, Column: before.Operands[1].Column}}
+					delta = sum
+				}
+			}
+		}
+		if delta == 0 {
+			keep[i] = false
+		}
+	}
+	return removeInstructions(p, keep)
+}
+
+// Drop the instructions not marked to keep, moving each label to the
+// next instruction that remains
+func removeInstructions(p Program, keep []bool) Program {
+	newIndex := make([]int, len(p.Instructions)+1)
+	q := Program{Labels: map[string]int{}, Constants: p.Constants, DataLabels: p.DataLabels,
+		Data: p.Data, Globals: p.Globals, Externs: p.Externs}
+	for i, inst := range p.Instructions {
+		newIndex[i] = len(q.Instructions)
+		if keep[i] {
+			q.Instructions = append(q.Instructions, inst)
+		}
+	}
+	newIndex[len(p.Instructions)] = len(q.Instructions)
+	for name, i := range p.Labels {
+		q.Labels[name] = newIndex[i]
+	}
+	return q
+}
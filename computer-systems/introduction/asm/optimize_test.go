@@ -0,0 +1,138 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"vm"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{
+			name:     "ZeroImmediate",
+			src:      "load r1, 1\naddi r1, 0\nsubi r2, 0\nstore r1, 0\nhalt",
+			expected: "load r1, 1\nstore r1, 0\nhalt",
+		},
+		{
+			name:     "CombineImmediates",
+			src:      "load r1, 1\naddi r1, 3\naddi r1, 4\nsubi r1, 2\naddi r2, 1\nstore r1, 0\nhalt",
+			expected: "load r1, 1\naddi r1, 5\naddi r2, 1\nstore r1, 0\nhalt",
+		},
+		{
+			name:     "CombineToNothing",
+			src:      "load r1, 1\naddi r1, 3\nsubi r1, 3\nstore r1, 0\nhalt",
+			expected: "load r1, 1\nstore r1, 0\nhalt",
+		},
+		{
+			name:     "TooLargeToCombine",
+			src:      "load r1, 1\naddi r1, 200\naddi r1, 100\nstore r1, 0\nhalt",
+			expected: "load r1, 1\naddi r1, 200\naddi r1, 100\nstore r1, 0\nhalt",
+		},
+		{
+			// the second addi is a branch target, so can't be merged
+			name:     "BranchBetween",
+			src:      "load r1, 1\nbeqz r1, two\naddi r1, 1\ntwo: addi r1, 1\nstore r1, 0\nhalt",
+			expected: "load r1, 1\nbeqz r1, 3\naddi r1, 1\naddi r1, 1\nstore r1, 0\nhalt",
+		},
+		{
+			// dropping the addi of 0 would change the flags bc reads
+			name:     "FlagsRead",
+			src:      "load r1, 1\naddi r1, 200\naddi r1, 0\nbc 0\nstore r1, 0\nhalt",
+			expected: "load r1, 1\naddi r1, 200\naddi r1, 0\nbc 0\nstore r1, 0\nhalt",
+		},
+		{
+			// and so would dropping it before an adc, which adds the carry
+			name:     "CarryRead",
+			src:      "li r1, 200\naddi r1, 100\nli r2, 0\naddi r2, 0\nli r3, 0\nli r4, 0\nadc r3, r4\nstore r3, 0\nhalt",
+			expected: "li r1, 200\naddi r1, 100\nli r2, 0\naddi r2, 0\nli r3, 0\nli r4, 0\nadc r3, r4\nstore r3, 0\nhalt",
+		},
+		{
+			// the numeric jump and branch targets move along with
+			// their instructions once the dead code is gone
+			name:     "Unreachable",
+			src:      "load r1, 1\njump 14\nhalt\nli r2, 1\nbeqz r1, 3\nstore r1, 0\nhalt\naddi r1, 1",
+			expected: "load r1, 1\njump 13\nli r2, 1\nbeqz r1, 3\nstore r1, 0\nhalt",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := Parse(test.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			optimized, err := Emit(Optimize(p))
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, err := Assemble(test.expected)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(optimized, expected) {
+				t.Fatalf("Expected\n%s\ngot\n%s", Disassemble(expected), Disassemble(optimized))
+			}
+
+			// and the program still computes the same thing
+			original, err := Assemble(test.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, input := range []byte{0, 1, 2, 128, 255} {
+				a := append([]byte(nil), original...)
+				b := append([]byte(nil), optimized...)
+				a[1], b[1] = input, input
+				errA, errB := vm.NewMachine(a).Run(), vm.NewMachine(b).Run()
+				if errA != nil || errB != nil {
+					t.Fatalf("Runs failed: %v, %v", errA, errB)
+				}
+				if !bytes.Equal(a[:vm.DefaultDataSize], b[:vm.DefaultDataSize]) {
+					t.Fatalf("Input %d: original produced % x, optimized % x", input, a[:8], b[:8])
+				}
+			}
+		})
+	}
+}
+
+func TestOptimizeLeavesUntrackableTargets(t *testing.T) {
+	for _, src := range []string{
+		// jumping into the middle of the li makes it impossible to move code
+		"jump 9\nli r1, 0\nhalt\nhalt",
+		// the data and the code after the entry point look unreachable
+		".entry start\n.byte 1\nstart: addi r1, 0\nhalt",
+	} {
+		p, err := Parse(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q := Optimize(p); len(q.Instructions) != len(p.Instructions) {
+			t.Fatalf("Expected the program to be left alone:\n%s", src)
+		}
+	}
+}
+
+func TestOptimizeKeepsInterruptHandlers(t *testing.T) {
+	// the handler at 0xc, counting the timer's interrupts, is only
+	// reached through the vector table at 1
+	p, err := Parse("sti\nloop: bnz loop\nhalt\nhandler: addi r2, 1\nstore r2, 0\niret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := Emit(Optimize(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	image[1] = 0xc
+	err = vm.NewMachine(image, vm.WithVectorTable(1), vm.WithTimer(5), vm.WithCycleLimit(50)).Run()
+	if !errors.Is(err, vm.ErrCycleLimit) {
+		t.Fatalf("Expected the program to loop until the cycle limit, got %v", err)
+	}
+	if image[0] == 0 {
+		t.Fatal("Expected the handler to run")
+	}
+}
@@ -0,0 +1,413 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"vm"
+)
+
+// A Program is parsed assembly source: the instructions in the order
+// they are laid out in memory, with their operands resolved.
+type Program struct {
+	Instructions []Instruction
+	// Labels maps each label to the index of the instruction it marks.
+	// A label after the last instruction maps to len(Instructions).
+	Labels map[string]int
+	// Entry is the label named by an .entry directive, if any.
+	Entry string
+	// Constants maps each name defined with .equ to its value.
+	Constants map[string]int
+	// DataLabels maps each label in the data section to its address.
+	DataLabels map[string]int
+	// Data holds the bytes laid out in the data section, which Emit
+	// copies to the start of the data region. Parse makes sure they fit
+	// in it.
+	Data []byte
+	// Globals lists the labels exported with .global, and Externs the
+	// names imported with .extern, for Link to match up.
+	Globals, Externs []string
+	// Config is the layout of the machine the program is for, with any
+	// field left as zero taking its default, as for vm.WithConfig. Emit
+	// lays out the code from its CodeStart.
+	Config vm.MachineConfig
+}
+
+// An Instruction is a single instruction of a Program.
+type Instruction struct {
+	Op       vm.OpInfo
+	Operands []Operand
+	// Data holds the bytes of a .byte directive, which takes the place
+	// of an instruction in the layout and has no Op.
+	Data []byte
+	// Addr is where the instruction was laid out by Parse; Emit
+	// recomputes it, so it need not be updated when editing a Program.
+	Addr         int
+	Line, Column int
+	// File names the source the instruction came from, if it is set,
+	// so that the debug info of a linked Program can tell its objects
+	// apart. Parse leaves it empty.
+	File string
+}
+
+// An Operand is an instruction operand. Value holds the register
+// number, address, immediate or signed branch offset it denotes.
+// Operands that refer to a label or constant also record its name, and
+// Emit resolves them again from the final layout. A constant stands for
+// its value as written, even as the operand of a branch.
+type Operand struct {
+	Value        int
+	Label        string
+	Line, Column int
+}
+
+type labelDef struct {
+	index int
+	line  int
+}
+
+// Parse translates src into a Program without encoding it. Problems
+// are reported as an AssembleErrors, as for Assemble.
+func Parse(src string) (Program, error) {
+	return ParseConfig(src, vm.DefaultConfig)
+}
+
+// ParseConfig is like Parse, but for a machine configured with
+// vm.WithConfig(c).
+func ParseConfig(src string, c vm.MachineConfig) (Program, error) {
+	var errs AssembleErrors
+	p := Program{Labels: map[string]int{}, Constants: map[string]int{}, DataLabels: map[string]int{}, Config: c}
+	dataEnd := c.Complete().DataEnd
+	defs := map[string]labelDef{}
+	var operandToks [][]token
+	var entry token
+	inData := false
+	var globals []token
+	// Check that a name for a label or constant is valid and new
+	define := func(tok token, name string) bool {
+		if !isIdent(name) {
+			errs.add(tok.line, tok.col, "invalid label name %q", name)
+			return false
+		}
+		if prev, ok := defs[name]; ok {
+			errs.add(tok.line, tok.col, "label %q already defined on line %d", name, prev.line)
+			return false
+		}
+		defs[name] = labelDef{len(p.Instructions), tok.line}
+		return true
+	}
+
+	for _, toks := range expandMacros(src, &errs) {
+		if len(toks) > 0 && strings.HasSuffix(toks[0].text, ":") {
+			tok := toks[0]
+			name := strings.TrimSuffix(tok.text, ":")
+			if define(tok, name) {
+				if inData {
+					p.DataLabels[name] = len(p.Data)
+				} else {
+					p.Labels[name] = len(p.Instructions)
+				}
+			}
+			toks = toks[1:]
+		}
+		if len(toks) == 0 {
+			continue
+		}
+
+		switch strings.ToLower(toks[0].text) {
+		case ".entry":
+			if p.Entry != "" {
+				errs.add(toks[0].line, toks[0].col, "entry point already set on line %d", entry.line)
+			} else if len(toks) != 2 || !isIdent(toks[1].text) {
+				errs.add(toks[0].line, toks[0].col, ".entry takes a label")
+			} else {
+				p.Entry, entry = toks[1].text, toks[1]
+			}
+			continue
+		case ".byte":
+			if len(toks) == 1 {
+				errs.add(toks[0].line, toks[0].col, ".byte takes at least one value")
+				continue
+			}
+			data := make([]byte, 0, len(toks)-1)
+			for _, tok := range toks[1:] {
+				n, err := strconv.ParseUint(tok.text, 0, 8)
+				if err != nil {
+					errs.add(tok.line, tok.col, "invalid byte %q", tok.text)
+				}
+				data = append(data, byte(n))
+			}
+			if inData {
+				if len(p.Data) <= dataEnd && len(p.Data)+len(data) > dataEnd {
+					errs.add(toks[0].line, toks[0].col, "data does not fit in the %d byte data region", dataEnd)
+				}
+				p.Data = append(p.Data, data...)
+				continue
+			}
+			p.Instructions = append(p.Instructions, Instruction{Data: data, Line: toks[0].line, Column: toks[0].col})
+			operandToks = append(operandToks, nil)
+			continue
+		case ".equ":
+			if len(toks) != 3 {
+				errs.add(toks[0].line, toks[0].col, ".equ takes a name and a value")
+				continue
+			}
+			n, err := strconv.ParseInt(toks[2].text, 0, 0)
+			if err != nil {
+				errs.add(toks[2].line, toks[2].col, "invalid number %q", toks[2].text)
+			}
+			if define(toks[1], toks[1].text) {
+				p.Constants[toks[1].text] = int(n)
+			}
+			continue
+		case ".global", ".extern":
+			if len(toks) != 2 || !isIdent(toks[1].text) {
+				errs.add(toks[0].line, toks[0].col, "%s takes a name", toks[0].text)
+			} else if strings.ToLower(toks[0].text) == ".global" {
+				p.Globals = append(p.Globals, toks[1].text)
+				globals = append(globals, toks[1])
+			} else if define(toks[1], toks[1].text) {
+				p.Externs = append(p.Externs, toks[1].text)
+			}
+			continue
+		case ".data", ".text":
+			if len(toks) != 1 {
+				errs.add(toks[0].line, toks[0].col, "%s takes no operands", toks[0].text)
+			}
+			inData = strings.ToLower(toks[0].text) == ".data"
+			continue
+		}
+		if strings.HasPrefix(toks[0].text, ".") {
+			errs.add(toks[0].line, toks[0].col, "unknown directive %q", toks[0].text)
+			continue
+		}
+		if inData {
+			errs.add(toks[0].line, toks[0].col, "instruction in the data section; start the code with .text")
+			continue
+		}
+
+		op, ok := vm.LookupMnemonic(toks[0].text)
+		if !ok {
+			errs.add(toks[0].line, toks[0].col, "unknown mnemonic %q", toks[0].text)
+			continue
+		}
+		if len(toks)-1 != len(op.Operands) {
+			errs.add(toks[0].line, toks[0].col, "%s takes %d operands, not %d", op.Mnemonic, len(op.Operands), len(toks)-1)
+			continue
+		}
+		p.Instructions = append(p.Instructions, Instruction{Op: op, Line: toks[0].line, Column: toks[0].col})
+		operandToks = append(operandToks, toks[1:])
+	}
+
+	// Labels may be used before they are defined, so operands can only
+	// be parsed once every line has been seen
+	for i := range p.Instructions {
+		inst := &p.Instructions[i]
+		for j, kind := range inst.Op.Operands {
+			inst.Operands = append(inst.Operands, parseOperand(kind, operandToks[i][j], &errs))
+		}
+	}
+
+	if _, ok := p.Labels[p.Entry]; p.Entry != "" && !ok {
+		errs.add(entry.line, entry.col, "undefined label %q", p.Entry)
+	}
+	for _, tok := range globals {
+		_, isCode := p.Labels[tok.text]
+		if _, isData := p.DataLabels[tok.text]; !isCode && !isData {
+			errs.add(tok.line, tok.col, "undefined label %q", tok.text)
+		}
+	}
+
+	addrs := p.layout()
+	for i := range p.Instructions {
+		p.Instructions[i].Addr = addrs[i]
+	}
+	p.resolve(addrs, true, &errs)
+	return p, errs.err()
+}
+
+// EntryPoint returns the address execution should start at, for use
+// with vm.WithEntryPoint: the instruction named by .entry, or else the
+// first one.
+func (p Program) EntryPoint() int {
+	addrs := p.layout()
+	if index, ok := p.Labels[p.Entry]; ok && p.Entry != "" {
+		return addrs[index]
+	}
+	return addrs[0]
+}
+
+// DebugInfo maps the address of each instruction in p's layout to its
+// line in file, or in its own File if that is set, and each code and
+// data label to its address.
+func (p Program) DebugInfo(file string) *vm.DebugInfo {
+	d := &vm.DebugInfo{Lines: map[int]vm.SourcePos{}, Symbols: map[string]int{}}
+	addrs := p.layout()
+	for i, inst := range p.Instructions {
+		if inst.Data == nil {
+			pos := vm.SourcePos{File: file, Line: inst.Line}
+			if inst.File != "" {
+				pos.File = inst.File
+			}
+			d.Lines[addrs[i]] = pos
+		}
+	}
+	for name, index := range p.Labels {
+		d.Symbols[name] = addrs[index]
+	}
+	for name, addr := range p.DataLabels {
+		d.Symbols[name] = addr
+	}
+	return d
+}
+
+func (inst Instruction) width() int {
+	if inst.Data != nil {
+		return len(inst.Data)
+	}
+	return inst.Op.Width()
+}
+
+func parseOperand(kind vm.OperandKind, tok token, errs *AssembleErrors) Operand {
+	operand := Operand{Line: tok.line, Column: tok.col}
+	switch {
+	case kind == vm.RegOperand:
+		name := strings.ToLower(tok.text)
+		if strings.HasPrefix(name, "r") {
+			if n, err := strconv.Atoi(name[1:]); err == nil && n >= 1 && n <= vm.MaxRegister {
+				operand.Value = n
+				return operand
+			}
+		}
+		errs.add(tok.line, tok.col, "invalid register %q", tok.text)
+	case isIdent(tok.text):
+		operand.Label = tok.text
+	default:
+		n, err := strconv.ParseInt(tok.text, 0, 0)
+		if err != nil {
+			errs.add(tok.line, tok.col, "invalid number %q", tok.text)
+		}
+		operand.Value = int(n)
+	}
+	return operand
+}
+
+// The address of each instruction, and of the end of the program
+func (p Program) layout() []int {
+	addrs := make([]int, len(p.Instructions)+1)
+	addrs[0] = p.Config.Complete().CodeStart
+	for i, inst := range p.Instructions {
+		addrs[i+1] = addrs[i] + inst.width()
+	}
+	return addrs
+}
+
+// Update the value of every label operand for the given layout. Names
+// declared with .extern are left at 0 if partial is set, and otherwise
+// reported as needing to be linked.
+func (p Program) resolve(addrs []int, partial bool, errs *AssembleErrors) {
+	externs := map[string]bool{}
+	for _, name := range p.Externs {
+		externs[name] = true
+	}
+	for i, inst := range p.Instructions {
+		for j, kind := range inst.Op.Operands {
+			operand := &inst.Operands[j]
+			if operand.Label == "" {
+				continue
+			}
+			if val, ok := p.Constants[operand.Label]; ok {
+				operand.Value = val
+				continue
+			}
+			if addr, ok := p.DataLabels[operand.Label]; ok {
+				operand.Value = addr
+				continue
+			}
+			index, ok := p.Labels[operand.Label]
+			if !ok && externs[operand.Label] {
+				if !partial {
+					errs.add(operand.Line, operand.Column, "%q is external; link with the object that defines it", operand.Label)
+				}
+				continue
+			}
+			if !ok || index < 0 || index >= len(addrs) {
+				errs.add(operand.Line, operand.Column, "undefined label %q", operand.Label)
+				continue
+			}
+			operand.Value = addrs[index]
+			if kind == vm.OffsetOperand {
+				operand.Value -= addrs[i+1]
+			}
+		}
+	}
+}
+
+// Emit lays out p in a memory image, as Assemble does. Label operands
+// are resolved against the layout, so instructions may be added to or
+// removed from a parsed Program as long as Labels is kept in step. p
+// itself is left as it was.
+func Emit(p Program) ([]byte, error) {
+	var errs AssembleErrors
+	addrs := p.layout()
+	// resolve updates the operands in place, so give it a copy of them
+	instructions := make([]Instruction, len(p.Instructions))
+	for i, inst := range p.Instructions {
+		inst.Operands = append([]Operand(nil), inst.Operands...)
+		instructions[i] = inst
+	}
+	p.Instructions = instructions
+	p.resolve(addrs, false, &errs)
+
+	c := p.Config.Complete()
+	if len(p.Data) > c.DataEnd {
+		return nil, fmt.Errorf("data does not fit in the %d byte data region", c.DataEnd)
+	}
+	image := make([]byte, c.MemSize)
+	copy(image[:c.DataEnd], p.Data)
+	for i, inst := range p.Instructions {
+		if addrs[i+1] > c.MemSize {
+			errs.add(inst.Line, inst.Column, "program does not fit in %d bytes of memory", c.MemSize)
+			break
+		}
+		if inst.Data != nil {
+			copy(image[addrs[i]:], inst.Data)
+			continue
+		}
+		code := inst.Op.Code
+		for j, kind := range inst.Op.Operands {
+			operand := inst.Operands[j]
+			val := operand.Value
+			if val < 0 && kind == vm.ImmOperand && (code == vm.Addi || code == vm.Subi) {
+				code, val = negated(code), -val
+			}
+			if kind == vm.OffsetOperand && (operand.Label != "" || val >= -128 && val < 0) {
+				// offsets are signed bytes, and the PC wraps around, so
+				// one byte reaches every target in the image; a number
+				// may also be written as the unsigned byte
+				val &= 0xff
+			}
+			if val < 0 || val > 0xff {
+				errs.add(operand.Line, operand.Column, "operand out of range: %d", val)
+				continue
+			}
+			image[addrs[i]+1+j] = byte(val)
+		}
+		image[addrs[i]] = code
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return image, nil
+}
+
+// Adding a negative number is encoded as subtracting its magnitude, and
+// vice versa, so that immediates are always stored unsigned
+func negated(code byte) byte {
+	if code == vm.Addi {
+		return vm.Subi
+	}
+	return vm.Addi
+}
@@ -0,0 +1,155 @@
+package asm
+
+import (
+	"bytes"
+	"testing"
+
+	"vm"
+)
+
+const sumSrc = `
+        load r1, 1
+loop:   beqz r1, done
+        add r2, r1
+        subi r1, 1
+        jump loop
+done:   store r2, 0
+        halt`
+
+func TestParse(t *testing.T) {
+	p, err := Parse(sumSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		code   byte
+		addr   int
+		values []int
+	}{
+		{vm.Load, 8, []int{1, 1}},
+		{vm.Beqz, 11, []int{1, 8}},
+		{vm.Add, 14, []int{2, 1}},
+		{vm.Subi, 17, []int{1, 1}},
+		{vm.Jump, 20, []int{11}},
+		{vm.Store, 22, []int{2, 0}},
+		{vm.Halt, 25, nil},
+	}
+	if len(p.Instructions) != len(expected) {
+		t.Fatalf("Expected %d instructions, got %d", len(expected), len(p.Instructions))
+	}
+	for i, e := range expected {
+		inst := p.Instructions[i]
+		if inst.Op.Code != e.code || inst.Addr != e.addr || inst.Line != i+2 {
+			t.Fatalf("Instruction %d: expected %s at %d on line %d, got %+v", i, inst.Op.Mnemonic, e.addr, i+2, inst)
+		}
+		for j, v := range e.values {
+			if inst.Operands[j].Value != v {
+				t.Fatalf("Instruction %d operand %d: expected %d, got %d", i, j, v, inst.Operands[j].Value)
+			}
+		}
+	}
+	if p.Labels["loop"] != 1 || p.Labels["done"] != 5 {
+		t.Fatalf("Unexpected labels %v", p.Labels)
+	}
+	if p.Instructions[1].Operands[1].Label != "done" {
+		t.Fatalf("Expected the branch to record its label")
+	}
+
+	image, err := Emit(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	direct, err := Assemble(sumSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(image, direct) {
+		t.Fatalf("Emit and Assemble disagree")
+	}
+}
+
+func TestEmitResolvesLabels(t *testing.T) {
+	p, err := Parse(sumSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// dropping the add moves everything after it back three bytes
+	p.Instructions = append(p.Instructions[:2], p.Instructions[3:]...)
+	p.Labels["done"]--
+	image, err := Emit(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := Assemble(`
+        load r1, 1
+loop:   beqz r1, done
+        subi r1, 1
+        jump loop
+done:   store r2, 0
+        halt`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(image, expected) {
+		t.Fatalf("Expected % x\ngot      % x", expected[8:30], image[8:30])
+	}
+	// without touching the Program it was given
+	if offset := p.Instructions[1].Operands[1].Value; offset != 8 {
+		t.Fatalf("Expected Emit to leave the parsed offset of 8 alone, got %d", offset)
+	}
+}
+
+func TestEntryPoint(t *testing.T) {
+	// A 16 byte table of constants ahead of the code, which starts by
+	// reading an entry from the second half
+	p, err := Parse(`
+        .entry start
+first:  .byte 1, 2, 3, 4, 5, 6, 7, 8
+second: .byte 9, 10, 11, 12, 13, 14, 15, 16
+start:  load r1, second
+        store r1, 0
+        halt`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := p.EntryPoint(); entry != 24 {
+		t.Fatalf("Expected entry point 24, got %d", entry)
+	}
+	image, err := Emit(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image[8] != 1 || image[23] != 16 || image[24] != vm.Load {
+		t.Fatalf("Data block not laid out ahead of the code: % x", image[8:28])
+	}
+
+	if err := vm.NewMachine(image, vm.WithEntryPoint(p.EntryPoint())).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if image[0] != 9 {
+		t.Fatalf("Expected 9 at offset 0, got %d", image[0])
+	}
+}
+
+func TestDebugInfo(t *testing.T) {
+	p, err := Parse(".data\nout: .byte 0\n.text\n" + sumSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Instructions[0].File = "load.s"
+	d := p.DebugInfo("sum.s")
+	if len(d.Lines) != len(p.Instructions) {
+		t.Fatalf("Expected a line for each of %d instructions, got %v", len(p.Instructions), d.Lines)
+	}
+	if pos := d.Lines[8]; pos != (vm.SourcePos{File: "load.s", Line: 5}) {
+		t.Errorf("Expected the first instruction to keep its own file, got %v", pos)
+	}
+	if pos := d.Lines[20]; pos != (vm.SourcePos{File: "sum.s", Line: 9}) {
+		t.Errorf("Expected the jump at sum.s:9, got %v", pos)
+	}
+	if d.Symbols["out"] != 0 || d.Symbols["loop"] != 11 || d.Symbols["done"] != 22 {
+		t.Errorf("Unexpected symbols %v", d.Symbols)
+	}
+}
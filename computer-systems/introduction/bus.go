@@ -0,0 +1,166 @@
+package vm
+
+import "fmt"
+
+// A Device claims a range of addresses on a machine's bus, and handles
+// the program's loads and stores to them. Addresses are passed as
+// offsets from the start of the range, so a device works wherever it is
+// attached. The machine checks each access, against the data region or
+// a memory map, before the device sees it. An error faults the
+// instruction; wrap ErrDevice for a failure of the device itself.
+type Device interface {
+	Load(offset int) (byte, error)
+	Store(offset int, val byte) error
+}
+
+// A Ticker is a Device that does work of its own as the machine runs,
+// as a timer or a DMA controller does. The machine calls Tick after
+// each instruction completes, before taking interrupts, so Tick may
+// Raise one.
+type Ticker interface {
+	Device
+	Tick(m *Machine)
+}
+
+// A Peeker is a Device that can report what a load would return
+// without the load's side effects on it, such as taking a key or
+// latching a count, for debuggers to inspect it. Machine.Peek reads the
+// memory under a device that isn't a Peeker instead.
+type Peeker interface {
+	Device
+	Peek(offset int) byte
+}
+
+// A Bus routes loads and stores to the devices that claim their
+// addresses. A machine's bus starts with its memory image attached as
+// RAM over the whole address space, and its built-in devices, such as
+// the console, attached over that; devices attached later take
+// precedence over those attached before, where their ranges overlap. A
+// Bus is itself a Device, over the addresses it covers, so buses can be
+// nested.
+type Bus struct {
+	devices []attachment
+}
+
+type attachment struct {
+	start, end int
+	dev        Device
+}
+
+// Attach connects d to the addresses start up to but not including end.
+func (b *Bus) Attach(start, end int, d Device) {
+	b.devices = append(b.devices, attachment{start, end, d})
+}
+
+// Load reads addr from the device claiming it, or faults with
+// ErrOutOfBounds if none does.
+func (b *Bus) Load(addr int) (byte, error) {
+	a := b.lookup(addr)
+	if a == nil {
+		return 0, fmt.Errorf("%w: no device at %#x", ErrOutOfBounds, addr)
+	}
+	return a.dev.Load(addr - a.start)
+}
+
+// Store writes val to addr on the device claiming it, or faults with
+// ErrOutOfBounds if none does.
+func (b *Bus) Store(addr int, val byte) error {
+	a := b.lookup(addr)
+	if a == nil {
+		return fmt.Errorf("%w: no device at %#x", ErrOutOfBounds, addr)
+	}
+	return a.dev.Store(addr-a.start, val)
+}
+
+// Peek at addr on the device claiming it, reporting false if that
+// device isn't a Peeker
+func (b *Bus) peek(addr int) (byte, bool) {
+	a := b.lookup(addr)
+	if a == nil {
+		return 0, false
+	}
+	switch d := a.dev.(type) {
+	case Peeker:
+		return d.Peek(addr - a.start), true
+	case *Bus:
+		return d.peek(addr - a.start)
+	}
+	return 0, false
+}
+
+// The attachment claiming addr, the last attached if several do
+func (b *Bus) lookup(addr int) *attachment {
+	for i := len(b.devices) - 1; i >= 0; i-- {
+		if a := &b.devices[i]; addr >= a.start && addr < a.end {
+			return a
+		}
+	}
+	return nil
+}
+
+// Tick each device that is a Ticker
+func (b *Bus) tick(m *Machine) {
+	for _, a := range b.devices {
+		if t, ok := a.dev.(Ticker); ok {
+			t.Tick(m)
+		}
+	}
+}
+
+func (b *Bus) validate(size int) error {
+	for _, a := range b.devices {
+		if a.start < 0 || a.end > size || a.start > a.end {
+			return fmt.Errorf("%w: device at [%#x, %#x) for %d bytes of memory", ErrInvalidConfig, a.start, a.end, size)
+		}
+	}
+	return nil
+}
+
+// WithDevice attaches d to the machine's bus, over the addresses start
+// up to but not including end, in place of the memory there. Programs
+// may only store to a device in the data region, unless a memory map
+// says otherwise, though they may load from one anywhere.
+func WithDevice(start, end int, d Device) Option {
+	return func(m *Machine) {
+		m.bus.Attach(start, end, d)
+	}
+}
+
+// Bus returns the machine's bus, for devices that access memory
+// themselves, as DMA does.
+func (m *Machine) Bus() *Bus {
+	return m.bus
+}
+
+// RAM is a Device of ordinary memory. Like ROM, it must be at least as
+// long as the range it is attached to.
+type RAM []byte
+
+func (r RAM) Load(offset int) (byte, error) {
+	return r[offset], nil
+}
+
+func (r RAM) Peek(offset int) byte {
+	return r[offset]
+}
+
+func (r RAM) Store(offset int, val byte) error {
+	r[offset] = val
+	return nil
+}
+
+// ROM is a Device of memory that programs can only read. Stores to it
+// fault with ErrReadOnlyWrite.
+type ROM []byte
+
+func (r ROM) Load(offset int) (byte, error) {
+	return r[offset], nil
+}
+
+func (r ROM) Peek(offset int) byte {
+	return r[offset]
+}
+
+func (r ROM) Store(offset int, val byte) error {
+	return fmt.Errorf("%w: store to ROM offset %#x", ErrReadOnlyWrite, offset)
+}
@@ -0,0 +1,167 @@
+package vm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// A device counting the loads from it since it was last stored to, and
+// the instructions it has been ticked for
+type counter struct {
+	loads, ticks byte
+}
+
+func (c *counter) Load(offset int) (byte, error) {
+	c.loads++
+	return c.loads, nil
+}
+
+func (c *counter) Store(offset int, val byte) error {
+	c.loads = val
+	return nil
+}
+
+func (c *counter) Tick(m *Machine) {
+	c.ticks++
+}
+
+func TestDevice(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+load r1 3
+load r2 3
+li r3 10
+store r3 3
+load r4 3
+halt`))
+	var c counter
+	m := NewMachine(memory, WithDevice(3, 4, &c))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Register(1) != 1 || m.Register(2) != 2 || m.Register(4) != 11 {
+		t.Errorf("Expected loads of 1, 2 and 11, got %d, %d and %d", m.Register(1), m.Register(2), m.Register(4))
+	}
+	if memory[3] != 0 {
+		t.Errorf("Expected the device to bypass memory, got %#x at 0x03", memory[3])
+	}
+	if c.ticks != 6 {
+		t.Errorf("Expected a tick for each of 6 instructions, got %d", c.ticks)
+	}
+}
+
+func TestDeviceOverConsole(t *testing.T) {
+	// a device attached with WithDevice takes precedence over the
+	// built-in ones
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+li r1 65
+store r1 7
+halt`))
+	var out strings.Builder
+	c := counter{}
+	if err := NewMachine(memory, WithConsole(&out), WithDevice(ConsoleAddr, ConsoleAddr+1, &c)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 || c.loads != 65 {
+		t.Errorf("Expected the store to reach the device, got %q on the console and %d", out.String(), c.loads)
+	}
+}
+
+func TestROM(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+load r1 1
+store r1 2
+halt`))
+	m := NewMachine(memory, WithDevice(0, 4, ROM{1, 2, 3, 4}))
+	err := m.Run()
+	if !errors.Is(err, ErrReadOnlyWrite) || !strings.Contains(err.Error(), "store to 0x2 at pc 0xb") {
+		t.Errorf("Expected ErrReadOnlyWrite storing to 0x2, got %v", err)
+	}
+	if m.Register(1) != 2 {
+		t.Errorf("Expected to load 2 from the ROM, got %d", m.Register(1))
+	}
+}
+
+func TestNestedBus(t *testing.T) {
+	var inner Bus
+	inner.Attach(0, 2, RAM{7, 8})
+	inner.Attach(2, 4, ROM{9, 10})
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+load r1 5
+load r2 6
+halt`))
+	m := NewMachine(memory, WithDevice(4, 8, &inner))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Register(1) != 8 || m.Register(2) != 9 {
+		t.Errorf("Expected 8 and 9 from the nested bus, got %d and %d", m.Register(1), m.Register(2))
+	}
+	if _, err := inner.Load(4); !errors.Is(err, ErrOutOfBounds) {
+		t.Errorf("Expected ErrOutOfBounds past the nested devices, got %v", err)
+	}
+}
+
+func TestDeviceOutsideMemory(t *testing.T) {
+	memory := make([]byte, 256)
+	memory[8] = Halt
+	err := NewMachine(memory, WithDevice(250, 260, RAM(make([]byte, 10)))).Run()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestExploreDevice(t *testing.T) {
+	paths, err := Explore(program("load r1 3\nhalt"), nil, 0, WithDevice(3, 4, &counter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || !errors.Is(paths[0].Err, ErrNotSymbolic) {
+		t.Errorf("Expected a path ending with ErrNotSymbolic, got %v", paths)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	// peeking at each device leaves the program's loads to see what they
+	// would have anyway
+	memory := make([]byte, 256)
+	copy(memory[32:], assemble("load r1 5\nload r2 6\nload r3 4\nload r4 17\nhalt"))
+	m := NewMachine(memory, WithDataSize(32), WithKeyboard(strings.NewReader("k")), WithRandom(1), WithClock(16))
+	if err := m.Step(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		key, _ := m.Peek(KeyboardAddr)
+		random, _ := m.Peek(RandomAddr)
+		m.Peek(16)
+		if key != 'k' || random != mixRandom(1+0x9e3779b97f4a7c15) {
+			t.Fatalf("Expected to peek at the key and the next random byte, got %q and %d", key, random)
+		}
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Register(2) != 'k' || m.Register(3) != mixRandom(1+0x9e3779b97f4a7c15) || m.Register(4) != 0 {
+		t.Errorf("Expected the key, the same random byte and an unlatched count, got %q, %d and %d", m.Register(2), m.Register(3), m.Register(4))
+	}
+
+	// and the UART keeps the byte it has received
+	memory = make([]byte, 256)
+	copy(memory[32:], assemble("jump 32"))
+	m = NewMachine(memory, WithDataSize(32), WithUART(16, strings.NewReader("u"), nil))
+	for i := 0; i < UARTCycles; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b, _ := m.Peek(16 + UARTData); b != 'u' {
+		t.Errorf("Expected to peek at 'u', got %q", b)
+	}
+	if status, _ := m.Bus().Load(16 + UARTStatus); status&UARTRxReady == 0 {
+		t.Errorf("Expected the byte still to be waiting, got status %#x", status)
+	}
+}
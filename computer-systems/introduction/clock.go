@@ -0,0 +1,76 @@
+package vm
+
+import "time"
+
+// The number of bytes of the count read from the clock attached by
+// WithClock or WithRealTimeClock.
+const ClockSize = 4
+
+// WithClock attaches a clock at addr, for programs to time themselves.
+// It counts cycles: clock cycles under a timing model, and instructions
+// executed without one. The count is ClockSize bytes, little-endian,
+// and wraps around once it fills them.
+//
+// Loading the low byte, at addr, latches the whole count, and loading
+// the others reads the latched bytes, so that a program reading the
+// count from the low byte up sees a single moment of it. Storing any
+// byte starts the count again from zero. The data region must include
+// the clock, for the program to store to it.
+func WithClock(addr int) Option {
+	return func(m *Machine) {
+		m.bus.Attach(addr, addr+ClockSize, &clock{m: m})
+	}
+}
+
+// WithRealTimeClock attaches a clock at addr like WithClock's, but
+// counting the milliseconds of wall-clock time since the machine was
+// created, or since the program last stored to it. With WithClockRate,
+// this lets a program wait for a real length of time.
+func WithRealTimeClock(addr int) Option {
+	return func(m *Machine) {
+		m.bus.Attach(addr, addr+ClockSize, &clock{m: m, realTime: true, epochTime: time.Now()})
+	}
+}
+
+type clock struct {
+	m         *Machine
+	realTime  bool
+	epoch     uint64 // the cycle the count started from
+	epochTime time.Time
+	latched   uint64
+}
+
+// The cycles the clock's machine has run
+func (c *clock) cycles() uint64 {
+	if c.m.timing != nil {
+		return c.m.counters.Clock
+	}
+	return c.m.cycles
+}
+
+func (c *clock) Load(offset int) (byte, error) {
+	if offset == 0 {
+		if c.realTime {
+			c.latched = uint64(time.Since(c.epochTime).Milliseconds())
+		} else {
+			c.latched = c.cycles() - c.epoch
+		}
+	}
+	return byte(c.latched >> (8 * offset)), nil
+}
+
+// Peek at the count without latching it
+func (c *clock) Peek(offset int) byte {
+	if offset != 0 {
+		return byte(c.latched >> (8 * offset))
+	}
+	if c.realTime {
+		return byte(time.Since(c.epochTime).Milliseconds())
+	}
+	return byte(c.cycles() - c.epoch)
+}
+
+func (c *clock) Store(offset int, val byte) error {
+	c.epoch, c.epochTime = c.cycles(), time.Now()
+	return nil
+}
@@ -0,0 +1,69 @@
+package vm
+
+import "testing"
+
+// Start the clock at 16 again, then read it three instructions later
+const clockProgram = `
+li r1 0
+store r1 16
+li r1 0
+li r1 0
+load r2 16
+load r3 17
+halt`
+
+func TestClock(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		opts     []Option
+		expected byte
+	}{
+		{"Instructions", nil, 3},
+		// the store costs more, for its memory access
+		{"Timing", []Option{WithTiming(DefaultTiming())}, 5},
+	} {
+		memory := make([]byte, 256)
+		copy(memory[32:], assemble(clockProgram))
+		m := NewMachine(memory, append(test.opts, WithDataSize(32), WithClock(16))...)
+		if err := m.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if m.Register(2) != test.expected || m.Register(3) != 0 {
+			t.Errorf("%s: expected a count of %d, got %d and %d", test.name, test.expected, m.Register(2), m.Register(3))
+		}
+	}
+}
+
+func TestClockLatch(t *testing.T) {
+	// read the clock after 401 instructions, 0x191
+	memory := make([]byte, 256)
+	copy(memory[32:], assemble(`
+li r4 200
+subi r4 1
+bnz 251
+load r2 16
+load r3 17
+load r4 16
+halt`))
+	m := NewMachine(memory, WithDataSize(32), WithClock(16))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Register(2) != 0x91 || m.Register(3) != 0x01 || m.Register(4) != 0x93 {
+		t.Errorf("Expected 0x91, 0x01 and then 0x93, got %#x, %#x and %#x", m.Register(2), m.Register(3), m.Register(4))
+	}
+}
+
+func TestRealTimeClock(t *testing.T) {
+	// at 100 cycles a second, the three instructions after the store
+	// take at least 30ms
+	memory := make([]byte, 256)
+	copy(memory[32:], assemble(clockProgram))
+	m := NewMachine(memory, WithDataSize(32), WithRealTimeClock(16), WithClockRate(100))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Register(2) < 30 || m.Register(3) != 0 {
+		t.Errorf("Expected a count of at least 30ms, got %d and %d", m.Register(2), m.Register(3))
+	}
+}
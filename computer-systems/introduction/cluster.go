@@ -0,0 +1,203 @@
+package vm
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// A Cluster runs several harts, or hardware threads, over one shared
+// memory. Each hart is a Machine with its own registers, flags and PC,
+// and its own stack: hart i's stack sits below hart i-1's, so the top of
+// memory must leave room for all of them. The harts all start at the
+// entry point, and tell themselves apart with hartid, which gives each
+// its number.
+//
+// The harts share one bus, and so one of each device, as they share
+// memory. The devices run along with hart 0, which takes their
+// interrupts, such as the timer's, and they stop once it has halted.
+//
+// Loads and stores by different harts are interleaved in whatever order
+// the harts run, so a read-modify-write made of ordinary instructions
+// can lose an update made by another hart in between. The atomic
+// instructions cas and xadd can't be interrupted like that.
+type Cluster struct {
+	harts   []*Machine
+	mu      sync.Mutex   // held by an atomic instruction
+	devices sync.Mutex   // held to access a device, or to tick them
+	decoded sync.RWMutex // held to use the instructions the harts share under PredecodedDispatch
+	stopped int32        // set when a hart faults under RunParallel
+}
+
+// NewCluster returns a Cluster of n harts, configured by opts, that will
+// run the program in memory.
+func NewCluster(memory []byte, n int, opts ...Option) *Cluster {
+	c := &Cluster{}
+	for i := 0; i < n; i++ {
+		m := NewMachine(memory, opts...)
+		m.hart, m.cluster = i, c
+		m.stackTop -= i * m.stackSize
+		m.sp = m.stackTop
+		if i > 0 {
+			m.bus = c.harts[0].bus
+		}
+		if m.dispatchMode == PredecodedDispatch {
+			// a store by any hart must invalidate the instructions
+			// every hart has decoded
+			if i == 0 {
+				m.decoded = make([]*decodedInstruction, len(memory))
+			} else {
+				m.decoded = c.harts[0].decoded
+			}
+		}
+		c.harts = append(c.harts, m)
+	}
+	return c
+}
+
+// Hart returns hart i, for inspecting its state.
+func (c *Cluster) Hart(i int) *Machine {
+	return c.harts[i]
+}
+
+// Halted reports whether every hart has halted.
+func (c *Cluster) Halted() bool {
+	for _, m := range c.harts {
+		if !m.halted {
+			return false
+		}
+	}
+	return true
+}
+
+// Step executes an instruction on each hart that hasn't halted, in
+// order, stopping at the first to fault. The error says which hart it
+// was.
+func (c *Cluster) Step() error {
+	for i, m := range c.harts {
+		if err := m.Step(); err != nil {
+			return fmt.Errorf("hart %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Run runs the harts round robin, an instruction each in turn, until
+// they have all halted or one faults. The interleaving is the same
+// every time, so a race between the harts always has the same outcome.
+func (c *Cluster) Run() error {
+	for !c.Halted() {
+		if err := c.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunParallel runs each hart on its own goroutine until they have all
+// halted or one faults, which stops the others. The Go scheduler decides
+// the interleaving, so a program that races gives different results
+// from one run to the next, and the race detector reports it.
+func (c *Cluster) RunParallel() error {
+	errs := make([]error, len(c.harts))
+	var wg sync.WaitGroup
+	for i, m := range c.harts {
+		wg.Add(1)
+		go func(i int, m *Machine) {
+			defer wg.Done()
+			for !m.halted && atomic.LoadInt32(&c.stopped) == 0 {
+				if err := m.Step(); err != nil {
+					errs[i] = fmt.Errorf("hart %d: %w", i, err)
+					atomic.StoreInt32(&c.stopped, 1)
+					return
+				}
+			}
+		}(i, m)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hold the cluster's device lock, if addr is on a device, returning the
+// function that releases it. Memory isn't locked, so that races between
+// harts behave as they would on hardware.
+func (m *Machine) lockDevice(addr int) func() {
+	if m.cluster == nil || m.ticking || !m.onDevice(addr) {
+		return func() {}
+	}
+	m.cluster.devices.Lock()
+	return m.cluster.devices.Unlock
+}
+
+// Hold the cluster's lock on the decoded instructions its harts share,
+// to change them if write is set and otherwise to look them up,
+// returning the function that releases it
+func (m *Machine) lockDecoded(write bool) func() {
+	if m.cluster == nil {
+		return func() {}
+	}
+	if write {
+		m.cluster.decoded.Lock()
+		return m.cluster.decoded.Unlock
+	}
+	m.cluster.decoded.RLock()
+	return m.cluster.decoded.RUnlock
+}
+
+// Run fn without any other hart of the cluster running an atomic
+// instruction at the same time
+func (m *Machine) atomically(fn func() error) error {
+	if m.cluster == nil {
+		return fn()
+	}
+	m.cluster.mu.Lock()
+	defer m.cluster.mu.Unlock()
+	return fn()
+}
+
+func execCas(m *Machine, args []int, next int) (bool, error) {
+	expected, replacement, addr := args[0], args[1], args[2]
+	// compare the byte at addr with the expected register, setting the
+	// flags as cmp does, and if they are equal store the replacement;
+	// otherwise load the byte into the expected register, ready to try
+	// again
+	return false, m.atomically(func() error {
+		val, err := m.load(addr)
+		if err != nil {
+			return err
+		}
+		if m.sub(val, m.registers[expected]); val == m.registers[expected] {
+			return m.store(addr, m.registers[replacement])
+		}
+		m.registers[expected] = val
+		return nil
+	})
+}
+
+func execXadd(m *Machine, args []int, next int) (bool, error) {
+	reg, addr := args[0], args[1]
+	// add the register to the byte at addr, setting the flags as add
+	// does, and load the byte's old value into the register
+	return false, m.atomically(func() error {
+		val, err := m.load(addr)
+		if err != nil {
+			return err
+		}
+		if err := m.store(addr, m.add(val, m.registers[reg])); err != nil {
+			return err
+		}
+		m.registers[reg] = val
+		return nil
+	})
+}
+
+func execHartid(m *Machine, args []int, next int) (bool, error) {
+	// load the number of the hart, which is 0 outside a cluster
+	m.registers[args[0]] = byte(m.hart)
+	return false, nil
+}
@@ -0,0 +1,146 @@
+package vm
+
+import (
+	"errors"
+	"testing"
+)
+
+// Increment the counter at 0 a hundred times, holding the spin lock at
+// 1 around each ordinary load, add and store
+const spinLock = `
+li r2 1
+li r5 100
+li r1 0
+cas r1 r2 1
+bnz 247
+load r3 0
+addi r3 1
+store r3 0
+li r4 255
+xadd r4 1
+subi r5 1
+bnz 227
+halt`
+
+func TestCluster(t *testing.T) {
+	tests := []struct {
+		name     string
+		asm      string
+		expected byte
+	}{
+		// round robin, both harts load the counter before either stores,
+		// so one update is lost
+		{"Race", "load r1 0\naddi r1 1\nstore r1 0\nhalt", 1},
+		{"Xadd", "li r1 1\nxadd r1 0\nhalt", 2},
+		{"SpinLock", spinLock, 200},
+	}
+	for _, test := range tests {
+		memory := make([]byte, 256)
+		copy(memory[8:], assemble(test.asm))
+		c := NewCluster(memory, 2)
+		if err := c.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if memory[0] != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.name, test.expected, memory[0])
+		}
+	}
+}
+
+func TestClusterXadd(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble("li r1 1\nxadd r1 0\nhalt"))
+	memory[0] = 255
+	c := NewCluster(memory, 2)
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	// each hart gets the count from before its own increment
+	if c.Hart(0).R1() != 255 || c.Hart(1).R1() != 0 || memory[0] != 1 {
+		t.Fatalf("Expected the old values 255 and 0, and 1 left, got %d, %d and %d", c.Hart(0).R1(), c.Hart(1).R1(), memory[0])
+	}
+	if c.Hart(0).Flags() != FlagZero|FlagCarry || c.Hart(1).Flags() != 0 {
+		t.Fatalf("Expected the flags from each addition, got %#x and %#x", c.Hart(0).Flags(), c.Hart(1).Flags())
+	}
+}
+
+func TestClusterParallel(t *testing.T) {
+	for _, mode := range []Dispatch{SwitchDispatch, PredecodedDispatch} {
+		for _, src := range []string{spinLock, "li r1 100\nli r2 1\nxadd r2 0\nsubi r1 1\nbnz 245\nhalt"} {
+			memory := make([]byte, 256)
+			copy(memory[8:], assemble(src))
+			if err := NewCluster(memory, 2, WithDispatch(mode)).RunParallel(); err != nil {
+				t.Fatal(err)
+			}
+			if memory[0] != 200 {
+				t.Fatalf("Expected every increment to count, got %d", memory[0])
+			}
+		}
+	}
+}
+
+func TestClusterStacks(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble("hartid r1\naddi r1 10\npush r1\nhalt"))
+	c := NewCluster(memory, 3)
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for i, addr := range []int{255, 239, 223} {
+		if memory[addr] != byte(10+i) || c.Hart(i).SP() != addr {
+			t.Errorf("Hart %d: expected to push %d at %#x, got %d with sp %#x", i, 10+i, addr, memory[addr], c.Hart(i).SP())
+		}
+	}
+}
+
+func TestClusterFault(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble("hartid r1\nsubi r1 1\nassert r1\nhalt"))
+	for _, run := range []func(c *Cluster) error{(*Cluster).Run, (*Cluster).RunParallel} {
+		err := run(NewCluster(memory, 2))
+		if !errors.Is(err, ErrAssertionFailed) || err.Error() != "hart 1: assertion failed: r1 is zero at pc 0xd" {
+			t.Fatalf("Expected hart 1 to fail its assertion, got %v", err)
+		}
+	}
+}
+
+func TestClusterDevices(t *testing.T) {
+	// both harts load from one counter, which ticks with hart 0
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble("hartid r1\nload r2 3\nhalt"))
+	var dev counter
+	c := NewCluster(memory, 2, WithDevice(3, 4, &dev))
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if dev.loads != 2 || dev.ticks != 3 {
+		t.Errorf("Expected the device to see 2 loads and tick 3 times, got %d and %d", dev.loads, dev.ticks)
+	}
+	if c.Hart(0).Bus() != c.Hart(1).Bus() {
+		t.Error("Expected the harts to share a bus")
+	}
+}
+
+func TestClusterPredecoded(t *testing.T) {
+	// hart 1 patches the immediate of the li hart 0 runs in a loop,
+	// after hart 0 has decoded it
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+hartid r1
+bne r1 6
+li r3 1
+jump 13
+halt
+li r4 7
+store r4 15
+halt`))
+	c := NewCluster(memory, 2, WithDispatch(PredecodedDispatch), WithMemoryMap(MemoryMap{{0, 256, PermRead | PermWrite | PermExecute}}))
+	for _, step := range []int{0, 0, 0, 0, 1, 1, 1, 1, 0} {
+		if err := c.Hart(step).Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r3 := c.Hart(0).Register(3); r3 != 7 {
+		t.Errorf("Expected hart 0 to run the patched li, got r3 = %d", r3)
+	}
+}
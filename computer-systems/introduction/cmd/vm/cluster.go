@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"vm"
+)
+
+// Run the program on a cluster of n harts, round robin, and print each
+// hart's registers, then the data they share, returning the exit status
+func runCluster(memory []byte, n int, stats bool, opts []vm.Option, stdout, stderr io.Writer) int {
+	c := vm.NewCluster(memory, n, opts...)
+	err := c.Run()
+	var exit *vm.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		fmt.Fprintf(stderr, "fault: %v\n", err)
+	}
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(stdout, "hart %d:\n", i)
+		c.Hart(i).DumpRegisters(stdout, vm.Unsigned)
+		if stats {
+			fmt.Fprintf(stderr, "hart %d:\n", i)
+			printCounters(stderr, c.Hart(i).Counters())
+		}
+	}
+	c.Hart(0).DumpData(stdout, vm.Unsigned)
+
+	switch {
+	case exit != nil:
+		return int(exit.Code)
+	case err != nil:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,284 @@
+// Command vm runs a program on the toy VM and prints the machine's state
+// once it stops.
+//
+//	vm [flags] program [input...]
+//	vm -repl
+//
+// The program is either assembly source, if its name ends in .s or
+// .asm, an image in Intel HEX format, if it ends in .hex, or a memory
+// image saved by vm.SaveImage. Any further arguments are bytes written
+// into the data region starting at address 1. The console and keyboard
+// devices, and the services of the sys instruction, use standard output
+// and input.
+//
+// With -repl, vm instead reads instructions from standard input and
+// executes each as soon as it is typed. Type :help for its commands.
+//
+// The flags are:
+//
+//	-clock-rate hz
+//		slow the machine down to hz clock cycles a second, with each
+//		instruction costing cycles as vm.DefaultTiming describes
+//	-coverage path
+//		write the disassembled program to path once it stops, with
+//		the number of times each instruction executed
+//	-format text|json
+//		print the final state as text, the default, or JSON
+//	-gdb addr
+//		instead of running the program, wait for GDB to connect on
+//		the TCP address addr, such as :1234, and let it drive the
+//		machine with "target remote"
+//	-harts n
+//		run the program on n harts sharing its memory, as a
+//		vm.Cluster, an instruction each in turn, and print the
+//		registers of each; not with -format json, -gdb or -symbolic
+//	-max-steps n
+//		stop with an error after n instructions; 0 for no limit
+//		(default 1000000)
+//	-no-verify
+//		run the program even if vm.Verify finds errors in it, which
+//		otherwise stops it being run; warnings are printed either way
+//	-repl
+//		execute instructions interactively
+//	-screen addr
+//		attach a character screen at addr, redrawing it to standard
+//		output whenever the program writes to it; the program's data
+//		region must hold the whole screen
+//	-stats
+//		print the counters, such as the number of instructions run and
+//		of clock cycles they took under vm.DefaultTiming, to standard
+//		error once the program stops, for comparing programs
+//	-symbolic addrs
+//		instead of running the program, explore every path through it
+//		with vm.Explore, taking the bytes at addrs, a list separated by
+//		commas, as unknown inputs, and print how each path ends and what
+//		it leaves in the registers and memory; -max-steps limits each
+//		path
+//	-trace
+//		log each instruction to standard error as it executes, with
+//		its source line for assembly source and for images saved with
+//		debug info by vmasm -g
+//	-trace-format text|json
+//		log with -trace as text, the default, or as one JSON object
+//		per line, as vm.WithJSONTrace describes
+//
+// The exit status is the program's own, from its exit instruction, or 1
+// if it faults.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"vm"
+	"vm/asm"
+	"vm/internal/gdbstub"
+	"vm/internal/program"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// The final state printed with -format json
+type state struct {
+	PC        int    `json:"pc"`
+	SP        int    `json:"sp"`
+	Registers []int  `json:"registers"`
+	Flags     int    `json:"flags"`
+	Data      []int  `json:"data"`
+	Cycles    uint64 `json:"cycles"`
+	ExitCode  int    `json:"exit_code"`
+	Fault     string `json:"fault,omitempty"`
+}
+
+// Run the command with the given arguments, returning its exit status
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("vm", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: vm [flags] program [input...]")
+		fmt.Fprintln(stderr, "       vm -repl")
+		flags.PrintDefaults()
+	}
+	format := flags.String("format", "text", "print the final state as `text` or json")
+	maxSteps := flags.Uint64("max-steps", 1000000, "stop after `n` instructions; 0 for no limit")
+	trace := flags.Bool("trace", false, "log each instruction to standard error")
+	traceFormat := flags.String("trace-format", "text", "log with -trace as `text` or json")
+	interactive := flags.Bool("repl", false, "execute instructions interactively")
+	screen := flags.Int("screen", -1, "attach a character screen at `addr`")
+	gdb := flags.String("gdb", "", "wait for GDB to connect on `addr`")
+	coverage := flags.String("coverage", "", "write a coverage report to `path`")
+	noVerify := flags.Bool("no-verify", false, "run the program even if it fails verification")
+	stats := flags.Bool("stats", false, "print the counters once the program stops")
+	clockRate := flags.Uint64("clock-rate", 0, "run at `hz` clock cycles a second")
+	harts := flags.Int("harts", 1, "run the program on `n` harts")
+	symbolic := flags.String("symbolic", "", "explore every path with the data at `addrs` as inputs")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *traceFormat != "text" && *traceFormat != "json" {
+		flags.Usage()
+		return 2
+	}
+	traceOption := vm.WithTrace(stderr)
+	if *traceFormat == "json" {
+		traceOption = vm.WithJSONTrace(stderr)
+	}
+	if *interactive {
+		var opts []vm.Option
+		if *trace {
+			opts = append(opts, traceOption)
+		}
+		newREPL(stdout, vm.DefaultConfig, opts...).run(stdin)
+		return 0
+	}
+	if flags.NArg() < 1 || *format != "text" && *format != "json" ||
+		*harts < 1 || *harts > 1 && (*format == "json" || *gdb != "" || *symbolic != "") {
+		flags.Usage()
+		return 2
+	}
+
+	memory, opts, err := program.Load(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if err := program.SetInputs(memory, flags.Args()[1:]); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	opts = append(opts, vm.WithCycleLimit(*maxSteps), vm.WithConsole(stdout), vm.WithKeyboard(stdin),
+		vm.WithHost(vm.NewHost(stdin, stdout)))
+	if *trace {
+		opts = append(opts, traceOption)
+	}
+	if *screen >= 0 {
+		opts = append(opts, vm.WithScreen(*screen, stdout))
+	}
+	if *stats {
+		opts = append(opts, vm.WithTiming(vm.DefaultTiming()))
+	}
+	if *clockRate > 0 {
+		opts = append(opts, vm.WithClockRate(*clockRate))
+	}
+	var cov vm.Coverage
+	if *coverage != "" {
+		opts = append(opts, vm.WithCoverage(&cov))
+	}
+
+	failed := false
+	for _, issue := range vm.Verify(memory, opts...) {
+		fmt.Fprintf(stderr, "verify: %s\n", issue)
+		failed = failed || !issue.Warning
+	}
+	if failed && !*noVerify {
+		fmt.Fprintln(stderr, "not running a program that fails verification; use -no-verify to run it anyway")
+		return 1
+	}
+
+	if *symbolic != "" {
+		inputs, err := parseAddrs(*symbolic)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		paths, err := vm.Explore(memory, inputs, *maxSteps, opts...)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		printPaths(stdout, paths, memory, inputs)
+		return 0
+	}
+
+	if *harts > 1 {
+		return runCluster(memory, *harts, *stats, opts, stdout, stderr)
+	}
+
+	m := vm.NewMachine(memory, opts...)
+	if *gdb != "" {
+		if err := serveGDB(*gdb, m, memory, stderr); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return 0
+	}
+	err = m.Run()
+	var exit *vm.ExitError
+	if errors.As(err, &exit) {
+		err = nil
+	}
+	if *coverage != "" {
+		if err := os.WriteFile(*coverage, []byte(asm.CoverageReport(memory, &cov)), 0666); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+
+	if *format == "json" {
+		s := state{
+			PC:       m.PC(),
+			SP:       m.SP(),
+			Flags:    int(m.Flags()),
+			Cycles:   m.Cycles(),
+			ExitCode: int(m.ExitCode()),
+		}
+		for i := 1; i <= vm.MaxRegister; i++ {
+			s.Registers = append(s.Registers, int(m.Register(i)))
+		}
+		for _, b := range m.Data() {
+			s.Data = append(s.Data, int(b))
+		}
+		if err != nil {
+			s.Fault = err.Error()
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(s)
+	} else {
+		if err != nil {
+			fmt.Fprintf(stderr, "fault: %v\n", err)
+		}
+		m.DumpRegisters(stdout, vm.Unsigned)
+		m.DumpData(stdout, vm.Unsigned)
+	}
+	if *stats {
+		printCounters(stderr, m.Counters())
+	}
+
+	if err != nil {
+		return 1
+	}
+	return int(m.ExitCode())
+}
+
+func printCounters(w io.Writer, c vm.Counters) {
+	fmt.Fprintf(w, "instructions:       %d\n", c.Instructions)
+	fmt.Fprintf(w, "clock cycles:       %d\n", c.Clock)
+	fmt.Fprintf(w, "reads:              %d\n", c.Reads)
+	fmt.Fprintf(w, "writes:             %d\n", c.Writes)
+	fmt.Fprintf(w, "branches taken:     %d\n", c.BranchesTaken)
+	fmt.Fprintf(w, "branches not taken: %d\n", c.BranchesNotTaken)
+}
+
+// Serve a single GDB session for m on addr
+func serveGDB(addr string, m *vm.Machine, memory []byte, stderr io.Writer) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	fmt.Fprintf(stderr, "waiting for gdb on %s\n", l.Addr())
+	conn, err := l.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return gdbstub.New(m, memory).Serve(conn)
+}
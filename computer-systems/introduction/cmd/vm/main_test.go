@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"vm"
+)
+
+func writeProgram(t *testing.T, src string) string {
+	path := filepath.Join(t.TempDir(), "program.s")
+	if err := os.WriteFile(path, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	path := writeProgram(t, "load r1, 1\nload r2, 2\nadd r1, r2\nstore r1, 0\nhalt")
+	var stdout, stderr strings.Builder
+	if status := run([]string{path, "3", "4"}, strings.NewReader(""), &stdout, &stderr); status != 0 {
+		t.Fatalf("Expected status 0, got %d: %s", status, stderr.String())
+	}
+	for _, s := range []string{"R1 = 0x07 (7)", "00: 0x07 (7)\n01: 0x03 (3)\n02: 0x04 (4)"} {
+		if !strings.Contains(stdout.String(), s) {
+			t.Errorf("Expected output to contain %q, got\n%s", s, stdout.String())
+		}
+	}
+}
+
+func TestRunEntry(t *testing.T) {
+	// the table ahead of the code would fault if run
+	path := writeProgram(t, ".entry start\ntable: .byte 0x40, 0x50\nstart: li r1, 9\nstore r1, 0\nhalt")
+	var stdout, stderr strings.Builder
+	if status := run([]string{path}, strings.NewReader(""), &stdout, &stderr); status != 0 {
+		t.Fatalf("Expected status 0, got %d: %s", status, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "00: 0x09 (9)") {
+		t.Errorf("Expected the program to start at its entry point, got\n%s", stdout.String())
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	path := writeProgram(t, "li r1, 72\nstore r1, 7\nli r1, 5\nexit r1")
+	var stdout, stderr strings.Builder
+	if status := run([]string{"-format", "json", path}, strings.NewReader(""), &stdout, &stderr); status != 5 {
+		t.Fatalf("Expected the program's exit status 5, got %d: %s", status, stderr.String())
+	}
+	// the console output comes first
+	out := stdout.String()
+	if !strings.HasPrefix(out, "H") {
+		t.Fatalf("Expected console output before the state, got %q", out)
+	}
+	var s state
+	if err := json.Unmarshal([]byte(out[1:]), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.ExitCode != 5 || s.Registers[0] != 5 || s.Cycles != 4 || len(s.Data) != 8 || s.Fault != "" {
+		t.Errorf("Unexpected final state %+v", s)
+	}
+}
+
+func TestRunSys(t *testing.T) {
+	path := writeProgram(t, "li r1, 1\nli r2, 4\nsys 2\nli r1, 1\nsys 1\nhalt")
+	var stdout, stderr strings.Builder
+	if status := run([]string{path}, strings.NewReader("abc\n"), &stdout, &stderr); status != 0 {
+		t.Fatalf("Expected status 0, got %d: %s", status, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "abc") {
+		t.Errorf("Expected the line read to be printed, got\n%s", stdout.String())
+	}
+}
+
+func TestRunScreen(t *testing.T) {
+	memory := make([]byte, vm.MemorySize)
+	dataSize := 8 + vm.ScreenSize
+	copy(memory[dataSize:], []byte{vm.LoadImm, 1, 'A', vm.Store, 1, 8, vm.Halt})
+	path := filepath.Join(t.TempDir(), "screen.img")
+	if err := vm.SaveImage(path, memory, &vm.ImageHeader{DataSize: dataSize, Entry: dataSize}); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr strings.Builder
+	if status := run([]string{"-screen", "8", path}, strings.NewReader(""), &stdout, &stderr); status != 0 {
+		t.Fatalf("Expected status 0, got %d: %s", status, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "+\n|A               |\n|") {
+		t.Errorf("Expected the screen to be drawn, got\n%s", stdout.String())
+	}
+}
+
+func TestRunCoverage(t *testing.T) {
+	path := writeProgram(t, "load r1, 1\nbeqz r1, 1\nhalt\nhalt")
+	report := filepath.Join(t.TempDir(), "coverage.txt")
+	var stdout, stderr strings.Builder
+	if status := run([]string{"-coverage", report, path}, strings.NewReader(""), &stdout, &stderr); status != 0 {
+		t.Fatalf("Expected status 0, got %d: %s", status, stderr.String())
+	}
+	got, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), "#####   0e: halt\n    1   0f: halt\n; 3 of 4 instructions executed") {
+		t.Errorf("Unexpected coverage report\n%s", got)
+	}
+}
+
+func TestRunStats(t *testing.T) {
+	path := writeProgram(t, "load r1, 1\nload r2, 2\nadd r1, r2\nstore r1, 0\nhalt")
+	var stdout, stderr strings.Builder
+	if status := run([]string{"-stats", path, "3", "4"}, strings.NewReader(""), &stdout, &stderr); status != 0 {
+		t.Fatalf("Expected status 0, got %d: %s", status, stderr.String())
+	}
+	expected := `instructions:       5
+clock cycles:       11
+reads:              2
+writes:             1
+branches taken:     0
+branches not taken: 0
+`
+	if stderr.String() != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, stderr.String())
+	}
+}
+
+func TestRunHarts(t *testing.T) {
+	path := writeProgram(t, "hartid r2\nload r1, 0\naddi r1, 1\nstore r1, 0\nhalt")
+	var stdout, stderr strings.Builder
+	if status := run([]string{"-harts", "3", path}, strings.NewReader(""), &stdout, &stderr); status != 0 {
+		t.Fatalf("Expected status 0, got %d: %s", status, stderr.String())
+	}
+	// every hart loads the count before any stores it
+	for _, s := range []string{"hart 2:\nPC = 0x13\nSP = 0xE0\nR1 = 0x01 (1)\nR2 = 0x02 (2)", "00: 0x01 (1)"} {
+		if !strings.Contains(stdout.String(), s) {
+			t.Errorf("Expected output to contain %q, got\n%s", s, stdout.String())
+		}
+	}
+}
+
+func TestRunSymbolic(t *testing.T) {
+	path := writeProgram(t, `
+        load r1, 0
+        load r2, 1
+        blt r1, r2, second
+        store r1, 2
+        halt
+second: store r2, 2
+        halt`)
+	var stdout, stderr strings.Builder
+	if status := run([]string{"-symbolic", "0,1", path}, strings.NewReader(""), &stdout, &stderr); status != 0 {
+		t.Fatalf("Expected status 0, got %d: %s", status, stderr.String())
+	}
+	expected := `path 1: halts after 5 instructions, for example with mem[0x00] = 0, mem[0x01] = 0
+	if ((mem[0x00] < mem[0x01]) == 0)
+	r1 = mem[0x00]
+	r2 = mem[0x01]
+	mem[0x02] = mem[0x00]
+path 2: halts after 5 instructions, for example with mem[0x00] = 0, mem[0x01] = 1
+	if (mem[0x00] < mem[0x01])
+	r1 = mem[0x00]
+	r2 = mem[0x01]
+	mem[0x02] = mem[0x01]
+`
+	if stdout.String() != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, stdout.String())
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	loop := writeProgram(t, "top: jump top")
+	bad := writeProgram(t, "store r1, 8\nhalt\nhalt")
+	tests := []struct {
+		name   string
+		args   []string
+		status int
+		stderr string
+	}{
+		{"Usage", nil, 2, "usage"},
+		{"Format", []string{"-format", "xml", loop}, 2, "usage"},
+		{"Missing", []string{"nope.s"}, 1, "no such file"},
+		{"Input", []string{loop, "256"}, 2, "invalid input"},
+		{"MaxSteps", []string{"-max-steps", "10", loop}, 1, "cycle limit exceeded"},
+		{"Trace", []string{"-trace", "-max-steps", "2", loop}, 1, "08: jump 8"},
+		{"JSONTrace", []string{"-trace", "-trace-format", "json", "-max-steps", "1", loop}, 1, `"pc":8,"opcode":7,"mnemonic":"jump","operands":[8]`},
+		{"TraceFormat", []string{"-trace-format", "xml", loop}, 2, "usage"},
+		{"Verify", []string{bad}, 1, "verify: write to read-only memory: store to instruction region 0x8 at pc 0x8\nverify: warning: unreachable code from 0xc to 0xc\nnot running"},
+		{"HartsJSON", []string{"-harts", "2", "-format", "json", loop}, 2, "usage"},
+		{"SymbolicAddrs", []string{"-symbolic", "0,x", loop}, 2, "bad address \"x\""},
+		{"NoVerify", []string{"-no-verify", bad}, 1, "fault: write to read-only memory"},
+	}
+	for _, test := range tests {
+		var stdout, stderr strings.Builder
+		status := run(test.args, strings.NewReader(""), &stdout, &stderr)
+		if status != test.status || !strings.Contains(stderr.String(), test.stderr) {
+			t.Errorf("%s: expected status %d and %q, got %d and\n%s", test.name, test.status, test.stderr, status, stderr.String())
+		}
+	}
+}
+
+func TestREPL(t *testing.T) {
+	input := `li r1, 40
+addi r1, 2
+:regs
+store r1, 3
+:mem 3 1
+frob
+jump 8
+:mem 0x08 3
+halt
+addi r1, 1
+:reset
+:regs
+li r1, 9
+exit r1
+:quit
+li r1, 1
+`
+	var stdout, stderr strings.Builder
+	if status := run([]string{"-repl"}, strings.NewReader(input), &stdout, &stderr); status != 0 {
+		t.Fatalf("Expected status 0, got %d: %s", status, stderr.String())
+	}
+	expected := []string{
+		"R1 = 0x2A (42)",
+		"03: 0x2A (42)",
+		"unknown mnemonic",
+		// the jump goes back to the li, which the halt then replaces
+		"08: 0x09 (9)\n09: 0x01 (1)\n0A: 0x28 (40)",
+		"halted",
+		"the machine has halted",
+		"PC = 0x08\nSP = 0x100\nR1 = 0x00 (0)",
+		"exited with status 9",
+	}
+	got := stdout.String()
+	for _, s := range expected {
+		i := strings.Index(got, s)
+		if i < 0 {
+			t.Fatalf("Expected output to contain %q, got\n%s", s, stdout.String())
+		}
+		got = got[i+len(s):]
+	}
+}
+
+func TestREPLConfig(t *testing.T) {
+	// a 128 byte machine with a 16 byte data region, so the code starts
+	// at 0x10
+	var out strings.Builder
+	newREPL(&out, vm.MachineConfig{MemSize: 128, DataEnd: 16}).run(strings.NewReader("li r1, 7\nstore r1, 12\n:mem\n:regs\n"))
+	for _, s := range []string{"0C: 0x07 (7)\n0D: 0x00 (0)\n0E: 0x00 (0)\n0F: 0x00 (0)\n>", "PC = 0x16\nSP = 0x80"} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("Expected output to contain %q, got\n%s", s, out.String())
+		}
+	}
+}
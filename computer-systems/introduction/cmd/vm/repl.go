@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"vm"
+	"vm/asm"
+)
+
+const replHelp = `Type an instruction to assemble it at the PC and execute it, or:
+  :regs             print the registers
+  :mem [ADDR [N]]   print N bytes of memory from ADDR, by default the data region
+  :reset            start over with a new machine and zeroed memory
+  :help             show this message
+  :quit             exit
+`
+
+// A repl assembles and executes instructions one at a time as they are
+// typed, on a machine that lives for the whole session. Each
+// instruction is written into memory at the PC and executed there, so
+// after a jump back the next one overwrites the code it lands on. The
+// machine's memory is laid out as config describes.
+type repl struct {
+	memory  []byte
+	machine *vm.Machine
+	config  vm.MachineConfig
+	opts    []vm.Option
+	out     io.Writer
+}
+
+func newREPL(out io.Writer, config vm.MachineConfig, opts ...vm.Option) *repl {
+	r := &repl{out: out, config: config.Complete(), opts: opts}
+	r.reset()
+	return r
+}
+
+func (r *repl) reset() {
+	r.memory = make([]byte, r.config.MemSize)
+	opts := append([]vm.Option{vm.WithConfig(r.config)}, r.opts...)
+	r.machine = vm.NewMachine(r.memory, append(opts, vm.WithConsole(r.out))...)
+}
+
+// Read lines from in until it is exhausted or the user quits
+func (r *repl) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(r.out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(r.out)
+			return
+		}
+		if !r.line(strings.TrimSpace(scanner.Text())) {
+			return
+		}
+	}
+}
+
+// Handle a line of input, reporting whether to keep going
+func (r *repl) line(line string) bool {
+	if line == "" || strings.HasPrefix(line, ";") {
+		return true
+	}
+	if !strings.HasPrefix(line, ":") {
+		r.execute(line)
+		return true
+	}
+
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case ":regs":
+		r.machine.DumpRegisters(r.out, vm.Unsigned)
+	case ":mem":
+		r.dumpMemory(fields[1:])
+	case ":reset":
+		r.reset()
+	case ":help":
+		fmt.Fprint(r.out, replHelp)
+	case ":quit":
+		return false
+	default:
+		fmt.Fprintf(r.out, "unknown command %q; try :help\n", fields[0])
+	}
+	return true
+}
+
+// Assemble an instruction at the PC and execute it
+func (r *repl) execute(src string) {
+	if r.machine.Halted() {
+		fmt.Fprintln(r.out, "the machine has halted; :reset to start over")
+		return
+	}
+	p, err := asm.ParseConfig(src, r.config)
+	if err == nil && (len(p.Instructions) != 1 || p.Instructions[0].Data != nil) {
+		err = fmt.Errorf("expected a single instruction")
+	}
+	var image []byte
+	if err == nil {
+		image, err = asm.Emit(p)
+	}
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+
+	pc, width := r.machine.PC(), p.Instructions[0].Op.Width()
+	if pc < r.config.DataEnd || pc+width > len(r.memory) {
+		fmt.Fprintf(r.out, "no room for an instruction at pc 0x%02X; :reset to start over\n", pc)
+		return
+	}
+	start := r.config.CodeStart
+	copy(r.memory[pc:], image[start:start+width])
+	err = r.machine.Step()
+	var exit *vm.ExitError
+	switch {
+	case errors.As(err, &exit):
+		fmt.Fprintf(r.out, "exited with status %d\n", exit.Code)
+	case err != nil:
+		fmt.Fprintf(r.out, "fault: %v\n", err)
+	case r.machine.Halted():
+		fmt.Fprintln(r.out, "halted")
+	}
+}
+
+func (r *repl) dumpMemory(args []string) {
+	var addr uint64
+	n := r.config.DataEnd
+	var err error
+	if len(args) > 0 {
+		addr, err = strconv.ParseUint(args[0], 0, 16)
+	}
+	if err == nil && len(args) > 1 {
+		n, err = strconv.Atoi(args[1])
+	}
+	if err != nil || n < 0 || len(args) > 2 {
+		fmt.Fprintln(r.out, "usage: :mem [ADDR [N]]")
+		return
+	}
+	for i := int(addr); i < int(addr)+n && i < len(r.memory); i++ {
+		fmt.Fprintf(r.out, "%02X: %s\n", i, vm.FormatValue(r.memory[i], vm.Unsigned))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"vm"
+)
+
+// Parse the addresses given to -symbolic, separated by commas
+func parseAddrs(list string) ([]int, error) {
+	var addrs []int
+	for _, field := range strings.Split(list, ",") {
+		addr, err := strconv.ParseUint(strings.TrimSpace(field), 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("bad address %q", field)
+		}
+		addrs = append(addrs, int(addr))
+	}
+	return addrs, nil
+}
+
+// Print how each path ends, the conditions that take it, and the
+// registers and data it changes, in terms of the inputs
+func printPaths(w io.Writer, paths []*vm.Path, memory []byte, inputs []int) {
+	for i, p := range paths {
+		ending := fmt.Sprintf("halts after %d instructions", p.Steps)
+		if p.Err != nil {
+			ending = p.Err.Error()
+		}
+		var example []string
+		for _, addr := range inputs {
+			example = append(example, fmt.Sprintf("%s = %d", vm.Input(addr), p.Inputs[addr]))
+		}
+		fmt.Fprintf(w, "path %d: %s, for example with %s\n", i+1, ending, strings.Join(example, ", "))
+		for _, cond := range p.Conditions {
+			fmt.Fprintf(w, "\tif %s\n", cond)
+		}
+		for n := 1; n <= vm.MaxRegister; n++ {
+			if v, ok := p.Register(n).Value(); !ok || v != 0 {
+				fmt.Fprintf(w, "\tr%d = %s\n", n, p.Register(n))
+			}
+		}
+		isInput := map[int]bool{}
+		for _, addr := range inputs {
+			isInput[addr] = true
+		}
+		for addr := range memory {
+			initial := vm.Const(memory[addr])
+			if isInput[addr] {
+				initial = vm.Input(addr)
+			}
+			if got := p.Memory(addr).String(); got != initial.String() {
+				fmt.Fprintf(w, "\t%s = %s\n", vm.Input(addr), got)
+			}
+		}
+	}
+}
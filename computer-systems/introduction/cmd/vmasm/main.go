@@ -0,0 +1,131 @@
+// Command vmasm assembles programs for the toy VM into memory images.
+//
+//	vmasm [flags] source...
+//
+// Each source file is parsed on its own, and if there are several they
+// are linked together, in the order given, so that they can share the
+// labels they declare with .global and .extern. The image is saved with
+// a header recording its entry point, as vm.SaveImage writes it, or in
+// Intel HEX format if the output name ends in .hex, which has no room
+// for a header, so execution starts at the first instruction.
+//
+// The flags are:
+//
+//	-g
+//		also write debug info mapping the image back to the source,
+//		next to the image with the extension .dbg, where vm and vmdbg
+//		look for it
+//	-o path
+//		write the image to path (default: the first source with the
+//		extension .img)
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"vm"
+	"vm/asm"
+	"vm/internal/program"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stderr))
+}
+
+// Run the command with the given arguments, returning its exit status
+func run(args []string, stderr io.Writer) int {
+	flags := flag.NewFlagSet("vmasm", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: vmasm [flags] source...")
+		flags.PrintDefaults()
+	}
+	out := flags.String("o", "", "write the image to `path`")
+	debug := flags.Bool("g", false, "also write debug info")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 2
+	}
+	if *out == "" {
+		first := flags.Arg(0)
+		*out = strings.TrimSuffix(first, filepath.Ext(first)) + ".img"
+	}
+
+	var objects []asm.Program
+	for _, path := range flags.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		p, err := asm.Parse(string(src))
+		if err != nil {
+			report(stderr, path, err)
+			return 1
+		}
+		for i := range p.Instructions {
+			p.Instructions[i].File = path
+		}
+		objects = append(objects, p)
+	}
+	p := objects[0]
+	if len(objects) > 1 {
+		var err error
+		if p, err = asm.Link(objects...); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+	memory, err := asm.Emit(p)
+	if err != nil {
+		// problems in a linked program can't be pinned on one file
+		if len(objects) > 1 {
+			fmt.Fprintln(stderr, err)
+		} else {
+			report(stderr, flags.Arg(0), err)
+		}
+		return 1
+	}
+
+	if filepath.Ext(*out) == ".hex" {
+		err = writeHex(*out, memory)
+	} else {
+		err = vm.SaveImage(*out, memory, &vm.ImageHeader{DataSize: vm.DefaultDataSize, Entry: p.EntryPoint()})
+	}
+	if err == nil && *debug {
+		err = vm.SaveDebugInfo(program.DebugPath(*out), p.DebugInfo(""))
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// Print each problem in err with the file it was found in, as compilers
+// do
+func report(w io.Writer, path string, err error) {
+	for _, line := range strings.Split(err.Error(), "\n") {
+		fmt.Fprintf(w, "%s:%s\n", path, line)
+	}
+}
+
+func writeHex(path string, memory []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := vm.WriteHex(f, memory); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"vm"
+	"vm/internal/program"
+)
+
+func writeSource(t *testing.T, dir, name, src string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	mainPath := writeSource(t, dir, "main.s", ".extern double\n.entry start\nstop: halt\nstart: load r1, 1\ncall double\nstore r1, 0\njump stop")
+	lib := writeSource(t, dir, "lib.s", ".global double\ndouble: add r1, r1\nret")
+	var stderr strings.Builder
+	if status := run([]string{"-g", mainPath, lib}, &stderr); status != 0 {
+		t.Fatalf("Expected status 0, got %d: %s", status, stderr.String())
+	}
+
+	memory, opts, err := program.Load(filepath.Join(dir, "main.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory[1] = 21
+	m := vm.NewMachine(memory, opts...)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if memory[0] != 42 {
+		t.Errorf("Expected 21 * 2 = 42, got %d", memory[0])
+	}
+	debug := m.DebugInfo()
+	if debug == nil {
+		t.Fatal("Expected the debug info to be loaded with the image")
+	}
+	if got := debug.Describe(debug.Symbols["double"]); got != lib+":2 (double)" {
+		t.Errorf("Expected double to be described as %s:2, got %q", lib, got)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := writeSource(t, dir, "bad.s", "halt\nfrob r1")
+	var stderr strings.Builder
+	if status := run([]string{"-o", filepath.Join(dir, "bad.hex"), path}, &stderr); status != 1 {
+		t.Fatalf("Expected status 1, got %d", status)
+	}
+	if expected := path + ":2:1: unknown mnemonic"; !strings.HasPrefix(stderr.String(), expected) {
+		t.Errorf("Expected %q, got %q", expected, stderr.String())
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"vm"
+)
+
+const help = `commands:
+  break ADDR, b ADDR    stop before executing the instruction at ADDR,
+                        which may be a label if there is debug info
+  break, b              list the breakpoints
+  watch ADDR [N]        stop after an instruction writes any of the N
+                        bytes starting at ADDR (default 1)
+  rwatch ADDR [N]       stop after an instruction reads any of them
+  awatch ADDR [N]       stop after an instruction reads or writes them
+  watch                 list the watchpoints
+  delete ADDR           remove the breakpoint and watchpoint at ADDR
+  step [N], s [N]       execute N instructions (default 1)
+  continue, c           run until a breakpoint, halt or fault
+  step-back [N], sb [N] undo the last N instructions (default 1)
+  reverse-continue, rc  undo instructions back to the previous breakpoint,
+                        or as far as the history goes
+  print, p              print the registers
+  x/N ADDR              print N bytes of memory starting at ADDR
+  dump                  print all of memory
+  diff                  show what the last command that ran the program
+                        changed
+  signed                toggle showing values as signed bytes
+  quit, q               exit
+An empty line repeats the last command.
+`
+
+// A watchpoint covers n bytes starting at addr
+type watchpoint struct {
+	addr, n     int
+	read, write bool
+}
+
+func (w watchpoint) String() string {
+	kind := "watch"
+	if w.read && w.write {
+		kind = "awatch"
+	} else if w.read {
+		kind = "rwatch"
+	}
+	if w.n == 1 {
+		return fmt.Sprintf("%s 0x%02X", kind, w.addr)
+	}
+	return fmt.Sprintf("%s 0x%02X-0x%02X", kind, w.addr, w.addr+w.n-1)
+}
+
+// An access that triggered a watchpoint, with the value before it
+type watchHit struct {
+	access vm.MemoryAccess
+	old    byte
+}
+
+// How many instructions can be stepped back over
+const historySize = 100000
+
+type debugger struct {
+	memory      []byte
+	machine     *vm.Machine
+	breakpoints map[int]bool
+	watchpoints []watchpoint
+	hits        []watchHit // during the current step
+	before      []byte     // a snapshot from before the program last ran
+	mode        vm.DisplayMode
+	out         io.Writer
+	done        bool // the program has halted or faulted
+}
+
+func newDebugger(memory []byte, out io.Writer, opts ...vm.Option) *debugger {
+	d := &debugger{
+		memory:      memory,
+		breakpoints: map[int]bool{},
+		out:         out,
+	}
+	d.machine = vm.NewMachine(memory, append(opts, vm.WithConsole(out), vm.WithAccessHook(d.watch),
+		vm.WithHistory(historySize))...)
+	return d
+}
+
+// Read and execute commands from in until it is exhausted or the user
+// quits
+func (d *debugger) repl(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	last := ""
+	for {
+		fmt.Fprint(d.out, "(vmdbg) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(d.out)
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			line = last
+		}
+		last = line
+		if !d.command(line) {
+			return
+		}
+	}
+}
+
+// Execute a single command, reporting whether to keep going
+func (d *debugger) command(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true
+	}
+	cmd, args := fields[0], fields[1:]
+	switch cmd {
+	case "step", "s", "continue", "c", "step-back", "sb", "reverse-continue", "rc":
+		d.before, _ = d.machine.Snapshot()
+	}
+
+	switch {
+	case (cmd == "break" || cmd == "b") && len(args) == 0:
+		for _, addr := range d.sortedBreakpoints() {
+			fmt.Fprintf(d.out, "breakpoint at 0x%02X\n", addr)
+		}
+	case cmd == "break" || cmd == "b":
+		if addr, ok := d.addrArg(args); ok {
+			d.breakpoints[addr] = true
+			fmt.Fprintf(d.out, "breakpoint at 0x%02X\n", addr)
+		}
+	case cmd == "watch" && len(args) == 0:
+		for _, w := range d.watchpoints {
+			fmt.Fprintln(d.out, w)
+		}
+	case cmd == "watch" || cmd == "rwatch" || cmd == "awatch":
+		n := 1
+		if len(args) == 2 {
+			var err error
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				fmt.Fprintf(d.out, "invalid count %q\n", args[1])
+				return true
+			}
+			args = args[:1]
+		}
+		if addr, ok := d.addrArg(args); ok {
+			w := watchpoint{addr: addr, n: n, read: cmd != "watch", write: cmd != "rwatch"}
+			d.watchpoints = append(d.watchpoints, w)
+			fmt.Fprintln(d.out, w)
+		}
+	case cmd == "delete":
+		if addr, ok := d.addrArg(args); ok {
+			delete(d.breakpoints, addr)
+			kept := d.watchpoints[:0]
+			for _, w := range d.watchpoints {
+				if w.addr != addr {
+					kept = append(kept, w)
+				}
+			}
+			d.watchpoints = kept
+		}
+	case cmd == "step" || cmd == "s":
+		n := 1
+		if len(args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+				fmt.Fprintf(d.out, "invalid count %q\n", args[0])
+				return true
+			}
+		}
+		for i := 0; i < n && d.stepOnce(); i++ {
+		}
+		d.where()
+	case cmd == "continue" || cmd == "c":
+		for d.stepOnce() && !d.breakpoints[d.machine.PC()] {
+		}
+		d.where()
+	case cmd == "step-back" || cmd == "sb":
+		n := 1
+		if len(args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+				fmt.Fprintf(d.out, "invalid count %q\n", args[0])
+				return true
+			}
+		}
+		for i := 0; i < n && d.stepBackOnce(); i++ {
+		}
+		d.where()
+	case cmd == "reverse-continue" || cmd == "rc":
+		for d.stepBackOnce() && !d.breakpoints[d.machine.PC()] {
+		}
+		d.where()
+	case cmd == "print" || cmd == "p":
+		d.machine.DumpRegisters(d.out, d.mode)
+	case strings.HasPrefix(cmd, "x/"):
+		n, err := strconv.Atoi(cmd[2:])
+		if err != nil || n < 1 {
+			fmt.Fprintf(d.out, "invalid count %q\n", cmd[2:])
+			return true
+		}
+		if addr, ok := d.addrArg(args); ok {
+			d.examine(addr, n)
+		}
+	case cmd == "dump":
+		d.machine.Dump(d.out)
+	case cmd == "diff":
+		d.diff()
+	case cmd == "signed":
+		if d.mode == vm.Signed {
+			d.mode = vm.Unsigned
+			fmt.Fprintln(d.out, "showing unsigned values")
+		} else {
+			d.mode = vm.Signed
+			fmt.Fprintln(d.out, "showing signed values")
+		}
+	case cmd == "help" || cmd == "h":
+		fmt.Fprint(d.out, help)
+	case cmd == "quit" || cmd == "q":
+		return false
+	default:
+		fmt.Fprintf(d.out, "unknown command %q; try help\n", cmd)
+	}
+	return true
+}
+
+// Execute one instruction, reporting whether the program can go on
+func (d *debugger) stepOnce() bool {
+	if d.done {
+		return false
+	}
+	d.hits = d.hits[:0]
+	err := d.machine.Step()
+	// the instruction has completed, so report what it touched before
+	// how it ended
+	for _, hit := range d.hits {
+		a := hit.access
+		if a.IsWrite {
+			fmt.Fprintf(d.out, "watchpoint: pc 0x%02X wrote 0x%02X: %s -> %s\n", a.PC, a.Addr,
+				vm.FormatValue(hit.old, d.mode), vm.FormatValue(d.memory[a.Addr], d.mode))
+		} else {
+			fmt.Fprintf(d.out, "watchpoint: pc 0x%02X read 0x%02X: %s\n", a.PC, a.Addr, vm.FormatValue(hit.old, d.mode))
+		}
+	}
+	var exit *vm.ExitError
+	if errors.As(err, &exit) {
+		fmt.Fprintf(d.out, "exited with status %d\n", exit.Code)
+		d.done = true
+		return false
+	}
+	if err != nil {
+		fmt.Fprintf(d.out, "fault: %v\n", err)
+		d.done = true
+		return false
+	}
+	if d.machine.Halted() {
+		fmt.Fprintln(d.out, "halted")
+		d.done = true
+		return false
+	}
+	return len(d.hits) == 0
+}
+
+// Undo one instruction, reporting whether there was one to undo
+func (d *debugger) stepBackOnce() bool {
+	if !d.machine.StepBack() {
+		fmt.Fprintln(d.out, "no more history")
+		return false
+	}
+	d.done = false
+	return true
+}
+
+// Record an access the machine makes if a watchpoint covers it
+func (d *debugger) watch(access vm.MemoryAccess) {
+	for _, w := range d.watchpoints {
+		covered := access.Addr >= w.addr && access.Addr < w.addr+w.n
+		if covered && (access.IsWrite && w.write || !access.IsWrite && w.read) {
+			d.hits = append(d.hits, watchHit{access, d.memory[access.Addr]})
+			return
+		}
+	}
+}
+
+// Show where execution has stopped
+func (d *debugger) where() {
+	pc := d.machine.PC()
+	mnemonic := "?"
+	if pc < len(d.memory) {
+		if op, ok := vm.LookupOp(d.memory[pc]); ok {
+			mnemonic = op.Mnemonic
+		}
+	}
+	marker := ""
+	if d.breakpoints[pc] {
+		marker = " (breakpoint)"
+	}
+	if debug := d.machine.DebugInfo(); debug != nil {
+		if desc := debug.Describe(pc); desc != "" {
+			marker += " at " + desc
+		}
+	}
+	fmt.Fprintf(d.out, "pc 0x%02X: %s%s\n", pc, mnemonic, marker)
+}
+
+func (d *debugger) diff() {
+	if d.before == nil {
+		fmt.Fprintln(d.out, "the program hasn't run yet")
+		return
+	}
+	before := vm.NewMachine(make([]byte, len(d.memory)))
+	if err := before.Restore(d.before); err != nil {
+		fmt.Fprintln(d.out, err)
+		return
+	}
+	if diff := vm.DiffStates(before, d.machine); diff != "" {
+		fmt.Fprint(d.out, diff)
+	} else {
+		fmt.Fprintln(d.out, "no changes")
+	}
+}
+
+func (d *debugger) examine(addr, n int) {
+	for i := addr; i < addr+n && i < len(d.memory); i++ {
+		fmt.Fprintf(d.out, "%02X: %s\n", i, vm.FormatValue(d.memory[i], d.mode))
+	}
+}
+
+func (d *debugger) addrArg(args []string) (int, bool) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "expected an address")
+		return 0, false
+	}
+	if debug := d.machine.DebugInfo(); debug != nil {
+		if addr, ok := debug.Symbols[args[0]]; ok {
+			return addr, true
+		}
+	}
+	n, err := strconv.ParseUint(args[0], 0, 16)
+	if err != nil {
+		fmt.Fprintf(d.out, "invalid address %q\n", args[0])
+		return 0, false
+	}
+	return int(n), true
+}
+
+// The breakpoints in address order
+func (d *debugger) sortedBreakpoints() []int {
+	var addrs []int
+	for addr := range d.breakpoints {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+	return addrs
+}
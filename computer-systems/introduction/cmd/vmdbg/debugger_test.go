@@ -0,0 +1,212 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"vm"
+	"vm/asm"
+)
+
+func TestDebugger(t *testing.T) {
+	memory, err := asm.Assemble(`
+        load r1, 1
+loop:   beqz r1, done
+        add r2, r1
+        subi r1, 1
+        jump loop
+done:   store r2, 0
+        halt`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory[1] = 3
+
+	var out strings.Builder
+	d := newDebugger(memory, &out)
+	d.repl(strings.NewReader(`break 0x0e
+continue
+
+print
+delete 0x0e
+step 2
+signed
+x/2 0
+continue
+`))
+
+	expected := []string{
+		"breakpoint at 0x0E",
+		"pc 0x0E: add (breakpoint)",
+		// the empty line continues again, to the next iteration
+		"pc 0x0E: add (breakpoint)",
+		"PC = 0x0E\nSP = 0x100\nR1 = 0x02 (2)\nR2 = 0x03 (3)\n",
+		"pc 0x14: jump",
+		"showing signed values",
+		"00: 0x00 (0 / 0)\n01: 0x03 (3 / 3)\n",
+		"halted\npc 0x19: halt",
+	}
+	got := out.String()
+	for _, s := range expected {
+		i := strings.Index(got, s)
+		if i < 0 {
+			t.Fatalf("Expected output to contain %q, got\n%s", s, out.String())
+		}
+		got = got[i+len(s):]
+	}
+	if memory[0] != 6 {
+		t.Fatalf("Expected the program to run to completion, storing 6, got %d", memory[0])
+	}
+}
+
+func TestDebuggerExit(t *testing.T) {
+	memory, err := asm.Assemble("li r1, 3\nexit r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	newDebugger(memory, &out).repl(strings.NewReader("continue\nstep\n"))
+	if expected := "exited with status 3\npc 0x0B: exit\n(vmdbg) pc 0x0B: exit\n"; !strings.Contains(out.String(), expected) {
+		t.Fatalf("Expected output to contain %q, got\n%s", expected, out.String())
+	}
+}
+
+func TestDebuggerDebugInfo(t *testing.T) {
+	p, err := asm.Parse("        li r1, 2\nloop:   subi r1, 1\n        bnz loop\n        halt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory, err := asm.Emit(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	d := newDebugger(memory, &out, vm.WithDebugInfo(p.DebugInfo("count.s")))
+	d.repl(strings.NewReader("break loop\ncontinue\nstep\n"))
+	for _, s := range []string{
+		"breakpoint at 0x0B",
+		"pc 0x0B: subi (breakpoint) at count.s:2 (loop)",
+		"pc 0x0E: bnz at count.s:3 (loop+3)",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("Expected output to contain %q, got\n%s", s, out.String())
+		}
+	}
+}
+
+func TestDebuggerWatchpoints(t *testing.T) {
+	memory, err := asm.Assemble(`
+        load r1, 1
+        li r2, 5
+        store r2, 0
+        push r2
+        pop r3
+        store r3, 2
+        halt`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory[0], memory[1] = 7, 3
+
+	var out strings.Builder
+	newDebugger(memory, &out).repl(strings.NewReader(`rwatch 1
+watch 0 3
+awatch 0xff
+watch
+continue
+continue
+continue
+delete 0
+continue
+`))
+	expected := []string{
+		"(vmdbg) awatch 0xFF\n",
+		"(vmdbg) rwatch 0x01\nwatch 0x00-0x02\nawatch 0xFF\n",
+		"watchpoint: pc 0x08 read 0x01: 0x03 (3)\npc 0x0B: li",
+		"watchpoint: pc 0x0E wrote 0x00: 0x07 (7) -> 0x05 (5)\npc 0x11: push",
+		"watchpoint: pc 0x11 wrote 0xFF: 0x00 (0) -> 0x05 (5)\npc 0x13: pop",
+		// the store to 2 is no longer watched, but the pop is
+		"watchpoint: pc 0x13 read 0xFF: 0x05 (5)\npc 0x15: store",
+	}
+	got := out.String()
+	for _, s := range expected {
+		i := strings.Index(got, s)
+		if i < 0 {
+			t.Fatalf("Expected output to contain %q, got\n%s", s, out.String())
+		}
+		got = got[i+len(s):]
+	}
+}
+
+func TestDebuggerReverse(t *testing.T) {
+	memory, err := asm.Assemble(`
+        load r1, 1
+loop:   beqz r1, done
+        add r2, r1
+        subi r1, 1
+        jump loop
+done:   store r2, 0
+        halt`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory[1] = 3
+
+	var out strings.Builder
+	newDebugger(memory, &out).repl(strings.NewReader(`break 0x0e
+continue
+continue
+step-back 2
+print
+reverse-continue
+print
+reverse-continue
+continue
+delete 0x0e
+continue
+x/1 0
+step-back
+continue
+`))
+	expected := []string{
+		"pc 0x0E: add (breakpoint)",
+		"pc 0x0E: add (breakpoint)",
+		"pc 0x14: jump",
+		"R1 = 0x02 (2)\nR2 = 0x03 (3)\n",
+		"pc 0x0E: add (breakpoint)",
+		"R1 = 0x03 (3)\nR2 = 0x00 (0)\n",
+		"no more history\npc 0x08: load",
+		"pc 0x0E: add (breakpoint)",
+		"halted",
+		"00: 0x06 (6)",
+		// stepping back after halting lets the program run again
+		"pc 0x19: halt",
+		"halted",
+	}
+	got := out.String()
+	for _, s := range expected {
+		i := strings.Index(got, s)
+		if i < 0 {
+			t.Fatalf("Expected output to contain %q, got\n%s", s, out.String())
+		}
+		got = got[i+len(s):]
+	}
+}
+
+func TestDebuggerDiff(t *testing.T) {
+	memory, err := asm.Assemble("li r2, 6\nstore r2, 0\nhalt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	newDebugger(memory, &out).repl(strings.NewReader("diff\nstep 2\ndiff\ndump\n"))
+	for _, s := range []string{
+		"the program hasn't run yet",
+		"PC: 0x08 -> 0x0E\nR2: 0x00 -> 0x06\nmem 00: 0x00 -> 0x06\n",
+		"; data\n0000: 06 00 00 00 00 00 00 00 ",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("Expected output to contain %q, got\n%s", s, out.String())
+		}
+	}
+}
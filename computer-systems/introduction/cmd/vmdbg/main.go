@@ -0,0 +1,44 @@
+// Command vmdbg runs a program on the toy VM under an interactive
+// debugger.
+//
+//	vmdbg program [input...]
+//
+// The program is either assembly source, if its name ends in .s or
+// .asm, an image in Intel HEX format, if it ends in .hex, or a memory
+// image saved by vm.SaveImage. An image's header, if it has one, sets
+// the data size and entry point. Any further arguments are bytes written
+// into the data region starting at address 1, where the exercise
+// programs read their inputs. Bytes the program stores to the console
+// address, vm.ConsoleAddr, are printed as they are written.
+//
+// The debugger shows the source line of each instruction, and accepts
+// labels in place of addresses, for assembly source and for images
+// saved with debug info by vmasm -g.
+//
+// Type "help" at the prompt for the list of commands.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"vm/internal/program"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: vmdbg program [input...]")
+		os.Exit(2)
+	}
+	memory, opts, err := program.Load(os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := program.SetInputs(memory, os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	newDebugger(memory, os.Stdout, opts...).repl(os.Stdin)
+}
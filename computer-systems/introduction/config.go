@@ -0,0 +1,74 @@
+package vm
+
+// A MachineConfig describes the layout of a machine's memory, for
+// exercises that vary it. The data region runs from 0 up to DataEnd,
+// exclusive, and everything after it holds instructions, except for the
+// stack, which grows down from StackTop.
+//
+// A field left as zero takes its default, which for the original
+// exercise's 256 byte memory is DefaultConfig: MemSize is MemorySize,
+// or with wide addresses the size of the memory image; DataEnd is
+// DefaultDataSize; CodeStart is DataEnd; and StackTop is MemSize.
+type MachineConfig struct {
+	MemSize   int // the size of the memory image
+	CodeStart int // the entry point, where execution starts
+	DataEnd   int // the end of the data region
+	StackTop  int // the initial SP, which is just above the stack
+}
+
+// DefaultConfig is the layout of the original exercise:
+//
+//	00 01 02 03 04 05 06 07 08 09 0a 0b 0c 0d 0e 0f ... ff
+//	__ __ __ __ __ __ __ __ __ __ __ __ __ __ __ __ ... __
+//	^==DATA===============^ ^==INSTRUCTIONS==============^
+var DefaultConfig = MachineConfig{
+	MemSize:   MemorySize,
+	CodeStart: DefaultDataSize,
+	DataEnd:   DefaultDataSize,
+	StackTop:  MemorySize,
+}
+
+// Complete returns c with each field left as zero set to its default,
+// as WithConfig sets them for a machine with byte addresses, for tools
+// that lay out or read an image without a machine to ask.
+func (c MachineConfig) Complete() MachineConfig {
+	if c.MemSize == 0 {
+		c.MemSize = MemorySize
+	}
+	if c.DataEnd == 0 {
+		c.DataEnd = DefaultDataSize
+	}
+	if c.CodeStart == 0 {
+		c.CodeStart = c.DataEnd
+	}
+	if c.StackTop == 0 {
+		c.StackTop = c.MemSize
+	}
+	return c
+}
+
+// WithConfig lays out the machine's memory as c describes. It overrides
+// WithDataSize and WithEntryPoint, and the other way round if they come
+// after it. MemSize can be at most MemorySize, unless the machine has
+// wide addresses.
+func WithConfig(c MachineConfig) Option {
+	return func(m *Machine) {
+		m.memSize, m.stackTop = c.MemSize, c.StackTop
+		m.dataSize = DefaultDataSize
+		if c.DataEnd != 0 {
+			m.dataSize = c.DataEnd
+		}
+		m.entry, m.hasEntry = c.CodeStart, c.CodeStart != 0
+	}
+}
+
+// Config returns the layout of the machine's memory, with the defaults
+// filled in.
+func (m *Machine) Config() MachineConfig {
+	return MachineConfig{
+		MemSize:   len(m.memory),
+		CodeStart: m.entry,
+		DataEnd:   m.dataSize,
+		StackTop:  m.stackTop,
+	}
+}
@@ -0,0 +1,91 @@
+package vm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConfigDefaults(t *testing.T) {
+	memory := make([]byte, 256)
+	if c := NewMachine(memory).Config(); c != DefaultConfig {
+		t.Fatalf("Expected the default layout %+v, got %+v", DefaultConfig, c)
+	}
+	if c := NewMachine(memory, WithConfig(DefaultConfig)).Config(); c != DefaultConfig {
+		t.Fatalf("Expected %+v, got %+v", DefaultConfig, c)
+	}
+	expected := MachineConfig{MemSize: 256, CodeStart: 16, DataEnd: 16, StackTop: 256}
+	if c := NewMachine(memory, WithConfig(MachineConfig{DataEnd: 16})).Config(); c != expected {
+		t.Fatalf("Expected zero fields to take their defaults, %+v, got %+v", expected, c)
+	}
+	if c := (MachineConfig{DataEnd: 16}).Complete(); c != expected {
+		t.Fatalf("Expected Complete to fill in the same defaults, %+v, got %+v", expected, c)
+	}
+	if c := (MachineConfig{}).Complete(); c != DefaultConfig {
+		t.Fatalf("Expected Complete to give the default layout %+v, got %+v", DefaultConfig, c)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	// 128 bytes, with data up to 0x10, the code at 0x14, and 16 bytes of
+	// stack below 0x70, which leaves room for more code above it
+	config := MachineConfig{MemSize: 128, CodeStart: 0x14, DataEnd: 0x10, StackTop: 0x70}
+	memory := make([]byte, 128)
+	copy(memory[0x14:], assemble("load r1 15\npush r1\npop r2\npush r2\nstore r2 0\nhalt"))
+	memory[15] = 42
+	m := NewMachine(memory, WithConfig(config))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if memory[0] != 42 || memory[0x6f] != 42 || m.SP() != 0x6f {
+		t.Fatalf("Expected 42 copied through the stack at 0x6f, got %d and %d, with sp %#x", memory[0], memory[0x6f], m.SP())
+	}
+	if c := m.Config(); c != config {
+		t.Fatalf("Expected %+v, got %+v", config, c)
+	}
+
+	tests := []struct {
+		name     string
+		asm      string
+		expected error
+	}{
+		{"StoreToCode", "store r1 16\nhalt", ErrReadOnlyWrite},
+		{"StackUnderflow", "pop r1\nhalt", ErrStackUnderflow},
+		{"StackOverflow", "push r1\npush r1\npush r1\npush r1\npush r1\npush r1\npush r1\npush r1\npush r1\npush r1\npush r1\npush r1\npush r1\npush r1\npush r1\npush r1\npush r1\nhalt", ErrStackOverflow},
+	}
+	for _, test := range tests {
+		memory := make([]byte, 128)
+		copy(memory[0x14:], assemble(test.asm))
+		if err := NewMachine(memory, WithConfig(config)).Run(); !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		opts     []Option
+		expected error
+	}{
+		{"WrongSize", 256, []Option{WithConfig(MachineConfig{MemSize: 128})}, ErrImageSize},
+		{"TooBig", 512, []Option{WithConfig(MachineConfig{MemSize: 512})}, ErrInvalidConfig},
+		{"StackInData", 256, []Option{WithConfig(MachineConfig{StackTop: 4})}, ErrInvalidConfig},
+		{"StackPastEnd", 256, []Option{WithConfig(MachineConfig{StackTop: 300})}, ErrInvalidConfig},
+		{"StackTooBig", 256, []Option{WithConfig(MachineConfig{StackTop: 20}), WithStackSize(16)}, ErrInvalidConfig},
+		{"CodeInData", 256, []Option{WithConfig(MachineConfig{CodeStart: 2})}, ErrInvalidConfig},
+	}
+	for _, test := range tests {
+		if err := NewMachine(make([]byte, test.size), test.opts...).Run(); !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, err)
+		}
+	}
+
+	// wide addresses allow any size up to the limit
+	memory := make([]byte, 1024)
+	memory[1000] = Halt
+	m := NewMachine(memory, WithWideAddresses(), WithConfig(MachineConfig{MemSize: 1024, CodeStart: 1000}))
+	if err := m.Run(); err != nil || m.SP() != 1024 {
+		t.Fatalf("Expected to halt with an empty stack at 1024, got sp %d (%v)", m.SP(), err)
+	}
+}
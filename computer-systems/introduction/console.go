@@ -0,0 +1,121 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+)
+
+// The data addresses of the console devices. With a console attached,
+// each byte stored to ConsoleAddr is written to it instead of to
+// memory. With a keyboard attached, loading from KeyboardAddr consumes
+// the next byte of input, or reads 0 once it is used up, and loading
+// from KeyboardStatusAddr reads 1 if there is another byte and 0 if not.
+const (
+	KeyboardStatusAddr = 0x05
+	KeyboardAddr       = 0x06
+	ConsoleAddr        = 0x07
+)
+
+// WithConsole attaches an output device at ConsoleAddr that writes
+// each byte a program stores there to w, so that programs can print.
+// The data region must include ConsoleAddr.
+func WithConsole(w io.Writer) Option {
+	return func(m *Machine) {
+		m.console = w
+	}
+}
+
+// WithKeyboard attaches an input device at KeyboardAddr and
+// KeyboardStatusAddr that reads from r. Checking the status reads ahead
+// a byte, so it blocks if r does, until there is more input or r
+// reaches EOF. The data region must include both addresses.
+func WithKeyboard(r io.Reader) Option {
+	return func(m *Machine) {
+		m.keyboard = &keyboard{r: r}
+	}
+}
+
+type keyboard struct {
+	r       io.Reader
+	next    byte
+	pending bool // next holds a byte read ahead
+	eof     bool
+}
+
+// Read ahead a byte if there isn't one already, reporting whether there
+// is one
+func (k *keyboard) fill() (bool, error) {
+	var buf [1]byte
+	for !k.pending && !k.eof {
+		n, err := k.r.Read(buf[:])
+		if n > 0 {
+			k.next, k.pending = buf[0], true
+		} else if err == io.EOF {
+			k.eof = true
+		} else if err != nil {
+			return false, err
+		}
+	}
+	return k.pending, nil
+}
+
+// Write a byte stored to the console address
+func (m *Machine) writeConsole(val byte) error {
+	if _, err := m.console.Write([]byte{val}); err != nil {
+		return fmt.Errorf("%w: console write: %v", ErrDevice, err)
+	}
+	return nil
+}
+
+// Load from one of the keyboard addresses
+func (m *Machine) readKeyboard(addr int) (byte, error) {
+	ready, err := m.keyboard.fill()
+	if err != nil {
+		return 0, fmt.Errorf("%w: keyboard read: %v", ErrDevice, err)
+	}
+	switch {
+	case addr == KeyboardStatusAddr && ready:
+		return 1, nil
+	case addr == KeyboardAddr && ready:
+		m.keyboard.pending = false
+		return m.keyboard.next, nil
+	}
+	return 0, nil
+}
+
+// The console on the bus, at ConsoleAddr. Loads read the memory under
+// it, since it has nothing to read.
+type consoleDevice struct{ m *Machine }
+
+func (d consoleDevice) Load(offset int) (byte, error) {
+	return d.m.memory[ConsoleAddr], nil
+}
+
+func (d consoleDevice) Store(offset int, val byte) error {
+	return d.m.writeConsole(val)
+}
+
+// The keyboard on the bus, at KeyboardStatusAddr and then KeyboardAddr.
+// Stores go to the memory under it.
+type keyboardDevice struct{ m *Machine }
+
+func (d keyboardDevice) Load(offset int) (byte, error) {
+	return d.m.readKeyboard(KeyboardStatusAddr + offset)
+}
+
+// Peek without reading ahead, so a key the program hasn't waited for
+// yet doesn't show
+func (d keyboardDevice) Peek(offset int) byte {
+	if !d.m.keyboard.pending {
+		return 0
+	}
+	if offset == 0 {
+		return 1
+	}
+	return d.m.keyboard.next
+}
+
+func (d keyboardDevice) Store(offset int, val byte) error {
+	d.m.memory[KeyboardStatusAddr+offset] = val
+	return nil
+}
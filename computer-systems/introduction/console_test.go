@@ -0,0 +1,141 @@
+package vm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Print x in decimal, without leading zeros, followed by a newline
+const printDecimal = `
+load r1 1
+li r2 100
+li r3 10
+li r4 48
+li r5 0
+mov r6 r1
+div r6 r2
+beqz r6 9
+add r6 r4
+store r6 7
+li r5 1
+mov r6 r1
+div r6 r3
+mod r6 r3
+add r5 r6
+beqz r5 6
+add r6 r4
+store r6 7
+mod r1 r3
+add r1 r4
+store r1 7
+li r1 10
+store r1 7
+halt`
+
+func TestConsole(t *testing.T) {
+	for _, test := range []struct {
+		x        byte
+		expected string
+	}{
+		{0, "0\n"},
+		{7, "7\n"},
+		{42, "42\n"},
+		{105, "105\n"},
+		{255, "255\n"},
+	} {
+		memory := make([]byte, 256)
+		copy(memory[8:], assemble(printDecimal))
+		memory[1] = test.x
+
+		var out strings.Builder
+		if err := NewMachine(memory, WithConsole(&out)).Run(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != test.expected {
+			t.Errorf("Expected %d to print %q, got %q", test.x, test.expected, out.String())
+		}
+		if memory[ConsoleAddr] != 0 {
+			t.Errorf("Expected console output to bypass memory, got %#x at %#x", memory[ConsoleAddr], ConsoleAddr)
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestConsoleErrors(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble("li r1 65\nstore r1 7\nhalt"))
+	err := NewMachine(memory, WithConsole(failingWriter{})).Run()
+	if !errors.Is(err, ErrDevice) || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("Expected a device error, got %v", err)
+	}
+
+	memory = make([]byte, 256)
+	copy(memory[4:], assemble("halt"))
+	err = NewMachine(memory, WithDataSize(4), WithConsole(&strings.Builder{})).Run()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected a console outside the data region to be rejected, got %v", err)
+	}
+}
+
+func TestKeyboard(t *testing.T) {
+	// Echo the input, uppercasing lowercase letters
+	echo := `
+load r1 5
+beqz r1 25
+load r1 6
+li r2 97
+blt r1 r2 10
+li r2 123
+bge r1 r2 3
+subi r1 32
+store r1 7
+jump 8
+halt`
+	for _, input := range []string{"", "a", "Hello, world!\n"} {
+		memory := make([]byte, 256)
+		copy(memory[8:], assemble(echo))
+
+		var out strings.Builder
+		if err := NewMachine(memory, WithKeyboard(strings.NewReader(input)), WithConsole(&out)).Run(); err != nil {
+			t.Fatal(err)
+		}
+		if expected := strings.ToUpper(input); out.String() != expected {
+			t.Errorf("Expected %q to echo as %q, got %q", input, expected, out.String())
+		}
+	}
+}
+
+func TestKeyboardEOF(t *testing.T) {
+	// Once the input runs out, both addresses read 0
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble("load r1 6\nload r2 6\nload r3 5\nhalt"))
+	memory[KeyboardStatusAddr], memory[KeyboardAddr] = 0xff, 0xff
+	m := NewMachine(memory, WithKeyboard(strings.NewReader("x")))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Register(1) != 'x' || m.Register(2) != 0 || m.Register(3) != 0 {
+		t.Errorf("Expected to read 'x', 0 and status 0, got %#x, %#x and %#x", m.Register(1), m.Register(2), m.Register(3))
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestKeyboardErrors(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble("load r1 5\nhalt"))
+	err := NewMachine(memory, WithKeyboard(failingReader{})).Run()
+	if !errors.Is(err, ErrDevice) || !strings.Contains(err.Error(), "connection reset") {
+		t.Errorf("Expected a device error, got %v", err)
+	}
+}
@@ -0,0 +1,21 @@
+package vm
+
+// Counters measure the work a program has done, for comparing the cost
+// of different ways of writing it.
+type Counters struct {
+	Instructions     uint64 // the instructions executed, as for Cycles
+	Reads            uint64 // bytes loaded or popped, but not fetched as instructions
+	Writes           uint64 // bytes stored or pushed
+	BranchesTaken    uint64 // conditional branches that moved the PC
+	BranchesNotTaken uint64 // conditional branches that fell through
+	Clock            uint64 // clock cycles, with WithTiming or WithClockRate
+}
+
+// Counters returns the counts for everything the program has done so
+// far. Instructions that fault are not counted, but any memory they
+// accessed first is.
+func (m *Machine) Counters() Counters {
+	c := m.counters
+	c.Instructions = m.cycles
+	return c
+}
@@ -0,0 +1,60 @@
+package vm
+
+import "testing"
+
+func TestCounters(t *testing.T) {
+	// Sum 1..n two ways: looping, and with the formula n * (n + 1) / 2
+	loop := `
+load r1 1
+beqz r1 8
+add r2 r1
+subi r1 1
+jump 11
+store r2 0
+halt`
+	formula := `
+load r1 1
+mov r2 r1
+addi r2 1
+mulw r1 r2
+shri r1 1
+shli r2 7
+or r1 r2
+store r1 0
+halt`
+	tests := []struct {
+		name     string
+		program  string
+		counters Counters
+	}{
+		{"Loop", loop, Counters{Instructions: 1 + 10*4 + 3, Reads: 1, Writes: 1, BranchesTaken: 1, BranchesNotTaken: 10}},
+		{"Formula", formula, Counters{Instructions: 9, Reads: 1, Writes: 1}},
+	}
+	for _, test := range tests {
+		memory := make([]byte, 256)
+		copy(memory[8:], assemble(test.program))
+		memory[1] = 10
+		m := NewMachine(memory)
+		if err := m.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if memory[0] != 55 {
+			t.Errorf("%s: expected the sum to be 55, got %d", test.name, memory[0])
+		}
+		if m.Counters() != test.counters {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.counters, m.Counters())
+		}
+	}
+}
+
+func TestCountersStack(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble("push r1\npush r2\npop r1\nhalt"))
+	m := NewMachine(memory)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if c := m.Counters(); c.Reads != 1 || c.Writes != 2 {
+		t.Errorf("Expected a read and two writes, got %+v", c)
+	}
+}
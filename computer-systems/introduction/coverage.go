@@ -0,0 +1,19 @@
+package vm
+
+// A Coverage records how many times the program executed the
+// instruction at each address, to show which parts of it a run
+// exercised.
+type Coverage struct {
+	Hits map[int]uint64
+}
+
+// WithCoverage counts the instructions the program executes in c.
+// Instructions that fault are not counted, as for Counters.
+func WithCoverage(c *Coverage) Option {
+	return func(m *Machine) {
+		if c.Hits == nil {
+			c.Hits = map[int]uint64{}
+		}
+		m.coverage = c
+	}
+}
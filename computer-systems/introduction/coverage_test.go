@@ -0,0 +1,29 @@
+package vm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	// The loop runs twice, the halt never does, and the division faults
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+load r1 1
+beqz r1 6
+subi r1 1
+jump 11
+halt
+div r1 r1`))
+	memory[1] = 2
+
+	var c Coverage
+	err := NewMachine(memory, WithCoverage(&c)).Run()
+	if err == nil {
+		t.Fatal("Expected the division by zero to fault")
+	}
+	expected := map[int]uint64{8: 1, 11: 3, 14: 2, 17: 2}
+	if !reflect.DeepEqual(c.Hits, expected) {
+		t.Fatalf("Expected hits %v, got %v", expected, c.Hits)
+	}
+}
@@ -0,0 +1,96 @@
+package vm
+
+import "fmt"
+
+// Opcodes from FirstCustomOpcode up to, but not including, Halt are free
+// for RegisterOpcode. Everything below is reserved for the instruction
+// set, so that a new built-in instruction never takes an opcode some
+// extension already uses.
+const FirstCustomOpcode = 0x80
+
+// An InstructionFunc executes a custom instruction, given the bytes
+// that follow its opcode. It may read and change the machine through
+// its exported methods. Execution continues with the next instruction,
+// unless it returns an error, which faults the machine.
+type InstructionFunc func(m *Machine, operands []byte) error
+
+// A custom instruction, as registered with RegisterOpcode
+type customOp struct {
+	info OpInfo
+	fn   InstructionFunc
+}
+
+// RegisterOpcode adds an instruction to this machine, encoded as the
+// opcode code followed by width-1 bytes of operands, which fn executes.
+// It fails with ErrInvalidConfig if code is reserved or already
+// registered, or if the instruction would be wider than any built-in
+// one.
+//
+// Custom instructions appear in traces and faults with a mnemonic like
+// "op80", and have immediate operands. The assembler and disassembler
+// don't know about them.
+func (m *Machine) RegisterOpcode(code byte, width int, fn InstructionFunc) error {
+	switch {
+	case code < FirstCustomOpcode || code == Halt:
+		return fmt.Errorf("%w: opcode %#02x is reserved for the instruction set", ErrInvalidConfig, code)
+	case m.custom[code] != nil:
+		return fmt.Errorf("%w: opcode %#02x is already registered", ErrInvalidConfig, code)
+	case width < 1 || width > maxWidth:
+		return fmt.Errorf("%w: opcode %#02x has width %d, expected 1 to %d", ErrInvalidConfig, code, width, maxWidth)
+	case fn == nil:
+		return fmt.Errorf("%w: opcode %#02x has no function", ErrInvalidConfig, code)
+	}
+	c := &customOp{
+		info: OpInfo{Code: code, Mnemonic: fmt.Sprintf("op%02x", code), Flow: FlowNext},
+		fn:   fn,
+	}
+	for i := 1; i < width; i++ {
+		c.info.Operands = append(c.info.Operands, ImmOperand)
+	}
+	if m.custom == nil {
+		m.custom = make(map[byte]*customOp)
+	}
+	m.custom[code] = c
+	return nil
+}
+
+// Look up the metadata for code, among the built-in instructions and
+// then this machine's custom ones
+func (m *Machine) lookupOp(code byte) *OpInfo {
+	if op := opTable[code]; op != nil {
+		return op
+	}
+	if c := m.custom[code]; c != nil {
+		return &c.info
+	}
+	return nil
+}
+
+// The handler for op, which is built in or custom
+func (m *Machine) handler(op *OpInfo) handler {
+	if h := handlers[op.Code]; h != nil {
+		return h
+	}
+	code := op.Code
+	return func(m *Machine, args []int, next int) (bool, error) {
+		return m.executeCustom(code, args)
+	}
+}
+
+// Run the function registered for code
+func (m *Machine) executeCustom(code byte, args []int) (bool, error) {
+	var operands [4]byte
+	for i, arg := range args {
+		operands[i] = byte(arg)
+	}
+	return false, m.custom[code].fn(m, operands[:len(args)])
+}
+
+// SetRegister sets general purpose register n, which must be between 1
+// and MaxRegister, to val.
+func (m *Machine) SetRegister(n int, val byte) {
+	if n < 1 || n > MaxRegister {
+		panic(fmt.Sprintf("no register r%d", n))
+	}
+	m.registers[n] = val
+}
@@ -0,0 +1,91 @@
+package vm
+
+import (
+	"errors"
+	"testing"
+)
+
+// A custom instruction that multiplies register a by the immediate b,
+// faulting if the product doesn't fit in a byte
+func mulImm(m *Machine, operands []byte) error {
+	reg, val := int(operands[0]), int(operands[1])
+	product := int(m.Register(reg)) * val
+	if product > 0xff {
+		return ErrOutOfBounds
+	}
+	m.SetRegister(reg, byte(product))
+	return nil
+}
+
+func TestRegisterOpcode(t *testing.T) {
+	for _, wide := range []bool{false, true} {
+		for _, d := range dispatches {
+			memory := make([]byte, MemorySize)
+			memory[1] = 7
+			// load r1 1; op80 r1 6; store r1 0; halt
+			program := []byte{Load, 1, 1, 0x80, 1, 6, Store, 1, 0, Halt}
+			if wide {
+				program = []byte{Load, 1, 1, 0, 0x80, 1, 6, Store, 1, 0, 0, Halt}
+			}
+			copy(memory[DefaultDataSize:], program)
+			opts := []Option{WithDispatch(d)}
+			if wide {
+				opts = append(opts, WithWideAddresses())
+			}
+			m := NewMachine(memory, opts...)
+			if err := m.RegisterOpcode(0x80, 3, mulImm); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Run(); err != nil {
+				t.Fatalf("%s dispatch, wide=%v: %v", d, wide, err)
+			}
+			if memory[0] != 42 {
+				t.Errorf("%s dispatch, wide=%v: expected 42, got %d", d, wide, memory[0])
+			}
+		}
+	}
+}
+
+func TestRegisterOpcodeFault(t *testing.T) {
+	memory := make([]byte, MemorySize)
+	memory[1] = 100
+	copy(memory[DefaultDataSize:], []byte{Load, 1, 1, 0x80, 1, 6, Halt})
+	m := NewMachine(memory)
+	if err := m.RegisterOpcode(0x80, 3, mulImm); err != nil {
+		t.Fatal(err)
+	}
+	err := m.Run()
+	var f *Fault
+	if !errors.As(err, &f) || !errors.Is(err, ErrOutOfBounds) {
+		t.Fatalf("Expected a fault wrapping ErrOutOfBounds, got %v", err)
+	}
+	if f.PC != 0x0b || f.Op == nil || f.Op.Mnemonic != "op80" || len(f.Operands) != 2 {
+		t.Errorf("Unexpected fault %+v", f)
+	}
+}
+
+func TestRegisterOpcodeErrors(t *testing.T) {
+	m := NewMachine(make([]byte, MemorySize))
+	if err := m.RegisterOpcode(0x80, 3, mulImm); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name  string
+		code  byte
+		width int
+		fn    InstructionFunc
+	}{
+		{"built in", Add, 3, mulImm},
+		{"reserved", 0x7f, 3, mulImm},
+		{"zero", 0x00, 1, mulImm},
+		{"halt", Halt, 1, mulImm},
+		{"already registered", 0x80, 3, mulImm},
+		{"too narrow", 0x81, 0, mulImm},
+		{"too wide", 0x81, maxWidth + 1, mulImm},
+		{"no function", 0x81, 1, nil},
+	} {
+		if err := m.RegisterOpcode(test.code, test.width, test.fn); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%s: expected ErrInvalidConfig, got %v", test.name, err)
+		}
+	}
+}
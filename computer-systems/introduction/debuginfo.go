@@ -0,0 +1,98 @@
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// DebugInfo maps the instructions of a memory image back to the source
+// they were assembled from, so that tools can show where the program is
+// in terms of the source rather than bare addresses. The assembler
+// produces it, and SaveDebugInfo stores it in a file alongside the image.
+type DebugInfo struct {
+	// Lines maps the address of each instruction to where it was
+	// written.
+	Lines map[int]SourcePos `json:"lines"`
+	// Symbols maps each label to the address it names.
+	Symbols map[string]int `json:"symbols"`
+}
+
+// A SourcePos is a line in a source file, numbered from 1.
+type SourcePos struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+func (p SourcePos) String() string {
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// WithDebugInfo attaches debug info to the machine, so that its trace
+// can show the source line and symbol of each instruction.
+func WithDebugInfo(d *DebugInfo) Option {
+	return func(m *Machine) {
+		m.debug = d
+	}
+}
+
+// DebugInfo returns the debug info attached with WithDebugInfo, or nil.
+func (m *Machine) DebugInfo() *DebugInfo {
+	return m.debug
+}
+
+// Symbolize describes addr relative to the nearest label at or before
+// it that marks an instruction, as in "loop" or "loop+3". It returns ""
+// if there is no such label.
+func (d *DebugInfo) Symbolize(addr int) string {
+	name, at := "", -1
+	for sym, symAddr := range d.Symbols {
+		if _, ok := d.Lines[symAddr]; !ok || symAddr > addr {
+			continue
+		}
+		// break ties by name, so that the result doesn't depend on
+		// map order
+		if symAddr > at || symAddr == at && sym < name {
+			name, at = sym, symAddr
+		}
+	}
+	if name == "" || addr == at {
+		return name
+	}
+	return fmt.Sprintf("%s+%d", name, addr-at)
+}
+
+// Describe returns the source position and symbol of the instruction at
+// addr, as in "sum.s:4 (loop+3)", or "" if d doesn't cover it.
+func (d *DebugInfo) Describe(addr int) string {
+	pos, ok := d.Lines[addr]
+	if !ok {
+		return ""
+	}
+	if sym := d.Symbolize(addr); sym != "" {
+		return fmt.Sprintf("%s (%s)", pos, sym)
+	}
+	return pos.String()
+}
+
+// LoadDebugInfo reads debug info saved by SaveDebugInfo.
+func LoadDebugInfo(path string) (*DebugInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var d DebugInfo
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &d, nil
+}
+
+// SaveDebugInfo writes d to path as JSON.
+func SaveDebugInfo(path string, d *DebugInfo) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0666)
+}
@@ -0,0 +1,69 @@
+package vm
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testDebugInfo = &DebugInfo{
+	Lines: map[int]SourcePos{
+		8:  {"sum.s", 1},
+		11: {"sum.s", 2},
+		14: {"sum.s", 3},
+	},
+	// result is a data label, and end marks no instruction, so neither
+	// is used to describe code addresses
+	Symbols: map[string]int{"result": 0, "start": 8, "loop": 11, "again": 11, "end": 17},
+}
+
+func TestSymbolize(t *testing.T) {
+	for addr, expected := range map[int]string{
+		0:  "",
+		8:  "start",
+		10: "start+2",
+		11: "again",
+		18: "again+7",
+	} {
+		if got := testDebugInfo.Symbolize(addr); got != expected {
+			t.Errorf("Expected %#x to be %q, got %q", addr, expected, got)
+		}
+	}
+	if got := testDebugInfo.Describe(14); got != "sum.s:3 (again+3)" {
+		t.Errorf("Unexpected description %q", got)
+	}
+	if got := testDebugInfo.Describe(15); got != "" {
+		t.Errorf("Expected no description inside an instruction, got %q", got)
+	}
+}
+
+func TestDebugInfoFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sum.dbg")
+	if err := SaveDebugInfo(path, testDebugInfo); err != nil {
+		t.Fatal(err)
+	}
+	d, err := LoadDebugInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d, testDebugInfo) {
+		t.Fatalf("Expected %+v, got %+v", testDebugInfo, d)
+	}
+}
+
+func TestTraceDebugInfo(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+li r1 1
+li r2 2
+halt`))
+	var trace strings.Builder
+	if err := NewMachine(memory, WithTrace(&trace), WithDebugInfo(testDebugInfo)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(trace.String(), "\n")
+	if !strings.HasSuffix(lines[0], "FLAGS=0000 ; sum.s:1 (start)") || !strings.HasSuffix(lines[2], "FLAGS=0000 ; sum.s:3 (again+3)") {
+		t.Fatalf("Expected each line to end with its source, got\n%s", trace.String())
+	}
+}
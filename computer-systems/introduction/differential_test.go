@@ -0,0 +1,192 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// A randomly generated instruction, with jump and branch targets and
+// loadcode addresses given as the index of another instruction, so that
+// it can be encoded for either address width
+type genInstruction struct {
+	op     OpInfo
+	args   []int
+	target int
+}
+
+// Opcodes left out of generated programs, because they put code
+// addresses on the stack, or may read code, which differs between
+// encodings
+var differentialSkip = map[byte]bool{Call: true, Ret: true, Iret: true, LoadR: true}
+
+// Generate a program of n instructions followed by a halt. Every
+// operand is valid, but the program may still fault, loop forever or
+// exit early, just as hand-written ones do.
+func randomProgram(rng *rand.Rand, n int) []genInstruction {
+	var choices []OpInfo
+	for _, op := range ops {
+		if !differentialSkip[op.Code] && op.Code != Halt {
+			choices = append(choices, op)
+		}
+	}
+
+	program := make([]genInstruction, n+1)
+	for i := 0; i < n; i++ {
+		op := choices[rng.Intn(len(choices))]
+		inst := genInstruction{op: op, args: make([]int, len(op.Operands))}
+		for j, kind := range op.Operands {
+			switch kind {
+			case RegOperand:
+				inst.args[j] = 1 + rng.Intn(MaxRegister)
+			case ImmOperand:
+				inst.args[j] = rng.Intn(256)
+			case AddrOperand:
+				if addressesCode(op) {
+					inst.target = rng.Intn(n + 1)
+				} else {
+					// leave room for a word
+					inst.args[j] = rng.Intn(DefaultDataSize - 1)
+				}
+			case OffsetOperand:
+				// keep branches short, so the offset fits in a signed
+				// byte however the program is encoded
+				inst.target = i - 8 + rng.Intn(17)
+				if inst.target < 0 || inst.target > n {
+					inst.target = i + 1
+				}
+			}
+		}
+		program[i] = inst
+	}
+	halt, _ := LookupOp(Halt)
+	program[n] = genInstruction{op: halt}
+	return program
+}
+
+// Whether the address operand of op refers to code rather than data
+func addressesCode(op OpInfo) bool {
+	return op.Flow == FlowJump || op.Flow == FlowCall || op.Code == LoadCode
+}
+
+// Encode a generated program into a memory image, with two byte address
+// operands if wide is set
+func encodeProgram(program []genInstruction, wide bool) []byte {
+	width := func(op OpInfo) int {
+		if wide {
+			return op.WideWidth()
+		}
+		return op.Width()
+	}
+	addrs := make([]int, len(program))
+	addr := DefaultDataSize
+	for i, inst := range program {
+		addrs[i] = addr
+		addr += width(inst.op)
+	}
+
+	memory := make([]byte, MemorySize)
+	for i, inst := range program {
+		at := addrs[i]
+		memory[at] = inst.op.Code
+		at++
+		for j, kind := range inst.op.Operands {
+			val := inst.args[j]
+			switch kind {
+			case AddrOperand:
+				if addressesCode(inst.op) {
+					val = addrs[inst.target]
+				}
+			case OffsetOperand:
+				val = addrs[inst.target] - (addrs[i] + width(inst.op))
+			}
+			if wide && kind == AddrOperand {
+				memory[at], memory[at+1] = byte(val), byte(val>>8)
+				at += 2
+				continue
+			}
+			memory[at] = byte(val)
+			at++
+		}
+	}
+	return memory
+}
+
+// The parts of a finished run that don't depend on how the program was
+// encoded
+type outcome struct {
+	data      []byte
+	stack     []byte
+	registers [MaxRegister + 1]byte
+	flags     byte
+	cycles    uint64
+	halted    bool
+	exitCode  byte
+	err       error
+}
+
+func runOutcome(memory []byte, opts ...Option) outcome {
+	m := NewMachine(memory, append([]Option{WithCycleLimit(fuzzCycleLimit)}, opts...)...)
+	err := m.Run()
+	o := outcome{
+		data:      append([]byte(nil), m.Data()...),
+		stack:     append([]byte(nil), memory[len(memory)-DefaultStackSize:]...),
+		registers: m.registers,
+		flags:     m.flags,
+		cycles:    m.cycles,
+		halted:    m.halted,
+		exitCode:  m.exitCode,
+	}
+	// errors mention addresses, so compare only which kind they are
+	if err != nil {
+		o.err = err
+		for _, target := range vmErrors {
+			if errors.Is(err, target) {
+				o.err = target
+				break
+			}
+		}
+	}
+	return o
+}
+
+func (o outcome) equal(p outcome) bool {
+	return bytes.Equal(o.data, p.data) && bytes.Equal(o.stack, p.stack) &&
+		o.registers == p.registers && o.flags == p.flags &&
+		o.cycles == p.cycles && o.halted == p.halted &&
+		o.exitCode == p.exitCode && o.err == p.err
+}
+
+// Run random programs with both address widths and every dispatch
+// strategy, which should all compute the same results. The wide machine
+// decodes, branches and checks bounds along its own paths, so it is
+// compared as if it were another implementation.
+func TestDifferential(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		program := randomProgram(rng, 1+rng.Intn(30))
+		inputs := []byte{byte(rng.Intn(256)), byte(rng.Intn(256))}
+
+		var want outcome
+		for j, wide := range []bool{false, true} {
+			for _, d := range dispatches {
+				memory := encodeProgram(program, wide)
+				copy(memory[1:], inputs)
+				opts := []Option{WithDispatch(d)}
+				if wide {
+					opts = append(opts, WithWideAddresses())
+				}
+				got := runOutcome(memory, opts...)
+				if j == 0 && d == SwitchDispatch {
+					want = got
+					continue
+				}
+				if !want.equal(got) {
+					t.Fatalf("Program %d differs with %s dispatch and wide=%v:\n%x\nwant: %+v\ngot:  %+v",
+						i, d, wide, memory, want, got)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package vm
+
+// A Dispatch is a way of getting from a decoded opcode to the code that
+// executes it. Every strategy runs the same handler for each opcode, so
+// they differ only in how fast they find it.
+type Dispatch int
+
+const (
+	// SwitchDispatch selects the handler with a switch statement on
+	// the opcode, which the compiler is free to turn into a jump table
+	// or a binary search. It is the default.
+	SwitchDispatch Dispatch = iota
+
+	// TableDispatch looks the handler up in an array indexed by the
+	// opcode, and calls it through a function pointer.
+	TableDispatch
+
+	// PredecodedDispatch decodes each instruction the first time it
+	// runs into a closure bound to its handler and operands, so that
+	// running it again skips decoding altogether. An instruction is
+	// decoded again after the program stores over any of its bytes,
+	// or the machine is restored from a snapshot, but not after memory
+	// is changed from outside the machine.
+	PredecodedDispatch
+)
+
+func (d Dispatch) String() string {
+	switch d {
+	case SwitchDispatch:
+		return "switch"
+	case TableDispatch:
+		return "table"
+	case PredecodedDispatch:
+		return "predecoded"
+	}
+	return "unknown"
+}
+
+// WithDispatch makes the machine dispatch instructions with d.
+func WithDispatch(d Dispatch) Option {
+	return func(m *Machine) {
+		m.dispatchMode = d
+	}
+}
+
+// Indexed by opcode, for TableDispatch
+var handlers [256]handler
+
+func init() {
+	for code, h := range map[byte]handler{
+		Load:     execLoad,
+		Store:    execStore,
+		LoadR:    execLoadR,
+		StoreR:   execStoreR,
+		Loadw:    execLoadw,
+		Storew:   execStorew,
+		Add:      execAdd,
+		Sub:      execSub,
+		Adc:      execAdc,
+		Sbb:      execSbb,
+		Addi:     execAddi,
+		Subi:     execSubi,
+		Jump:     execJump,
+		Beqz:     execBeqz,
+		LoadImm:  execLoadImm,
+		Mov:      execMov,
+		Cmp:      execCmp,
+		Bz:       execBz,
+		Bc:       execBc,
+		Cycles:   execCycles,
+		Assert:   execAssert,
+		LoadCode: execLoadCode,
+		Swap:     execSwap,
+		Push:     execPush,
+		Pop:      execPop,
+		Call:     execCall,
+		Ret:      execRet,
+		And:      execAnd,
+		Or:       execOr,
+		Xor:      execXor,
+		Not:      execNot,
+		Shl:      execShl,
+		Shr:      execShr,
+		Shli:     execShli,
+		Shri:     execShri,
+		Mul:      execMul,
+		Mulw:     execMulw,
+		Div:      execDiv,
+		Mod:      execMod,
+		Bnz:      execBnz,
+		Bn:       execBn,
+		Bv:       execBv,
+		Bslt:     execBslt,
+		Bsge:     execBsge,
+		Bne:      execBne,
+		Blt:      execBlt,
+		Bge:      execBge,
+		Iret:     execIret,
+		Cli:      execCli,
+		Sti:      execSti,
+		Exit:     execExit,
+		Sys:      execSys,
+		Cas:      execCas,
+		Xadd:     execXadd,
+		Hartid:   execHartid,
+		Halt:     execHalt,
+	} {
+		handlers[code] = h
+	}
+	for _, op := range ops {
+		if handlers[op.Code] == nil {
+			panic("vm: no handler for " + op.Mnemonic)
+		}
+	}
+}
+
+// Run the handler for a decoded instruction, found with a switch
+func switchExecute(m *Machine, code byte, args []int, next int) (bool, error) {
+	switch code {
+	case Load:
+		return execLoad(m, args, next)
+	case Store:
+		return execStore(m, args, next)
+	case LoadR:
+		return execLoadR(m, args, next)
+	case StoreR:
+		return execStoreR(m, args, next)
+	case Loadw:
+		return execLoadw(m, args, next)
+	case Storew:
+		return execStorew(m, args, next)
+	case Add:
+		return execAdd(m, args, next)
+	case Sub:
+		return execSub(m, args, next)
+	case Adc:
+		return execAdc(m, args, next)
+	case Sbb:
+		return execSbb(m, args, next)
+	case Addi:
+		return execAddi(m, args, next)
+	case Subi:
+		return execSubi(m, args, next)
+	case Jump:
+		return execJump(m, args, next)
+	case Beqz:
+		return execBeqz(m, args, next)
+	case LoadImm:
+		return execLoadImm(m, args, next)
+	case Mov:
+		return execMov(m, args, next)
+	case Cmp:
+		return execCmp(m, args, next)
+	case Bz:
+		return execBz(m, args, next)
+	case Bc:
+		return execBc(m, args, next)
+	case Cycles:
+		return execCycles(m, args, next)
+	case Assert:
+		return execAssert(m, args, next)
+	case LoadCode:
+		return execLoadCode(m, args, next)
+	case Swap:
+		return execSwap(m, args, next)
+	case Push:
+		return execPush(m, args, next)
+	case Pop:
+		return execPop(m, args, next)
+	case Call:
+		return execCall(m, args, next)
+	case Ret:
+		return execRet(m, args, next)
+	case And:
+		return execAnd(m, args, next)
+	case Or:
+		return execOr(m, args, next)
+	case Xor:
+		return execXor(m, args, next)
+	case Not:
+		return execNot(m, args, next)
+	case Shl:
+		return execShl(m, args, next)
+	case Shr:
+		return execShr(m, args, next)
+	case Shli:
+		return execShli(m, args, next)
+	case Shri:
+		return execShri(m, args, next)
+	case Mul:
+		return execMul(m, args, next)
+	case Mulw:
+		return execMulw(m, args, next)
+	case Div:
+		return execDiv(m, args, next)
+	case Mod:
+		return execMod(m, args, next)
+	case Bnz:
+		return execBnz(m, args, next)
+	case Bn:
+		return execBn(m, args, next)
+	case Bv:
+		return execBv(m, args, next)
+	case Bslt:
+		return execBslt(m, args, next)
+	case Bsge:
+		return execBsge(m, args, next)
+	case Bne:
+		return execBne(m, args, next)
+	case Blt:
+		return execBlt(m, args, next)
+	case Bge:
+		return execBge(m, args, next)
+	case Iret:
+		return execIret(m, args, next)
+	case Cli:
+		return execCli(m, args, next)
+	case Sti:
+		return execSti(m, args, next)
+	case Exit:
+		return execExit(m, args, next)
+	case Sys:
+		return execSys(m, args, next)
+	case Cas:
+		return execCas(m, args, next)
+	case Xadd:
+		return execXadd(m, args, next)
+	case Hartid:
+		return execHartid(m, args, next)
+	case Halt:
+		return execHalt(m, args, next)
+	}
+	// decode only returns opcodes in the instruction set, which all
+	// have a case above
+	panic("vm: no handler for opcode")
+}
@@ -0,0 +1,46 @@
+package vm
+
+import (
+	"testing"
+	"time"
+)
+
+// Every dispatch strategy, for the benchmarks to compare
+var dispatches = []Dispatch{SwitchDispatch, TableDispatch, PredecodedDispatch}
+
+// Run the benchmark workload under each dispatch strategy, reporting the
+// time per instruction so that the strategies can be compared directly:
+//
+//	go test -run NONE -bench Dispatch
+func BenchmarkDispatch(b *testing.B) {
+	program := make([]byte, MemorySize)
+	copy(program[DefaultDataSize:], assemble(benchmarkAsm))
+	program[1] = 255
+	program[2] = 255
+
+	for _, d := range dispatches {
+		b.Run(d.String(), func(b *testing.B) {
+			memory := make([]byte, MemorySize)
+			var instructions uint64
+			b.ResetTimer()
+			start := time.Now()
+			for n := 0; n < b.N; n++ {
+				copy(memory, program)
+				m := NewMachine(memory, WithDispatch(d))
+				if err := m.Run(); err != nil {
+					b.Fatal(err)
+				}
+				instructions += m.Cycles()
+			}
+			b.ReportMetric(float64(time.Since(start).Nanoseconds())/float64(instructions), "ns/instruction")
+		})
+	}
+}
+
+func TestDispatchString(t *testing.T) {
+	for d, want := range map[Dispatch]string{SwitchDispatch: "switch", TableDispatch: "table", PredecodedDispatch: "predecoded", Dispatch(-1): "unknown"} {
+		if got := d.String(); got != want {
+			t.Errorf("Dispatch(%d).String() = %q, want %q", d, got, want)
+		}
+	}
+}
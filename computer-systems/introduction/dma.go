@@ -0,0 +1,104 @@
+package vm
+
+// The registers of the DMA controller attached by WithDMA, as offsets
+// from its address. A program sets the source and destination addresses
+// and the number of bytes to copy, then stores DMAStart to the control
+// register. The controller copies a byte after each instruction from
+// then on, advancing the source and destination and counting down the
+// length as it goes, so the registers show its progress. Loading the
+// control register reads the status bits.
+const (
+	DMASource = iota
+	DMADest
+	DMALength
+	DMAControl
+	DMARegisters // the number of registers
+)
+
+// The bits of the DMA control register.
+const (
+	DMAStart = 1 << 0 // stored to start a transfer
+	DMABusy  = 1 << 0 // read while a transfer is under way
+	DMAError = 1 << 1 // read after a transfer stopped on a fault
+)
+
+// The IRQ line raised by the DMA controller when a transfer completes.
+const DMAIRQ = 1
+
+// WithDMA attaches a DMA controller with its registers at addr, which
+// copies blocks of memory while the program carries on running, and
+// raises DMAIRQ when each copy is done. A program may instead poll the
+// control register until it is no longer busy.
+//
+// The controller copies over the bus, so it can copy to and from other
+// devices, and it is free of the protection the data region and any
+// memory map give programs. Its copies are counted, logged and traced
+// like the program's own accesses, as part of the instruction they
+// follow, and StepBack takes them back, though not the progress of the
+// transfer. A transfer that faults, as a store to a ROM
+// does, stops with DMAError set. The data region must include the
+// registers, for the program to store to them.
+func WithDMA(addr int) Option {
+	return func(m *Machine) {
+		m.bus.Attach(addr, addr+DMARegisters, &dma{})
+	}
+}
+
+type dma struct {
+	regs         [DMARegisters]byte
+	busy, failed bool
+}
+
+func (d *dma) Load(offset int) (byte, error) {
+	if offset != DMAControl {
+		return d.regs[offset], nil
+	}
+	var status byte
+	if d.busy {
+		status |= DMABusy
+	}
+	if d.failed {
+		status |= DMAError
+	}
+	return status, nil
+}
+
+// Loads have no side effects to avoid
+func (d *dma) Peek(offset int) byte {
+	val, _ := d.Load(offset)
+	return val
+}
+
+func (d *dma) Store(offset int, val byte) error {
+	if offset != DMAControl {
+		d.regs[offset] = val
+	} else if val&DMAStart != 0 && !d.busy {
+		d.busy, d.failed = true, false
+	}
+	return nil
+}
+
+// Copy the next byte of a transfer under way
+func (d *dma) Tick(m *Machine) {
+	if !d.busy {
+		return
+	}
+	if d.regs[DMALength] > 0 {
+		src, dst := int(d.regs[DMASource]), int(d.regs[DMADest])
+		val, err := m.read(src)
+		if err == nil {
+			err = m.write(dst, val)
+		}
+		if err != nil {
+			d.failed = true
+		} else {
+			d.regs[DMASource]++
+			d.regs[DMADest]++
+			d.regs[DMALength]--
+		}
+	}
+	if d.failed || d.regs[DMALength] == 0 {
+		d.busy = false
+		m.Raise(DMAIRQ)
+	}
+}
@@ -0,0 +1,118 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Copy the four bytes at 0 to 4 with the DMA controller at 16, waiting
+// for it by polling and counting the polls in r4
+const dmaPoll = `
+li r1 0
+store r1 16
+li r1 4
+store r1 17
+li r1 4
+store r1 18
+li r1 1
+store r1 19
+load r2 19
+addi r4 1
+addi r2 0
+bnz 245
+load r3 18
+halt`
+
+func TestDMAPoll(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory, []byte{1, 2, 3, 4})
+	copy(memory[32:], assemble(dmaPoll))
+	m := NewMachine(memory, WithDataSize(32), WithDMA(16))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(memory[4:8], []byte{1, 2, 3, 4}) {
+		t.Errorf("Expected the bytes to be copied, got % x", memory[4:8])
+	}
+	if m.Register(4) < 2 || m.Register(2) != 0 || m.Register(3) != 0 {
+		t.Errorf("Expected to poll the controller until it finished, got %d polls, status %#x, length %d", m.Register(4), m.Register(2), m.Register(3))
+	}
+}
+
+func TestDMAInterrupt(t *testing.T) {
+	// start the same copy as dmaPoll, then spin until the handler at
+	// 0x40 halts the machine
+	memory := make([]byte, 256)
+	copy(memory, []byte{1, 2, 3, 4})
+	copy(memory[32:], assemble(`
+sti
+li r1 0
+store r1 16
+li r1 4
+store r1 17
+li r1 4
+store r1 18
+li r1 1
+store r1 19
+addi r4 1
+jump 57`))
+	copy(memory[0x40:], assemble("li r5 1\nhalt"))
+	memory[24+DMAIRQ] = 0x40
+	m := NewMachine(memory, WithDataSize(32), WithDMA(16), WithVectorTable(24), WithCycleLimit(100))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Register(5) != 1 || !bytes.Equal(memory[4:8], []byte{1, 2, 3, 4}) {
+		t.Errorf("Expected the handler to run after the copy, got r5 = %d and % x", m.Register(5), memory[4:8])
+	}
+	if m.Register(4) == 0 {
+		t.Error("Expected the program to run during the copy")
+	}
+}
+
+func TestDMAError(t *testing.T) {
+	// a copy into a ROM stops at its first byte, which is still
+	// waiting to be copied
+	memory := make([]byte, 256)
+	copy(memory[32:], assemble(`
+li r1 8
+store r1 17
+li r1 4
+store r1 18
+li r1 1
+store r1 19
+load r2 19
+load r3 18
+halt`))
+	m := NewMachine(memory, WithDataSize(32), WithDMA(16), WithDevice(8, 12, ROM(make([]byte, 4))))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Register(2) != DMAError || m.Register(3) != 4 {
+		t.Errorf("Expected status %#x with 4 bytes left, got %#x with %d", DMAError, m.Register(2), m.Register(3))
+	}
+}
+
+func TestDMAHistory(t *testing.T) {
+	// the copies are traced and undone with the instructions they follow
+	memory := make([]byte, 256)
+	copy(memory, []byte{1, 2, 3, 4})
+	copy(memory[32:], assemble(dmaPoll))
+	var trace bytes.Buffer
+	m := NewMachine(memory, WithDataSize(32), WithDMA(16), WithHistory(100), WithJSONTrace(&trace))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for addr := 4; addr < 8; addr++ {
+		if delta := fmt.Sprintf(`{"addr":%d,"old":0,"new":%d}`, addr, addr-3); !strings.Contains(trace.String(), delta) {
+			t.Errorf("Expected the trace to show the copy to %d, %s", addr, delta)
+		}
+	}
+	for m.StepBack() {
+	}
+	if !bytes.Equal(memory[4:8], make([]byte, 4)) || m.PC() != 32 {
+		t.Errorf("Expected stepping back to the start to undo the copy, got % x at pc %#x", memory[4:8], m.PC())
+	}
+}
@@ -0,0 +1,143 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A DisplayMode controls how dumps render register and memory values.
+type DisplayMode int
+
+const (
+	// Unsigned renders values as unsigned bytes: 0xFE (254)
+	Unsigned DisplayMode = iota
+	// Signed also renders the two's complement value: 0xFE (254 / -2)
+	Signed
+)
+
+// FormatValue renders a byte in hex and decimal according to mode.
+func FormatValue(v byte, mode DisplayMode) string {
+	if mode == Signed {
+		return fmt.Sprintf("0x%02X (%d / %d)", v, v, int8(v))
+	}
+	return fmt.Sprintf("0x%02X (%d)", v, v)
+}
+
+// DumpRegisters writes the PC, the stack pointer, each general purpose
+// register and the flags to w, one per line.
+func (m *Machine) DumpRegisters(w io.Writer, mode DisplayMode) {
+	fmt.Fprintf(w, "PC = 0x%02X\n", m.pc)
+	fmt.Fprintf(w, "SP = 0x%02X\n", m.sp)
+	for i := 1; i <= MaxRegister; i++ {
+		fmt.Fprintf(w, "R%d = %s\n", i, FormatValue(m.registers[i], mode))
+	}
+	fmt.Fprintf(w, "FLAGS = %04b\n", m.flags)
+}
+
+// DumpData writes each byte of the data region to w, one per line.
+func (m *Machine) DumpData(w io.Writer, mode DisplayMode) {
+	for addr := 0; addr < m.dataSize && addr < len(m.memory); addr++ {
+		fmt.Fprintf(w, "%02X: %s\n", addr, FormatValue(m.memory[addr], mode))
+	}
+}
+
+// The bytes on each line of Dump
+const dumpWidth = 16
+
+// Dump writes all of memory to w in the style of xxd, sixteen bytes to
+// a line with the printable ones alongside. Each region starts a line of
+// its own under a heading, and a run of lines that are all zero is
+// shown as a single *:
+//
+//	; data
+//	0000: 2a 2a 00 00 00 00 00 00                          **......
+//	; instructions
+//	0008: 01 01 01 12 01 02 01 00 ff 00 00 00 00 00 00 00  ................
+//	0018: 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00  ................
+//	*
+//	00e8: 00 00 00 00 00 00 00 00                          ........
+//	; stack
+//	00f0: 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 2a  ...............*
+func (m *Machine) Dump(w io.Writer) {
+	stack := m.stackTop - m.stackSize
+	regions := []struct {
+		name       string
+		start, end int
+	}{
+		{"data", 0, m.dataSize},
+		{"instructions", m.dataSize, stack},
+		{"stack", stack, m.stackTop},
+		{"instructions", m.stackTop, len(m.memory)},
+	}
+	for _, r := range regions {
+		if r.start >= r.end {
+			continue
+		}
+		fmt.Fprintf(w, "; %s\n", r.name)
+		starred := false
+		for addr := r.start; addr < r.end; addr += dumpWidth {
+			line := m.memory[addr:r.end]
+			if len(line) > dumpWidth {
+				line = line[:dumpWidth]
+			}
+			// a zero line after another is elided, but the last line of
+			// a region always shows, so that its extent is clear
+			if addr > r.start && addr+len(line) < r.end && isZero(line) && isZero(m.memory[addr-dumpWidth:addr]) {
+				if !starred {
+					fmt.Fprintln(w, "*")
+					starred = true
+				}
+				continue
+			}
+			starred = false
+			dumpLine(w, addr, line)
+		}
+	}
+}
+
+func dumpLine(w io.Writer, addr int, line []byte) {
+	text := make([]byte, len(line))
+	for i, b := range line {
+		text[i] = '.'
+		if b >= ' ' && b <= '~' {
+			text[i] = b
+		}
+	}
+	fmt.Fprintf(w, "%04x: %-*s  %s\n", addr, 3*dumpWidth-1, fmt.Sprintf("% x", line), text)
+}
+
+// DiffStates describes how the state of b differs from that of a, with
+// a line for each register, flag or byte of memory that changed, or
+// returns "" if they are the same:
+//
+//	R2: 0x00 -> 0x06
+//	mem 00: 0x00 -> 0x06
+//
+// Memory is only compared up to the end of the smaller of the two.
+func DiffStates(a, b *Machine) string {
+	var s strings.Builder
+	if a.pc != b.pc {
+		fmt.Fprintf(&s, "PC: 0x%02X -> 0x%02X\n", a.pc, b.pc)
+	}
+	if a.sp != b.sp {
+		fmt.Fprintf(&s, "SP: 0x%02X -> 0x%02X\n", a.sp, b.sp)
+	}
+	for i := 1; i <= MaxRegister; i++ {
+		if a.registers[i] != b.registers[i] {
+			fmt.Fprintf(&s, "R%d: 0x%02X -> 0x%02X\n", i, a.registers[i], b.registers[i])
+		}
+	}
+	if a.flags != b.flags {
+		fmt.Fprintf(&s, "FLAGS: %04b -> %04b\n", a.flags, b.flags)
+	}
+	if a.halted != b.halted {
+		fmt.Fprintf(&s, "halted: %v -> %v\n", a.halted, b.halted)
+	}
+	for addr := 0; addr < len(a.memory) && addr < len(b.memory); addr++ {
+		if a.memory[addr] != b.memory[addr] {
+			fmt.Fprintf(&s, "mem %02x: 0x%02X -> 0x%02X\n", addr, a.memory[addr], b.memory[addr])
+		}
+	}
+	return s.String()
+}
@@ -0,0 +1,105 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatValue(t *testing.T) {
+	if s := FormatValue(0xfe, Unsigned); s != "0xFE (254)" {
+		t.Fatalf("Unexpected unsigned format %q", s)
+	}
+	if s := FormatValue(0xfe, Signed); s != "0xFE (254 / -2)" {
+		t.Fatalf("Unexpected signed format %q", s)
+	}
+	if s := FormatValue(0x7f, Signed); s != "0x7F (127 / 127)" {
+		t.Fatalf("Unexpected signed format %q", s)
+	}
+}
+
+func TestDumpRegisters(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+li r1 254
+store r1 2
+halt`))
+	m := NewMachine(memory)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	m.DumpRegisters(&b, Signed)
+	expected := "PC = 0x0E\n" +
+		"SP = 0x100\n" +
+		"R1 = 0xFE (254 / -2)\n" +
+		"R2 = 0x00 (0 / 0)\n" +
+		"R3 = 0x00 (0 / 0)\n" +
+		"R4 = 0x00 (0 / 0)\n" +
+		"R5 = 0x00 (0 / 0)\n" +
+		"R6 = 0x00 (0 / 0)\n" +
+		"R7 = 0x00 (0 / 0)\n" +
+		"FLAGS = 0000\n"
+	if b.String() != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, b.String())
+	}
+
+	b.Reset()
+	m.DumpData(&b, Unsigned)
+	if lines := strings.Split(b.String(), "\n"); len(lines) != 9 || lines[2] != "02: 0xFE (254)" {
+		t.Fatalf("Unexpected data dump\n%s", b.String())
+	}
+}
+
+func TestDump(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+load r1 1
+push r1
+store r1 0
+halt`))
+	memory[1] = '*'
+	m := NewMachine(memory)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	m.Dump(&b)
+	expected := "; data\n" +
+		"0000: 2a 2a 00 00 00 00 00 00                          **......\n" +
+		"; instructions\n" +
+		"0008: 01 01 01 12 01 02 01 00 ff 00 00 00 00 00 00 00  ................\n" +
+		"0018: 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00  ................\n" +
+		"*\n" +
+		"00e8: 00 00 00 00 00 00 00 00                          ........\n" +
+		"; stack\n" +
+		"00f0: 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 2a  ...............*\n"
+	if b.String() != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, b.String())
+	}
+}
+
+func TestDiffStates(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+li r2 6
+store r2 0
+halt`))
+	before := NewMachine(append([]byte(nil), memory...))
+	after := NewMachine(memory)
+	if err := after.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "PC: 0x08 -> 0x0E\n" +
+		"R2: 0x00 -> 0x06\n" +
+		"halted: false -> true\n" +
+		"mem 00: 0x00 -> 0x06\n"
+	if diff := DiffStates(before, after); diff != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, diff)
+	}
+	if diff := DiffStates(after, after); diff != "" {
+		t.Fatalf("Expected no differences, got\n%s", diff)
+	}
+}
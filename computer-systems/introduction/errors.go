@@ -0,0 +1,80 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned when a program faults. They are wrapped with details
+// about the fault, so compare against them with errors.Is.
+var (
+	ErrIllegalOpcode   = errors.New("illegal opcode")
+	ErrBadRegister     = errors.New("invalid register")
+	ErrOutOfBounds     = errors.New("out of bounds memory access")
+	ErrReadOnlyWrite   = errors.New("write to read-only memory")
+	ErrCycleLimit      = errors.New("cycle limit exceeded")
+	ErrFellOffEnd      = errors.New("ran past the end of the program")
+	ErrInvalidConfig   = errors.New("invalid configuration")
+	ErrImageSize       = errors.New("memory image is the wrong size")
+	ErrAssertionFailed = errors.New("assertion failed")
+	ErrStackOverflow   = errors.New("stack overflow")
+	ErrStackUnderflow  = errors.New("pop from empty stack")
+	ErrDivideByZero    = errors.New("division by zero")
+	ErrDevice          = errors.New("device error")
+	ErrExitStatus      = errors.New("nonzero exit status")
+	ErrProtection      = errors.New("memory protection violation")
+	ErrBadSyscall      = errors.New("unknown system call")
+)
+
+// ErrReadOnly is the old name of ErrReadOnlyWrite, kept so that code
+// comparing against it still works.
+var ErrReadOnly = ErrReadOnlyWrite
+
+// A Fault is returned when an instruction faults. It records the
+// instruction, and wraps an error describing the fault which matches
+// one of the errors above, so that both errors.Is and errors.As work on
+// it.
+type Fault struct {
+	PC       int
+	Op       *OpInfo // nil if the opcode is illegal or past the end of memory
+	Operands []int   // nil unless the operands decoded
+	Err      error
+}
+
+func (f *Fault) Error() string {
+	return f.Err.Error()
+}
+
+func (f *Fault) Unwrap() error {
+	return f.Err
+}
+
+// An AssertionError reports which assert instruction failed. It
+// matches ErrAssertionFailed with errors.Is.
+type AssertionError struct {
+	PC  int
+	Reg int
+}
+
+func (e *AssertionError) Error() string {
+	return fmt.Sprintf("%v: r%d is zero at pc %#x", ErrAssertionFailed, e.Reg, e.PC)
+}
+
+func (e *AssertionError) Unwrap() error {
+	return ErrAssertionFailed
+}
+
+// An ExitError reports that the program executed an exit with a nonzero
+// status. It matches ErrExitStatus with errors.Is.
+type ExitError struct {
+	PC   int
+	Code byte
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d at pc %#x", e.Code, e.PC)
+}
+
+func (e *ExitError) Unwrap() error {
+	return ErrExitStatus
+}
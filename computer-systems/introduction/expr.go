@@ -0,0 +1,251 @@
+package vm
+
+import "fmt"
+
+// An Expr is a byte computed from the inputs of a program explored
+// symbolically, as Explore does. Arithmetic wraps around, as it does on
+// the machine, and comparisons give 1 for true and 0 for false.
+// Expressions are immutable, and are folded as they are built, so an
+// expression with no inputs in it is always a constant.
+type Expr struct {
+	op   exprOp
+	val  byte // for a constant
+	addr int  // for an input
+	x, y *Expr
+}
+
+type exprOp int
+
+const (
+	exprConst exprOp = iota
+	exprInput
+	exprAdd
+	exprSub
+	exprMul
+	exprMulHi
+	exprDiv
+	exprMod
+	exprAnd
+	exprOr
+	exprXor
+	exprNot
+	exprShl
+	exprShr
+	exprEq
+	exprUlt
+)
+
+// How each binary operator is written by String
+var exprSymbols = map[exprOp]string{
+	exprAdd: "+",
+	exprSub: "-",
+	exprMul: "*",
+	exprDiv: "/",
+	exprMod: "%",
+	exprAnd: "&",
+	exprOr:  "|",
+	exprXor: "^",
+	exprShl: "<<",
+	exprShr: ">>",
+	exprEq:  "==",
+	exprUlt: "<",
+}
+
+// Const returns the constant b.
+func Const(b byte) *Expr {
+	return &Expr{op: exprConst, val: b}
+}
+
+// Input returns the initial value of the byte of memory at addr.
+func Input(addr int) *Expr {
+	return &Expr{op: exprInput, addr: addr}
+}
+
+func (x *Expr) Add(y *Expr) *Expr { return newExpr(exprAdd, x, y) }
+func (x *Expr) Sub(y *Expr) *Expr { return newExpr(exprSub, x, y) }
+func (x *Expr) Mul(y *Expr) *Expr { return newExpr(exprMul, x, y) }
+
+// Div and Mod divide unsigned, as div and mod do, and give 0 for a zero
+// divisor, where the instructions would fault.
+func (x *Expr) Div(y *Expr) *Expr { return newExpr(exprDiv, x, y) }
+func (x *Expr) Mod(y *Expr) *Expr { return newExpr(exprMod, x, y) }
+
+func (x *Expr) And(y *Expr) *Expr { return newExpr(exprAnd, x, y) }
+func (x *Expr) Or(y *Expr) *Expr  { return newExpr(exprOr, x, y) }
+func (x *Expr) Xor(y *Expr) *Expr { return newExpr(exprXor, x, y) }
+func (x *Expr) Not() *Expr        { return newExpr(exprNot, x, nil) }
+func (x *Expr) Shl(y *Expr) *Expr { return newExpr(exprShl, x, y) }
+func (x *Expr) Shr(y *Expr) *Expr { return newExpr(exprShr, x, y) }
+
+// Eq is 1 if x equals y, and 0 otherwise.
+func (x *Expr) Eq(y *Expr) *Expr { return newExpr(exprEq, x, y) }
+
+// Ult is 1 if x is less than y as unsigned bytes, and 0 otherwise.
+func (x *Expr) Ult(y *Expr) *Expr { return newExpr(exprUlt, x, y) }
+
+// The high byte of the 16 bit product of x and y, as mulw computes it
+func (x *Expr) mulHi(y *Expr) *Expr { return newExpr(exprMulHi, x, y) }
+
+// 1 if x is nonzero, and 0 otherwise
+func (x *Expr) truth() *Expr { return Const(0).Ult(x) }
+
+// Build an expression, folding constants and simplifying the identities
+// that arise from running ordinary code, like adding zero
+func newExpr(op exprOp, x, y *Expr) *Expr {
+	e := &Expr{op: op, x: x, y: y}
+	xv, xConst := x.Value()
+	if op == exprNot {
+		if xConst {
+			return Const(e.apply(xv, 0))
+		}
+		return e
+	}
+	yv, yConst := y.Value()
+	switch {
+	case xConst && yConst:
+		return Const(e.apply(xv, yv))
+	case yConst && (op == exprAdd || op == exprSub) && (x.op == exprAdd || x.op == exprSub) && x.y.op == exprConst:
+		// fold (a + c1) - c2 into a + (c1 - c2), so that a counter
+		// stays small however many times a loop goes around
+		c := x.y.val
+		if x.op == exprSub {
+			c = -c
+		}
+		if op == exprAdd {
+			c += yv
+		} else {
+			c -= yv
+		}
+		return x.x.Add(Const(c))
+	case yConst && yv == 0 && (op == exprAdd || op == exprSub || op == exprOr || op == exprXor || op == exprShl || op == exprShr):
+		return x
+	case xConst && xv == 0 && (op == exprAdd || op == exprOr || op == exprXor):
+		return y
+	case (xConst && xv == 0 || yConst && yv == 0) && (op == exprMul || op == exprMulHi || op == exprAnd):
+		return Const(0)
+	case yConst && yv == 1 && op == exprMul, yConst && yv == 0xff && op == exprAnd:
+		return x
+	case xConst && xv == 1 && op == exprMul, xConst && xv == 0xff && op == exprAnd:
+		return y
+	case x == y && (op == exprSub || op == exprXor):
+		return Const(0)
+	case x == y && op == exprEq:
+		return Const(1)
+	}
+	return e
+}
+
+// Value returns the value of x if it is a constant.
+func (x *Expr) Value() (byte, bool) {
+	if x.op == exprConst {
+		return x.val, true
+	}
+	return 0, false
+}
+
+// Eval returns the value of x when the inputs have the given values.
+// Inputs missing from the map are 0.
+func (x *Expr) Eval(inputs map[int]byte) byte {
+	switch x.op {
+	case exprConst:
+		return x.val
+	case exprInput:
+		return inputs[x.addr]
+	case exprNot:
+		return x.apply(x.x.Eval(inputs), 0)
+	}
+	return x.apply(x.x.Eval(inputs), x.y.Eval(inputs))
+}
+
+// Like Eval, but with the inputs indexed by address, which is much
+// faster when searching for inputs
+func (x *Expr) eval(env []byte) byte {
+	switch x.op {
+	case exprConst:
+		return x.val
+	case exprInput:
+		return env[x.addr]
+	case exprNot:
+		return x.apply(x.x.eval(env), 0)
+	}
+	return x.apply(x.x.eval(env), x.y.eval(env))
+}
+
+// Apply the operator of x to the values of its operands
+func (x *Expr) apply(a, b byte) byte {
+	switch x.op {
+	case exprAdd:
+		return a + b
+	case exprSub:
+		return a - b
+	case exprMul:
+		return a * b
+	case exprMulHi:
+		return byte(uint16(a) * uint16(b) >> 8)
+	case exprDiv:
+		if b == 0 {
+			return 0
+		}
+		return a / b
+	case exprMod:
+		if b == 0 {
+			return 0
+		}
+		return a % b
+	case exprAnd:
+		return a & b
+	case exprOr:
+		return a | b
+	case exprXor:
+		return a ^ b
+	case exprNot:
+		return ^a
+	case exprShl:
+		return a << b
+	case exprShr:
+		return a >> b
+	case exprEq:
+		if a == b {
+			return 1
+		}
+	case exprUlt:
+		if a < b {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Add the addresses of the inputs in x to addrs, unless already seen
+func (x *Expr) inputs(seen map[int]bool, addrs []int) []int {
+	switch x.op {
+	case exprConst:
+	case exprInput:
+		if !seen[x.addr] {
+			seen[x.addr] = true
+			addrs = append(addrs, x.addr)
+		}
+	default:
+		addrs = x.x.inputs(seen, addrs)
+		if x.y != nil {
+			addrs = x.y.inputs(seen, addrs)
+		}
+	}
+	return addrs
+}
+
+// String returns x much as Go would write it, with constants in decimal
+// and inputs written as mem[addr].
+func (x *Expr) String() string {
+	switch x.op {
+	case exprConst:
+		return fmt.Sprint(x.val)
+	case exprInput:
+		return fmt.Sprintf("mem[0x%02x]", x.addr)
+	case exprNot:
+		return "^" + x.x.String()
+	case exprMulHi:
+		return fmt.Sprintf("hi(%s * %s)", x.x, x.y)
+	}
+	return fmt.Sprintf("(%s %s %s)", x.x, exprSymbols[x.op], x.y)
+}
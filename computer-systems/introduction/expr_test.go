@@ -0,0 +1,41 @@
+package vm
+
+import "testing"
+
+func TestExpr(t *testing.T) {
+	a, b := Input(0), Input(1)
+	tests := []struct {
+		expr     *Expr
+		expected string
+		value    byte // with a = 200 and b = 100
+	}{
+		{a.Add(b), "(mem[0x00] + mem[0x01])", 44},
+		{a.Sub(b).Mul(Const(2)), "((mem[0x00] - mem[0x01]) * 2)", 200},
+		{a.mulHi(b), "hi(mem[0x00] * mem[0x01])", 78},
+		{a.Div(b).Or(b.Mod(Const(0))), "((mem[0x00] / mem[0x01]) | (mem[0x01] % 0))", 2},
+		{a.Not().And(b.Shr(Const(2))), "(^mem[0x00] & (mem[0x01] >> 2))", 17},
+		{a.Ult(b).Xor(a.Eq(b)), "((mem[0x00] < mem[0x01]) ^ (mem[0x00] == mem[0x01]))", 0},
+		{b.Shl(Const(1)), "(mem[0x01] << 1)", 200},
+		// folded as they are built
+		{Const(3).Mul(Const(4)).Add(Const(1)), "13", 13},
+		{a.Add(Const(0)).And(Const(0xff)).Mul(Const(1)), "mem[0x00]", 200},
+		{a.Sub(Const(1)).Sub(Const(1)).Add(Const(5)), "(mem[0x00] + 3)", 203},
+		{a.Add(Const(1)).Sub(Const(1)), "mem[0x00]", 200},
+		{a.Xor(a).Add(b.Mul(Const(0))), "0", 0},
+		{a.Eq(a), "1", 1},
+	}
+	for _, test := range tests {
+		if got := test.expr.String(); got != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, got)
+		}
+		if got := test.expr.Eval(map[int]byte{0: 200, 1: 100}); got != test.value {
+			t.Errorf("%s: expected %d, got %d", test.expected, test.value, got)
+		}
+	}
+	if v, ok := Const(6).Div(Const(0)).Value(); !ok || v != 0 {
+		t.Fatalf("Expected dividing by zero to fold to 0, got %d, %v", v, ok)
+	}
+	if _, ok := a.Value(); ok {
+		t.Fatalf("Expected an input not to be constant")
+	}
+}
@@ -0,0 +1,82 @@
+package vm
+
+import (
+	"errors"
+	"testing"
+)
+
+const fuzzCycleLimit = 10000
+
+var vmErrors = []error{
+	ErrIllegalOpcode,
+	ErrBadRegister,
+	ErrOutOfBounds,
+	ErrReadOnlyWrite,
+	ErrCycleLimit,
+	ErrFellOffEnd,
+	ErrInvalidConfig,
+	ErrImageSize,
+	ErrAssertionFailed,
+	ErrStackOverflow,
+	ErrStackUnderflow,
+	ErrDivideByZero,
+	ErrDevice,
+	ErrExitStatus,
+	ErrProtection,
+	ErrBadSyscall,
+}
+
+// Run arbitrary memory images through the machine, checking that it never
+// panics, only fails with one of its own errors, stops within the cycle
+// limit, and keeps its memory accesses within bounds: reads anywhere in
+// memory, and writes only to the data region and the stack
+func FuzzCompute(f *testing.F) {
+	for _, tests := range [][]vmTest{mainTests, stretchGoalTests, extensionTests} {
+		for _, test := range tests {
+			memory := make([]byte, MemorySize)
+			copy(memory[DefaultDataSize:], assemble(test.asm))
+			memory[1] = test.cases[0].x
+			memory[2] = test.cases[0].y
+			f.Add(memory)
+		}
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 0, Load, 1})
+
+	f.Fuzz(func(t *testing.T, memory []byte) {
+		// Run the image both ways, each on its own copy
+		for _, wide := range []bool{false, true} {
+			var log AccessLog
+			opts := []Option{WithCycleLimit(fuzzCycleLimit), WithAccessLog(&log)}
+			if wide {
+				opts = append(opts, WithWideAddresses())
+			}
+			m := NewMachine(append([]byte(nil), memory...), opts...)
+			err := m.Run()
+			if err != nil && !isVMError(err) {
+				t.Fatalf("Unexpected error type: %v", err)
+			}
+			if m.cycles > fuzzCycleLimit {
+				t.Fatalf("Ran %d instructions, more than the limit of %d", m.cycles, fuzzCycleLimit)
+			}
+			stack := len(memory) - DefaultStackSize
+			for _, access := range log.Accesses {
+				if access.Addr < 0 || access.Addr >= len(memory) {
+					t.Fatalf("Instruction at pc %#x accessed %#x, outside memory", access.PC, access.Addr)
+				}
+				if access.IsWrite && access.Addr >= DefaultDataSize && access.Addr < stack {
+					t.Fatalf("Instruction at pc %#x wrote to %#x, outside the data region and stack", access.PC, access.Addr)
+				}
+			}
+		}
+	})
+}
+
+func isVMError(err error) bool {
+	for _, target := range vmErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package vm
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Intel HEX record types
+const (
+	hexData = 0x00
+	hexEOF  = 0x01
+)
+
+// The number of data bytes in each record WriteHex writes
+const hexRecordSize = 16
+
+// WriteHex writes memory to w in Intel HEX format, in records of 16
+// bytes each. Records that would be all zeros are left out, so an image
+// reads as just its data and program.
+func WriteHex(w io.Writer, memory []byte) error {
+	if len(memory) > MaxWideMemorySize {
+		return fmt.Errorf("%w: %d bytes, expected at most %d", ErrImageSize, len(memory), MaxWideMemorySize)
+	}
+	bw := bufio.NewWriter(w)
+	for addr := 0; addr < len(memory); addr += hexRecordSize {
+		end := addr + hexRecordSize
+		if end > len(memory) {
+			end = len(memory)
+		}
+		if isZero(memory[addr:end]) {
+			continue
+		}
+		writeHexRecord(bw, addr, hexData, memory[addr:end])
+	}
+	writeHexRecord(bw, 0, hexEOF, nil)
+	return bw.Flush()
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func writeHexRecord(w *bufio.Writer, addr int, kind byte, data []byte) {
+	record := append([]byte{byte(len(data)), byte(addr >> 8), byte(addr), kind}, data...)
+	var sum byte
+	for _, b := range record {
+		sum += b
+	}
+	record = append(record, -sum)
+	fmt.Fprintf(w, ":%s\n", strings.ToUpper(hex.EncodeToString(record)))
+}
+
+// ReadHex reads an image in Intel HEX format from r into size bytes of
+// memory, leaving the addresses it doesn't mention zero. Only data and
+// end of file records are supported, which limits it to 64K images.
+func ReadHex(r io.Reader, size int) ([]byte, error) {
+	memory := make([]byte, size)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if text[0] != ':' {
+			return nil, fmt.Errorf("hex line %d: expected a record starting with ':'", line)
+		}
+		record, err := hex.DecodeString(text[1:])
+		if err != nil {
+			return nil, fmt.Errorf("hex line %d: %v", line, err)
+		}
+		if len(record) < 5 || len(record) != 5+int(record[0]) {
+			return nil, fmt.Errorf("hex line %d: record length doesn't match its byte count", line)
+		}
+		var sum byte
+		for _, b := range record {
+			sum += b
+		}
+		if sum != 0 {
+			return nil, fmt.Errorf("hex line %d: bad checksum", line)
+		}
+
+		addr := int(record[1])<<8 | int(record[2])
+		data := record[4 : len(record)-1]
+		switch record[3] {
+		case hexData:
+			if addr+len(data) > size {
+				return nil, fmt.Errorf("%w: hex line %d writes past %d bytes of memory", ErrImageSize, line, size)
+			}
+			copy(memory[addr:], data)
+		case hexEOF:
+			return memory, nil
+		default:
+			return nil, fmt.Errorf("hex line %d: unsupported record type %#02x", line, record[3])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("hex: missing end of file record")
+}
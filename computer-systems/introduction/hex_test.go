@@ -0,0 +1,79 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestWriteHex(t *testing.T) {
+	memory := make([]byte, 256)
+	memory[1] = 3
+	copy(memory[8:], assemble("load r1 1\naddi r1 1\nstore r1 0\nhalt"))
+
+	var out strings.Builder
+	if err := WriteHex(&out, memory); err != nil {
+		t.Fatal(err)
+	}
+	expected := ":1000000000030000000000000101010501010201E0\n" +
+		":1000100000FF0000000000000000000000000000E1\n" +
+		":00000001FF\n"
+	if out.String() != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+func TestHexRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, size := range []int{MemorySize, 0x400} {
+		memory := make([]byte, size)
+		// leave gaps of zeros for the writer to skip
+		for i := range memory {
+			if rng.Intn(3) == 0 {
+				memory[i] = byte(rng.Intn(256))
+			}
+		}
+		for i := 0x40; i < 0x80; i++ {
+			memory[i] = 0
+		}
+
+		var buf bytes.Buffer
+		if err := WriteHex(&buf, memory); err != nil {
+			t.Fatal(err)
+		}
+		read, err := ReadHex(&buf, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(read, memory) {
+			t.Errorf("%d bytes: image changed in the round trip", size)
+		}
+	}
+}
+
+func TestReadHexErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+		msg  string
+	}{
+		{"NoColon", "00000001FF\n", "starting with ':'"},
+		{"NotHex", ":0000000zFF\n", "invalid byte"},
+		{"Length", ":0200000000FE\n:00000001FF\n", "byte count"},
+		{"Checksum", ":0100100000EE\n:00000001FF\n", "bad checksum"},
+		{"RecordType", ":020000040000FA\n:00000001FF\n", "unsupported record type"},
+		{"MissingEOF", ":0100100000EF\n", "missing end of file"},
+	}
+	for _, test := range tests {
+		_, err := ReadHex(strings.NewReader(test.hex), MemorySize)
+		if err == nil || !strings.Contains(err.Error(), test.msg) {
+			t.Errorf("%s: expected an error containing %q, got %v", test.name, test.msg, err)
+		}
+	}
+
+	if _, err := ReadHex(strings.NewReader(":0101000000FE\n:00000001FF\n"), MemorySize); !errors.Is(err, ErrImageSize) {
+		t.Errorf("Expected data past the end of memory to fail with ErrImageSize, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package vm
+
+// The state of the machine before an instruction, apart from memory,
+// and the bytes of memory the instruction wrote, with their old values
+type undoRecord struct {
+	pc, sp    int
+	registers [MaxRegister + 1]byte
+	flags     byte
+	cycles    uint64
+	counters  Counters
+	pending   byte
+	masked    bool
+	halted    bool
+	exitCode  byte
+	random    uint64
+	writes    []MemoryDelta
+}
+
+// The most recent undo records, in a ring of size records that grows
+// as it is first filled
+type history struct {
+	records []undoRecord
+	size    int
+	next    int // where the next record goes
+	n       int // how many of records are in use
+}
+
+// WithHistory keeps enough information to undo each of the last n
+// instructions the machine executed, for StepBack. The history covers
+// memory, registers and interrupts, but not devices: stepping back over
+// an instruction that wrote to the console doesn't take back the output.
+func WithHistory(n int) Option {
+	return func(m *Machine) {
+		m.history = &history{size: n}
+	}
+}
+
+// Start a record for the instruction about to execute
+func (m *Machine) record() {
+	h := m.history
+	if h.size <= 0 {
+		return
+	}
+	if h.next == len(h.records) {
+		h.records = append(h.records, undoRecord{})
+	}
+	r := &h.records[h.next]
+	*r = undoRecord{
+		pc:        m.pc,
+		sp:        m.sp,
+		registers: m.registers,
+		flags:     m.flags,
+		cycles:    m.cycles,
+		counters:  m.counters,
+		pending:   m.pending,
+		masked:    m.masked,
+		halted:    m.halted,
+		exitCode:  m.exitCode,
+		random:    m.random,
+		writes:    r.writes[:0],
+	}
+	h.next = (h.next + 1) % h.size
+	if h.n < h.size {
+		h.n++
+	}
+}
+
+// Note a write to memory by the instruction being recorded
+func (m *Machine) recordWrite(addr int) {
+	h := m.history
+	if h.n == 0 {
+		return
+	}
+	r := &h.records[(h.next+h.size-1)%h.size]
+	r.writes = append(r.writes, MemoryDelta{Addr: addr, Old: m.memory[addr]})
+}
+
+// StepBack undoes the last instruction executed, along with any
+// interrupt taken after it, returning the machine to the state it was
+// in before. An instruction that faulted counts too, so stepping back
+// over it takes back anything it wrote before the fault. StepBack
+// reports false, and does nothing, if the machine wasn't created with
+// WithHistory or there is no more history to undo.
+func (m *Machine) StepBack() bool {
+	h := m.history
+	if h == nil || h.n == 0 {
+		return false
+	}
+	h.next = (h.next + h.size - 1) % h.size
+	h.n--
+	r := &h.records[h.next]
+	for i := len(r.writes) - 1; i >= 0; i-- {
+		m.memory[r.writes[i].Addr] = r.writes[i].Old
+		m.invalidate(r.writes[i].Addr)
+	}
+	m.pc, m.sp = r.pc, r.sp
+	m.registers = r.registers
+	m.flags = r.flags
+	m.cycles = r.cycles
+	m.counters = r.counters
+	m.pending, m.masked = r.pending, r.masked
+	m.halted, m.exitCode = r.halted, r.exitCode
+	m.random = r.random
+	return true
+}
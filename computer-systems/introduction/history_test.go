@@ -0,0 +1,92 @@
+package vm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStepBack(t *testing.T) {
+	// Count down from 3 in a subroutine, storing each value, and pushing
+	// and popping it again
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+load r1 1
+call 14
+halt
+store r1 0
+push r1
+pop r2
+subi r1 1
+bnz 244
+ret`))
+	memory[1] = 3
+
+	m := NewMachine(memory, WithHistory(100))
+	// the first step also checks the image, which stepping back leaves
+	// checked, so compare snapshots from the second instruction on
+	if err := m.Step(); err != nil {
+		t.Fatal(err)
+	}
+	var states [][]byte
+	for !m.Halted() {
+		state, err := m.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, state)
+		if err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if memory[0] != 1 || memory[254] != 1 {
+		t.Fatalf("Expected the program to count down to 1, got %d and %d", memory[0], memory[254])
+	}
+
+	for i := len(states) - 1; i >= 0; i-- {
+		if !m.StepBack() {
+			t.Fatalf("Expected history left for instruction %d", i)
+		}
+		state, err := m.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(state, states[i]) {
+			t.Fatalf("State after stepping back to instruction %d differs from before it ran", i)
+		}
+	}
+	if !m.StepBack() || m.PC() != 8 || m.Register(1) != 0 {
+		t.Fatalf("Expected to step back over the first instruction, got r1=%d at %#x", m.Register(1), m.PC())
+	}
+	if m.StepBack() {
+		t.Fatal("Expected no history before the first instruction")
+	}
+
+	// and forward again
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if memory[0] != 1 || m.Cycles() != uint64(len(states)+1) {
+		t.Fatalf("Expected the same run again, got %d after %d instructions", memory[0], m.Cycles())
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+addi r1 1
+addi r1 1
+addi r1 1
+addi r1 1
+halt`))
+	m := NewMachine(memory, WithHistory(2))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	back := 0
+	for m.StepBack() {
+		back++
+	}
+	if back != 2 || m.Register(1) != 3 || m.PC() != 17 {
+		t.Fatalf("Expected to step back over the last 2 instructions to r1=3 at 0x11, got %d, r1=%d at %#x", back, m.Register(1), m.PC())
+	}
+}
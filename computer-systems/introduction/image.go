@@ -0,0 +1,90 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// An image file is either the raw bytes of memory, or this header
+// followed by them:
+//
+//	offset  size  contents
+//	0       4     "VMI\x01", the magic number and format version
+//	4       1     flags: bit 0 set for wide addresses
+//	5       2     the data size, little-endian
+//	7       2     the entry point, little-endian
+var imageMagic = []byte("VMI\x01")
+
+const imageHeaderSize = 9
+
+// An ImageHeader records how to run the memory image it is saved with.
+type ImageHeader struct {
+	DataSize int
+	Entry    int
+	Wide     bool
+}
+
+// Options returns the options that configure a machine as h describes.
+func (h *ImageHeader) Options() []Option {
+	opts := []Option{WithDataSize(h.DataSize), WithEntryPoint(h.Entry)}
+	if h.Wide {
+		opts = append(opts, WithWideAddresses())
+	}
+	return opts
+}
+
+// LoadImage reads a memory image saved by SaveImage, returning its
+// header, or nil if it was saved without one. A raw image, or one with
+// a header that isn't for wide addresses, is padded with zeros to
+// MemorySize bytes.
+func LoadImage(path string) ([]byte, *ImageHeader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var h *ImageHeader
+	if len(data) >= imageHeaderSize && bytes.Equal(data[:len(imageMagic)], imageMagic) {
+		h = &ImageHeader{
+			DataSize: int(binary.LittleEndian.Uint16(data[5:])),
+			Entry:    int(binary.LittleEndian.Uint16(data[7:])),
+			Wide:     data[4]&1 != 0,
+		}
+		data = data[imageHeaderSize:]
+	}
+
+	size := MemorySize
+	if h != nil && h.Wide {
+		size = MaxWideMemorySize
+	}
+	if len(data) > size {
+		return nil, nil, fmt.Errorf("%w: %s holds %d bytes, expected at most %d", ErrImageSize, path, len(data), size)
+	}
+	if h == nil || !h.Wide {
+		memory := make([]byte, MemorySize)
+		copy(memory, data)
+		data = memory
+	}
+	return data, h, nil
+}
+
+// SaveImage writes memory to path, preceded by h unless it is nil.
+func SaveImage(path string, memory []byte, h *ImageHeader) error {
+	var buf bytes.Buffer
+	if h != nil {
+		if h.DataSize < 0 || h.DataSize > 0xffff || h.Entry < 0 || h.Entry > 0xffff {
+			return fmt.Errorf("%w: data size %d and entry point %#x don't fit in an image header", ErrInvalidConfig, h.DataSize, h.Entry)
+		}
+		var header [imageHeaderSize]byte
+		copy(header[:], imageMagic)
+		if h.Wide {
+			header[4] = 1
+		}
+		binary.LittleEndian.PutUint16(header[5:], uint16(h.DataSize))
+		binary.LittleEndian.PutUint16(header[7:], uint16(h.Entry))
+		buf.Write(header[:])
+	}
+	buf.Write(memory)
+	return os.WriteFile(path, buf.Bytes(), 0666)
+}
@@ -0,0 +1,85 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImageRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	// without address operands, so that it runs the same wide
+	program := assemble("li r1 41\naddi r1 1\nhalt")
+
+	tests := []struct {
+		name   string
+		memory []byte
+		header *ImageHeader
+	}{
+		{"Raw", append(make([]byte, 8), program...), nil},
+		{"Header", append(make([]byte, 16), program...), &ImageHeader{DataSize: 16, Entry: 16}},
+		{"Wide", append(append(make([]byte, 0x300), program...), make([]byte, 0x100)...), &ImageHeader{DataSize: 0x300, Entry: 0x300, Wide: true}},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		if err := SaveImage(path, test.memory, test.header); err != nil {
+			t.Fatal(err)
+		}
+		memory, header, err := LoadImage(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (header == nil) != (test.header == nil) || header != nil && *header != *test.header {
+			t.Errorf("%s: expected header %+v, got %+v", test.name, test.header, header)
+		}
+		if !bytes.HasPrefix(memory, test.memory) {
+			t.Errorf("%s: image changed between saving and loading", test.name)
+		}
+
+		// the loaded image runs as saved
+		var opts []Option
+		if header != nil {
+			opts = header.Options()
+		}
+		m := NewMachine(memory, opts...)
+		if err := m.Run(); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if m.Register(1) != 42 {
+			t.Errorf("%s: expected 42, got %d", test.name, m.Register(1))
+		}
+	}
+}
+
+func TestLoadImagePads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short")
+	if err := os.WriteFile(path, []byte{0, 0, 0, 0, 0, 0, 0, 0, Halt}, 0666); err != nil {
+		t.Fatal(err)
+	}
+	memory, header, err := LoadImage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header != nil || len(memory) != MemorySize || memory[8] != Halt {
+		t.Errorf("Expected a raw image padded to %d bytes, got %d bytes and header %+v", MemorySize, len(memory), header)
+	}
+}
+
+func TestLoadImageErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "long")
+	if err := SaveImage(path, make([]byte, MemorySize+1), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := LoadImage(path); !errors.Is(err, ErrImageSize) {
+		t.Errorf("Expected an image too big for memory to be rejected, got %v", err)
+	}
+	if _, _, err := LoadImage(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing file to fail, got %v", err)
+	}
+	if err := SaveImage(path, nil, &ImageHeader{DataSize: 8, Entry: 1 << 16}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected an entry point too big for the header to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,421 @@
+package vm
+
+import "fmt"
+
+// A handler executes one decoded instruction, given its operands and the
+// address of the instruction after it. It reports whether it moved the
+// PC itself; if not, execution continues with the next instruction.
+//
+// Each opcode has exactly one handler, whichever way the machine
+// dispatches to it, so the dispatch strategies can't disagree about
+// what an instruction does.
+type handler func(m *Machine, args []int, next int) (bool, error)
+
+func execLoad(m *Machine, args []int, next int) (bool, error) {
+	reg, addr := args[0], args[1]
+	// load data at addr into register reg
+	val, err := m.load(addr)
+	if err != nil {
+		return false, err
+	}
+	m.registers[reg] = val
+	return false, nil
+}
+
+func execStore(m *Machine, args []int, next int) (bool, error) {
+	reg, addr := args[0], args[1]
+	// store the value of register reg at addr
+	return false, m.store(addr, m.registers[reg])
+}
+
+func execLoadR(m *Machine, args []int, next int) (bool, error) {
+	reg, addrReg := args[0], args[1]
+	// load the data at the address held in register addrReg, which
+	// reaches only the first 256 bytes with wide addresses
+	val, err := m.load(int(m.registers[addrReg]))
+	if err != nil {
+		return false, err
+	}
+	m.registers[reg] = val
+	return false, nil
+}
+
+func execStoreR(m *Machine, args []int, next int) (bool, error) {
+	reg, addrReg := args[0], args[1]
+	// store the value of register reg at the address held in addrReg
+	return false, m.store(int(m.registers[addrReg]), m.registers[reg])
+}
+
+func execLoadw(m *Machine, args []int, next int) (bool, error) {
+	low, high, addr := args[0], args[1], args[2]
+	// load the little-endian word at addr, the low byte into register
+	// low and the high byte, at addr+1, into register high
+	lo, err := m.load(addr)
+	if err != nil {
+		return false, err
+	}
+	hi, err := m.load(addr + 1)
+	if err != nil {
+		return false, err
+	}
+	m.registers[low], m.registers[high] = lo, hi
+	return false, nil
+}
+
+func execStorew(m *Machine, args []int, next int) (bool, error) {
+	low, high, addr := args[0], args[1], args[2]
+	// store the word made of registers low and high at addr, low byte
+	// first; if the high byte faults, the low byte has been stored
+	if err := m.store(addr, m.registers[low]); err != nil {
+		return false, err
+	}
+	return false, m.store(addr+1, m.registers[high])
+}
+
+func execAdd(m *Machine, args []int, next int) (bool, error) {
+	reg1, reg2 := args[0], args[1]
+	// add register values, store in reg1
+	m.registers[reg1] = m.add(m.registers[reg1], m.registers[reg2])
+	return false, nil
+}
+
+func execSub(m *Machine, args []int, next int) (bool, error) {
+	reg1, reg2 := args[0], args[1]
+	// subtract register values, store in reg1
+	m.registers[reg1] = m.sub(m.registers[reg1], m.registers[reg2])
+	return false, nil
+}
+
+func execAdc(m *Machine, args []int, next int) (bool, error) {
+	reg1, reg2 := args[0], args[1]
+	// add register values and the carry, store in reg1
+	m.registers[reg1] = m.addCarry(m.registers[reg1], m.registers[reg2])
+	return false, nil
+}
+
+func execSbb(m *Machine, args []int, next int) (bool, error) {
+	reg1, reg2 := args[0], args[1]
+	// subtract register values and the borrow, store in reg1
+	m.registers[reg1] = m.subBorrow(m.registers[reg1], m.registers[reg2])
+	return false, nil
+}
+
+func execMul(m *Machine, args []int, next int) (bool, error) {
+	reg1, reg2 := args[0], args[1]
+	// multiply the register values, keeping the low byte in reg1
+	m.registers[reg1] *= m.registers[reg2]
+	return false, nil
+}
+
+func execMulw(m *Machine, args []int, next int) (bool, error) {
+	reg1, reg2 := args[0], args[1]
+	// multiply the register values, putting the low byte of the 16 bit
+	// product in reg1 and the high byte in reg2
+	product := uint16(m.registers[reg1]) * uint16(m.registers[reg2])
+	m.registers[reg1], m.registers[reg2] = byte(product), byte(product>>8)
+	return false, nil
+}
+
+func execDiv(m *Machine, args []int, next int) (bool, error) {
+	reg1, reg2 := args[0], args[1]
+	// divide reg1 by reg2, unsigned, keeping the quotient in reg1
+	if err := m.checkDivisor(reg2); err != nil {
+		return false, err
+	}
+	m.registers[reg1] /= m.registers[reg2]
+	return false, nil
+}
+
+func execMod(m *Machine, args []int, next int) (bool, error) {
+	reg1, reg2 := args[0], args[1]
+	// divide reg1 by reg2, unsigned, keeping the remainder in reg1
+	if err := m.checkDivisor(reg2); err != nil {
+		return false, err
+	}
+	m.registers[reg1] %= m.registers[reg2]
+	return false, nil
+}
+
+// Fault if the instruction at the PC is about to divide by register reg,
+// and it holds zero
+func (m *Machine) checkDivisor(reg int) error {
+	if m.registers[reg] == 0 {
+		return fmt.Errorf("%w: %s at pc %#x", ErrDivideByZero, m.lookupOp(m.memory[m.pc]).Mnemonic, m.pc)
+	}
+	return nil
+}
+
+func execAnd(m *Machine, args []int, next int) (bool, error) {
+	reg1, reg2 := args[0], args[1]
+	// bitwise and of the register values, stored in reg1
+	m.registers[reg1] &= m.registers[reg2]
+	return false, nil
+}
+
+func execOr(m *Machine, args []int, next int) (bool, error) {
+	reg1, reg2 := args[0], args[1]
+	// bitwise or of the register values, stored in reg1
+	m.registers[reg1] |= m.registers[reg2]
+	return false, nil
+}
+
+func execXor(m *Machine, args []int, next int) (bool, error) {
+	reg1, reg2 := args[0], args[1]
+	// bitwise exclusive or of the register values, stored in reg1
+	m.registers[reg1] ^= m.registers[reg2]
+	return false, nil
+}
+
+func execNot(m *Machine, args []int, next int) (bool, error) {
+	// invert every bit of the register
+	m.registers[args[0]] = ^m.registers[args[0]]
+	return false, nil
+}
+
+func execShl(m *Machine, args []int, next int) (bool, error) {
+	reg1, reg2 := args[0], args[1]
+	// shift reg1 left by the value of reg2; shifting by 8 or more
+	// clears it
+	m.registers[reg1] <<= m.registers[reg2]
+	return false, nil
+}
+
+func execShr(m *Machine, args []int, next int) (bool, error) {
+	reg1, reg2 := args[0], args[1]
+	// shift reg1 right, filling with zeros
+	m.registers[reg1] >>= m.registers[reg2]
+	return false, nil
+}
+
+func execShli(m *Machine, args []int, next int) (bool, error) {
+	reg, amount := args[0], args[1]
+	// shift reg left by an immediate amount
+	m.registers[reg] <<= uint(amount)
+	return false, nil
+}
+
+func execShri(m *Machine, args []int, next int) (bool, error) {
+	reg, amount := args[0], args[1]
+	// shift reg right by an immediate amount
+	m.registers[reg] >>= uint(amount)
+	return false, nil
+}
+
+func execAddi(m *Machine, args []int, next int) (bool, error) {
+	reg, val := args[0], args[1]
+	// add val to value stored in register
+	m.registers[reg] = m.add(m.registers[reg], byte(val))
+	return false, nil
+}
+
+func execSubi(m *Machine, args []int, next int) (bool, error) {
+	reg, val := args[0], args[1]
+	// subtract val from value stored in register
+	m.registers[reg] = m.sub(m.registers[reg], byte(val))
+	return false, nil
+}
+
+func execJump(m *Machine, args []int, next int) (bool, error) {
+	// set PC to addr specified in arg
+	m.pc = args[0]
+	return true, nil
+}
+
+// Branch by offset from next if cond holds, reporting whether it did
+func (m *Machine) branchIf(cond bool, next, offset int) (bool, error) {
+	if cond {
+		m.branch(next, offset)
+	}
+	return cond, nil
+}
+
+func execBeqz(m *Machine, args []int, next int) (bool, error) {
+	reg, offset := args[0], args[1]
+	// move PC by offset conditional on value in reg
+	return m.branchIf(m.registers[reg] == 0, next, offset)
+}
+
+func execBne(m *Machine, args []int, next int) (bool, error) {
+	reg, offset := args[0], args[1]
+	// the opposite of beqz: branch unless reg is zero
+	return m.branchIf(m.registers[reg] != 0, next, offset)
+}
+
+func execBlt(m *Machine, args []int, next int) (bool, error) {
+	reg1, reg2, offset := args[0], args[1], args[2]
+	// branch if reg1 < reg2 as unsigned bytes, leaving the flags alone;
+	// use cmp and bslt to compare signed bytes
+	return m.branchIf(m.registers[reg1] < m.registers[reg2], next, offset)
+}
+
+func execBge(m *Machine, args []int, next int) (bool, error) {
+	reg1, reg2, offset := args[0], args[1], args[2]
+	// branch if reg1 >= reg2 as unsigned bytes
+	return m.branchIf(m.registers[reg1] >= m.registers[reg2], next, offset)
+}
+
+func execLoadImm(m *Machine, args []int, next int) (bool, error) {
+	reg, val := args[0], args[1]
+	// load the immediate val directly into register reg
+	m.registers[reg] = byte(val)
+	return false, nil
+}
+
+func execMov(m *Machine, args []int, next int) (bool, error) {
+	dst, src := args[0], args[1]
+	// copy the value of register src into register dst
+	m.registers[dst] = m.registers[src]
+	return false, nil
+}
+
+func execSwap(m *Machine, args []int, next int) (bool, error) {
+	reg1, reg2 := args[0], args[1]
+	// exchange the values of the two registers
+	m.registers[reg1], m.registers[reg2] = m.registers[reg2], m.registers[reg1]
+	return false, nil
+}
+
+func execPush(m *Machine, args []int, next int) (bool, error) {
+	// copy the register to a new slot on top of the stack
+	return false, m.push(m.registers[args[0]])
+}
+
+func execPop(m *Machine, args []int, next int) (bool, error) {
+	// move the value on top of the stack into the register
+	val, err := m.pop()
+	if err != nil {
+		return false, err
+	}
+	m.registers[args[0]] = val
+	return false, nil
+}
+
+func execCall(m *Machine, args []int, next int) (bool, error) {
+	// push the address of the next instruction and jump to addr
+	if err := m.pushAddr(next); err != nil {
+		return false, err
+	}
+	m.pc = args[0]
+	return true, nil
+}
+
+func execRet(m *Machine, args []int, next int) (bool, error) {
+	// pop the return address pushed by call and jump to it
+	addr, err := m.popAddr()
+	if err != nil {
+		return false, err
+	}
+	m.pc = addr
+	return true, nil
+}
+
+func execIret(m *Machine, args []int, next int) (bool, error) {
+	// return from an interrupt handler, restoring the PC and then the
+	// flags, and enabling interrupts again
+	addr, err := m.popAddr()
+	if err != nil {
+		return false, err
+	}
+	flags, err := m.pop()
+	if err != nil {
+		return false, err
+	}
+	m.pc, m.flags = addr, flags
+	m.masked = false
+	return true, nil
+}
+
+func execCli(m *Machine, args []int, next int) (bool, error) {
+	// disable interrupts; any that are raised stay pending
+	m.masked = true
+	return false, nil
+}
+
+func execSti(m *Machine, args []int, next int) (bool, error) {
+	// enable interrupts, from after the next instruction
+	m.masked = false
+	return false, nil
+}
+
+func execCmp(m *Machine, args []int, next int) (bool, error) {
+	a, b := m.registers[args[0]], m.registers[args[1]]
+	// set the flags from a - b, discarding the result
+	m.sub(a, b)
+	return false, nil
+}
+
+func execBz(m *Machine, args []int, next int) (bool, error) {
+	return m.branchIf(m.flags&FlagZero != 0, next, args[0])
+}
+
+func execBnz(m *Machine, args []int, next int) (bool, error) {
+	return m.branchIf(m.flags&FlagZero == 0, next, args[0])
+}
+
+func execBn(m *Machine, args []int, next int) (bool, error) {
+	return m.branchIf(m.flags&FlagNegative != 0, next, args[0])
+}
+
+func execBv(m *Machine, args []int, next int) (bool, error) {
+	return m.branchIf(m.flags&FlagOverflow != 0, next, args[0])
+}
+
+func execBslt(m *Machine, args []int, next int) (bool, error) {
+	// branch if cmp found reg1 < reg2 as signed bytes
+	return m.branchIf(m.signedLess(), next, args[0])
+}
+
+func execBsge(m *Machine, args []int, next int) (bool, error) {
+	return m.branchIf(!m.signedLess(), next, args[0])
+}
+
+func execBc(m *Machine, args []int, next int) (bool, error) {
+	return m.branchIf(m.flags&FlagCarry != 0, next, args[0])
+}
+
+func execLoadCode(m *Machine, args []int, next int) (bool, error) {
+	reg, addr := args[0], args[1]
+	// load a byte of the program itself into register reg
+	if addr < m.dataSize {
+		return false, fmt.Errorf("%w: loadcode from data address %#x at pc %#x", ErrOutOfBounds, addr, m.pc)
+	}
+	val, err := m.load(addr)
+	if err != nil {
+		return false, err
+	}
+	m.registers[reg] = val
+	return false, nil
+}
+
+func execCycles(m *Machine, args []int, next int) (bool, error) {
+	// read the low byte of the number of instructions executed before
+	// this one
+	m.registers[args[0]] = byte(m.cycles)
+	return false, nil
+}
+
+func execAssert(m *Machine, args []int, next int) (bool, error) {
+	// halt if the register holds a true (nonzero) value, and fault
+	// otherwise
+	if m.registers[args[0]] == 0 {
+		return false, &AssertionError{PC: m.pc, Reg: args[0]}
+	}
+	m.halted = true
+	return true, nil
+}
+
+func execHalt(m *Machine, args []int, next int) (bool, error) {
+	m.halted = true
+	return true, nil
+}
+
+func execExit(m *Machine, args []int, next int) (bool, error) {
+	// halt with the value of the register as the exit status, which is
+	// an error unless it is 0
+	m.halted = true
+	m.exitCode = m.registers[args[0]]
+	if m.exitCode != 0 {
+		return true, &ExitError{PC: m.pc, Code: m.exitCode}
+	}
+	return true, nil
+}
@@ -0,0 +1,438 @@
+// Package gdbstub lets GDB drive a machine over its remote serial
+// protocol, as it would a program under gdbserver:
+//
+//	(gdb) target remote :1234
+//
+// The stub supports reading and writing registers and memory, stepping,
+// continuing, and breakpoints, and describes the registers to GDB with
+// a target description, in this order:
+//
+//	0-6  r1 to r7, a byte each
+//	7    flags
+//	8    sp, two bytes, little-endian
+//	9    pc, two bytes, little-endian
+//
+// Memory is read and written over the machine's bus, so GDB sees the
+// devices attached there as the program does, and a patched instruction
+// takes effect however the machine dispatches them. Reading memory
+// peeks at the devices, with vm.Machine.Peek, so that inspecting them
+// doesn't change what the program goes on to see.
+//
+// GDB doesn't know the instruction set, so it can't disassemble, and
+// only the registers r1 to r7 can be written.
+package gdbstub
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"vm"
+)
+
+// The registers, as target.xml describes them
+const (
+	flagsReg = vm.MaxRegister
+	spReg    = vm.MaxRegister + 1
+	pcReg    = vm.MaxRegister + 2
+	numRegs  = vm.MaxRegister + 3
+)
+
+var targetXML = func() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.bradfield.vm">
+`)
+	for i := 1; i <= vm.MaxRegister; i++ {
+		fmt.Fprintf(&b, "    <reg name=\"r%d\" bitsize=\"8\" type=\"uint8\"/>\n", i)
+	}
+	b.WriteString(`    <reg name="flags" bitsize="8" type="uint8"/>
+    <reg name="sp" bitsize="16" type="data_ptr"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+  </feature>
+</target>
+`)
+	return b.String()
+}()
+
+// Signals reported when the machine stops
+const (
+	sigint  = 0x02
+	sigill  = 0x04
+	sigtrap = 0x05
+	sigfpe  = 0x08
+	sigsegv = 0x0b
+	sigxcpu = 0x18
+)
+
+// A Stub serves one GDB session for a machine.
+type Stub struct {
+	machine     *vm.Machine
+	memory      []byte
+	breakpoints map[int]bool
+	in          chan byte
+	out         io.Writer
+	noAck       bool
+	last        string // the last packet sent, in case GDB asks again
+	exited      string // the reply to resume with once the program ends
+}
+
+// New returns a stub for m, which runs in memory.
+func New(m *vm.Machine, memory []byte) *Stub {
+	return &Stub{machine: m, memory: memory, breakpoints: map[int]bool{}}
+}
+
+// errDetached ends a session that GDB closed deliberately
+var errDetached = errors.New("detached")
+
+// Serve handles packets from conn until GDB detaches or kills the
+// program, or the connection is closed.
+func (s *Stub) Serve(conn io.ReadWriter) error {
+	s.out = conn
+	s.in = make(chan byte, 4096)
+	go func() {
+		defer close(s.in)
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			for _, b := range buf[:n] {
+				s.in <- b
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		packet, err := s.readPacket()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reply, err := s.handle(packet)
+		if err == errDetached {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.send(reply); err != nil {
+			return err
+		}
+	}
+}
+
+// Read the next packet, acknowledging it, and returning its data
+func (s *Stub) readPacket() (string, error) {
+	for {
+		b, ok := <-s.in
+		if !ok {
+			return "", io.EOF
+		}
+		switch b {
+		case '-':
+			if err := s.write("$" + s.last + "#" + checksum(s.last)); err != nil {
+				return "", err
+			}
+			continue
+		case '$':
+		default:
+			// acks, and interrupts while the machine is already stopped
+			continue
+		}
+
+		var data []byte
+		for b = range s.in {
+			if b == '#' {
+				break
+			}
+			data = append(data, b)
+		}
+		sum := make([]byte, 0, 2)
+		for len(sum) < 2 {
+			b, ok := <-s.in
+			if !ok {
+				return "", io.EOF
+			}
+			sum = append(sum, b)
+		}
+		if string(sum) != checksum(string(data)) {
+			if err := s.write("-"); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if !s.noAck {
+			if err := s.write("+"); err != nil {
+				return "", err
+			}
+		}
+		return string(unescape(data)), nil
+	}
+}
+
+func (s *Stub) send(data string) error {
+	s.last = data
+	return s.write("$" + data + "#" + checksum(data))
+}
+
+func (s *Stub) write(text string) error {
+	_, err := io.WriteString(s.out, text)
+	return err
+}
+
+func checksum(data string) string {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return fmt.Sprintf("%02x", sum)
+}
+
+// Undo the escaping of '#', '$', '}' and '*' in binary data
+func unescape(data []byte) []byte {
+	if bytes.IndexByte(data, '}') < 0 {
+		return data
+	}
+	var out []byte
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			out = append(out, data[i]^0x20)
+			continue
+		}
+		out = append(out, data[i])
+	}
+	return out
+}
+
+// Handle a packet, returning the reply. An empty reply tells GDB the
+// packet isn't supported.
+func (s *Stub) handle(packet string) (string, error) {
+	if packet == "" {
+		return "", nil
+	}
+	args := packet[1:]
+	switch packet[0] {
+	case '?':
+		return s.stopReply(sigtrap), nil
+	case 'g':
+		var b strings.Builder
+		for n := 0; n < numRegs; n++ {
+			b.WriteString(s.register(n))
+		}
+		return b.String(), nil
+	case 'p':
+		n, err := strconv.ParseUint(args, 16, 8)
+		if err != nil || n >= numRegs {
+			return "E01", nil
+		}
+		return s.register(int(n)), nil
+	case 'P':
+		parts := strings.SplitN(args, "=", 2)
+		if len(parts) != 2 {
+			return "E01", nil
+		}
+		n, err := strconv.ParseUint(parts[0], 16, 8)
+		val, valErr := strconv.ParseUint(parts[1], 16, 8)
+		if err != nil || valErr != nil || n >= vm.MaxRegister {
+			return "E01", nil
+		}
+		s.machine.SetRegister(int(n)+1, byte(val))
+		return "OK", nil
+	case 'm':
+		addr, n, ok := s.memoryRange(args)
+		if !ok {
+			return "E01", nil
+		}
+		data := make([]byte, n)
+		for i := range data {
+			b, err := s.machine.Peek(addr + i)
+			if err != nil {
+				return "E01", nil
+			}
+			data[i] = b
+		}
+		return fmt.Sprintf("%x", data), nil
+	case 'M':
+		parts := strings.SplitN(args, ":", 2)
+		addr, n, ok := s.memoryRange(parts[0])
+		if !ok || len(parts) != 2 || len(parts[1]) != 2*n {
+			return "E01", nil
+		}
+		data, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return "E01", nil
+		}
+		for i, b := range data {
+			if err := s.machine.Poke(addr+i, b); err != nil {
+				return "E01", nil
+			}
+		}
+		return "OK", nil
+	case 'c', 's':
+		if args != "" {
+			// resuming elsewhere would need a way to set the PC
+			return "E01", nil
+		}
+		if packet[0] == 's' {
+			return s.resume(1)
+		}
+		return s.resume(-1)
+	case 'Z', 'z':
+		// software and hardware breakpoints are the same thing here
+		parts := strings.Split(args, ",")
+		if len(parts) < 2 || parts[0] != "0" && parts[0] != "1" {
+			return "", nil
+		}
+		addr, err := strconv.ParseUint(parts[1], 16, 16)
+		if err != nil {
+			return "E01", nil
+		}
+		if packet[0] == 'Z' {
+			s.breakpoints[int(addr)] = true
+		} else {
+			delete(s.breakpoints, int(addr))
+		}
+		return "OK", nil
+	case 'H':
+		return "OK", nil
+	case 'D':
+		s.send("OK")
+		return "", errDetached
+	case 'k':
+		return "", errDetached
+	case 'q', 'Q':
+		return s.query(packet), nil
+	}
+	return "", nil
+}
+
+// Answer a general query or set packet
+func (s *Stub) query(packet string) string {
+	switch {
+	case strings.HasPrefix(packet, "qSupported"):
+		return "PacketSize=1000;qXfer:features:read+;QStartNoAckMode+"
+	case packet == "QStartNoAckMode":
+		s.noAck = true
+		return "OK"
+	case packet == "qAttached":
+		return "1"
+	case packet == "qC":
+		return "QC1"
+	case packet == "qfThreadInfo":
+		return "m1"
+	case packet == "qsThreadInfo":
+		return "l"
+	case strings.HasPrefix(packet, "qXfer:features:read:target.xml:"):
+		var offset, length int
+		if _, err := fmt.Sscanf(packet[len("qXfer:features:read:target.xml:"):], "%x,%x", &offset, &length); err != nil {
+			return "E01"
+		}
+		if offset >= len(targetXML) {
+			return "l"
+		}
+		if offset+length >= len(targetXML) {
+			return "l" + targetXML[offset:]
+		}
+		return "m" + targetXML[offset:offset+length]
+	}
+	return ""
+}
+
+// The hex encoding of register n, in target byte order
+func (s *Stub) register(n int) string {
+	switch n {
+	case flagsReg:
+		return fmt.Sprintf("%02x", s.machine.Flags())
+	case spReg:
+		sp := s.machine.SP()
+		return fmt.Sprintf("%02x%02x", byte(sp), byte(sp>>8))
+	case pcReg:
+		pc := s.machine.PC()
+		return fmt.Sprintf("%02x%02x", byte(pc), byte(pc>>8))
+	}
+	return fmt.Sprintf("%02x", s.machine.Register(n+1))
+}
+
+// Parse "addr,length" for a memory packet, checking it lies in memory
+func (s *Stub) memoryRange(arg string) (int, int, bool) {
+	parts := strings.Split(arg, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	addr, err := strconv.ParseUint(parts[0], 16, 32)
+	n, nErr := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil || nErr != nil || addr+n > uint64(len(s.memory)) {
+		return 0, 0, false
+	}
+	return int(addr), int(n), true
+}
+
+// Execute n instructions, or until the machine stops if n is negative,
+// returning the stop reply. Running stops early at a breakpoint, or if
+// GDB sends an interrupt.
+func (s *Stub) resume(n int) (string, error) {
+	for i := 0; n < 0 || i < n; i++ {
+		if s.exited != "" {
+			return s.exited, nil
+		}
+		select {
+		case b, ok := <-s.in:
+			if !ok {
+				return "", io.EOF
+			}
+			if b == 0x03 {
+				return s.stopReply(sigint), nil
+			}
+		default:
+		}
+
+		err := s.machine.Step()
+		var exit *vm.ExitError
+		switch {
+		case errors.As(err, &exit):
+			s.exited = fmt.Sprintf("W%02x", exit.Code)
+		case err != nil:
+			return s.stopReply(signal(err)), nil
+		case s.machine.Halted():
+			s.exited = "W00"
+		case s.breakpoints[s.machine.PC()]:
+			return s.stopReply(sigtrap), nil
+		}
+	}
+	if s.exited != "" {
+		return s.exited, nil
+	}
+	return s.stopReply(sigtrap), nil
+}
+
+// A stop reply for sig, with the PC so GDB needn't ask for it
+func (s *Stub) stopReply(sig int) string {
+	if s.exited != "" {
+		return s.exited
+	}
+	return fmt.Sprintf("T%02x%02x:%s;", sig, pcReg, s.register(pcReg))
+}
+
+// The signal a Unix process would get for the fault err
+func signal(err error) int {
+	switch {
+	case errors.Is(err, vm.ErrIllegalOpcode), errors.Is(err, vm.ErrBadRegister):
+		return sigill
+	case errors.Is(err, vm.ErrDivideByZero):
+		return sigfpe
+	case errors.Is(err, vm.ErrCycleLimit):
+		return sigxcpu
+	}
+	return sigsegv
+}
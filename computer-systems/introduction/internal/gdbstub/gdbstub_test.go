@@ -0,0 +1,189 @@
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"vm"
+	"vm/asm"
+)
+
+// A client speaking the protocol as GDB does, acknowledging each reply
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *client) send(data string) {
+	if _, err := fmt.Fprintf(c.conn, "$%s#%s", data, checksum(data)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// Send a packet and return the reply
+func (c *client) call(data string) string {
+	c.t.Helper()
+	c.send(data)
+	if ack, err := c.r.ReadByte(); err != nil || ack != '+' {
+		c.t.Fatalf("%s: expected an ack, got %q (%v)", data, ack, err)
+	}
+	return c.reply()
+}
+
+func (c *client) reply() string {
+	c.t.Helper()
+	if _, err := c.r.ReadString('$'); err != nil {
+		c.t.Fatal(err)
+	}
+	packet, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	packet = strings.TrimSuffix(packet, "#")
+	sum := make([]byte, 2)
+	if _, err := c.r.Read(sum); err != nil || string(sum) != checksum(packet) {
+		c.t.Fatalf("Bad checksum %q for %q", sum, packet)
+	}
+	fmt.Fprint(c.conn, "+")
+	return packet
+}
+
+func startStub(t *testing.T, src string, opts ...vm.Option) (*client, []byte, chan error) {
+	memory, err := asm.Assemble(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, conn := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- New(vm.NewMachine(memory, opts...), memory).Serve(server)
+		server.Close()
+	}()
+	t.Cleanup(func() { conn.Close() })
+	return &client{t, conn, bufio.NewReader(conn)}, memory, done
+}
+
+func TestStub(t *testing.T) {
+	c, memory, done := startStub(t, `
+        load r1, 1
+loop:   beqz r1, done
+        add r2, r1
+        subi r1, 1
+        jump loop
+done:   store r2, 0
+        halt`)
+
+	for _, step := range []struct{ send, expected string }{
+		{"qSupported:multiprocess+", "PacketSize=1000;qXfer:features:read+;QStartNoAckMode+"},
+		{"?", "T0509:0800;"},
+		{"M1,1:03", "OK"},
+		{"m0,3", "000300"},
+		{"m100,1", "E01"},
+		{"s", "T0509:0b00;"},
+		// r1 to r7, flags, sp and pc
+		{"g", "03000000000000" + "00" + "0001" + "0b00"},
+		{"p9", "0b00"},
+		// count down from 5 instead
+		{"P0=05", "OK"},
+		{"p0", "05"},
+		{"P8=00", "E01"},
+		{"Z0,e,1", "OK"},
+		{"c", "T0509:0e00;"},
+		{"c", "T0509:0e00;"},
+		{"z0,e,1", "OK"},
+		{"Z2,0,1", ""},
+		{"c", "W00"},
+		{"m0,1", "0f"},
+	} {
+		if got := c.call(step.send); got != step.expected {
+			t.Fatalf("%s: expected %q, got %q", step.send, step.expected, got)
+		}
+	}
+	if memory[0] != 15 {
+		t.Fatalf("Expected the program to store 5 + 4 + 3 + 2 + 1, got %d", memory[0])
+	}
+
+	c.call("D")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTargetDescription(t *testing.T) {
+	c, _, _ := startStub(t, "halt")
+	var xml strings.Builder
+	for {
+		reply := c.call(fmt.Sprintf("qXfer:features:read:target.xml:%x,40", xml.Len()))
+		xml.WriteString(reply[1:])
+		if reply[0] == 'l' {
+			break
+		}
+		if reply[0] != 'm' {
+			t.Fatalf("Unexpected reply %q", reply)
+		}
+	}
+	if xml.String() != targetXML || !strings.Contains(targetXML, `<reg name="r7" bitsize="8"`) {
+		t.Fatalf("Unexpected target description\n%s", xml.String())
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	c, _, _ := startStub(t, "top: jump top")
+	c.send("c")
+	if ack, err := c.r.ReadByte(); err != nil || ack != '+' {
+		t.Fatalf("Expected an ack, got %q (%v)", ack, err)
+	}
+	fmt.Fprint(c.conn, "\x03")
+	if got := c.reply(); got != "T0209:0800;" {
+		t.Fatalf("Expected to stop with SIGINT, got %q", got)
+	}
+}
+
+func TestFault(t *testing.T) {
+	c, _, _ := startStub(t, "li r1, 0\nli r2, 1\ndiv r2, r1\nhalt")
+	if got := c.call("c"); got != "T0809:0e00;" {
+		t.Fatalf("Expected to stop with SIGFPE, got %q", got)
+	}
+}
+
+func TestMemoryDevices(t *testing.T) {
+	// patch the immediate of the li at 8 once it has been decoded, and
+	// read and write the ROM at 0x40
+	c, _, _ := startStub(t, "top: li r1, 5\njump top", vm.WithDispatch(vm.PredecodedDispatch),
+		vm.WithDevice(0x40, 0x44, vm.ROM{1, 2, 3, 4}))
+	for _, step := range []struct{ send, expected string }{
+		{"s", "T0509:0b00;"},
+		{"s", "T0509:0800;"},
+		{"Ma,1:09", "OK"},
+		{"m8,3", "090109"},
+		{"s", "T0509:0b00;"},
+		{"p0", "09"},
+		{"m40,4", "01020304"},
+		{"M40,1:ff", "E01"},
+		{"m40,1", "01"},
+	} {
+		if got := c.call(step.send); got != step.expected {
+			t.Fatalf("%s: expected %q, got %q", step.send, step.expected, got)
+		}
+	}
+}
+
+func TestMemoryPeek(t *testing.T) {
+	// reading the keyboard leaves the key for the program
+	c, _, _ := startStub(t, "load r1, 5\nload r2, 6\nhalt", vm.WithKeyboard(strings.NewReader("k")))
+	for _, step := range []struct{ send, expected string }{
+		{"s", "T0509:0b00;"},
+		{"m5,2", "016b"},
+		{"m5,2", "016b"},
+		{"c", "W00"},
+		{"p1", "6b"},
+	} {
+		if got := c.call(step.send); got != step.expected {
+			t.Fatalf("%s: expected %q, got %q", step.send, step.expected, got)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Package program loads programs for the commands that run them.
+package program
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"vm"
+	"vm/asm"
+)
+
+// Load reads the program at path, along with the options its image
+// header calls for, if it has one. The program is either assembly
+// source, if its name ends in .s or .asm, an image in Intel HEX format,
+// if it ends in .hex, or a memory image saved by vm.SaveImage.
+//
+// The options include the program's entry point, from .entry in
+// assembly source or from an image's header, and its debug info: for
+// assembly source, that of the source itself, and for an image,
+// whatever DebugPath names, if the file exists.
+func Load(path string) ([]byte, []vm.Option, error) {
+	switch filepath.Ext(path) {
+	case ".s", ".asm":
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		p, err := asm.Parse(string(src))
+		if err != nil {
+			return nil, nil, err
+		}
+		memory, err := asm.Emit(p)
+		return memory, []vm.Option{vm.WithEntryPoint(p.EntryPoint()), vm.WithDebugInfo(p.DebugInfo(path))}, err
+	}
+
+	var memory []byte
+	var opts []vm.Option
+	if filepath.Ext(path) == ".hex" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+		if memory, err = vm.ReadHex(f, vm.MemorySize); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		var header *vm.ImageHeader
+		var err error
+		if memory, header, err = vm.LoadImage(path); err != nil {
+			return nil, nil, err
+		}
+		if header != nil {
+			opts = header.Options()
+		}
+	}
+
+	debug, err := vm.LoadDebugInfo(DebugPath(path))
+	if err == nil {
+		opts = append(opts, vm.WithDebugInfo(debug))
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	return memory, opts, nil
+}
+
+// DebugPath returns where the debug info for the image at path is kept:
+// the same path, with its extension replaced by .dbg.
+func DebugPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".dbg"
+}
+
+// SetInputs parses each of args as a byte and writes them into memory
+// starting at address 1, where the exercise programs read their inputs.
+func SetInputs(memory []byte, args []string) error {
+	for i, arg := range args {
+		n, err := strconv.ParseUint(arg, 0, 8)
+		if err != nil || 1+i >= vm.DefaultDataSize {
+			return fmt.Errorf("invalid input %q", arg)
+		}
+		memory[1+i] = byte(n)
+	}
+	return nil
+}
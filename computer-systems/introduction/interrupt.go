@@ -0,0 +1,119 @@
+package vm
+
+import "fmt"
+
+// The number of interrupt request lines. A lower numbered line takes
+// priority when several are raised at once.
+const NumIRQs = 4
+
+// The IRQ line raised by the timer.
+const TimerIRQ = 0
+
+// WithVectorTable places the interrupt vector table at addr. Entry n
+// holds the address of the handler for IRQ n: a byte each, or two bytes
+// little-endian with wide addresses. The table may be in the data
+// region, for a program to install its handlers as it runs, or in the
+// instruction region as fixed vectors ahead of the code.
+func WithVectorTable(addr int) Option {
+	return func(m *Machine) {
+		m.vectors, m.hasVectors = addr, true
+	}
+}
+
+// WithTimer raises TimerIRQ every period instructions. This needs a
+// vector table, from WithVectorTable, to find the handler.
+func WithTimer(period uint64) Option {
+	return func(m *Machine) {
+		m.timer = period
+	}
+}
+
+// Raise raises IRQ line irq, which interrupts the program once
+// interrupts are enabled. This is how devices outside the machine
+// signal it; a line raised again before its handler runs is only
+// handled once.
+func (m *Machine) Raise(irq int) {
+	if irq < 0 || irq >= NumIRQs {
+		panic(fmt.Sprintf("no IRQ line %d", irq))
+	}
+	m.pending |= 1 << irq
+}
+
+// Tick the devices after the instruction just executed. The harts of a
+// cluster share theirs, which tick with hart 0.
+func (m *Machine) tick() {
+	if m.cluster == nil {
+		m.bus.tick(m)
+		return
+	}
+	if m.hart != 0 {
+		return
+	}
+	m.cluster.devices.Lock()
+	m.ticking = true
+	m.bus.tick(m)
+	m.ticking = false
+	m.cluster.devices.Unlock()
+}
+
+// The timer, on the bus without any addresses, as it has no registers
+type timerDevice struct{ period uint64 }
+
+func (d timerDevice) Load(offset int) (byte, error)    { return 0, nil }
+func (d timerDevice) Store(offset int, val byte) error { return nil }
+func (d timerDevice) Peek(offset int) byte             { return 0 }
+
+// Raise the timer line if it is due after the instruction just executed
+func (d timerDevice) Tick(m *Machine) {
+	if m.cycles%d.period == 0 {
+		m.Raise(TimerIRQ)
+	}
+}
+
+// Take the highest priority pending interrupt, if interrupts are
+// enabled.
+//
+// An interrupt disables further interrupts, pushes the flags and then
+// the PC, as call pushes its return address, and jumps to the handler
+// in the vector table. The handler returns to the interrupted program
+// with iret, which restores both and enables interrupts again. It must
+// save and restore any registers it uses.
+func (m *Machine) dispatch() error {
+	if m.masked || m.halted || m.pending == 0 {
+		return nil
+	}
+	irq := 0
+	for m.pending&(1<<irq) == 0 {
+		irq++
+	}
+	if !m.hasVectors {
+		return fmt.Errorf("%w: IRQ %d raised without a vector table", ErrInvalidConfig, irq)
+	}
+	if m.sp-1-m.addrSize() < m.stackTop-m.stackSize {
+		return fmt.Errorf("%w: IRQ %d at pc %#x", ErrStackOverflow, irq, m.pc)
+	}
+
+	entry := m.vectors + irq*m.addrSize()
+	handler := int(m.memory[entry])
+	if m.wide {
+		handler |= int(m.memory[entry+1]) << 8
+	}
+	if err := m.push(m.flags); err != nil {
+		return m.fault(err)
+	}
+	if err := m.pushAddr(m.pc); err != nil {
+		return m.fault(err)
+	}
+	m.pending &^= 1 << irq
+	m.pc = handler
+	m.masked = true
+	return nil
+}
+
+// The size of an address in memory
+func (m *Machine) addrSize() int {
+	if m.wide {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,130 @@
+package vm
+
+import (
+	"errors"
+	"testing"
+)
+
+// Loop until the timer handler has run three times, counting the
+// iterations in r1. The handler counts its runs in r7 and clobbers the
+// flags, which the loop relies on across the interrupt. With a short
+// enough period, interrupts keep coming after the loop ends.
+const timerProgram = `
+sti
+li r2 3
+addi r1 1
+cmp r7 r2
+bc 248
+store r1 0
+halt
+li r6 0
+subi r6 1
+addi r7 1
+iret`
+
+func TestTimer(t *testing.T) {
+	for _, test := range []struct {
+		period     uint64
+		interrupts byte
+		loops      byte
+	}{
+		// every period-th instruction is followed by an interrupt;
+		// the handler's own instructions count towards the next one
+		{1, 8, 2},
+		{10, 3, 8},
+		{20, 3, 18},
+	} {
+		memory := make([]byte, 256)
+		copy(memory[8:], assemble(timerProgram))
+		memory[3] = 0x18
+		m := NewMachine(memory, WithTimer(test.period), WithVectorTable(3), WithCycleLimit(1000))
+		if err := m.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if m.Register(7) != test.interrupts || memory[0] != test.loops {
+			t.Errorf("Every %d instructions: expected %d interrupts in %d loops, got %d in %d", test.period, test.interrupts, test.loops, m.Register(7), memory[0])
+		}
+		if m.SP() != len(memory) {
+			t.Errorf("Every %d instructions: expected an empty stack, sp is %#x", test.period, m.SP())
+		}
+	}
+}
+
+func TestInterruptMasking(t *testing.T) {
+	// Interrupts start disabled, and stay pending until sti and one
+	// more instruction have executed. The handler at 0x20 stores the
+	// value of r1 when it ran.
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+li r1 1
+cli
+li r1 2
+sti
+li r1 3
+li r1 4
+halt`))
+	copy(memory[0x20:], assemble("store r1 0\niret"))
+	memory[4] = 0x20
+	m := NewMachine(memory, WithVectorTable(3))
+	m.Raise(1)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if memory[0] != 3 {
+		t.Errorf("Expected the interrupt after li r1 3 ran, got r1 = %d", memory[0])
+	}
+}
+
+func TestInterruptPriority(t *testing.T) {
+	// IRQ 3's handler is at 0x30 and IRQ 1's at 0x40
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble("sti\nli r1 0\nli r1 0\nli r1 0\nhalt"))
+	copy(memory[0x30:], assemble("li r1 3\nstore r1 0\niret"))
+	copy(memory[0x40:], assemble("li r1 1\nstore r1 1\niret"))
+	memory[0x10+1], memory[0x10+3] = 0x40, 0x30
+	m := NewMachine(memory, WithVectorTable(0x10))
+	m.Raise(3)
+	m.Raise(1)
+	m.Raise(1)
+	var order []int
+	for !m.Halted() {
+		if err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if pc := m.PC(); pc == 0x30 || pc == 0x40 {
+			order = append(order, pc)
+		}
+	}
+	if len(order) != 2 || order[0] != 0x40 || order[1] != 0x30 {
+		t.Errorf("Expected IRQ 1 and then IRQ 3 to be handled once each, got handlers % x", order)
+	}
+}
+
+func TestInterruptErrors(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble("sti\njump 9"))
+	err := NewMachine(memory, WithTimer(1)).Run()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected an interrupt without a vector table to fail, got %v", err)
+	}
+
+	err = NewMachine(memory, WithVectorTable(254)).Run()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected a vector table past the end of memory to be rejected, got %v", err)
+	}
+
+	// the flags and PC need two bytes of stack
+	err = NewMachine(memory, WithTimer(1), WithVectorTable(3), WithStackSize(1)).Run()
+	if !errors.Is(err, ErrStackOverflow) {
+		t.Errorf("Expected interrupts to overflow the stack, got %v", err)
+	}
+
+	// a stack the memory map doesn't let the program write
+	mm := MemoryMap{{0, 8, PermRead | PermWrite}, {8, 256, PermRead | PermExecute}}
+	m := NewMachine(memory, WithTimer(1), WithVectorTable(3), WithMemoryMap(mm))
+	err = m.Run()
+	var fault *Fault
+	if !errors.Is(err, ErrProtection) || !errors.As(err, &fault) || m.PC() != 9 {
+		t.Errorf("Expected a Fault pushing onto a read-only stack, without entering the handler, got %v at pc %#x", err, m.PC())
+	}
+}
@@ -0,0 +1,178 @@
+package vm
+
+import "strings"
+
+// An OperandKind describes how an instruction operand is interpreted.
+type OperandKind int
+
+const (
+	RegOperand    OperandKind = iota // a register number
+	AddrOperand                      // an absolute memory address
+	ImmOperand                       // an immediate value
+	OffsetOperand                    // a signed byte offset from the next instruction
+)
+
+// A Flow describes where execution goes after an instruction.
+type Flow int
+
+const (
+	FlowNext   Flow = iota // always continue with the next instruction
+	FlowBranch             // either the next instruction or the branch target
+	FlowJump               // always the jump target
+	FlowHalt               // execution stops
+	FlowCall               // the call target, returning to the next instruction
+	FlowReturn             // the instruction after the most recent call
+)
+
+// OpInfo describes the encoding of a single opcode.
+type OpInfo struct {
+	Code     byte
+	Mnemonic string
+	Operands []OperandKind
+	Flow     Flow
+}
+
+// Width is the encoded size of the instruction in bytes, including the
+// opcode itself.
+func (o OpInfo) Width() int {
+	return 1 + len(o.Operands)
+}
+
+// WideWidth returns the size of an instruction, in bytes, on a machine
+// with wide addresses, where address operands take two bytes.
+func (o OpInfo) WideWidth() int {
+	width := o.Width()
+	for _, kind := range o.Operands {
+		if kind == AddrOperand {
+			width++
+		}
+	}
+	return width
+}
+
+var ops = []OpInfo{
+	{Load, "load", []OperandKind{RegOperand, AddrOperand}, FlowNext},
+	{Store, "store", []OperandKind{RegOperand, AddrOperand}, FlowNext},
+	{LoadR, "loadr", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{StoreR, "storer", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{Loadw, "loadw", []OperandKind{RegOperand, RegOperand, AddrOperand}, FlowNext},
+	{Storew, "storew", []OperandKind{RegOperand, RegOperand, AddrOperand}, FlowNext},
+	{Add, "add", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{Sub, "sub", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{Addi, "addi", []OperandKind{RegOperand, ImmOperand}, FlowNext},
+	{Subi, "subi", []OperandKind{RegOperand, ImmOperand}, FlowNext},
+	{Jump, "jump", []OperandKind{AddrOperand}, FlowJump},
+	{Beqz, "beqz", []OperandKind{RegOperand, OffsetOperand}, FlowBranch},
+	{Bne, "bne", []OperandKind{RegOperand, OffsetOperand}, FlowBranch},
+	{Blt, "blt", []OperandKind{RegOperand, RegOperand, OffsetOperand}, FlowBranch},
+	{Bge, "bge", []OperandKind{RegOperand, RegOperand, OffsetOperand}, FlowBranch},
+	{LoadImm, "li", []OperandKind{RegOperand, ImmOperand}, FlowNext},
+	{Mov, "mov", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{Cmp, "cmp", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{Bz, "bz", []OperandKind{OffsetOperand}, FlowBranch},
+	{Bnz, "bnz", []OperandKind{OffsetOperand}, FlowBranch},
+	{Bc, "bc", []OperandKind{OffsetOperand}, FlowBranch},
+	{Bn, "bn", []OperandKind{OffsetOperand}, FlowBranch},
+	{Bv, "bv", []OperandKind{OffsetOperand}, FlowBranch},
+	{Bslt, "bslt", []OperandKind{OffsetOperand}, FlowBranch},
+	{Bsge, "bsge", []OperandKind{OffsetOperand}, FlowBranch},
+	{Cycles, "cycles", []OperandKind{RegOperand}, FlowNext},
+	{Assert, "assert", []OperandKind{RegOperand}, FlowHalt},
+	{LoadCode, "loadcode", []OperandKind{RegOperand, AddrOperand}, FlowNext},
+	{Swap, "swap", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{Push, "push", []OperandKind{RegOperand}, FlowNext},
+	{Pop, "pop", []OperandKind{RegOperand}, FlowNext},
+	{Call, "call", []OperandKind{AddrOperand}, FlowCall},
+	{Ret, "ret", nil, FlowReturn},
+	{Iret, "iret", nil, FlowReturn},
+	{Cli, "cli", nil, FlowNext},
+	{Sti, "sti", nil, FlowNext},
+	{And, "and", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{Or, "or", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{Xor, "xor", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{Not, "not", []OperandKind{RegOperand}, FlowNext},
+	{Shl, "shl", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{Shr, "shr", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{Shli, "shli", []OperandKind{RegOperand, ImmOperand}, FlowNext},
+	{Shri, "shri", []OperandKind{RegOperand, ImmOperand}, FlowNext},
+	{Mul, "mul", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{Mulw, "mulw", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{Div, "div", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{Mod, "mod", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{Halt, "halt", nil, FlowHalt},
+	{Exit, "exit", []OperandKind{RegOperand}, FlowHalt},
+	{Sys, "sys", []OperandKind{ImmOperand}, FlowNext},
+	{Cas, "cas", []OperandKind{RegOperand, RegOperand, AddrOperand}, FlowNext},
+	{Xadd, "xadd", []OperandKind{RegOperand, AddrOperand}, FlowNext},
+	{Hartid, "hartid", []OperandKind{RegOperand}, FlowNext},
+	{Adc, "adc", []OperandKind{RegOperand, RegOperand}, FlowNext},
+	{Sbb, "sbb", []OperandKind{RegOperand, RegOperand}, FlowNext},
+}
+
+// ReadsFlags reports whether the instruction depends on the flags left
+// by the one before it: the flag branches, and adc and sbb, which take
+// in the carry.
+func (o OpInfo) ReadsFlags() bool {
+	switch o.Code {
+	case Bz, Bnz, Bc, Bn, Bv, Bslt, Bsge, Adc, Sbb:
+		return true
+	}
+	return false
+}
+
+// Target returns the address that an instruction at pc, with the given
+// operands, jumps or branches to on a machine with byte addresses. It
+// reports false for instructions that don't transfer control. With
+// wide addresses the operands are encoded differently, so analyze the
+// program with AnalyzeReachability's options instead.
+func (o OpInfo) Target(pc int, args []byte) (int, bool) {
+	for i, kind := range o.Operands {
+		switch {
+		case o.Flow == FlowBranch && kind == OffsetOperand:
+			// offsets are relative to the next instruction, and the PC
+			// wraps around like any other byte
+			return int(byte(pc + o.Width() + int(args[i]))), true
+		case (o.Flow == FlowJump || o.Flow == FlowCall) && kind == AddrOperand:
+			return int(args[i]), true
+		}
+	}
+	return 0, false
+}
+
+// Indexed by opcode, for decoding instructions without a search
+var (
+	opTable    [256]*OpInfo
+	wideWidths [256]int
+)
+
+func init() {
+	for i := range ops {
+		opTable[ops[i].Code] = &ops[i]
+		wideWidths[ops[i].Code] = ops[i].WideWidth()
+	}
+}
+
+// Ops returns the metadata for every opcode in the instruction set.
+func Ops() []OpInfo {
+	return append([]OpInfo(nil), ops...)
+}
+
+// LookupOp returns the metadata for the given opcode.
+func LookupOp(code byte) (OpInfo, bool) {
+	if op := opTable[code]; op != nil {
+		return *op, true
+	}
+	return OpInfo{}, false
+}
+
+// LookupMnemonic returns the metadata for the opcode with the given
+// mnemonic, ignoring case.
+func LookupMnemonic(mnemonic string) (OpInfo, bool) {
+	mnemonic = strings.ToLower(mnemonic)
+	for _, op := range ops {
+		if op.Mnemonic == mnemonic {
+			return op, true
+		}
+	}
+	return OpInfo{}, false
+}
@@ -0,0 +1,81 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Permission is a set of the ways memory may be accessed.
+type Permission uint8
+
+const (
+	PermRead    Permission = 1 << iota // loaded from, or popped off the stack
+	PermWrite                          // stored to, or pushed onto the stack
+	PermExecute                        // fetched as an instruction
+)
+
+func (p Permission) String() string {
+	var b strings.Builder
+	for i, c := range "rwx" {
+		if p&(1<<i) != 0 {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	return b.String()
+}
+
+// A Region grants permissions on the addresses Start up to but not
+// including End.
+type Region struct {
+	Start, End int
+	Perm       Permission
+}
+
+// A MemoryMap describes how the program may access each address, as the
+// union of the permissions of the regions containing it. An address in
+// no region can't be accessed at all.
+type MemoryMap []Region
+
+// WithMemoryMap enforces mm on every access the program makes, in place
+// of the default rule that programs may only store into the data
+// region. Each violation faults with ErrProtection.
+//
+// The map applies to the program's accesses, including the devices it
+// loads from and stores to, but not to the machine reading the vector
+// table. A map may make the instruction region writable, for
+// self-modifying code.
+func WithMemoryMap(mm MemoryMap) Option {
+	return func(m *Machine) {
+		m.memoryMap = mm
+	}
+}
+
+// The permissions granted on addr
+func (mm MemoryMap) perm(addr int) Permission {
+	var p Permission
+	for _, r := range mm {
+		if addr >= r.Start && addr < r.End {
+			p |= r.Perm
+		}
+	}
+	return p
+}
+
+// Check the memory map, if there is one, allows accessing addr as want
+func (m *Machine) checkAccess(addr int, want Permission) error {
+	if m.memoryMap == nil || m.memoryMap.perm(addr)&want == want {
+		return nil
+	}
+	return fmt.Errorf("%w: %s access to %#x, which is %s, at pc %#x", ErrProtection, want, addr, m.memoryMap.perm(addr), m.pc)
+}
+
+func (mm MemoryMap) validate(size int) error {
+	for _, r := range mm {
+		if r.Start < 0 || r.End > size || r.Start > r.End {
+			return fmt.Errorf("%w: region [%#x, %#x) for %d bytes of memory", ErrInvalidConfig, r.Start, r.End, size)
+		}
+	}
+	return nil
+}
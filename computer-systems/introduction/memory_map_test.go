@@ -0,0 +1,69 @@
+package vm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMemoryMap(t *testing.T) {
+	// Inputs at 1 and 2 are read-only, the output at 0 write-only, and
+	// the rest of the data region off limits
+	mm := MemoryMap{
+		{0, 1, PermWrite},
+		{1, 3, PermRead},
+		{8, 0xf0, PermRead | PermExecute},
+		{0xf0, 0x100, PermRead | PermWrite},
+	}
+	tests := []struct {
+		name    string
+		program string
+		err     error
+	}{
+		{"Allowed", "load r1 1\nload r2 2\nadd r1 r2\npush r1\npop r1\nstore r1 0\nhalt", nil},
+		{"ReadOnly", "li r1 1\nstore r1 1\nhalt", ErrProtection},
+		{"WriteOnly", "load r1 0\nhalt", ErrProtection},
+		{"NoAccess", "load r1 5\nhalt", ErrProtection},
+		{"ReadCode", "load r1 8\nhalt", nil},
+		{"ExecuteData", "jump 1", ErrProtection},
+		{"ExecuteStack", "jump 240", ErrProtection},
+		// the operand of the jump at 0xef is outside the code
+		{"Straddle", "jump 239", ErrProtection},
+	}
+	for _, test := range tests {
+		memory := make([]byte, 256)
+		copy(memory[8:], assemble(test.program))
+		memory[1], memory[2] = Halt, Halt
+		memory[0xef], memory[0xf0] = Jump, 8
+		err := NewMachine(memory, WithMemoryMap(mm)).Run()
+		if test.err == nil && err != nil || !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestMemoryMapSelfModifying(t *testing.T) {
+	// Overwrite the operand of an addi before running it
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+li r1 5
+store r1 16
+addi r1 1
+store r1 0
+halt`))
+	mm := MemoryMap{{0, 0x100, PermRead | PermWrite | PermExecute}}
+	if err := NewMachine(memory, WithMemoryMap(mm)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if memory[0] != 10 {
+		t.Errorf("Expected 5 + 5, got %d", memory[0])
+	}
+}
+
+func TestMemoryMapErrors(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble("halt"))
+	err := NewMachine(memory, WithMemoryMap(MemoryMap{{0, 0x101, PermExecute}})).Run()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected a region past the end of memory to be rejected, got %v", err)
+	}
+}
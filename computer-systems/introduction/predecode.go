@@ -0,0 +1,66 @@
+package vm
+
+// An instruction decoded for PredecodedDispatch, ready to run again, on
+// any of the harts of a cluster, which share their decoded instructions
+// as they share memory
+type decodedInstruction struct {
+	op   *OpInfo
+	next int
+	h    handler
+	args []int
+}
+
+// The widest instruction, in bytes, in either encoding
+var maxWidth int
+
+func init() {
+	for _, op := range ops {
+		if w := op.WideWidth(); w > maxWidth {
+			maxWidth = w
+		}
+	}
+}
+
+// Return the instruction at the PC, decoding it if it hasn't run since
+// it was last changed. Instructions that fail to decode aren't cached,
+// so they fail the same way each time.
+func (m *Machine) predecoded() (*decodedInstruction, error) {
+	if m.decoded == nil {
+		m.decoded = make([]*decodedInstruction, len(m.memory))
+	}
+	if m.pc < len(m.decoded) {
+		unlock := m.lockDecoded(false)
+		d := m.decoded[m.pc]
+		unlock()
+		if d != nil {
+			return d, nil
+		}
+	}
+	op, args, err := m.decode()
+	if err != nil {
+		return nil, err
+	}
+	// decode reuses its operand slice, so the instruction needs a copy
+	args = append([]int(nil), args...)
+	h, next := m.handler(op), m.next(op)
+	d := &decodedInstruction{op: op, next: next, h: h, args: args}
+	unlock := m.lockDecoded(true)
+	m.decoded[m.pc] = d
+	unlock()
+	return d, nil
+}
+
+// Forget any decoded instruction that includes the byte at addr, which
+// has just been written
+func (m *Machine) invalidate(addr int) {
+	if m.decoded == nil {
+		return
+	}
+	unlock := m.lockDecoded(true)
+	defer unlock()
+	for start := addr - maxWidth + 1; start <= addr; start++ {
+		if start >= 0 {
+			m.decoded[start] = nil
+		}
+	}
+}
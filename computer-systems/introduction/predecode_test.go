@@ -0,0 +1,58 @@
+package vm
+
+import "testing"
+
+// Add 1 to r2, then patch the addi at 0x0b to add 10 instead, looping
+// five times in all: a stale decoded addi would leave r2 at 5
+const selfModifyingAsm = `
+li r1 5
+addi r2 1
+li r3 10
+store r3 13
+subi r1 1
+bne r1 241
+store r2 0
+halt`
+
+func selfModifyingMachine(d Dispatch) (*Machine, []byte) {
+	memory := make([]byte, MemorySize)
+	copy(memory[DefaultDataSize:], assemble(selfModifyingAsm))
+	m := NewMachine(memory, WithDispatch(d), WithMemoryMap(MemoryMap{
+		{0, MemorySize, PermRead | PermWrite | PermExecute},
+	}))
+	return m, memory
+}
+
+func TestSelfModifyingCode(t *testing.T) {
+	for _, d := range dispatches {
+		m, memory := selfModifyingMachine(d)
+		if err := m.Run(); err != nil {
+			t.Fatalf("%s dispatch: %v", d, err)
+		}
+		if memory[0] != 41 {
+			t.Errorf("%s dispatch: expected 41, got %d", d, memory[0])
+		}
+	}
+}
+
+// Restoring a snapshot puts back the original code, which must be
+// decoded again
+func TestPredecodedRestore(t *testing.T) {
+	m, memory := selfModifyingMachine(PredecodedDispatch)
+	snap, err := m.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Restore(snap); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if memory[0] != 41 {
+		t.Errorf("Expected 41 after restoring, got %d", memory[0])
+	}
+}
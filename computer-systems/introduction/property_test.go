@@ -0,0 +1,100 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"testing/quick"
+)
+
+// Check that property holds for random arguments, on every dispatch
+// strategy
+func checkProperty(t *testing.T, property func(d Dispatch) interface{}) {
+	for _, d := range dispatches {
+		t.Run(d.String(), func(t *testing.T) {
+			if err := quick.Check(property(d), nil); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// Run the program, which has no data, and return the machine it ran on
+func runProperty(d Dispatch, asm string, opts ...Option) (*Machine, error) {
+	m := NewMachine(program(asm), append(opts, WithDispatch(d))...)
+	return m, m.Run()
+}
+
+func TestAddCommutes(t *testing.T) {
+	checkProperty(t, func(d Dispatch) interface{} {
+		return func(a, b byte) bool {
+			add := func(x, y byte) (byte, byte) {
+				m, err := runProperty(d, fmt.Sprintf("li r1 %d\nli r2 %d\nadd r1 r2\nhalt", x, y))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return m.R1(), m.Flags()
+			}
+			sum, flags := add(a, b)
+			swappedSum, swappedFlags := add(b, a)
+			return sum == a+b && sum == swappedSum && flags == swappedFlags
+		}
+	})
+}
+
+func TestAddiSubiIdentity(t *testing.T) {
+	checkProperty(t, func(d Dispatch) interface{} {
+		return func(a, v byte) bool {
+			m, err := runProperty(d, fmt.Sprintf("li r1 %d\naddi r1 %d\nsubi r1 %d\nhalt", a, v, v))
+			return err == nil && m.R1() == a
+		}
+	})
+}
+
+func TestJumpToSelf(t *testing.T) {
+	checkProperty(t, func(d Dispatch) interface{} {
+		return func(limit uint16) bool {
+			n := uint64(limit) + 1
+			m, err := runProperty(d, "jump 8", WithCycleLimit(n))
+			return errors.Is(err, ErrCycleLimit) && m.Cycles() == n && m.PC() == DefaultDataSize
+		}
+	})
+}
+
+func TestStoreLoadRoundTrip(t *testing.T) {
+	checkProperty(t, func(d Dispatch) interface{} {
+		return func(v, addr byte) bool {
+			addr %= DefaultDataSize
+			m, err := runProperty(d, fmt.Sprintf("li r1 %d\nstore r1 %d\nload r2 %d\nli r3 %d\nli r4 0\nstorer r2 r3\nloadr r4 r3\nhalt", v, addr, addr, addr))
+			return err == nil && m.R2() == v && m.Register(4) == v && m.Data()[addr] == v
+		}
+	})
+}
+
+// The symbolic engine executes instructions separately from the
+// machine, so check the two agree
+func TestExploreAgrees(t *testing.T) {
+	src := "load r1 0\nload r2 1\nadd r1 r2\nmov r3 r1\nsub r3 r2\nmul r3 r3\nxor r3 r1\nstore r3 2\nhalt"
+	paths, err := Explore(program(src), []int{0, 1}, 100)
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("Expected a single path, got %d (%v)", len(paths), err)
+	}
+	p := paths[0]
+	checkProperty(t, func(d Dispatch) interface{} {
+		return func(a, b byte) bool {
+			memory := program(src)
+			memory[0], memory[1] = a, b
+			m := NewMachine(memory, WithDispatch(d))
+			if err := m.Run(); err != nil {
+				t.Fatal(err)
+			}
+			inputs := map[int]byte{0: a, 1: b}
+			for n := 1; n <= 3; n++ {
+				if p.Register(n).Eval(inputs) != m.Register(n) {
+					return false
+				}
+			}
+			return p.Memory(2).Eval(inputs) == memory[2] && p.Flags().Eval(inputs) == m.Flags()
+		}
+	})
+}
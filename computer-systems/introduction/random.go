@@ -0,0 +1,47 @@
+package vm
+
+// The data address of the random number generator. With one attached,
+// each load from RandomAddr reads the next pseudo-random byte.
+const RandomAddr = 0x04
+
+// WithRandom attaches a pseudo-random number generator at RandomAddr,
+// seeded with seed. The sequence is fixed for a seed, on every platform
+// and Go version, so runs can be reproduced exactly. The data region
+// must include RandomAddr.
+func WithRandom(seed uint64) Option {
+	return func(m *Machine) {
+		m.random, m.hasRandom = seed, true
+	}
+}
+
+// Generate the next random byte, with the SplitMix64 algorithm
+func (m *Machine) nextRandom() byte {
+	m.random += 0x9e3779b97f4a7c15
+	return mixRandom(m.random)
+}
+
+// The byte the generator gives for its state
+func mixRandom(z uint64) byte {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return byte(z >> 56)
+}
+
+// The generator on the bus, at RandomAddr. Stores go to the memory
+// under it.
+type randomDevice struct{ m *Machine }
+
+func (d randomDevice) Load(offset int) (byte, error) {
+	return d.m.nextRandom(), nil
+}
+
+// Peek at the byte the next load will give, without moving on
+func (d randomDevice) Peek(offset int) byte {
+	return mixRandom(d.m.random + 0x9e3779b97f4a7c15)
+}
+
+func (d randomDevice) Store(offset int, val byte) error {
+	d.m.memory[RandomAddr] = val
+	return nil
+}
@@ -0,0 +1,62 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// Roll three random bytes into the start of the data region
+const rollAsm = `
+load r1 4
+store r1 0
+load r1 4
+store r1 1
+load r1 4
+store r1 2
+halt`
+
+func roll(t *testing.T, seed uint64) []byte {
+	memory := make([]byte, MemorySize)
+	copy(memory[DefaultDataSize:], assemble(rollAsm))
+	if err := NewMachine(memory, WithRandom(seed)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if memory[RandomAddr] != 0 {
+		t.Errorf("Expected reads from the generator to leave memory alone, got %#x", memory[RandomAddr])
+	}
+	return memory[:3]
+}
+
+func TestRandom(t *testing.T) {
+	a, b, c := roll(t, 1), roll(t, 1), roll(t, 2)
+	if !bytes.Equal(a, b) {
+		t.Errorf("Expected the same seed to give the same bytes, got % x and % x", a, b)
+	}
+	if bytes.Equal(a, c) {
+		t.Errorf("Expected different seeds to give different bytes, both gave % x", a)
+	}
+	if a[0] == a[1] && a[1] == a[2] {
+		t.Errorf("Expected successive reads to differ, got % x", a)
+	}
+}
+
+func TestRandomSpread(t *testing.T) {
+	m := NewMachine(make([]byte, MemorySize), WithRandom(0))
+	seen := map[byte]bool{}
+	for i := 0; i < 1000; i++ {
+		seen[m.nextRandom()] = true
+	}
+	if len(seen) < 200 {
+		t.Errorf("Expected 1000 random bytes to take most values, got only %d", len(seen))
+	}
+}
+
+func TestRandomOutsideData(t *testing.T) {
+	memory := make([]byte, MemorySize)
+	memory[4] = Halt
+	err := NewMachine(memory, WithDataSize(4), WithRandom(0)).Run()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package vm
+
+// AnalyzeReachability returns the addresses of instructions that can
+// never execute when the program in memory starts at the entry point
+// of the machine that opts configure, by default DefaultDataSize. Every
+// path is explored by following fall-through, jump and branch targets,
+// regardless of whether a branch can actually be taken, and the
+// operands are decoded as that machine decodes them, so that with
+// WithWideAddresses the targets are 16 bits.
+//
+// The only indirect jump is ret, which returns to the instruction after
+// a call, and that is always explored along with the call target. So
+// this is exact with respect to control flow; a program that jumps
+// into the middle of an instruction is analyzed along the bytes it
+// actually executes. The exception is interrupt handlers, which are
+// entered without a jump and so are reported as unreachable.
+func AnalyzeReachability(memory []byte, opts ...Option) []int {
+	m := NewMachine(memory, opts...)
+	reachable := map[int]bool{}
+	work := []int{m.entry}
+	for len(work) > 0 {
+		m.pc = work[len(work)-1]
+		work = work[:len(work)-1]
+		if reachable[m.pc] || m.pc >= len(memory) {
+			continue
+		}
+		reachable[m.pc] = true
+
+		op, args, err := m.decode()
+		if err != nil {
+			// execution faults here
+			continue
+		}
+		next := m.next(op)
+		if op.Flow == FlowNext || op.Flow == FlowBranch || op.Flow == FlowCall {
+			work = append(work, next)
+		}
+		if target, ok := m.target(op, args, next); ok {
+			work = append(work, target)
+		}
+	}
+
+	// Sweep the instruction region in order, as the disassembler does,
+	// to find the instructions that were never visited
+	end := len(memory)
+	for end > m.dataSize && memory[end-1] == 0 {
+		end--
+	}
+	var unreachable []int
+	for pc := m.dataSize; pc < end; {
+		width := 1
+		if op := m.lookupOp(memory[pc]); op != nil {
+			width = m.width(op)
+		}
+		if !reachable[pc] {
+			unreachable = append(unreachable, pc)
+		}
+		pc += width
+	}
+	return unreachable
+}
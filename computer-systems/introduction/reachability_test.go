@@ -0,0 +1,78 @@
+package vm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeReachability(t *testing.T) {
+	tests := []struct {
+		name        string
+		asm         string
+		unreachable []int
+	}{
+		{
+			name: "AllReachable",
+			asm: `
+load r1 1
+beqz r1 3
+addi r1 1
+store r1 0
+halt`,
+			unreachable: nil,
+		},
+		{
+			// the addi and store are skipped by the jump, and nothing
+			// branches to the li after the halt
+			name: "DeadCode",
+			asm: `
+load r1 1
+jump 19
+addi r1 1
+store r1 0
+halt
+li r1 3
+halt`,
+			unreachable: []int{13, 16, 20, 23},
+		},
+		{
+			// both the branch and the jump skip the first halt
+			name: "BranchTarget",
+			asm: `
+load r1 1
+beqz r1 3
+jump 17
+halt
+halt`,
+			unreachable: []int{16},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			memory := make([]byte, 256)
+			copy(memory[8:], assemble(test.asm))
+			unreachable := AnalyzeReachability(memory)
+			if !reflect.DeepEqual(unreachable, test.unreachable) {
+				t.Fatalf("Expected unreachable %v, got %v", test.unreachable, unreachable)
+			}
+		})
+	}
+}
+
+func TestAnalyzeWideReachability(t *testing.T) {
+	// the jump at 0xfc and the branch after it go past the first 256
+	// bytes, skipping the halt and li in between
+	memory := make([]byte, 512)
+	copy(memory[0xfc:], []byte{
+		Jump, 0x03, 0x01,
+		Halt,
+		LoadImm, 1, 0,
+		Beqz, 1, 1,
+		Halt,
+		Halt,
+	})
+	unreachable := AnalyzeReachability(memory, WithWideAddresses(), WithDataSize(0xfc))
+	if expected := []int{0xff, 0x100}; !reflect.DeepEqual(unreachable, expected) {
+		t.Fatalf("Expected unreachable %#x, got %#x", expected, unreachable)
+	}
+}
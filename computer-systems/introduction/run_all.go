@@ -0,0 +1,61 @@
+package vm
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// RunAll runs program once for each input, as RunWithInput does with
+// opts, returning the final memory of every run. The runs are spread across
+// one goroutine per CPU.
+//
+// If any run fails, RunAll returns the error of the first failing input
+// along with all the results.
+func RunAll(program []byte, inputs [][]byte, opts ...Option) ([][]byte, error) {
+	results := make([][]byte, len(inputs))
+	errs := make([]error, len(inputs))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = RunWithInput(program, inputs[i], opts...)
+			}
+		}()
+	}
+	for i := range inputs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return results, fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	return results, nil
+}
+
+// RunWithInput runs a copy of program with input in its data region,
+// on the machine that opts configure, returning the final memory. The
+// rest of the data region is zeroed, so the result doesn't depend on
+// whatever the program image left there, and program itself is never
+// modified.
+func RunWithInput(program []byte, input []byte, opts ...Option) ([]byte, error) {
+	memory := append([]byte(nil), program...)
+	m := NewMachine(memory, opts...)
+	if len(input) > m.dataSize {
+		return nil, fmt.Errorf("input of %d bytes does not fit in the %d byte data region", len(input), m.dataSize)
+	}
+	for i := 0; i < m.dataSize && i < len(memory); i++ {
+		memory[i] = 0
+	}
+	copy(memory, input)
+	err := m.Run()
+	return memory, err
+}
@@ -0,0 +1,88 @@
+package vm
+
+import "testing"
+
+func TestRunAll(t *testing.T) {
+	program := make([]byte, 256)
+	copy(program[8:], assemble(`
+load r1 1
+addi r1 1
+store r1 0
+halt`))
+
+	inputs := make([][]byte, 1000)
+	for i := range inputs {
+		inputs[i] = []byte{0, byte(i)}
+	}
+
+	results, err := RunAll(program, inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, memory := range results {
+		if memory[0] != byte(i)+1 || memory[1] != byte(i) {
+			t.Fatalf("Input %d: expected %d, got %d", i, byte(i)+1, memory[0])
+		}
+	}
+	if program[0] != 0 || program[1] != 0 {
+		t.Fatalf("RunAll modified the original program")
+	}
+}
+
+func TestRunAllError(t *testing.T) {
+	program := make([]byte, 256)
+	copy(program[8:], assemble("halt"))
+
+	inputs := [][]byte{{1}, make([]byte, 9), {2}}
+	if _, err := RunAll(program, inputs); err == nil {
+		t.Fatalf("Expected oversized input to fail")
+	}
+}
+
+func TestRunWithInput(t *testing.T) {
+	program := make([]byte, 256)
+	copy(program[8:], assemble(`
+load r1 1
+load r2 2
+add r1 r2
+store r1 0
+halt`))
+	program[3] = 99 // leftover state that must not leak into the run
+
+	memory, err := RunWithInput(program, []byte{0, 40, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if memory[0] != 42 {
+		t.Fatalf("Expected 40 + 2 = 42, got %d", memory[0])
+	}
+	if memory[3] != 0 {
+		t.Fatalf("Expected the data region beyond the input to be zeroed, got %d", memory[3])
+	}
+	if program[0] != 0 || program[3] != 99 {
+		t.Fatalf("RunWithInput modified the original program")
+	}
+
+	if _, err := RunWithInput(program, make([]byte, 9)); err == nil {
+		t.Fatalf("Expected oversized input to fail")
+	}
+}
+
+func TestRunWithInputConfig(t *testing.T) {
+	// input fills the 16 byte data region of a 128 byte machine
+	config := MachineConfig{MemSize: 128, DataEnd: 16}
+	program := make([]byte, 128)
+	copy(program[16:], assemble("load r1 12\nstore r1 15\nhalt"))
+	input := make([]byte, 16)
+	input[12] = 42
+	results, err := RunAll(program, [][]byte{input}, WithConfig(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0][15] != 42 {
+		t.Fatalf("Expected the input at 12 copied to 15, got %d", results[0][15])
+	}
+	if _, err := RunWithInput(program, make([]byte, 17), WithConfig(config)); err == nil {
+		t.Fatalf("Expected an input past the configured data region to fail")
+	}
+}
@@ -0,0 +1,79 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// The size of the character display attached by WithScreen.
+const (
+	ScreenWidth  = 16
+	ScreenHeight = 4
+	ScreenSize   = ScreenWidth * ScreenHeight
+)
+
+// WithScreen maps a character display onto the ScreenSize bytes of
+// memory starting at addr, one byte per character, a row at a time. If
+// w isn't nil, the machine redraws the screen to it with RenderScreen
+// after every byte the program stores there. The data region must
+// include the whole screen.
+func WithScreen(addr int, w io.Writer) Option {
+	return func(m *Machine) {
+		m.screen, m.hasScreen, m.screenOut = addr, true, w
+	}
+}
+
+// Screen returns the memory of the display attached by WithScreen, or
+// nil if there isn't one.
+func (m *Machine) Screen() []byte {
+	if !m.hasScreen || m.screen < 0 || m.screen+ScreenSize > len(m.memory) {
+		return nil
+	}
+	return m.memory[m.screen : m.screen+ScreenSize]
+}
+
+// RenderScreen draws the contents of a screen to a terminal: it clears
+// it with ANSI escapes, then draws the characters in a box. Bytes that
+// aren't printable ASCII, including the zeros a screen starts as, are
+// drawn as spaces.
+func RenderScreen(w io.Writer, screen []byte) error {
+	bw := bufio.NewWriter(w)
+	border := "+"
+	for i := 0; i < ScreenWidth; i++ {
+		border += "-"
+	}
+	border += "+\n"
+
+	bw.WriteString("\x1b[H\x1b[2J")
+	bw.WriteString(border)
+	for row := 0; row < ScreenHeight; row++ {
+		bw.WriteByte('|')
+		for col := 0; col < ScreenWidth; col++ {
+			c := byte(' ')
+			if i := row*ScreenWidth + col; i < len(screen) && screen[i] >= 0x20 && screen[i] < 0x7f {
+				c = screen[i]
+			}
+			bw.WriteByte(c)
+		}
+		bw.WriteString("|\n")
+	}
+	bw.WriteString(border)
+	return bw.Flush()
+}
+
+// The screen on the bus, when it is drawn, so that it can be redrawn
+// after each store. Its characters are kept in the memory under it.
+type screenDevice struct{ m *Machine }
+
+func (d screenDevice) Load(offset int) (byte, error) {
+	return d.m.memory[d.m.screen+offset], nil
+}
+
+func (d screenDevice) Store(offset int, val byte) error {
+	d.m.memory[d.m.screen+offset] = val
+	if err := RenderScreen(d.m.screenOut, d.m.Screen()); err != nil {
+		return fmt.Errorf("%w: screen write: %v", ErrDevice, err)
+	}
+	return nil
+}
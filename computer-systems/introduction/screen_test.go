@@ -0,0 +1,73 @@
+package vm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRenderScreen(t *testing.T) {
+	screen := make([]byte, ScreenSize)
+	copy(screen, "Hello, world!")
+	copy(screen[ScreenWidth*3:], "\x01tab\there")
+	var out strings.Builder
+	if err := RenderScreen(&out, screen); err != nil {
+		t.Fatal(err)
+	}
+	expected := "\x1b[H\x1b[2J" +
+		"+----------------+\n" +
+		"|Hello, world!   |\n" +
+		"|                |\n" +
+		"|                |\n" +
+		"| tab here       |\n" +
+		"+----------------+\n"
+	if out.String() != expected {
+		t.Errorf("Expected\n%q, got\n%q", expected, out.String())
+	}
+}
+
+// Write "HI" to the top left of a screen just after the default data
+// region, which is enlarged to hold it
+const screenAsm = `
+li r1 72
+store r1 8
+li r1 73
+store r1 9
+halt`
+
+func TestScreen(t *testing.T) {
+	dataSize := DefaultDataSize + ScreenSize
+	memory := make([]byte, MemorySize)
+	copy(memory[dataSize:], assemble(screenAsm))
+
+	var out strings.Builder
+	m := NewMachine(memory, WithDataSize(dataSize), WithScreen(DefaultDataSize, &out))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(m.Screen()[:3]); got != "HI\x00" {
+		t.Errorf("Expected the screen to start with \"HI\", got %q", got)
+	}
+
+	var last strings.Builder
+	RenderScreen(&last, m.Screen())
+	frames := strings.Split(out.String(), "\x1b[H")
+	if len(frames) != 3 || "\x1b[H"+frames[2] != last.String() {
+		t.Errorf("Expected a frame for each store, the last showing the final screen, got %q", out.String())
+	}
+}
+
+func TestScreenOutsideData(t *testing.T) {
+	memory := make([]byte, MemorySize)
+	memory[DefaultDataSize] = Halt
+	err := NewMachine(memory, WithScreen(0, nil)).Run()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestNoScreen(t *testing.T) {
+	if screen := NewMachine(make([]byte, MemorySize)).Screen(); screen != nil {
+		t.Errorf("Expected no screen, got %v", screen)
+	}
+}
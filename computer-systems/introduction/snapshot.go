@@ -0,0 +1,77 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+)
+
+// The gob encoded form of a Machine's state
+type snapshot struct {
+	Memory    []byte
+	PC, SP    int
+	Registers [MaxRegister + 1]byte
+	Flags     byte
+	Cycles    uint64
+	Counters  Counters
+	Pending   byte
+	Masked    bool
+	Validated bool
+	Halted    bool
+	ExitCode  byte
+	Random    uint64
+}
+
+// Snapshot saves the state of the machine: its memory, registers, flags
+// and counters, where it is in running the program, and the state of
+// the random number generator. The options it was created with are not
+// saved, and neither is the state of any other devices.
+func (m *Machine) Snapshot() ([]byte, error) {
+	s := snapshot{
+		Memory:    m.memory,
+		PC:        m.pc,
+		SP:        m.sp,
+		Registers: m.registers,
+		Flags:     m.flags,
+		Cycles:    m.cycles,
+		Counters:  m.counters,
+		Pending:   m.pending,
+		Masked:    m.masked,
+		Validated: m.validated,
+		Halted:    m.halted,
+		ExitCode:  m.exitCode,
+		Random:    m.random,
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Restore returns the machine to the state saved by Snapshot, which
+// should be from a machine created with the same options. The saved
+// memory is copied into the machine's own, which must be the same size.
+func (m *Machine) Restore(data []byte) error {
+	var s snapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return fmt.Errorf("decoding snapshot: %v", err)
+	}
+	if len(s.Memory) != len(m.memory) {
+		return fmt.Errorf("%w: snapshot of %d bytes of memory, expected %d", ErrImageSize, len(s.Memory), len(m.memory))
+	}
+	copy(m.memory, s.Memory)
+	for i := range m.decoded {
+		m.decoded[i] = nil
+	}
+	m.pc, m.sp = s.PC, s.SP
+	m.registers = s.Registers
+	m.flags = s.Flags
+	m.cycles = s.Cycles
+	m.counters = s.Counters
+	m.pending, m.masked = s.Pending, s.Masked
+	m.validated = s.Validated
+	m.halted, m.exitCode = s.Halted, s.ExitCode
+	m.random = s.Random
+	return nil
+}
@@ -0,0 +1,107 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	// Sum 1..n, pushing each partial sum
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+load r1 1
+beqz r1 10
+add r2 r1
+push r2
+subi r1 1
+jump 11
+store r2 0
+halt`))
+	memory[1] = 5
+	m := NewMachine(memory)
+	for i := 0; i < 10; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshot, err := m.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	finished := append([]byte(nil), memory...)
+	counters := m.Counters()
+
+	// Resume from the snapshot, both on the same machine and on a new
+	// one
+	other := make([]byte, 256)
+	for _, resumed := range []struct {
+		m      *Machine
+		memory []byte
+	}{{m, memory}, {NewMachine(other), other}} {
+		if err := resumed.m.Restore(snapshot); err != nil {
+			t.Fatal(err)
+		}
+		if resumed.m.Halted() || resumed.m.Cycles() != 10 {
+			t.Fatalf("Expected to resume after 10 instructions, got %d", resumed.m.Cycles())
+		}
+		if err := resumed.m.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(resumed.memory, finished) || resumed.m.Counters() != counters {
+			t.Errorf("Expected the resumed run to finish as the original did")
+		}
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	m := NewMachine(make([]byte, 256))
+	snapshot, err := m.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wide := NewMachine(make([]byte, 1024), WithWideAddresses())
+	if err := wide.Restore(snapshot); !errors.Is(err, ErrImageSize) {
+		t.Errorf("Expected restoring a different size of memory to fail, got %v", err)
+	}
+	if err := m.Restore([]byte("not a snapshot")); err == nil {
+		t.Errorf("Expected restoring garbage to fail")
+	}
+}
+
+func TestSnapshotRandom(t *testing.T) {
+	// a machine restored part way through rollAsm rolls the same bytes
+	// as the one the snapshot came from
+	newRoller := func() (*Machine, []byte) {
+		memory := make([]byte, MemorySize)
+		copy(memory[DefaultDataSize:], assemble(rollAsm))
+		return NewMachine(memory, WithRandom(7)), memory
+	}
+	m, memory := newRoller()
+	for i := 0; i < 2; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := m.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, restoredMemory := newRoller()
+	if err := restored.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restoredMemory[:3], memory[:3]) {
+		t.Errorf("Expected the restored machine to roll % x, got % x", memory[:3], restoredMemory[:3])
+	}
+}
@@ -0,0 +1,775 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrNotSymbolic is the fault for an instruction Explore can't execute
+// on inputs it doesn't know the values of.
+var ErrNotSymbolic = errors.New("can't execute symbolically")
+
+// The most inputs that the conditions of a path may tie together.
+// Conditions are checked by trying every combination of the inputs they
+// mention, which takes a noticeable time for three.
+const maxSolverInputs = 3
+
+// A Path is one way a program can run, explored by Explore, and the
+// state it leaves the machine in, in terms of the inputs.
+type Path struct {
+	// The branches, divisions and assertions that take this path rather
+	// than another, each as a condition that is nonzero on the path
+	Conditions []*Expr
+	// Example values of the inputs that take the path
+	Inputs map[int]byte
+	PC     int
+	Steps  uint64 // the instructions executed
+	// Err is nil if the path ends with halt or exit, and otherwise the
+	// fault it ends with, which is ErrCycleLimit for a path cut off
+	// after the most steps Explore was allowed.
+	Err error
+
+	sp        int
+	registers [MaxRegister + 1]*Expr
+	flags     *Expr
+	exitCode  *Expr
+	memory    []byte        // the concrete bytes of memory
+	values    map[int]*Expr // and those that depend on the inputs
+	halted    bool
+	env       []byte // the example inputs, by address
+	forced    *bool  // the outcome of a fork, for the copy that took it
+}
+
+// Register returns the value of general purpose register n, which must
+// be between 1 and MaxRegister.
+func (p *Path) Register(n int) *Expr {
+	if n < 1 || n > MaxRegister {
+		panic(fmt.Sprintf("no register r%d", n))
+	}
+	return p.registers[n]
+}
+
+// Flags returns the flags register.
+func (p *Path) Flags() *Expr {
+	return p.flags
+}
+
+// ExitCode returns the status the path exits with, which is 0 if it
+// ends with halt.
+func (p *Path) ExitCode() *Expr {
+	return p.exitCode
+}
+
+// Memory returns the byte of memory at addr.
+func (p *Path) Memory(addr int) *Expr {
+	if v := p.values[addr]; v != nil {
+		return v
+	}
+	return Const(p.memory[addr])
+}
+
+func (p *Path) set(addr int, v *Expr) {
+	if b, ok := v.Value(); ok {
+		p.memory[addr] = b
+		delete(p.values, addr)
+		return
+	}
+	p.values[addr] = v
+}
+
+func (p *Path) clone() *Path {
+	q := *p
+	q.Conditions = p.Conditions[:len(p.Conditions):len(p.Conditions)]
+	q.memory = append([]byte(nil), p.memory...)
+	q.values = make(map[int]*Expr, len(p.values))
+	for addr, v := range p.values {
+		q.values[addr] = v
+	}
+	q.env = append([]byte(nil), p.env...)
+	return &q
+}
+
+// Explore runs the program in memory over every path it can take, for
+// the machine that opts configure, treating the bytes of the data region
+// at the addresses in inputs as unknown. Applied to the program's
+// results, it shows what the program computes for any input, and it
+// finds the inputs that make the program fault, that exhaustive testing
+// would need to stumble on.
+//
+// Each branch whose direction depends on the inputs forks the path, as
+// does a division by an input that may be zero, an assert, or an exit
+// with a status that depends on the inputs. Only paths that some inputs
+// actually take are followed; a loop with a bound that depends on the
+// inputs is explored once for each number of times it can go around.
+// Each path runs for at most maxSteps instructions, unless it is 0.
+//
+// Addresses held in registers, for loadr, storer and ret, must not
+// depend on the inputs, and devices, system calls and custom
+// instructions can't be used at all: a path ends with ErrNotSymbolic if
+// they are. Interrupts never happen. Explore fails if the conditions
+// of a path tie more than three inputs together, as they are too many
+// to search.
+func Explore(memory []byte, inputs []int, maxSteps uint64, opts ...Option) ([]*Path, error) {
+	m := NewMachine(memory, opts...)
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	start := &Path{
+		PC:       m.entry,
+		sp:       m.stackTop,
+		flags:    Const(0),
+		exitCode: Const(0),
+		memory:   append([]byte(nil), memory...),
+		values:   map[int]*Expr{},
+		env:      make([]byte, len(memory)),
+	}
+	for i := range start.registers {
+		start.registers[i] = Const(0)
+	}
+	for _, addr := range inputs {
+		if addr < 0 || addr >= m.dataSize {
+			return nil, fmt.Errorf("%w: input %#x is outside the data region", ErrInvalidConfig, addr)
+		}
+		start.values[addr] = Input(addr)
+		start.env[addr] = memory[addr]
+	}
+
+	e := &explorer{m: m, maxSteps: maxSteps, work: []*Path{start}}
+	var paths []*Path
+	for len(e.work) > 0 {
+		p := e.work[len(e.work)-1]
+		e.work = e.work[:len(e.work)-1]
+		for !p.halted && p.Err == nil {
+			if err := e.step(p); err != nil {
+				return nil, err
+			}
+		}
+		p.Inputs = map[int]byte{}
+		for _, addr := range inputs {
+			p.Inputs[addr] = p.env[addr]
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// A Counterexample is the error Prove returns for inputs that make the
+// program fault or fail the postcondition.
+type Counterexample struct {
+	Inputs map[int]byte
+	Path   *Path
+}
+
+func (c *Counterexample) Error() string {
+	reason := "postcondition fails"
+	if c.Path.Err != nil {
+		reason = c.Path.Err.Error()
+	}
+	var addrs []int
+	for addr := range c.Inputs {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+	var inputs []string
+	for _, addr := range addrs {
+		inputs = append(inputs, fmt.Sprintf("%s = %d", Input(addr), c.Inputs[addr]))
+	}
+	return fmt.Sprintf("%s for inputs %s", reason, strings.Join(inputs, ", "))
+}
+
+// Prove checks that the program in memory halts without faulting for
+// any values of its inputs, and that post is nonzero for the state it
+// ends in, exploring it as Explore does. For example, post could be
+//
+//	func(p *vm.Path) *vm.Expr {
+//		return p.Memory(2).Eq(vm.Input(0).Add(vm.Input(1)))
+//	}
+//
+// It returns a *Counterexample if it finds inputs that break either
+// part, and an error matching ErrCycleLimit if it doesn't but a path was
+// cut off, so that the proof is incomplete.
+func Prove(memory []byte, inputs []int, maxSteps uint64, post func(*Path) *Expr, opts ...Option) error {
+	paths, err := Explore(memory, inputs, maxSteps, opts...)
+	if err != nil {
+		return err
+	}
+	var cutOff error
+	for _, p := range paths {
+		if errors.Is(p.Err, ErrCycleLimit) {
+			if cutOff == nil {
+				cutOff = fmt.Errorf("proof incomplete: %w", p.Err)
+			}
+			continue
+		}
+		if p.Err != nil {
+			return &Counterexample{Inputs: p.Inputs, Path: p}
+		}
+		conds := append(p.Conditions[:len(p.Conditions):len(p.Conditions)], post(p).Eq(Const(0)))
+		env, ok, err := solve(conds, p.env)
+		if err != nil {
+			return err
+		}
+		if ok {
+			c := &Counterexample{Inputs: map[int]byte{}, Path: p}
+			for _, addr := range inputs {
+				c.Inputs[addr] = env[addr]
+			}
+			return c
+		}
+	}
+	return cutOff
+}
+
+// Find inputs for which every one of conds is nonzero, starting from
+// hint, which holds values for every input and may already be a
+// solution. Only the inputs of the conditions that fail for hint are
+// searched, along with the inputs of any condition sharing one with
+// them, and so on, so that the conditions left out still hold.
+func solve(conds []*Expr, hint []byte) ([]byte, bool, error) {
+	var group []*Expr
+	var addrs []int
+	inGroup := make([]bool, len(conds))
+	seen := map[int]bool{}
+	mentions := make([][]int, len(conds))
+	add := func(i int) {
+		inGroup[i] = true
+		group = append(group, conds[i])
+		for _, addr := range mentions[i] {
+			if !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	for i, c := range conds {
+		mentions[i] = c.inputs(map[int]bool{}, nil)
+		if c.eval(hint) == 0 {
+			add(i)
+		}
+	}
+	for changed := len(group) > 0; changed; {
+		changed = false
+		for i := range conds {
+			if inGroup[i] {
+				continue
+			}
+			for _, addr := range mentions[i] {
+				if seen[addr] {
+					add(i)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	env := append([]byte(nil), hint...)
+	if len(group) == 0 {
+		return env, true, nil
+	}
+	if len(addrs) > maxSolverInputs {
+		return nil, false, fmt.Errorf("%w: conditions tie %d inputs together, more than %d", ErrNotSymbolic, len(addrs), maxSolverInputs)
+	}
+
+	for _, addr := range addrs {
+		env[addr] = 0
+	}
+	for {
+		holds := true
+		for _, c := range group {
+			if c.eval(env) == 0 {
+				holds = false
+				break
+			}
+		}
+		if holds {
+			return env, true, nil
+		}
+		// count through the combinations, the first input fastest
+		i := 0
+		for ; i < len(addrs); i++ {
+			env[addrs[i]]++
+			if env[addrs[i]] != 0 {
+				break
+			}
+		}
+		if i == len(addrs) {
+			return nil, false, nil
+		}
+	}
+}
+
+type explorer struct {
+	m        *Machine // for the layout, and decoding
+	maxSteps uint64
+	work     []*Path // paths forked but not yet followed
+}
+
+// Decide whether cond is nonzero on p. If the inputs decide it, and both
+// outcomes are possible, p takes the one its example inputs give and a
+// copy of p, to follow later, takes the other. The copy starts again at
+// the instruction that forked, so it must fork at most once.
+func (e *explorer) fork(p *Path, cond *Expr) (bool, error) {
+	if p.forced != nil {
+		holds := *p.forced
+		p.forced = nil
+		return holds, nil
+	}
+	if v, ok := cond.Value(); ok {
+		return v != 0, nil
+	}
+	holds := cond.eval(p.env) != 0
+	this, other := cond, cond.Eq(Const(0))
+	if !holds {
+		this, other = other, this
+	}
+	conds := append(p.Conditions[:len(p.Conditions):len(p.Conditions)], other)
+	env, ok, err := solve(conds, p.env)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		q := p.clone()
+		q.Conditions, q.env = conds, env
+		taken := !holds
+		q.forced = &taken
+		e.work = append(e.work, q)
+	}
+	p.Conditions = append(p.Conditions, this)
+	return holds, nil
+}
+
+// Execute the instruction at p's PC, ending the path if it faults. The
+// error is for a problem with the search itself.
+func (e *explorer) step(p *Path) error {
+	m := e.m
+	m.memory, m.pc = p.memory, p.PC
+	if e.maxSteps > 0 && p.Steps >= e.maxSteps {
+		p.Err = fmt.Errorf("%w: %d instructions executed, stopped at pc %#x", ErrCycleLimit, p.Steps, p.PC)
+		return nil
+	}
+	err := e.execute(p)
+	var search searchError
+	if errors.As(err, &search) {
+		return search.err
+	}
+	if exit, ok := err.(*ExitError); ok {
+		p.Steps++
+		p.Err = exit
+		return nil
+	}
+	if err != nil {
+		p.Err = m.fault(err)
+		return nil
+	}
+	p.Steps++
+	return nil
+}
+
+// An error from the solver, to tell it apart from a fault
+type searchError struct{ err error }
+
+func (s searchError) Error() string { return s.err.Error() }
+
+// The fault for an instruction that uses an address that depends on
+// the inputs, or is itself changed by them
+func (e *explorer) dependent(op *OpInfo) error {
+	return fmt.Errorf("%w: %s at pc %#x depends on the inputs", ErrNotSymbolic, op.Mnemonic, e.m.pc)
+}
+
+func (e *explorer) execute(p *Path) error {
+	m := e.m
+	if p.values[p.PC] != nil {
+		return fmt.Errorf("%w: the opcode at pc %#x depends on the inputs", ErrNotSymbolic, p.PC)
+	}
+	op, args, err := m.decode()
+	if err != nil {
+		return err
+	}
+	next := m.next(op)
+	for addr := p.PC + 1; addr < next; addr++ {
+		if p.values[addr] != nil {
+			return e.dependent(op)
+		}
+	}
+	fork := func(cond *Expr) (bool, error) {
+		holds, err := e.fork(p, cond)
+		if err != nil {
+			return false, searchError{err}
+		}
+		return holds, nil
+	}
+	r := &p.registers
+	branch := func(cond *Expr) error {
+		taken, err := fork(cond)
+		if err != nil {
+			return err
+		}
+		if taken {
+			p.PC = m.branchTarget(next, args[len(args)-1])
+			return nil
+		}
+		return e.fallThrough(p, op, next)
+	}
+
+	switch op.Code {
+	case Load:
+		v, err := e.load(p, op, args[1])
+		if err != nil {
+			return err
+		}
+		r[args[0]] = v
+	case Store:
+		if err := e.store(p, op, args[1], r[args[0]]); err != nil {
+			return err
+		}
+	case LoadR, StoreR:
+		addr, ok := r[args[1]].Value()
+		if !ok {
+			return e.dependent(op)
+		}
+		if op.Code == StoreR {
+			if err := e.store(p, op, int(addr), r[args[0]]); err != nil {
+				return err
+			}
+			break
+		}
+		v, err := e.load(p, op, int(addr))
+		if err != nil {
+			return err
+		}
+		r[args[0]] = v
+	case Loadw:
+		lo, err := e.load(p, op, args[2])
+		if err != nil {
+			return err
+		}
+		hi, err := e.load(p, op, args[2]+1)
+		if err != nil {
+			return err
+		}
+		r[args[0]], r[args[1]] = lo, hi
+	case Storew:
+		if err := e.store(p, op, args[2], r[args[0]]); err != nil {
+			return err
+		}
+		if err := e.store(p, op, args[2]+1, r[args[1]]); err != nil {
+			return err
+		}
+	case Add, Addi:
+		a, b := r[args[0]], Const(byte(args[1]))
+		if op.Code == Add {
+			b = r[args[1]]
+		}
+		r[args[0]], p.flags = a.Add(b), addFlags(a, b)
+	case Adc:
+		a, b, c := r[args[0]], r[args[1]], p.flags.And(Const(FlagCarry)).Shr(Const(1))
+		r[args[0]], p.flags = a.Add(b).Add(c), carryFlags(a, b, c)
+	case Sbb:
+		a, b, c := r[args[0]], r[args[1]], p.flags.And(Const(FlagCarry)).Shr(Const(1))
+		r[args[0]], p.flags = a.Sub(b).Sub(c), borrowFlags(a, b, c)
+	case Sub, Subi, Cmp:
+		a, b := r[args[0]], Const(byte(args[1]))
+		if op.Code != Subi {
+			b = r[args[1]]
+		}
+		p.flags = subFlags(a, b)
+		if op.Code != Cmp {
+			r[args[0]] = a.Sub(b)
+		}
+	case Mul:
+		r[args[0]] = r[args[0]].Mul(r[args[1]])
+	case Mulw:
+		a, b := r[args[0]], r[args[1]]
+		r[args[0]], r[args[1]] = a.Mul(b), a.mulHi(b)
+	case Div, Mod:
+		zero, err := fork(r[args[1]].Eq(Const(0)))
+		if err != nil {
+			return err
+		}
+		if zero {
+			return fmt.Errorf("%w: %s at pc %#x", ErrDivideByZero, op.Mnemonic, p.PC)
+		}
+		if op.Code == Div {
+			r[args[0]] = r[args[0]].Div(r[args[1]])
+		} else {
+			r[args[0]] = r[args[0]].Mod(r[args[1]])
+		}
+	case And:
+		r[args[0]] = r[args[0]].And(r[args[1]])
+	case Or:
+		r[args[0]] = r[args[0]].Or(r[args[1]])
+	case Xor:
+		r[args[0]] = r[args[0]].Xor(r[args[1]])
+	case Not:
+		r[args[0]] = r[args[0]].Not()
+	case Shl:
+		r[args[0]] = r[args[0]].Shl(r[args[1]])
+	case Shr:
+		r[args[0]] = r[args[0]].Shr(r[args[1]])
+	case Shli:
+		r[args[0]] = r[args[0]].Shl(Const(byte(args[1])))
+	case Shri:
+		r[args[0]] = r[args[0]].Shr(Const(byte(args[1])))
+	case LoadImm:
+		r[args[0]] = Const(byte(args[1]))
+	case Mov:
+		r[args[0]] = r[args[1]]
+	case Swap:
+		r[args[0]], r[args[1]] = r[args[1]], r[args[0]]
+	case Jump:
+		p.PC = args[0]
+		return nil
+	case Beqz:
+		return branch(r[args[0]].Eq(Const(0)))
+	case Bne:
+		return branch(r[args[0]])
+	case Blt:
+		return branch(r[args[0]].Ult(r[args[1]]))
+	case Bge:
+		return branch(r[args[0]].Ult(r[args[1]]).Eq(Const(0)))
+	case Bz:
+		return branch(p.flags.And(Const(FlagZero)))
+	case Bnz:
+		return branch(p.flags.And(Const(FlagZero)).Eq(Const(0)))
+	case Bc:
+		return branch(p.flags.And(Const(FlagCarry)))
+	case Bn:
+		return branch(p.flags.And(Const(FlagNegative)))
+	case Bv:
+		return branch(p.flags.And(Const(FlagOverflow)))
+	case Bslt:
+		return branch(signedLess(p.flags))
+	case Bsge:
+		return branch(signedLess(p.flags).Eq(Const(0)))
+	case Push:
+		if err := e.push(p, op, r[args[0]]); err != nil {
+			return err
+		}
+	case Pop:
+		v, err := e.pop(p, op)
+		if err != nil {
+			return err
+		}
+		r[args[0]] = v
+	case Call:
+		if m.wide {
+			if err := e.push(p, op, Const(byte(next>>8))); err != nil {
+				return err
+			}
+		}
+		if err := e.push(p, op, Const(byte(next))); err != nil {
+			return err
+		}
+		p.PC = args[0]
+		return nil
+	case Ret, Iret:
+		addr, err := e.popAddr(p, op)
+		if err != nil {
+			return err
+		}
+		if op.Code == Iret {
+			if p.flags, err = e.pop(p, op); err != nil {
+				return err
+			}
+		}
+		p.PC = addr
+		return nil
+	case Cas:
+		old, err := e.load(p, op, args[2])
+		if err != nil {
+			return err
+		}
+		p.flags = subFlags(old, r[args[0]])
+		equal, err := fork(old.Eq(r[args[0]]))
+		if err != nil {
+			return err
+		}
+		if !equal {
+			r[args[0]] = old
+			break
+		}
+		if err := e.store(p, op, args[2], r[args[1]]); err != nil {
+			return err
+		}
+	case Xadd:
+		a, err := e.load(p, op, args[1])
+		if err != nil {
+			return err
+		}
+		p.flags = addFlags(a, r[args[0]])
+		if err := e.store(p, op, args[1], a.Add(r[args[0]])); err != nil {
+			return err
+		}
+		r[args[0]] = a
+	case Hartid:
+		r[args[0]] = Const(0)
+	case Cli, Sti:
+		// interrupts never happen, so masking them has no effect
+	case Cycles:
+		r[args[0]] = Const(byte(p.Steps))
+	case Assert:
+		ok, err := fork(r[args[0]])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return &AssertionError{PC: p.PC, Reg: args[0]}
+		}
+		p.halted = true
+		return nil
+	case LoadCode:
+		if args[1] < m.dataSize {
+			return fmt.Errorf("%w: loadcode from data address %#x at pc %#x", ErrOutOfBounds, args[1], p.PC)
+		}
+		v, err := e.load(p, op, args[1])
+		if err != nil {
+			return err
+		}
+		r[args[0]] = v
+	case Halt:
+		p.halted = true
+		return nil
+	case Exit:
+		zero, err := fork(r[args[0]].Eq(Const(0)))
+		if err != nil {
+			return err
+		}
+		p.halted, p.exitCode = true, r[args[0]]
+		if !zero {
+			return &ExitError{PC: p.PC, Code: r[args[0]].eval(p.env)}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %s at pc %#x", ErrNotSymbolic, op.Mnemonic, p.PC)
+	}
+	return e.fallThrough(p, op, next)
+}
+
+// Move p on to the instruction after op, as Machine.execute does
+func (e *explorer) fallThrough(p *Path, op *OpInfo, next int) error {
+	if next >= len(p.memory) {
+		return fmt.Errorf("%w: %s at pc %#x is the last instruction in memory", ErrFellOffEnd, op.Mnemonic, p.PC)
+	}
+	p.PC = next
+	return nil
+}
+
+// The flags register after adding b to a, as Machine.add sets it
+func addFlags(a, b *Expr) *Expr {
+	sum := a.Add(b)
+	return flagBits(sum, sum.Ult(a), a.Xor(sum).And(b.Xor(sum)).And(Const(0x80)).truth())
+}
+
+// The flags register after subtracting b from a, as Machine.sub sets it
+func subFlags(a, b *Expr) *Expr {
+	diff := a.Sub(b)
+	return flagBits(diff, a.Ult(b), a.Xor(b).And(a.Xor(diff)).And(Const(0x80)).truth())
+}
+
+// The flags register after adding b and the carry c to a, as
+// Machine.addCarry sets it: the carry out is from either addition
+func carryFlags(a, b, c *Expr) *Expr {
+	partial := a.Add(b)
+	sum := partial.Add(c)
+	carry := partial.Ult(a).Or(sum.Ult(partial))
+	return flagBits(sum, carry, a.Xor(sum).And(b.Xor(sum)).And(Const(0x80)).truth())
+}
+
+// The flags register after subtracting b and the borrow c from a, as
+// Machine.subBorrow sets it
+func borrowFlags(a, b, c *Expr) *Expr {
+	partial := a.Sub(b)
+	diff := partial.Sub(c)
+	borrow := a.Ult(b).Or(partial.Ult(c))
+	return flagBits(diff, borrow, a.Xor(b).And(a.Xor(diff)).And(Const(0x80)).truth())
+}
+
+// The flags register for an arithmetic result, as setFlags computes it
+func flagBits(result, carry, overflow *Expr) *Expr {
+	zero := result.Eq(Const(0))
+	negative := result.Shr(Const(7))
+	return zero.Or(carry.Shl(Const(1))).Or(overflow.Shl(Const(2))).Or(negative.Shl(Const(3)))
+}
+
+// Whether the flags show a signed a < b, as Machine.signedLess decides
+func signedLess(flags *Expr) *Expr {
+	negative := flags.And(Const(FlagNegative)).truth()
+	overflow := flags.And(Const(FlagOverflow)).truth()
+	return negative.Xor(overflow)
+}
+
+// Whether addr is one of the machine's devices, whose effects Explore
+// doesn't model
+func (e *explorer) device(addr int) bool {
+	return e.m.onDevice(addr)
+}
+
+func (e *explorer) load(p *Path, op *OpInfo, addr int) (*Expr, error) {
+	if addr >= len(p.memory) {
+		return nil, fmt.Errorf("%w: address %#x at pc %#x", ErrOutOfBounds, addr, p.PC)
+	}
+	if err := e.m.checkAccess(addr, PermRead); err != nil {
+		return nil, err
+	}
+	if e.device(addr) {
+		return nil, fmt.Errorf("%w: %s at pc %#x reads a device", ErrNotSymbolic, op.Mnemonic, p.PC)
+	}
+	return p.Memory(addr), nil
+}
+
+func (e *explorer) store(p *Path, op *OpInfo, addr int, v *Expr) error {
+	if err := e.m.checkStore(addr); err != nil {
+		return err
+	}
+	if e.device(addr) {
+		return fmt.Errorf("%w: %s at pc %#x writes a device", ErrNotSymbolic, op.Mnemonic, p.PC)
+	}
+	p.set(addr, v)
+	return nil
+}
+
+func (e *explorer) push(p *Path, op *OpInfo, v *Expr) error {
+	if p.sp <= e.m.stackTop-e.m.stackSize {
+		return fmt.Errorf("%w: %s at pc %#x", ErrStackOverflow, op.Mnemonic, p.PC)
+	}
+	if err := e.m.checkAccess(p.sp-1, PermWrite); err != nil {
+		return err
+	}
+	p.sp--
+	p.set(p.sp, v)
+	return nil
+}
+
+func (e *explorer) pop(p *Path, op *OpInfo) (*Expr, error) {
+	if p.sp >= e.m.stackTop {
+		return nil, fmt.Errorf("%w: %s at pc %#x", ErrStackUnderflow, op.Mnemonic, p.PC)
+	}
+	if err := e.m.checkAccess(p.sp, PermRead); err != nil {
+		return nil, err
+	}
+	v := p.Memory(p.sp)
+	p.sp++
+	return v, nil
+}
+
+// Pop a return address, which must not depend on the inputs
+func (e *explorer) popAddr(p *Path, op *OpInfo) (int, error) {
+	low, err := e.pop(p, op)
+	if err != nil {
+		return 0, err
+	}
+	high := Const(0)
+	if e.m.wide {
+		if high, err = e.pop(p, op); err != nil {
+			return 0, err
+		}
+	}
+	lo, loOK := low.Value()
+	hi, hiOK := high.Value()
+	if !loOK || !hiOK {
+		return 0, e.dependent(op)
+	}
+	return int(lo) | int(hi)<<8, nil
+}
@@ -0,0 +1,153 @@
+package vm
+
+import (
+	"errors"
+	"testing"
+)
+
+func program(asm string) []byte {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(asm))
+	return memory
+}
+
+func TestExplore(t *testing.T) {
+	// store the larger of the two inputs
+	memory := program("load r1 0\nload r2 1\nblt r1 r2 4\nstore r1 2\nhalt\nstore r2 2\nhalt")
+	paths, err := Explore(memory, []int{0, 1}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("Expected 2 paths, got %d", len(paths))
+	}
+	for i, expected := range []struct {
+		cond, result string
+		pc           int
+	}{
+		{"((mem[0x00] < mem[0x01]) == 0)", "mem[0x00]", 21},
+		{"(mem[0x00] < mem[0x01])", "mem[0x01]", 25},
+	} {
+		p := paths[i]
+		if p.Err != nil || p.PC != expected.pc || p.Steps != 5 {
+			t.Fatalf("Path %d: expected to halt at %#x after 5 steps, got %#x after %d (%v)", i, expected.pc, p.PC, p.Steps, p.Err)
+		}
+		if len(p.Conditions) != 1 || p.Conditions[0].String() != expected.cond {
+			t.Fatalf("Path %d: expected the condition %s, got %v", i, expected.cond, p.Conditions)
+		}
+		if got := p.Memory(2).String(); got != expected.result {
+			t.Fatalf("Path %d: expected to store %s, got %s", i, expected.result, got)
+		}
+		if p.Conditions[0].Eval(p.Inputs) == 0 {
+			t.Fatalf("Path %d: the example inputs %v don't take the path", i, p.Inputs)
+		}
+	}
+
+	max := func(p *Path) *Expr {
+		return p.Memory(2).Ult(Input(0)).Or(p.Memory(2).Ult(Input(1))).Eq(Const(0))
+	}
+	if err := Prove(memory, []int{0, 1}, 100, max); err != nil {
+		t.Fatal(err)
+	}
+	first := func(p *Path) *Expr {
+		return p.Memory(2).Eq(Input(0))
+	}
+	var c *Counterexample
+	err = Prove(memory, []int{0, 1}, 100, first)
+	if !errors.As(err, &c) || err.Error() != "postcondition fails for inputs mem[0x00] = 0, mem[0x01] = 1" {
+		t.Fatalf("Expected a counterexample, got %v", err)
+	}
+}
+
+func TestProve(t *testing.T) {
+	tests := []struct {
+		name   string
+		asm    string
+		post   func(*Path) *Expr
+		inputs []int
+	}{
+		{"Sum", "load r1 0\nload r2 1\nadd r1 r2\nstore r1 2\nhalt",
+			func(p *Path) *Expr { return p.Memory(2).Eq(Input(0).Add(Input(1))) }, []int{0, 1}},
+		// count up to the input, around a loop that forks every time
+		{"Loop", "load r1 0\nbeqz r1 8\naddi r2 1\nsubi r1 1\njump 11\nstore r2 1\nhalt",
+			func(p *Path) *Expr { return p.Memory(1).Eq(Input(0)) }, []int{0}},
+		// store whether the input is negative, from the flags
+		{"Signed", "load r1 0\nli r2 0\ncmp r1 r2\nbslt 1\nhalt\nli r3 1\nstore r3 1\nhalt",
+			func(p *Path) *Expr { return p.Memory(1).Eq(Input(0).Shr(Const(7))) }, []int{0}},
+		{"Carry", "load r1 0\naddi r1 16\nbc 1\nhalt\nli r3 1\nstore r3 1\nhalt",
+			func(p *Path) *Expr { return p.Memory(1).Eq(Const(239).Ult(Input(0))) }, []int{0}},
+		{"Cas", "li r1 5\nli r2 9\ncas r1 r2 0\nstore r1 1\nhalt",
+			func(p *Path) *Expr {
+				swapped := Input(0).Eq(Const(5))
+				return swapped.Mul(p.Memory(0).Eq(Const(9)).And(p.Memory(1).Eq(Const(5)))).
+					Or(p.Memory(0).Eq(Input(0)).And(p.Memory(1).Eq(Input(0))))
+			}, []int{0}},
+		{"Xadd", "li r1 3\nxadd r1 0\nstore r1 1\nhalt",
+			func(p *Path) *Expr { return p.Memory(0).Eq(Input(0).Add(Const(3))).And(p.Memory(1).Eq(Input(0))) }, []int{0}},
+		{"Adc", "load r1 0\nload r2 1\nli r3 255\nli r4 1\nadd r1 r3\nadc r2 r4\nstore r2 3\nhalt",
+			func(p *Path) *Expr { return p.Memory(3).Eq(Input(1).Add(Const(1)).Add(Const(0).Ult(Input(0)))) }, []int{0, 1}},
+		{"Sbb", "load r1 0\nload r2 1\nli r3 1\nsub r1 r3\nsbb r2 r3\nstore r2 3\nhalt",
+			func(p *Path) *Expr { return p.Memory(3).Eq(Input(1).Sub(Const(1)).Sub(Input(0).Eq(Const(0)))) }, []int{0, 1}},
+		{"Stack", "load r1 0\npush r1\ncall 19\nstore r2 1\nhalt\npop r3\npop r2\npush r3\nret",
+			func(p *Path) *Expr { return p.Memory(1).Eq(Input(0)) }, []int{0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := Prove(program(test.asm), test.inputs, 2000, test.post); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestProveFaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		asm      string
+		expected error
+		inputs   map[int]byte
+	}{
+		{"DivideByZero", "load r1 0\nload r2 1\ndiv r1 r2\nstore r1 2\nhalt", ErrDivideByZero, map[int]byte{0: 0, 1: 0}},
+		{"Assert", "load r1 1\nsubi r1 3\nassert r1\nhalt", ErrAssertionFailed, map[int]byte{1: 3}},
+		{"Exit", "load r1 0\nexit r1", ErrExitStatus, map[int]byte{0: 1}},
+		{"Loop", "load r1 0\nbeqz r1 8\naddi r2 1\nsubi r1 1\njump 11\nstore r2 1\nhalt", ErrCycleLimit, nil},
+		{"SymbolicAddress", "load r1 0\nloadr r2 r1\nhalt", ErrNotSymbolic, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Prove(program(test.asm), []int{0, 1}, 20, func(*Path) *Expr { return Const(1) })
+			if !errors.Is(err, test.expected) {
+				var c *Counterexample
+				if !errors.As(err, &c) || !errors.Is(c.Path.Err, test.expected) {
+					t.Fatalf("Expected %v, got %v", test.expected, err)
+				}
+				for addr, val := range test.inputs {
+					if c.Inputs[addr] != val {
+						t.Fatalf("Expected the inputs %v, got %v", test.inputs, c.Inputs)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExploreErrors(t *testing.T) {
+	memory := program("halt")
+	if _, err := Explore(memory, []int{8}, 10); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Expected an input outside the data region to be rejected, got %v", err)
+	}
+	if _, err := Explore(memory[:100], nil, 10); !errors.Is(err, ErrImageSize) {
+		t.Fatalf("Expected a short image to be rejected, got %v", err)
+	}
+	// a condition tying four inputs together is too hard to solve
+	memory = program("load r1 0\nload r2 1\nload r3 2\nload r4 3\nadd r1 r2\nadd r1 r3\nadd r1 r4\nassert r1\nhalt")
+	if _, err := Explore(memory, []int{0, 1, 2, 3}, 20); !errors.Is(err, ErrNotSymbolic) {
+		t.Fatalf("Expected the search to give up, got %v", err)
+	}
+	// but independent conditions are solved one at a time
+	memory = program("load r1 0\ndiv r2 r1\nload r1 1\ndiv r2 r1\nload r1 2\ndiv r2 r1\nload r1 3\nassert r1\nhalt")
+	paths, err := Explore(memory, []int{0, 1, 2, 3}, 20)
+	if err != nil || len(paths) != 5 {
+		t.Fatalf("Expected 5 paths, got %d (%v)", len(paths), err)
+	}
+}
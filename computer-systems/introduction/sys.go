@@ -0,0 +1,145 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// The services a sys instruction can request, selected by its
+// immediate operand. Arguments and results are passed in registers:
+//
+//	SysPrintInt     print r1 in decimal
+//	SysPrintString  print the string at the address in r1, up to a zero
+//	                byte or the end of memory
+//	SysReadLine     read a line, without its newline, into memory from
+//	                the address in r1, keeping at most r2 bytes; set r1
+//	                to the number of bytes stored, and r2 to 1, or to 0
+//	                at the end of the input
+//	SysExit         exit with r1 as the status, as exit r1 does
+const (
+	SysPrintInt = iota
+	SysPrintString
+	SysReadLine
+	SysExit
+)
+
+// A Host provides the services behind the sys instruction.
+type Host interface {
+	PrintInt(n int) error
+	PrintString(s string) error
+	// ReadLine returns the next line of input without its newline, or
+	// io.EOF if there are no more lines.
+	ReadLine() (string, error)
+}
+
+// WithHost makes h provide the services requested with sys. Without a
+// host, only SysExit works, and the other services fault with ErrDevice.
+func WithHost(h Host) Option {
+	return func(m *Machine) {
+		m.host = h
+	}
+}
+
+// NewHost returns a Host that prints to out and reads lines from in.
+// It reads a byte at a time, so that it never takes more input than it
+// returns, and can share in with a keyboard.
+func NewHost(in io.Reader, out io.Writer) Host {
+	return &streamHost{in: in, out: out}
+}
+
+type streamHost struct {
+	in  io.Reader
+	out io.Writer
+}
+
+func (h *streamHost) PrintInt(n int) error {
+	_, err := io.WriteString(h.out, strconv.Itoa(n))
+	return err
+}
+
+func (h *streamHost) PrintString(s string) error {
+	_, err := io.WriteString(h.out, s)
+	return err
+}
+
+func (h *streamHost) ReadLine() (string, error) {
+	var line []byte
+	var buf [1]byte
+	for {
+		n, err := h.in.Read(buf[:])
+		if n > 0 {
+			if buf[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, buf[0])
+			continue
+		}
+		if err == io.EOF && len(line) > 0 {
+			return string(line), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+func execSys(m *Machine, args []int, next int) (bool, error) {
+	service := args[0]
+	if service == SysExit {
+		return execExit(m, []int{1}, next)
+	}
+	if service > SysExit {
+		return false, fmt.Errorf("%w %d at pc %#x", ErrBadSyscall, service, m.pc)
+	}
+	if m.host == nil {
+		return false, fmt.Errorf("%w: sys %d at pc %#x with no host attached", ErrDevice, service, m.pc)
+	}
+
+	var err error
+	switch service {
+	case SysPrintInt:
+		err = m.host.PrintInt(int(m.registers[1]))
+	case SysPrintString:
+		var s []byte
+		for addr := int(m.registers[1]); addr < len(m.memory); addr++ {
+			c, loadErr := m.load(addr)
+			if loadErr != nil {
+				return false, loadErr
+			}
+			if c == 0 {
+				break
+			}
+			s = append(s, c)
+		}
+		err = m.host.PrintString(string(s))
+	case SysReadLine:
+		return false, m.sysReadLine()
+	}
+	if err != nil {
+		return false, fmt.Errorf("%w: sys %d at pc %#x: %v", ErrDevice, service, m.pc, err)
+	}
+	return false, nil
+}
+
+func (m *Machine) sysReadLine() error {
+	line, err := m.host.ReadLine()
+	if err == io.EOF {
+		m.registers[1], m.registers[2] = 0, 0
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: sys %d at pc %#x: %v", ErrDevice, SysReadLine, m.pc, err)
+	}
+	if len(line) > int(m.registers[2]) {
+		line = line[:m.registers[2]]
+	}
+	addr := int(m.registers[1])
+	for i := 0; i < len(line); i++ {
+		if err := m.store(addr+i, line[i]); err != nil {
+			return err
+		}
+	}
+	m.registers[1], m.registers[2] = byte(len(line)), 1
+	return nil
+}
@@ -0,0 +1,121 @@
+package vm
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// A Host that records what is printed, and reads from a list of lines
+type stubHost struct {
+	out   []string
+	lines []string
+}
+
+func (h *stubHost) PrintInt(n int) error {
+	h.out = append(h.out, strconv.Itoa(n))
+	return nil
+}
+
+func (h *stubHost) PrintString(s string) error {
+	h.out = append(h.out, s)
+	return nil
+}
+
+func (h *stubHost) ReadLine() (string, error) {
+	if len(h.lines) == 0 {
+		return "", io.EOF
+	}
+	line := h.lines[0]
+	h.lines = h.lines[1:]
+	return line, nil
+}
+
+// Read a line of up to 4 bytes into address 1, print it, print its
+// length, then exit with it as the status
+const sysAsm = `
+li r1 1
+li r2 4
+sys 2
+mov r3 r1
+li r1 1
+sys 1
+mov r1 r3
+sys 0
+sys 3`
+
+func TestSys(t *testing.T) {
+	for _, test := range []struct {
+		lines []string
+		out   []string
+		exit  byte
+	}{
+		{[]string{"hi"}, []string{"hi", "2"}, 2},
+		{[]string{"hello"}, []string{"hell", "4"}, 4},
+		{nil, []string{"", "0"}, 0},
+	} {
+		memory := make([]byte, MemorySize)
+		copy(memory[DefaultDataSize:], assemble(sysAsm))
+		host := &stubHost{lines: test.lines}
+		err := NewMachine(memory, WithHost(host)).Run()
+		var exit *ExitError
+		if test.exit == 0 && err != nil || test.exit != 0 && (!errors.As(err, &exit) || exit.Code != test.exit) {
+			t.Errorf("%q: expected exit status %d, got %v", test.lines, test.exit, err)
+		}
+		if strings.Join(host.out, "|") != strings.Join(test.out, "|") {
+			t.Errorf("%q: expected output %q, got %q", test.lines, test.out, host.out)
+		}
+	}
+}
+
+func TestSysErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		asm  string
+		host Host
+		err  error
+	}{
+		{"NoHost", "sys 0\nhalt", nil, ErrDevice},
+		{"Unknown", "sys 4\nhalt", &stubHost{}, ErrBadSyscall},
+		{"ReadOnly", "li r1 8\nli r2 1\nsys 2\nhalt", &stubHost{lines: []string{"x"}}, ErrReadOnlyWrite},
+	} {
+		memory := make([]byte, MemorySize)
+		copy(memory[DefaultDataSize:], assemble(test.asm))
+		opts := []Option{}
+		if test.host != nil {
+			opts = append(opts, WithHost(test.host))
+		}
+		if err := NewMachine(memory, opts...).Run(); !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestSysExitWithoutHost(t *testing.T) {
+	memory := make([]byte, MemorySize)
+	copy(memory[DefaultDataSize:], assemble("li r1 0\nsys 3"))
+	m := NewMachine(memory)
+	if err := m.Run(); err != nil || !m.Halted() {
+		t.Errorf("Expected sys 3 to halt, got %v", err)
+	}
+}
+
+func TestNewHost(t *testing.T) {
+	var out strings.Builder
+	h := NewHost(strings.NewReader("one\ntwo"), &out)
+	for _, want := range []string{"one", "two"} {
+		if line, err := h.ReadLine(); err != nil || line != want {
+			t.Errorf("Expected %q, got %q and %v", want, line, err)
+		}
+	}
+	if _, err := h.ReadLine(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+	h.PrintInt(42)
+	h.PrintString(" ok")
+	if out.String() != "42 ok" {
+		t.Errorf("Expected \"42 ok\", got %q", out.String())
+	}
+}
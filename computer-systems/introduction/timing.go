@@ -0,0 +1,87 @@
+package vm
+
+import "time"
+
+// A Timing is a model of how long each instruction would take on real
+// hardware, in clock cycles. An instruction costs its opcode's base
+// cost, plus Memory for each byte it loads, stores, pushes or pops, and
+// Taken if it is a branch that is taken, as a pipelined processor would
+// have fetched the wrong instruction next.
+type Timing struct {
+	Costs   map[byte]uint64 // the base cost of each opcode, or Default
+	Default uint64
+	Memory  uint64
+	Taken   uint64
+}
+
+// DefaultTiming returns a timing model for a simple processor without a
+// cache, where memory is slow: most instructions take a cycle, but each
+// byte of memory accessed costs two more, multiplying takes a few, and
+// dividing several.
+func DefaultTiming() Timing {
+	return Timing{
+		Costs: map[byte]uint64{
+			Jump: 2, Call: 2, Ret: 2, Iret: 2,
+			Mul: 3, Mulw: 4, Div: 8, Mod: 8,
+			Sys: 10,
+		},
+		Default: 1,
+		Memory:  2,
+		Taken:   1,
+	}
+}
+
+// The costs of a Timing, by opcode, for charging them quickly
+type timing struct {
+	costs         [256]uint64
+	memory, taken uint64
+}
+
+// WithTiming makes the machine count the clock cycles it takes to run
+// the program under the timing model t, which Counters reports as
+// Clock. Interrupts are free, as are instructions that fault.
+func WithTiming(t Timing) Option {
+	return func(m *Machine) {
+		m.timing = &timing{memory: t.Memory, taken: t.Taken}
+		for code := range m.timing.costs {
+			cost, ok := t.Costs[byte(code)]
+			if !ok {
+				cost = t.Default
+			}
+			m.timing.costs[code] = cost
+		}
+	}
+}
+
+// WithClockRate slows the machine down to run at hz clock cycles a
+// second, in real time, for demonstrations. It counts clock cycles as
+// WithTiming does, under DefaultTiming unless another model is given.
+func WithClockRate(hz uint64) Option {
+	return func(m *Machine) {
+		m.clockRate = hz
+	}
+}
+
+// Charge the instruction with opcode code, which has just executed,
+// given the counters as they were before it
+func (m *Machine) charge(code byte, before Counters) {
+	t := m.timing
+	accesses := m.counters.Reads + m.counters.Writes - before.Reads - before.Writes
+	m.counters.Clock += t.costs[code] + t.memory*accesses + t.taken*(m.counters.BranchesTaken-before.BranchesTaken)
+	if m.clockRate > 0 {
+		m.throttle()
+	}
+}
+
+// Sleep until real time, since the first instruction, catches up with
+// the clock. Sleeping for less than a millisecond is too inaccurate to
+// be worth it, so a fast clock runs in bursts.
+func (m *Machine) throttle() {
+	if m.clockStart.IsZero() {
+		m.clockStart = time.Now()
+	}
+	due := time.Duration(float64(m.counters.Clock) / float64(m.clockRate) * float64(time.Second))
+	if ahead := due - time.Since(m.clockStart); ahead >= time.Millisecond {
+		time.Sleep(ahead)
+	}
+}
@@ -0,0 +1,77 @@
+package vm
+
+import (
+	"testing"
+	"time"
+)
+
+// Sum 1..10, as TestCounters does
+const sumLoop = `
+load r1 1
+beqz r1 8
+add r2 r1
+subi r1 1
+jump 11
+store r2 0
+halt`
+
+func TestTiming(t *testing.T) {
+	tests := []struct {
+		name     string
+		timing   Timing
+		expected uint64
+	}{
+		// a load and a store, at 3 cycles each, ten times around a loop
+		// of 5 cycles, a branch taken at the end, and the halt
+		{"Default", DefaultTiming(), 3 + 10*5 + 2 + 3 + 1},
+		{"Instructions", Timing{Default: 1}, 1 + 10*4 + 3},
+		{"Costs", Timing{Costs: map[byte]uint64{Jump: 10}, Memory: 1}, 10*10 + 2},
+	}
+	for _, test := range tests {
+		memory := make([]byte, 256)
+		copy(memory[8:], assemble(sumLoop))
+		memory[1] = 10
+		m := NewMachine(memory, WithTiming(test.timing))
+		if err := m.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if c := m.Counters(); c.Clock != test.expected {
+			t.Errorf("%s: expected %d clock cycles, got %d", test.name, test.expected, c.Clock)
+		}
+	}
+}
+
+func TestTimingStepBack(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble("load r1 1\nhalt"))
+	m := NewMachine(memory, WithTiming(DefaultTiming()), WithHistory(10))
+	if err := m.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if c := m.Counters(); c.Clock != 3 {
+		t.Fatalf("Expected the load to take 3 cycles, got %d", c.Clock)
+	}
+	m.StepBack()
+	if c := m.Counters(); c.Clock != 0 {
+		t.Fatalf("Expected stepping back to undo the cycles, got %d", c.Clock)
+	}
+}
+
+func TestClockRate(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(sumLoop))
+	memory[1] = 10
+	m := NewMachine(memory, WithClockRate(1000))
+	start := time.Now()
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	// 59 cycles at a millisecond each, less the last millisecond, which
+	// isn't worth sleeping for
+	if elapsed := time.Since(start); elapsed < 58*time.Millisecond {
+		t.Fatalf("Expected the program to take at least 58ms, took %v", elapsed)
+	}
+	if c := m.Counters(); c.Clock != 59 {
+		t.Fatalf("Expected the default timing, got %d cycles", c.Clock)
+	}
+}
@@ -0,0 +1,137 @@
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WithTrace logs every instruction the machine executes to w, along
+// with the register state after it:
+//
+//	0E: add r2, r1         PC=11 R1=03 R2=03 R3=00 ... R7=00 FLAGS=0000
+//
+// With WithDebugInfo, each line ends with where the instruction came
+// from:
+//
+//	0E: add r2, r1         PC=11 ... FLAGS=0000 ; sum.s:4 (loop+3)
+//
+// Instructions that fault are not logged.
+func WithTrace(w io.Writer) Option {
+	return func(m *Machine) {
+		m.trace = w
+	}
+}
+
+// Log the instruction at pc, which has just executed
+func (m *Machine) traceInstruction(pc int, op *OpInfo, args []int) {
+	operands := make([]string, len(args))
+	for i, kind := range op.Operands {
+		switch kind {
+		case RegOperand:
+			operands[i] = fmt.Sprintf("r%d", args[i])
+		case OffsetOperand:
+			operands[i] = fmt.Sprintf("%d", int8(args[i]))
+		default:
+			operands[i] = fmt.Sprintf("%d", args[i])
+		}
+	}
+	text := strings.TrimSpace(op.Mnemonic + " " + strings.Join(operands, ", "))
+
+	var registers strings.Builder
+	for i := 1; i <= MaxRegister; i++ {
+		fmt.Fprintf(&registers, " R%d=%02X", i, m.registers[i])
+	}
+	source := ""
+	if m.debug != nil {
+		if desc := m.debug.Describe(pc); desc != "" {
+			source = " ; " + desc
+		}
+	}
+	fmt.Fprintf(m.trace, "%02X: %-18s PC=%02X%s FLAGS=%04b%s\n", pc, text, m.pc, registers.String(), m.flags, source)
+}
+
+// WithJSONTrace logs every instruction the machine executes to w as a
+// JSON object on a line of its own, for other programs to read:
+//
+//	{"cycle":2,"pc":14,"opcode":2,"mnemonic":"add","operands":[2,1],
+//	 "next_pc":17,"registers":[3,3,0,0,0,0,0],"flags":0,"memory":[]}
+//
+// (shown here across two lines). Registers lists R1 to R7 after the
+// instruction, and memory each byte it wrote, or a device such as DMA
+// wrote after it, as a MemoryDelta. As with WithTrace, instructions
+// that fault are not logged, and a write error stops the machine with
+// ErrDevice.
+func WithJSONTrace(w io.Writer) Option {
+	return func(m *Machine) {
+		m.jsonTrace = json.NewEncoder(w)
+	}
+}
+
+// A MemoryDelta is a byte of memory written by an instruction, with its
+// values before and after.
+type MemoryDelta struct {
+	Addr int  `json:"addr"`
+	Old  byte `json:"old"`
+	New  byte `json:"new"`
+}
+
+// A TraceRecord is the JSON object WithJSONTrace logs for an
+// instruction.
+type TraceRecord struct {
+	Cycle     uint64        `json:"cycle"`
+	PC        int           `json:"pc"`
+	Opcode    byte          `json:"opcode"`
+	Mnemonic  string        `json:"mnemonic"`
+	Operands  []int         `json:"operands"`
+	NextPC    int           `json:"next_pc"`
+	Registers []int         `json:"registers"`
+	Flags     byte          `json:"flags"`
+	Memory    []MemoryDelta `json:"memory"`
+}
+
+// Start the JSON record of the instruction at pc, once it has executed.
+// Step logs it with logJSONTrace after ticking the devices, so that it
+// includes what they wrote, as DMA does.
+func (m *Machine) traceJSON(pc int, op *OpInfo, args []int) {
+	r := &TraceRecord{
+		Cycle:     m.cycles,
+		PC:        pc,
+		Opcode:    op.Code,
+		Mnemonic:  op.Mnemonic,
+		Operands:  make([]int, len(args)),
+		NextPC:    m.pc,
+		Registers: make([]int, MaxRegister),
+		Flags:     m.flags,
+		Memory:    []MemoryDelta{},
+	}
+	for i, kind := range op.Operands {
+		r.Operands[i] = args[i]
+		if kind == OffsetOperand {
+			r.Operands[i] = int(int8(args[i]))
+		}
+	}
+	for i := range r.Registers {
+		r.Registers[i] = int(m.registers[i+1])
+	}
+	m.traceRecord = r
+}
+
+// Log the record traceJSON started, if there is one, with the memory
+// written since the instruction began
+func (m *Machine) logJSONTrace() error {
+	r := m.traceRecord
+	if r == nil {
+		return nil
+	}
+	m.traceRecord = nil
+	for _, delta := range m.traceWrites {
+		delta.New = m.memory[delta.Addr]
+		r.Memory = append(r.Memory, delta)
+	}
+	if err := m.jsonTrace.Encode(r); err != nil {
+		return fmt.Errorf("%w: writing trace: %v", ErrDevice, err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package vm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+load r1 1
+beqz r1 3
+addi r2 5
+halt`))
+	memory[1] = 3
+
+	var trace strings.Builder
+	if err := NewMachine(memory, WithTrace(&trace)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "08: load r1, 1         PC=0B R1=03 R2=00 R3=00 R4=00 R5=00 R6=00 R7=00 FLAGS=0000\n" +
+		"0B: beqz r1, 3         PC=0E R1=03 R2=00 R3=00 R4=00 R5=00 R6=00 R7=00 FLAGS=0000\n" +
+		"0E: addi r2, 5         PC=11 R1=03 R2=05 R3=00 R4=00 R5=00 R6=00 R7=00 FLAGS=0000\n" +
+		"11: halt               PC=11 R1=03 R2=05 R3=00 R4=00 R5=00 R6=00 R7=00 FLAGS=0000\n"
+	if trace.String() != expected {
+		t.Fatalf("Expected trace\n%s\ngot\n%s", expected, trace.String())
+	}
+}
+
+func TestJSONTrace(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+load r1 1
+push r1
+beqz r1 251
+store r1 0
+halt`))
+	memory[0], memory[1] = 9, 3
+
+	var trace strings.Builder
+	if err := NewMachine(memory, WithJSONTrace(&trace)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`{"cycle":0,"pc":8,"opcode":1,"mnemonic":"load","operands":[1,1],"next_pc":11,"registers":[3,0,0,0,0,0,0],"flags":0,"memory":[]}`,
+		`{"cycle":1,"pc":11,"opcode":18,"mnemonic":"push","operands":[1],"next_pc":13,"registers":[3,0,0,0,0,0,0],"flags":0,"memory":[{"addr":255,"old":0,"new":3}]}`,
+		`{"cycle":2,"pc":13,"opcode":8,"mnemonic":"beqz","operands":[1,-5],"next_pc":16,"registers":[3,0,0,0,0,0,0],"flags":0,"memory":[]}`,
+		`{"cycle":3,"pc":16,"opcode":2,"mnemonic":"store","operands":[1,0],"next_pc":19,"registers":[3,0,0,0,0,0,0],"flags":0,"memory":[{"addr":0,"old":9,"new":3}]}`,
+		`{"cycle":4,"pc":19,"opcode":255,"mnemonic":"halt","operands":[],"next_pc":19,"registers":[3,0,0,0,0,0,0],"flags":0,"memory":[]}`,
+	}
+	if got := strings.Split(strings.TrimSpace(trace.String()), "\n"); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected trace\n%s\ngot\n%s", strings.Join(expected, "\n"), trace.String())
+	}
+
+	// each line decodes back to a record
+	var r TraceRecord
+	if err := json.Unmarshal([]byte(expected[3]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.PC != 16 || len(r.Memory) != 1 || r.Memory[0] != (MemoryDelta{Addr: 0, Old: 9, New: 3}) {
+		t.Fatalf("Unexpected record %+v", r)
+	}
+}
@@ -0,0 +1,156 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+)
+
+// The registers of the UART attached by WithUART, as offsets from its
+// address. Loading UARTData takes the byte received, and storing to it
+// sends a byte. UARTStatus holds the status bits, and UARTControl the
+// interrupt enable bits.
+const (
+	UARTData = iota
+	UARTStatus
+	UARTControl
+	UARTRegisters // the number of registers
+)
+
+// The bits of the UART status register.
+const (
+	UARTRxReady = 1 << 0 // a received byte is waiting in UARTData
+	UARTTxReady = 1 << 1 // the UART can take another byte to send
+	UARTClosed  = 1 << 2 // the input has ended, and every byte been taken
+)
+
+// The bits of the UART control register, which enable its interrupts.
+const (
+	UARTRxInterrupt = 1 << 0 // raise UARTIRQ when a byte is received
+	UARTTxInterrupt = 1 << 1 // raise UARTIRQ when a byte has been sent
+)
+
+// The IRQ line raised by the UART.
+const UARTIRQ = 2
+
+// The number of instructions the UART takes to receive or to send a
+// byte, as a slow serial line would.
+const UARTCycles = 10
+
+// WithUART attaches a serial port with its registers at addr, which
+// receives bytes from r and sends them to w. Unlike the keyboard and
+// console, it makes the program wait for the line: each byte takes
+// UARTCycles instructions to arrive, from when the one before was
+// taken, and as long to send, so a program must poll the status
+// register until the UART is ready, or enable its interrupts and wait
+// for those. The timing is the same from run to run, so an interrupt
+// always comes at the same point in a program.
+//
+// The UART reads each byte from r as it starts receiving it, so with an
+// r that blocks, like a terminal, the machine waits for input then. A
+// byte stored while the UART isn't ready to send is lost. Once reading
+// r or writing w fails, loads and stores to the UART fault with
+// ErrDevice. The data region must include the registers.
+func WithUART(addr int, r io.Reader, w io.Writer) Option {
+	return func(m *Machine) {
+		m.bus.Attach(addr, addr+UARTRegisters, &uart{in: keyboard{r: r}, w: w})
+	}
+}
+
+type uart struct {
+	in      keyboard // reads ahead the byte being received
+	w       io.Writer
+	control byte
+	rx, tx  byte
+	rxReady bool
+	rxLeft  int // the instructions until the byte being received arrives
+	txLeft  int // and until the byte being sent has gone, or 0 for none
+	closed  bool
+	err     error
+}
+
+func (u *uart) Load(offset int) (byte, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+	switch offset {
+	case UARTData:
+		u.rxReady = false
+		return u.rx, nil
+	case UARTStatus:
+		var status byte
+		if u.rxReady {
+			status |= UARTRxReady
+		}
+		if u.txLeft == 0 {
+			status |= UARTTxReady
+		}
+		if u.closed && !u.rxReady {
+			status |= UARTClosed
+		}
+		return status, nil
+	}
+	return u.control, nil
+}
+
+// Peek without taking the byte received, or faulting
+func (u *uart) Peek(offset int) byte {
+	if offset == UARTData {
+		return u.rx
+	}
+	val, _ := u.Load(offset)
+	return val
+}
+
+func (u *uart) Store(offset int, val byte) error {
+	if u.err != nil {
+		return u.err
+	}
+	switch offset {
+	case UARTData:
+		if u.txLeft == 0 {
+			u.tx, u.txLeft = val, UARTCycles
+		}
+	case UARTControl:
+		u.control = val
+	}
+	return nil
+}
+
+// Carry on sending and receiving bytes
+func (u *uart) Tick(m *Machine) {
+	if u.err != nil {
+		return
+	}
+	if u.txLeft > 0 {
+		if u.txLeft--; u.txLeft == 0 {
+			if _, err := u.w.Write([]byte{u.tx}); err != nil {
+				u.err = fmt.Errorf("%w: UART write: %v", ErrDevice, err)
+				return
+			}
+			if u.control&UARTTxInterrupt != 0 {
+				m.Raise(UARTIRQ)
+			}
+		}
+	}
+	if u.rxReady || u.closed {
+		return
+	}
+	if !u.in.pending {
+		ready, err := u.in.fill()
+		if err != nil {
+			u.err = fmt.Errorf("%w: UART read: %v", ErrDevice, err)
+			return
+		}
+		if !ready {
+			u.closed = true
+			return
+		}
+		u.rxLeft = UARTCycles
+	}
+	if u.rxLeft--; u.rxLeft == 0 {
+		u.rx, u.rxReady, u.in.pending = u.in.next, true, false
+		if u.control&UARTRxInterrupt != 0 {
+			m.Raise(UARTIRQ)
+		}
+	}
+}
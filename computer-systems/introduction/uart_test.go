@@ -0,0 +1,114 @@
+package vm
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Echo the input of the UART at 16 back out until it closes, polling
+// its status register, then wait for the last byte to be sent
+const uartEcho = `
+load r1 17
+li r2 4
+and r2 r1
+bne r2 29
+li r2 1
+and r2 r1
+beqz r2 235
+load r3 16
+load r1 17
+li r2 2
+and r2 r1
+beqz r2 244
+store r3 16
+jump 32
+load r1 17
+li r2 2
+and r2 r1
+beqz r2 244
+halt`
+
+func TestUARTPoll(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[32:], assemble(uartEcho))
+	var out strings.Builder
+	m := NewMachine(memory, WithDataSize(32), WithUART(16, strings.NewReader("hello"), &out), WithCycleLimit(10000))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "hello" {
+		t.Errorf("Expected the input echoed, got %q", out.String())
+	}
+}
+
+func TestUARTInterrupt(t *testing.T) {
+	// the handler at 0x40 takes each byte into r6 and counts them in
+	// r7, while the program waits for two
+	memory := make([]byte, 256)
+	copy(memory[32:], assemble(`
+sti
+li r1 1
+store r1 18
+li r5 2
+cmp r7 r5
+bnz 251
+halt`))
+	copy(memory[0x40:], assemble("load r6 16\naddi r7 1\niret"))
+	memory[24+UARTIRQ] = 0x40
+	m := NewMachine(memory, WithDataSize(32), WithUART(16, strings.NewReader("hi"), nil),
+		WithVectorTable(24), WithCycleLimit(10000))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Register(6) != 'i' || m.Register(7) != 2 {
+		t.Errorf("Expected to receive 2 bytes ending in 'i', got %d ending in %q", m.Register(7), m.Register(6))
+	}
+}
+
+func TestUARTErrors(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[32:], assemble("li r1 65\nstore r1 16\nload r2 17\njump 38"))
+	err := NewMachine(memory, WithDataSize(32), WithUART(16, strings.NewReader(""), failingWriter{}), WithCycleLimit(100)).Run()
+	if !errors.Is(err, ErrDevice) || !strings.Contains(err.Error(), "UART write") {
+		t.Errorf("Expected ErrDevice from the write, got %v", err)
+	}
+}
+
+func TestUARTTiming(t *testing.T) {
+	// each byte arrives UARTCycles instructions after the one before
+	// was taken, however the host delivers them
+	memory := make([]byte, 256)
+	copy(memory[32:], assemble("jump 32"))
+	m := NewMachine(memory, WithDataSize(32), WithUART(16, strings.NewReader("ab"), nil))
+	for _, expected := range "ab" {
+		for i := 1; i <= UARTCycles; i++ {
+			if err := m.Step(); err != nil {
+				t.Fatal(err)
+			}
+			status, _ := m.Bus().Load(16 + UARTStatus)
+			if ready := status&UARTRxReady != 0; ready != (i == UARTCycles) {
+				t.Fatalf("Expected %q to arrive after %d instructions, got status %#x after %d", expected, UARTCycles, status, i)
+			}
+		}
+		if b, _ := m.Bus().Load(16 + UARTData); b != byte(expected) {
+			t.Errorf("Expected to receive %q, got %q", expected, b)
+		}
+	}
+}
+
+func TestUARTGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		memory := make([]byte, 256)
+		copy(memory[32:], assemble(uartEcho))
+		var out strings.Builder
+		if err := NewMachine(memory, WithDataSize(32), WithUART(16, strings.NewReader("hi"), &out), WithCycleLimit(10000)).Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected the UART to leave no goroutines behind, went from %d to %d", before, after)
+	}
+}
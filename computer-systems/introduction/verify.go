@@ -0,0 +1,146 @@
+package vm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// An Issue is a problem Verify found in a program.
+type Issue struct {
+	PC int // the address of the instruction at fault
+	// Warning is set for problems that don't stop the program running
+	// correctly, such as unreachable code, which may just be data.
+	Warning bool
+	Msg     string
+	Err     error // for an error, the fault the instruction would raise
+}
+
+func (i Issue) String() string {
+	if i.Warning {
+		return "warning: " + i.Msg
+	}
+	return i.Msg
+}
+
+// Verify checks the program in memory without running it, for the
+// machine that opts configure, and returns the problems it finds in
+// address order. Every instruction reachable from the entry point is
+// checked in the way AnalyzeReachability explores them, so a problem
+// reported for it could fault the program if the path to it is ever
+// taken:
+//
+//   - an illegal opcode, or running past the end of the program
+//   - an instruction that doesn't fit in memory, or names an invalid
+//     register
+//   - a jump, call or branch to an address outside the instruction
+//     region
+//   - a store, to a fixed address, that isn't to the data region
+//
+// Each run of instructions that can't be reached is reported as a
+// warning. Stores to addresses held in registers can't be checked.
+func Verify(memory []byte, opts ...Option) []Issue {
+	m := NewMachine(memory, opts...)
+	if err := m.validate(); err != nil {
+		return []Issue{{PC: m.entry, Msg: err.Error(), Err: err}}
+	}
+
+	var issues []Issue
+	fault := func(err error) {
+		issues = append(issues, Issue{PC: m.pc, Msg: err.Error(), Err: err})
+	}
+	visited := map[int]bool{}
+	work := []int{m.entry}
+	for len(work) > 0 {
+		m.pc = work[len(work)-1]
+		work = work[:len(work)-1]
+		if visited[m.pc] {
+			continue
+		}
+		visited[m.pc] = true
+
+		op, args, err := m.decode()
+		if err != nil {
+			fault(err)
+			continue
+		}
+		next := m.next(op)
+		switch op.Code {
+		case Store:
+			if err := m.checkStore(args[1]); err != nil {
+				fault(err)
+			}
+		case Cas:
+			if err := m.checkStore(args[2]); err != nil {
+				fault(err)
+			}
+		case Xadd:
+			if err := m.checkStore(args[1]); err != nil {
+				fault(err)
+			}
+		case Storew:
+			if err := m.checkStore(args[2]); err != nil {
+				fault(err)
+			} else if err := m.checkStore(args[2] + 1); err != nil {
+				fault(err)
+			}
+		}
+
+		if target, ok := m.target(op, args, next); ok {
+			if target < m.dataSize || target >= len(m.memory) {
+				fault(fmt.Errorf("%w: %s to %#x, outside the instruction region, at pc %#x", ErrOutOfBounds, op.Mnemonic, target, m.pc))
+			} else {
+				work = append(work, target)
+			}
+		}
+		if op.Flow == FlowNext || op.Flow == FlowBranch || op.Flow == FlowCall {
+			work = append(work, next)
+		}
+	}
+
+	// Sweep the instruction region in order, as AnalyzeReachability
+	// does, for runs of instructions that were never visited
+	end := len(memory)
+	for end > m.dataSize && memory[end-1] == 0 {
+		end--
+	}
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			issues = append(issues, Issue{PC: start, Warning: true,
+				Msg: fmt.Sprintf("unreachable code from %#x to %#x", start, end-1)})
+			start = -1
+		}
+	}
+	for pc := m.dataSize; pc < end; {
+		if visited[pc] {
+			flush(pc)
+		} else if start < 0 {
+			start = pc
+		}
+		width := 1
+		if op := m.lookupOp(memory[pc]); op != nil {
+			width = m.width(op)
+		}
+		pc += width
+	}
+	flush(end)
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].PC < issues[j].PC
+	})
+	return issues
+}
+
+// The address a jump, call or branch decoded with args goes to, if it
+// has one
+func (m *Machine) target(op *OpInfo, args []int, next int) (int, bool) {
+	for i, kind := range op.Operands {
+		switch {
+		case op.Flow == FlowBranch && kind == OffsetOperand:
+			return m.branchTarget(next, args[i]), true
+		case (op.Flow == FlowJump || op.Flow == FlowCall) && kind == AddrOperand:
+			return args[i], true
+		}
+	}
+	return 0, false
+}
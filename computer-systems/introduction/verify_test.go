@@ -0,0 +1,60 @@
+package vm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		asm    string
+		extra  []byte // placed after the code
+		issues []string
+	}{
+		{"Clean", "load r1 1\nbeqz r1 3\naddi r1 1\nstore r1 0\nhalt", nil, nil},
+		{"IllegalOpcode", "load r1 1\nbeqz r1 1\nhalt", []byte{0xfe}, []string{"illegal opcode 0xfe at pc 0xf"}},
+		{"FallsOffEnd", "load r1 1", nil, []string{"no instructions left at pc 0xb"}},
+		{"BadRegister", "load r1 1\nbeqz r1 1\nhalt", []byte{Mov, 1, 9}, []string{"invalid register r9 at pc 0xf"}},
+		{"JumpIntoData", "jump 2", nil, []string{"jump to 0x2, outside the instruction region, at pc 0x8"}},
+		{"BranchIntoData", "beqz r1 247\nhalt", nil, []string{"beqz to 0x2, outside the instruction region, at pc 0x8"}},
+		{"StoreToCode", "store r1 8\nhalt", nil, []string{"store to instruction region 0x8 at pc 0x8"}},
+		{"StorewOverlapsCode", "storew r1 r2 7\nhalt", nil, []string{"store to instruction region 0x8 at pc 0x8"}},
+		{"XaddToCode", "xadd r1 8\nhalt", nil, []string{"store to instruction region 0x8 at pc 0x8"}},
+		{"Unreachable", "jump 14\nhalt\naddi r1 1\nhalt", nil, []string{"warning: unreachable code from 0xa to 0xd"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			memory := make([]byte, 256)
+			code := append(assemble(test.asm), test.extra...)
+			copy(memory[8:], code)
+			var got []string
+			for _, issue := range Verify(memory) {
+				got = append(got, issue.String())
+			}
+			if len(got) != len(test.issues) {
+				t.Fatalf("Expected issues %q, got %q", test.issues, got)
+			}
+			for i, msg := range test.issues {
+				if !strings.HasSuffix(got[i], msg) {
+					t.Fatalf("Expected issues %q, got %q", test.issues, got)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyOptions(t *testing.T) {
+	// with a larger data region, the store is fine and the code starts
+	// later, but a bad entry point is reported at once
+	memory := make([]byte, 256)
+	copy(memory[16:], assemble("store r1 8\nhalt"))
+	if issues := Verify(memory, WithDataSize(16)); len(issues) != 0 {
+		t.Fatalf("Expected no issues, got %v", issues)
+	}
+	issues := Verify(memory, WithDataSize(16), WithEntryPoint(4))
+	if len(issues) != 1 || !errors.Is(issues[0].Err, ErrInvalidConfig) {
+		t.Fatalf("Expected an invalid configuration, got %v", issues)
+	}
+}
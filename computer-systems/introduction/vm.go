@@ -1,6 +1,11 @@
 package vm
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
 
 const (
 	Load  = 0x01
@@ -18,78 +23,861 @@ const (
 	Beqz = 0x08
 )
 
-// Given a 256 byte array of "memory", run the stored program
-// to completion, modifying the data in place to reflect the result
+// Extensions beyond the original exercise
+const (
+	LoadImm  = 0x09
+	Mov      = 0x0a
+	Cmp      = 0x0b
+	Bz       = 0x0c
+	Bc       = 0x0d
+	Cycles   = 0x0e
+	Assert   = 0x0f
+	LoadCode = 0x10
+	Swap     = 0x11
+	Push     = 0x12
+	Pop      = 0x13
+	Call     = 0x14
+	Ret      = 0x15
+	And      = 0x16
+	Or       = 0x17
+	Xor      = 0x18
+	Not      = 0x19
+	Shl      = 0x1a
+	Shr      = 0x1b
+	Shli     = 0x1c
+	Shri     = 0x1d
+	Mul      = 0x1e
+	Mulw     = 0x1f
+	Div      = 0x20
+	Mod      = 0x21
+	Bnz      = 0x22
+	Bn       = 0x23
+	Bv       = 0x24
+	Bslt     = 0x25
+	Bsge     = 0x26
+	Bne      = 0x27
+	Blt      = 0x28
+	Bge      = 0x29
+	Iret     = 0x2a
+	Cli      = 0x2b
+	Sti      = 0x2c
+	Exit     = 0x2d
+	LoadR    = 0x2e
+	StoreR   = 0x2f
+	Sys      = 0x30
+	Loadw    = 0x31
+	Storew   = 0x32
+	Cas      = 0x33
+	Xadd     = 0x34
+	Hartid   = 0x35
+	Adc      = 0x36
+	Sbb      = 0x37
+)
+
+// Bits of the flags register, set by Cmp and by add, sub, addi, subi,
+// adc and sbb from their result
+const (
+	FlagZero     = 1 << iota // the result was zero
+	FlagCarry                // an addition carried out or a subtraction borrowed
+	FlagOverflow             // the result overflowed as signed bytes
+	FlagNegative             // the high bit of the result was set
+)
+
+// The size of a memory image.
+const MemorySize = 256
+
+// The largest memory image a machine with wide addresses can run.
+const MaxWideMemorySize = 1 << 16
+
+// The general purpose registers are numbered 1 through MaxRegister.
+// Register 0 is the PC, which is not addressable by instructions.
+const MaxRegister = 7
+
+// The default size of the data region. Instructions start immediately
+// after it.
+const DefaultDataSize = 8
+
+// The default size of the stack, which occupies the top of memory.
+const DefaultStackSize = 16
+
+// A Machine runs the program stored in a memory image, modifying the
+// data in place to reflect the result. It can run the program to
+// completion, or one instruction at a time so that its state can be
+// inspected in between.
 //
-// The memory format is:
+// The memory format, with the default data size, is:
 //
-// 00 01 02 03 04 05 06 07 08 09 0a 0b 0c 0d 0e 0f ... ff
-// __ __ __ __ __ __ __ __ __ __ __ __ __ __ __ __ ... __
-// ^==DATA===============^ ^==INSTRUCTIONS==============^
+//	00 01 02 03 04 05 06 07 08 09 0a 0b 0c 0d 0e 0f ... ff
+//	__ __ __ __ __ __ __ __ __ __ __ __ __ __ __ __ ... __
+//	^==DATA===============^ ^==INSTRUCTIONS==============^
 //
-func compute(memory []byte) {
+// Programs may only store into the data region; the instructions are
+// read-only. The exception is the stack, which takes up the top
+// DefaultStackSize bytes of memory, unless set with WithStackSize, and
+// is written only by push. It grows downward from the end of memory,
+// so a program that pushes must leave that space free.
+//
+// By default addresses are a single byte, as in the original exercise.
+// See WithWideAddresses for running larger programs, and WithConfig for
+// other layouts.
+type Machine struct {
+	memory       []byte
+	pc           int
+	sp           int // the address of the top of the stack
+	stackSize    int
+	stackTop     int                   // the initial SP, with the stack below it
+	memSize      int                   // the image size required, if set by WithConfig
+	registers    [MaxRegister + 1]byte // R1 to R7, with 0 unused
+	operands     [4]int                // the decoded operands of the current instruction
+	wide         bool
+	flags        byte
+	dataSize     int
+	entry        int
+	hasEntry     bool
+	cycles       uint64
+	cycleLimit   uint64
+	accessLog    *AccessLog
+	coverage     *Coverage
+	history      *history
+	accessHook   func(MemoryAccess)
+	trace        io.Writer
+	jsonTrace    *json.Encoder
+	traceWrites  []MemoryDelta // made by the instruction being traced
+	traceRecord  *TraceRecord  // for it, until the devices have ticked
+	debug        *DebugInfo
+	console      io.Writer
+	keyboard     *keyboard
+	timer        uint64 // the timer period, or 0 for none
+	vectors      int
+	hasVectors   bool
+	pending      byte // a bit for each raised IRQ line
+	masked       bool // interrupts are disabled
+	validated    bool
+	halted       bool
+	exitCode     byte
+	memoryMap    MemoryMap
+	counters     Counters
+	timing       *timing
+	clockRate    uint64 // for WithClockRate, in cycles a second
+	clockStart   time.Time
+	dispatchMode Dispatch
+	decoded      []*decodedInstruction // for PredecodedDispatch, by address
+	custom       map[byte]*customOp
+	screen       int
+	hasScreen    bool
+	screenOut    io.Writer
+	random       uint64 // the generator state for WithRandom
+	hasRandom    bool
+	host         Host
+	bus          *Bus
+	ticking      bool     // the devices are ticking, for the cluster's lock
+	hart         int      // the number of the hart, in a cluster
+	cluster      *Cluster // or nil for a machine on its own
+}
 
-	registers := [3]byte{8, 0, 0} // PC, R1 and R2
+// An Option configures a Machine.
+type Option func(*Machine)
 
+// WithDataSize makes the data region span addresses 0..n-1, so that
+// the first instruction, and the initial PC, is at n.
+func WithDataSize(n int) Option {
+	return func(m *Machine) {
+		m.dataSize = n
+	}
+}
+
+// WithEntryPoint makes execution start at pc, rather than at the first
+// byte after the data region. pc must lie in the instruction region,
+// which lets a program keep constants there ahead of its code.
+func WithEntryPoint(pc int) Option {
+	return func(m *Machine) {
+		m.entry, m.hasEntry = pc, true
+	}
+}
+
+// WithStackSize reserves the top n bytes of memory for the stack.
+func WithStackSize(n int) Option {
+	return func(m *Machine) {
+		m.stackSize = n
+	}
+}
+
+// WithWideAddresses gives the machine a 16 bit address space. The PC
+// and every address operand are 16 bits, with operands encoded as two
+// bytes, little-endian, and the memory image may be any size up to
+// MaxWideMemorySize. Registers and immediates are still bytes, and a
+// branch offset is a single byte read as signed, so branches reach 128
+// bytes back or 127 forward.
+func WithWideAddresses() Option {
+	return func(m *Machine) {
+		m.wide = true
+	}
+}
+
+// WithCycleLimit makes Run fail with ErrCycleLimit rather than execute
+// more than n instructions, which stops a program stuck in a loop. The
+// machine is left as it was after the last instruction, for its state
+// to be inspected. Zero means no limit.
+func WithCycleLimit(n uint64) Option {
+	return func(m *Machine) {
+		m.cycleLimit = n
+	}
+}
+
+// NewMachine returns a Machine that will run the program in memory.
+func NewMachine(memory []byte, opts ...Option) *Machine {
+	m := &Machine{memory: memory, dataSize: DefaultDataSize, stackSize: DefaultStackSize, bus: &Bus{}}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if !m.hasEntry {
+		m.entry = m.dataSize
+	}
+	if m.clockRate > 0 && m.timing == nil {
+		WithTiming(DefaultTiming())(m)
+	}
+	if m.stackTop == 0 {
+		m.stackTop = len(memory)
+	}
+	m.attachDevices()
+	m.pc = m.entry
+	m.sp = m.stackTop
+	m.masked = true
+	return m
+}
+
+// Put the machine's memory on the bus as RAM, with the built-in devices
+// over it, and then those attached with WithDevice over those
+func (m *Machine) attachDevices() {
+	attached := m.bus.devices
+	m.bus.devices = nil
+	m.bus.Attach(0, len(m.memory), RAM(m.memory))
+	if m.hasRandom {
+		m.bus.Attach(RandomAddr, RandomAddr+1, randomDevice{m})
+	}
+	if m.keyboard != nil {
+		m.bus.Attach(KeyboardStatusAddr, KeyboardAddr+1, keyboardDevice{m})
+	}
+	if m.console != nil {
+		m.bus.Attach(ConsoleAddr, ConsoleAddr+1, consoleDevice{m})
+	}
+	if m.screenOut != nil {
+		m.bus.Attach(m.screen, m.screen+ScreenSize, screenDevice{m})
+	}
+	if m.timer > 0 {
+		m.bus.Attach(0, 0, timerDevice{m.timer})
+	}
+	m.bus.devices = append(m.bus.devices, attached...)
+}
+
+// Whether addr belongs to a device rather than to the machine's memory
+func (m *Machine) onDevice(addr int) bool {
+	a := m.bus.lookup(addr)
+	return a != nil && a != &m.bus.devices[0]
+}
+
+// Run executes the program until it halts or faults. A program that
+// exits with a nonzero status returns an *ExitError.
+//
+// The memory image must be exactly MemorySize bytes long, unless the
+// machine has wide addresses. Shorter images are rejected rather than
+// padded, since the program's results are written back into the
+// caller's memory.
+func (m *Machine) Run() error {
 	// Keep looping, like a physical computer's clock
-	for {
-		position := registers[0]
-		op := memory[position]
-
-		switch op {
-		case Load:
-			// increment PC
-			registers[0] += 3
-			reg := memory[position+1]
-			addr := memory[position+2]
-			// load data at dataAddr into register reg
-			registers[reg] = memory[addr]
-		case Store:
-			registers[0] += 3
-			reg := memory[position+1]
-			addr := memory[position+2]
-			// load data at dataAddr into register reg
-			memory[addr] = registers[reg]
-		case Add:
-			registers[0] += 3
-			reg1 := memory[position+1]
-			reg2 := memory[position+2]
-			// add register values, store in reg1
-			registers[reg1] += registers[reg2]
-		case Sub:
-			registers[0] += 3
-			reg1 := memory[position+1]
-			reg2 := memory[position+2]
-			// add register values, store in reg1
-			registers[reg1] -= registers[reg2]
-		case Addi:
-			registers[0] += 3
-			reg := memory[position+1]
-			val := memory[position+2]
-			// add val to value stored in register
-			registers[reg] += val
-		case Subi:
-			registers[0] += 3
-			reg := memory[position+1]
-			val := memory[position+2]
-			// subtract val from value stored in register
-			registers[reg] -= val
-		case Jump:
-			jumpTo := memory[position+1]
-			// set PC to addr specified in arg
-			registers[0] = jumpTo
-		case Beqz:
-			registers[0] += 3
-			reg := memory[position+1]
-			offset := memory[position+2]
-			// move PC by offset conditional on value in reg
-			if registers[reg] == 0 {
-				registers[0] += offset
-			}
-		case Halt:
-			return
-		default:
-			panic(fmt.Errorf("Unknown opcode: %#x", op))
+	for !m.halted {
+		if err := m.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Step executes the instruction at the PC. It does nothing once the
+// machine has halted. The memory image is checked before the first
+// instruction, as for Run.
+func (m *Machine) Step() error {
+	if m.halted {
+		return nil
+	}
+	if !m.validated {
+		if err := m.validate(); err != nil {
+			return err
+		}
+		m.validated = true
+	}
+	if m.cycleLimit > 0 && m.cycles >= m.cycleLimit {
+		return fmt.Errorf("%w: %d instructions executed, stopped at pc %#x", ErrCycleLimit, m.cycles, m.pc)
+	}
+	// interrupts are only taken after an instruction that ran with
+	// them enabled, so the program always makes progress between an
+	// iret or sti and the next interrupt
+	masked := m.masked
+	pc := m.pc
+	var code byte
+	var before Counters
+	if m.timing != nil && pc < len(m.memory) {
+		code, before = m.memory[pc], m.counters
+	}
+	if m.history != nil {
+		m.record()
+	}
+	var err error
+	if m.trace != nil || m.jsonTrace != nil {
+		err = m.tracedExecute()
+	} else {
+		err = m.execute()
+	}
+	if exit, ok := err.(*ExitError); ok {
+		// exiting completes the instruction, as halting does
+		m.cycles++
+		m.cover(pc)
+		if m.timing != nil {
+			m.charge(code, before)
 		}
+		return exit
+	}
+	if err != nil {
+		return m.fault(err)
+	}
+	m.cycles++
+	m.cover(pc)
+	if m.timing != nil {
+		m.charge(code, before)
+	}
+	m.tick()
+	if err := m.logJSONTrace(); err != nil {
+		return m.fault(err)
+	}
+	if masked {
+		return nil
+	}
+	return m.dispatch()
+}
+
+func (m *Machine) cover(pc int) {
+	if m.coverage != nil {
+		m.coverage.Hits[pc]++
+	}
+}
+
+// Wrap an error from the instruction at the PC in a Fault
+func (m *Machine) fault(err error) error {
+	f := &Fault{PC: m.pc, Err: err}
+	if m.pc < len(m.memory) {
+		f.Op = m.lookupOp(m.memory[m.pc])
+	}
+	if _, args, decodeErr := m.decode(); decodeErr == nil {
+		f.Operands = append([]int(nil), args...)
+	}
+	return f
+}
+
+func (m *Machine) tracedExecute() error {
+	pc := m.pc
+	op, args, err := m.decode()
+	if err != nil {
+		return err
+	}
+	args = append([]int(nil), args...)
+	m.traceWrites = m.traceWrites[:0]
+	if err := m.execute(); err != nil {
+		return err
+	}
+	if m.trace != nil {
+		m.traceInstruction(pc, op, args)
+	}
+	if m.jsonTrace != nil {
+		m.traceJSON(pc, op, args)
+	}
+	return nil
+}
+
+// PC returns the address of the next instruction to execute.
+func (m *Machine) PC() int {
+	return m.pc
+}
+
+// SP returns the address of the value on top of the stack, which is
+// the end of memory, or the StackTop of WithConfig, when the stack is
+// empty.
+func (m *Machine) SP() int {
+	return m.sp
+}
+
+// R1 returns the value of register 1.
+func (m *Machine) R1() byte {
+	return m.registers[1]
+}
+
+// R2 returns the value of register 2.
+func (m *Machine) R2() byte {
+	return m.registers[2]
+}
+
+// Register returns the value of general purpose register n, which must
+// be between 1 and MaxRegister.
+func (m *Machine) Register(n int) byte {
+	if n < 1 || n > MaxRegister {
+		panic(fmt.Sprintf("no register r%d", n))
+	}
+	return m.registers[n]
+}
+
+// Flags returns the flags register, a combination of the Flag bits.
+func (m *Machine) Flags() byte {
+	return m.flags
+}
+
+// Data returns the data region of memory.
+func (m *Machine) Data() []byte {
+	return m.memory[:m.dataSize]
+}
+
+// Cycles returns the number of instructions executed so far.
+func (m *Machine) Cycles() uint64 {
+	return m.cycles
+}
+
+// Halted reports whether the program has executed a halt or exit.
+func (m *Machine) Halted() bool {
+	return m.halted
+}
+
+// ExitCode returns the status the program exited with, which is 0 if it
+// executed a halt.
+func (m *Machine) ExitCode() byte {
+	return m.exitCode
+}
+
+func (m *Machine) validate() error {
+	if m.wide && len(m.memory) > MaxWideMemorySize {
+		return fmt.Errorf("%w: %d bytes, expected at most %d", ErrImageSize, len(m.memory), MaxWideMemorySize)
+	}
+	if !m.wide && m.memSize > MemorySize {
+		return fmt.Errorf("%w: memory size %d needs wide addresses", ErrInvalidConfig, m.memSize)
+	}
+	if m.memSize > 0 && len(m.memory) != m.memSize || m.memSize == 0 && !m.wide && len(m.memory) != MemorySize {
+		size := m.memSize
+		if size == 0 {
+			size = MemorySize
+		}
+		return fmt.Errorf("%w: %d bytes, expected %d", ErrImageSize, len(m.memory), size)
+	}
+	if m.dataSize <= 0 || m.dataSize >= len(m.memory) {
+		return fmt.Errorf("%w: data size %d for %d bytes of memory", ErrInvalidConfig, m.dataSize, len(m.memory))
+	}
+	if m.stackTop <= m.dataSize || m.stackTop > len(m.memory) {
+		return fmt.Errorf("%w: stack top %#x is outside the instruction region", ErrInvalidConfig, m.stackTop)
+	}
+	if m.stackSize < 0 || m.stackTop-m.stackSize < m.dataSize {
+		return fmt.Errorf("%w: stack size %d overlaps the data region", ErrInvalidConfig, m.stackSize)
+	}
+	if m.entry < m.dataSize || m.entry >= len(m.memory) {
+		return fmt.Errorf("%w: entry point %#x is outside the instruction region", ErrInvalidConfig, m.entry)
+	}
+	if m.console != nil && ConsoleAddr >= m.dataSize {
+		return fmt.Errorf("%w: console address %#x is outside the data region", ErrInvalidConfig, ConsoleAddr)
+	}
+	if m.hasVectors && (m.vectors < 0 || m.vectors+NumIRQs*m.addrSize() > len(m.memory)) {
+		return fmt.Errorf("%w: vector table at %#x runs past the end of memory", ErrInvalidConfig, m.vectors)
+	}
+	if m.hasRandom && RandomAddr >= m.dataSize {
+		return fmt.Errorf("%w: random number generator address %#x is outside the data region", ErrInvalidConfig, RandomAddr)
+	}
+	if m.hasScreen && (m.screen < 0 || m.screen+ScreenSize > m.dataSize) {
+		return fmt.Errorf("%w: screen at %#x is outside the data region", ErrInvalidConfig, m.screen)
+	}
+	if m.keyboard != nil && KeyboardAddr >= m.dataSize {
+		return fmt.Errorf("%w: keyboard address %#x is outside the data region", ErrInvalidConfig, KeyboardAddr)
+	}
+	if err := m.bus.validate(len(m.memory)); err != nil {
+		return err
+	}
+	return m.memoryMap.validate(len(m.memory))
+}
+
+// Fetch and decode the instruction at the PC, checking that it and its
+// register operands are valid
+func (m *Machine) decode() (*OpInfo, []int, error) {
+	position := m.pc
+	if position >= len(m.memory) {
+		return nil, nil, fmt.Errorf("%w: pc %#x", ErrOutOfBounds, position)
+	}
+	if err := m.checkAccess(position, PermExecute); err != nil {
+		return nil, nil, err
+	}
+	op := m.lookupOp(m.memory[position])
+	if op == nil && m.pastEnd(position) {
+		return nil, nil, fmt.Errorf("%w: no instructions left at pc %#x", ErrFellOffEnd, position)
+	}
+	if op == nil {
+		return nil, nil, fmt.Errorf("%w %#x at pc %#x", ErrIllegalOpcode, m.memory[position], position)
+	}
+	width := m.width(op)
+	if position+width > len(m.memory) {
+		return nil, nil, fmt.Errorf("%w: %s at pc %#x runs past end of memory", ErrOutOfBounds, op.Mnemonic, position)
+	}
+	for addr := position + 1; addr < position+width; addr++ {
+		if err := m.checkAccess(addr, PermExecute); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	args := m.operands[:len(op.Operands)]
+	at := position + 1
+	for i, kind := range op.Operands {
+		if m.wide && kind == AddrOperand {
+			args[i] = int(m.memory[at]) | int(m.memory[at+1])<<8
+			at += 2
+			continue
+		}
+		args[i] = int(m.memory[at])
+		at++
+		if kind == RegOperand && (args[i] == 0 || args[i] > MaxRegister) {
+			return nil, nil, fmt.Errorf("%w r%d at pc %#x", ErrBadRegister, args[i], position)
+		}
+	}
+	return op, args, nil
+}
+
+// Whether everything from addr to the end of memory is zeroed, as it
+// is after the last instruction of an assembled program
+func (m *Machine) pastEnd(addr int) bool {
+	for _, b := range m.memory[addr:] {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Read a byte of data for the instruction at the PC
+func (m *Machine) load(addr int) (byte, error) {
+	if addr >= len(m.memory) {
+		return 0, fmt.Errorf("%w: address %#x at pc %#x", ErrOutOfBounds, addr, m.pc)
+	}
+	if err := m.checkAccess(addr, PermRead); err != nil {
+		return 0, err
+	}
+	val, err := m.read(addr)
+	if err != nil {
+		return 0, fmt.Errorf("%w (load from %#x at pc %#x)", err, addr, m.pc)
+	}
+	return val, nil
+}
+
+// Read a byte over the bus, counting and logging it as the program's
+// loads are, but without the checks they make, for devices like DMA
+func (m *Machine) read(addr int) (byte, error) {
+	if addr < 0 || addr >= len(m.memory) {
+		return 0, fmt.Errorf("%w: address %#x", ErrOutOfBounds, addr)
+	}
+	m.logAccess(addr, false)
+	unlock := m.lockDevice(addr)
+	val, err := m.bus.Load(addr)
+	unlock()
+	return val, err
+}
+
+// Write a byte over the bus, as read reads one. The write is recorded
+// for history and tracing like a store.
+func (m *Machine) write(addr int, val byte) error {
+	if addr < 0 || addr >= len(m.memory) {
+		return fmt.Errorf("%w: address %#x", ErrOutOfBounds, addr)
+	}
+	m.logAccess(addr, true)
+	unlock := m.lockDevice(addr)
+	err := m.bus.Store(addr, val)
+	unlock()
+	m.invalidate(addr)
+	return err
+}
+
+// Peek returns the byte at addr as a load would see it, for debuggers,
+// but without changing anything: it isn't checked against the memory
+// map, counted or logged, and a device attached there is only asked
+// for the byte if it is a Peeker, so peeking at the keyboard doesn't
+// take a key. For any other device, Peek returns the memory under it.
+func (m *Machine) Peek(addr int) (byte, error) {
+	if addr < 0 || addr >= len(m.memory) {
+		return 0, fmt.Errorf("%w: address %#x", ErrOutOfBounds, addr)
+	}
+	unlock := m.lockDevice(addr)
+	defer unlock()
+	if val, ok := m.bus.peek(addr); ok {
+		return val, nil
+	}
+	return m.memory[addr], nil
+}
+
+// Poke stores val at addr over the bus as Peek loads, so that a
+// debugger can patch the program. Any instruction decoded from addr is
+// decoded again when it next runs.
+func (m *Machine) Poke(addr int, val byte) error {
+	if addr < 0 || addr >= len(m.memory) {
+		return fmt.Errorf("%w: address %#x", ErrOutOfBounds, addr)
+	}
+	unlock := m.lockDevice(addr)
+	err := m.bus.Store(addr, val)
+	unlock()
+	m.invalidate(addr)
+	return err
+}
+
+func (m *Machine) logAccess(addr int, isWrite bool) {
+	if isWrite {
+		m.counters.Writes++
+	} else {
+		m.counters.Reads++
+	}
+	if m.accessLog != nil {
+		m.accessLog.Accesses = append(m.accessLog.Accesses, MemoryAccess{addr, isWrite, m.pc})
+	}
+	if m.accessHook != nil {
+		m.accessHook(MemoryAccess{addr, isWrite, m.pc})
+	}
+	if m.history != nil && isWrite {
+		m.recordWrite(addr)
+	}
+	if m.jsonTrace != nil && isWrite {
+		m.traceWrites = append(m.traceWrites, MemoryDelta{Addr: addr, Old: m.memory[addr]})
+	}
+}
+
+// Push a byte onto the stack for the instruction at the PC
+func (m *Machine) push(val byte) error {
+	if m.sp <= m.stackTop-m.stackSize {
+		return fmt.Errorf("%w: %s at pc %#x", ErrStackOverflow, m.lookupOp(m.memory[m.pc]).Mnemonic, m.pc)
+	}
+	if err := m.checkAccess(m.sp-1, PermWrite); err != nil {
+		return err
+	}
+	m.sp--
+	m.logAccess(m.sp, true)
+	m.memory[m.sp] = val
+	m.invalidate(m.sp)
+	return nil
+}
+
+// Push an address, high byte first with wide addresses, so that the
+// low byte ends up on top
+func (m *Machine) pushAddr(addr int) error {
+	if m.wide {
+		if err := m.push(byte(addr >> 8)); err != nil {
+			return err
+		}
+	}
+	return m.push(byte(addr))
+}
+
+// Pop an address pushed by pushAddr
+func (m *Machine) popAddr() (int, error) {
+	low, err := m.pop()
+	if err != nil {
+		return 0, err
+	}
+	addr := int(low)
+	if m.wide {
+		high, err := m.pop()
+		if err != nil {
+			return 0, err
+		}
+		addr |= int(high) << 8
+	}
+	return addr, nil
+}
+
+// Pop a byte off the stack for the instruction at the PC
+func (m *Machine) pop() (byte, error) {
+	if m.sp >= m.stackTop {
+		return 0, fmt.Errorf("%w: %s at pc %#x", ErrStackUnderflow, m.lookupOp(m.memory[m.pc]).Mnemonic, m.pc)
+	}
+	if err := m.checkAccess(m.sp, PermRead); err != nil {
+		return 0, err
+	}
+	val := m.memory[m.sp]
+	m.logAccess(m.sp, false)
+	m.sp++
+	return val, nil
+}
+
+// Write a byte of data for the instruction at the PC
+func (m *Machine) store(addr int, val byte) error {
+	if err := m.checkStore(addr); err != nil {
+		return err
+	}
+	if err := m.write(addr, val); err != nil {
+		return fmt.Errorf("%w (store to %#x at pc %#x)", err, addr, m.pc)
+	}
+	return nil
+}
+
+// Check that the instruction at the PC may store to addr
+func (m *Machine) checkStore(addr int) error {
+	if addr >= len(m.memory) {
+		return fmt.Errorf("%w: address %#x at pc %#x", ErrOutOfBounds, addr, m.pc)
+	}
+	if m.memoryMap != nil {
+		return m.checkAccess(addr, PermWrite)
+	}
+	if addr >= m.dataSize {
+		return fmt.Errorf("%w: store to instruction region %#x at pc %#x", ErrReadOnlyWrite, addr, m.pc)
+	}
+	return nil
+}
+
+// Add two bytes, setting the flags from the sum. Carry is unsigned
+// overflow, and overflow is signed overflow: both operands had the same
+// sign and the sum has the other.
+func (m *Machine) add(a, b byte) byte {
+	sum := a + b
+	m.setFlags(sum, sum < a, (a^sum)&(b^sum)&0x80 != 0)
+	return sum
+}
+
+// Subtract b from a, setting the flags from the difference. Carry means
+// the subtraction borrowed, and overflow that the operands had different
+// signs and the difference doesn't have the sign of a.
+func (m *Machine) sub(a, b byte) byte {
+	diff := a - b
+	m.setFlags(diff, a < b, (a^b)&(a^diff)&0x80 != 0)
+	return diff
+}
+
+// Add two bytes and the carry flag, setting the flags as add does, so
+// that adding the low bytes of two numbers and then each higher byte in
+// turn adds them whole
+func (m *Machine) addCarry(a, b byte) byte {
+	c := m.flags & FlagCarry >> 1
+	sum := a + b + c
+	m.setFlags(sum, int(a)+int(b)+int(c) > 0xff, (a^sum)&(b^sum)&0x80 != 0)
+	return sum
+}
+
+// Subtract a byte and the carry flag, as a borrow, from another,
+// setting the flags as sub does
+func (m *Machine) subBorrow(a, b byte) byte {
+	c := m.flags & FlagCarry >> 1
+	diff := a - b - c
+	m.setFlags(diff, int(a) < int(b)+int(c), (a^b)&(a^diff)&0x80 != 0)
+	return diff
+}
+
+func (m *Machine) setFlags(result byte, carry, overflow bool) {
+	m.flags = 0
+	if result == 0 {
+		m.flags |= FlagZero
+	}
+	if carry {
+		m.flags |= FlagCarry
+	}
+	if overflow {
+		m.flags |= FlagOverflow
+	}
+	if result&0x80 != 0 {
+		m.flags |= FlagNegative
+	}
+}
+
+// Whether the last subtraction or comparison found a < b, treating both
+// as two's complement. The sign of the difference tells, unless it
+// overflowed.
+func (m *Machine) signedLess() bool {
+	return (m.flags&FlagNegative != 0) != (m.flags&FlagOverflow != 0)
+}
+
+// Execute a single instruction
+func (m *Machine) execute() error {
+	op, next, jumped, err := m.executeOp()
+	if err != nil || jumped {
+		return err
+	}
+
+	// every other instruction falls through to the next one, which
+	// must still be in memory
+	if next >= len(m.memory) {
+		return fmt.Errorf("%w: %s at pc %#x is the last instruction in memory", ErrFellOffEnd, op.Mnemonic, m.pc)
+	}
+	if op.Flow == FlowBranch {
+		m.counters.BranchesNotTaken++
+	}
+	m.pc = next
+	return nil
+}
+
+// Decode the instruction at the PC and run its handler, returning the
+// address of the instruction after it and whether the handler moved the
+// PC itself
+func (m *Machine) executeOp() (*OpInfo, int, bool, error) {
+	if m.dispatchMode == PredecodedDispatch {
+		d, err := m.predecoded()
+		if err != nil {
+			return nil, 0, false, err
+		}
+		jumped, err := d.h(m, d.args, d.next)
+		return d.op, d.next, jumped, err
+	}
+
+	op, args, err := m.decode()
+	if err != nil {
+		return nil, 0, false, err
+	}
+	next := m.next(op)
+	var jumped bool
+	switch {
+	case handlers[op.Code] == nil:
+		jumped, err = m.executeCustom(op.Code, args)
+	case m.dispatchMode == TableDispatch:
+		jumped, err = handlers[op.Code](m, args, next)
+	default:
+		jumped, err = switchExecute(m, op.Code, args, next)
+	}
+	return op, next, jumped, err
+}
+
+// The address of the instruction after op, which is at the PC
+func (m *Machine) next(op *OpInfo) int {
+	return m.pc + m.width(op)
+}
+
+// The encoded size of op in bytes, including the opcode
+func (m *Machine) width(op *OpInfo) int {
+	if !m.wide {
+		return len(op.Operands) + 1
+	}
+	if w := wideWidths[op.Code]; w != 0 {
+		return w
+	}
+	return op.WideWidth()
+}
+
+// Move the PC by offset from the instruction after a branch. Every
+// branch offset is a signed byte, reaching from 128 bytes back to 127
+// forward, and the PC wraps around at the end of the address space.
+// With byte addresses that means a branch can reach any address.
+func (m *Machine) branch(next, offset int) {
+	m.counters.BranchesTaken++
+	m.pc = m.branchTarget(next, offset)
+}
+
+func (m *Machine) branchTarget(next, offset int) int {
+	target := next + int(int8(offset))
+	if m.wide {
+		return target & 0xffff
+	}
+	return int(byte(target))
+}
+
+// Compute runs the program stored in memory to completion using the
+// default layout. It is shorthand for NewMachine(memory).Run().
+func Compute(memory []byte) error {
+	return NewMachine(memory).Run()
+}
+
+// Like Compute, but panicking if the program faults
+func compute(memory []byte) {
+	if err := Compute(memory); err != nil {
+		panic(err)
 	}
 }
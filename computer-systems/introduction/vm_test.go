@@ -1,6 +1,8 @@
 package vm
 
 import (
+	"encoding/binary"
+	"errors"
 	"os"
 	"strconv"
 	"strings"
@@ -123,10 +125,503 @@ halt`,
 	},
 }
 
+var extensionTests = []vmTest{
+	// Load an immediate without going through the data region
+	{
+		name: "LoadImm",
+		asm: `
+li r1 42
+addi r1 3
+store r1 0
+halt`,
+		cases: []vmCase{{0, 0, 45}},
+	},
+	// Copy a register, then make sure the copy is independent
+	{
+		name: "Mov",
+		asm: `
+load r1 1
+mov r2 r1
+addi r1 1
+store r2 0
+halt`,
+		cases: []vmCase{
+			{7, 0, 7},
+			{255, 0, 255},
+		},
+	},
+	// Quadruple x by calling a routine that doubles it twice
+	{
+		name: "Call",
+		asm: `
+load r1 1
+call 17
+store r1 0
+halt
+call 22
+call 22
+ret
+add r1 r1
+ret`,
+		cases: []vmCase{
+			{1, 0, 4},
+			{3, 0, 12},
+			{64, 0, 0},
+		},
+	},
+	// Bitwise operations on the two inputs
+	{
+		name: "And",
+		asm: `
+load r1 1
+load r2 2
+and r1 r2
+store r1 0
+halt`,
+		cases: []vmCase{
+			{0xf0, 0x3c, 0x30},
+			{0xff, 0x00, 0x00},
+		},
+	},
+	{
+		name: "Or",
+		asm: `
+load r1 1
+load r2 2
+or r1 r2
+store r1 0
+halt`,
+		cases: []vmCase{
+			{0xf0, 0x3c, 0xfc},
+			{0x00, 0x00, 0x00},
+		},
+	},
+	{
+		name: "Xor",
+		asm: `
+load r1 1
+load r2 2
+xor r1 r2
+store r1 0
+halt`,
+		cases: []vmCase{
+			{0xf0, 0x3c, 0xcc},
+			{0x5a, 0x5a, 0x00},
+		},
+	},
+	{
+		name: "Not",
+		asm: `
+load r1 1
+not r1
+store r1 0
+halt`,
+		cases: []vmCase{
+			{0xf0, 0, 0x0f},
+			{0x00, 0, 0xff},
+		},
+	},
+	// Shift x by y, left then right, and by immediates. Shifts of 8 or
+	// more leave nothing behind.
+	{
+		name: "Shl",
+		asm: `
+load r1 1
+load r2 2
+shl r1 r2
+store r1 0
+halt`,
+		cases: []vmCase{
+			{0x81, 1, 0x02},
+			{0x01, 7, 0x80},
+			{0xff, 8, 0x00},
+			{0xff, 200, 0x00},
+		},
+	},
+	{
+		name: "Shr",
+		asm: `
+load r1 1
+load r2 2
+shr r1 r2
+store r1 0
+halt`,
+		cases: []vmCase{
+			{0x81, 1, 0x40},
+			{0x80, 7, 0x01},
+			{0xff, 8, 0x00},
+		},
+	},
+	// Pack the low nibbles of x and y into one byte, x's on top
+	{
+		name: "ShiftImmediate",
+		asm: `
+load r1 1
+load r2 2
+shli r1 4
+shli r2 4
+shri r2 4
+or r1 r2
+store r1 0
+halt`,
+		cases: []vmCase{
+			{0x0a, 0x0b, 0xab},
+			{0xf3, 0x4c, 0x3c},
+		},
+	},
+	// Sum the data bytes from address 1 up to 3, through a pointer
+	{
+		name: "LoadR",
+		asm: `
+li r3 1
+li r4 3
+loadr r1 r3
+add r2 r1
+addi r3 1
+blt r3 r4 243
+store r2 0
+halt`,
+		cases: []vmCase{
+			{1, 2, 3},
+			{200, 100, 44},
+		},
+	},
+	// Add x and y through address 3, by pointer
+	{
+		name: "StoreR",
+		asm: `
+li r3 3
+load r1 1
+load r2 2
+add r1 r2
+storer r1 r3
+load r4 3
+store r4 0
+halt`,
+		cases: []vmCase{
+			{2, 3, 5},
+			{200, 100, 44},
+		},
+	},
+	// Increment the word with x as its low byte and y as its high byte,
+	// and store the new high byte
+	{
+		name: "Word",
+		asm: `
+loadw r1 r2 1
+addi r1 1
+bc 2
+jump 22
+addi r2 1
+storew r1 r2 3
+load r3 4
+store r3 0
+halt`,
+		cases: []vmCase{
+			{0x10, 0x05, 0x05},
+			{0xff, 0x05, 0x06},
+			{0xff, 0xff, 0x00},
+		},
+	},
+	// Multiplication keeps the low byte of the product
+	{
+		name: "Mul",
+		asm: `
+load r1 1
+load r2 2
+mul r1 r2
+store r1 0
+halt`,
+		cases: []vmCase{
+			{6, 7, 42},
+			{16, 16, 0},
+			{255, 255, 1},
+		},
+	},
+	// The widening form also keeps the high byte, stored here
+	{
+		name: "Mulw",
+		asm: `
+load r1 1
+load r2 2
+mulw r1 r2
+store r2 0
+halt`,
+		cases: []vmCase{
+			{6, 7, 0},
+			{16, 16, 1},
+			{255, 255, 254},
+		},
+	},
+	{
+		name: "Div",
+		asm: `
+load r1 1
+load r2 2
+div r1 r2
+store r1 0
+halt`,
+		cases: []vmCase{
+			{42, 6, 7},
+			{43, 6, 7},
+			{255, 1, 255},
+		},
+	},
+	{
+		name: "Mod",
+		asm: `
+load r1 1
+load r2 2
+mod r1 r2
+store r1 0
+halt`,
+		cases: []vmCase{
+			{42, 6, 0},
+			{43, 6, 1},
+			{5, 255, 5},
+		},
+	},
+	// Store 1 if x is nonzero, without disturbing it
+	{
+		name: "Bne",
+		asm: `
+load r1 1
+li r2 0
+bne r1 1
+halt
+li r2 1
+store r2 0
+halt`,
+		cases: []vmCase{
+			{0, 0, 0},
+			{1, 0, 1},
+			{255, 0, 1},
+		},
+	},
+	// Store the smaller of x and y, as unsigned bytes
+	{
+		name: "Blt",
+		asm: `
+load r1 1
+load r2 2
+blt r1 r2 4
+store r2 0
+halt
+store r1 0
+halt`,
+		cases: []vmCase{
+			{3, 7, 3},
+			{7, 3, 3},
+			{5, 5, 5},
+			{0x7f, 0x80, 0x7f},
+			{0, 255, 0},
+		},
+	},
+	// Multiply by repeated addition while the counter r3 stays below y
+	{
+		name: "Bge",
+		asm: `
+load r1 1
+load r2 2
+li r3 0
+li r4 0
+bge r3 r2 8
+add r4 r1
+addi r3 1
+jump 20
+store r4 0
+halt`,
+		cases: []vmCase{
+			{3, 0, 0},
+			{3, 4, 12},
+			{7, 6, 42},
+			{100, 3, 44},
+		},
+	},
+	// Count down from x to zero, storing how many times the loop ran
+	// plus y
+	{
+		name: "Bnz",
+		asm: `
+load r1 1
+load r2 2
+addi r2 1
+subi r1 1
+bnz 248
+store r2 0
+halt`,
+		cases: []vmCase{
+			{1, 0, 1},
+			{5, 10, 15},
+			{0, 0, 0},
+		},
+	},
+	// Add x to the 16 bit value 0x01ff using the carry from the low
+	// bytes, storing the high byte of the sum
+	{
+		name: "AddWithCarry",
+		asm: `
+load r1 1
+li r2 255
+li r3 1
+add r2 r1
+bc 4
+store r3 0
+halt
+addi r3 1
+store r3 0
+halt`,
+		cases: []vmCase{
+			{1, 0, 2},
+			{0, 0, 1},
+			{255, 0, 2},
+		},
+	},
+	// Compare as signed bytes, storing 1 if x < y and 0 otherwise
+	{
+		name: "SignedLess",
+		asm: `
+load r1 1
+load r2 2
+li r3 0
+cmp r1 r2
+bslt 4
+store r3 0
+halt
+li r3 1
+store r3 0
+halt`,
+		cases: []vmCase{
+			{3, 7, 1},
+			{7, 3, 0},
+			{7, 7, 0},
+			{0xff, 0x00, 1}, // -1 < 0
+			{0x00, 0xff, 0},
+			{0x80, 0x7f, 1}, // -128 < 127, though the difference overflows
+			{0x7f, 0x80, 0},
+			{0x80, 0x80, 0},
+		},
+	},
+	// Also signed, storing 1 if x >= y and 0 otherwise
+	{
+		name: "SignedGreaterOrEqual",
+		asm: `
+load r1 1
+load r2 2
+li r3 0
+cmp r1 r2
+bsge 4
+store r3 0
+halt
+li r3 1
+store r3 0
+halt`,
+		cases: []vmCase{
+			{7, 3, 1},
+			{3, 7, 0},
+			{0x7f, 0x80, 1},
+			{0x80, 0x7f, 0},
+			{0x80, 0x80, 1},
+		},
+	},
+	// Add x and y as signed bytes, storing 1 if the sum overflowed
+	// and 2 if it is negative
+	{
+		name: "SignedAdd",
+		asm: `
+load r1 1
+load r2 2
+li r3 0
+add r1 r2
+bv 6
+bn 11
+store r3 0
+halt
+li r3 1
+store r3 0
+halt
+li r3 2
+store r3 0
+halt`,
+		cases: []vmCase{
+			{1, 2, 0},
+			{0x7f, 0x01, 1},
+			{0x80, 0xff, 1},
+			{0x7f, 0x80, 2},
+			{0xff, 0xff, 2},
+		},
+	},
+	// Compare without clobbering, storing 0 if x == y, 1 if x < y and
+	// 2 if x > y
+	{
+		name: "Cmp",
+		asm: `
+load r1 1
+load r2 2
+cmp r1 r2
+bz 16
+bc 7
+li r1 2
+store r1 0
+halt
+li r1 1
+store r1 0
+halt
+li r1 0
+store r1 0
+halt`,
+		cases: []vmCase{
+			{7, 7, 0},
+			{3, 7, 1},
+			{7, 3, 2},
+			{0, 255, 1},
+			{255, 0, 2},
+		},
+	},
+	// Add with carry adds in the carry from adding the same operands
+	{
+		name: "Adc",
+		asm: `
+load r1 1
+load r2 2
+mov r3 r1
+add r3 r2
+adc r1 r2
+store r1 0
+halt`,
+		cases: []vmCase{
+			{1, 2, 3},
+			{200, 100, 45},
+			{255, 1, 1},
+		},
+	},
+	// and subtract with borrow takes away the borrow from subtracting
+	// them
+	{
+		name: "Sbb",
+		asm: `
+load r1 1
+load r2 2
+mov r3 r1
+sub r3 r2
+sbb r1 r2
+store r1 0
+halt`,
+		cases: []vmCase{
+			{7, 3, 4},
+			{3, 7, 251},
+			{0, 255, 0},
+		},
+	},
+}
+
 func TestCompute(t *testing.T) {
 	for _, test := range mainTests {
 		t.Run(test.name, func(t *testing.T) { testCompute(t, test) })
 	}
+	for _, test := range extensionTests {
+		t.Run(test.name, func(t *testing.T) { testCompute(t, test) })
+	}
 	if os.Getenv("STRETCH") != "true" {
 		println("Skipping stretch goal tests. Run `STRETCH=true go test` to include them.")
 	} else {
@@ -136,9 +631,637 @@ func TestCompute(t *testing.T) {
 	}
 }
 
+func TestDataSize(t *testing.T) {
+	// With a 16 byte data region the program starts at offset 16, and
+	// stores anywhere below that are legal
+	memory := make([]byte, 256)
+	copy(memory[16:], assemble(`
+load r1 9
+addi r1 1
+store r1 15
+halt`))
+	memory[9] = 41
+
+	if err := NewMachine(memory, WithDataSize(16)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if memory[15] != 42 {
+		t.Fatalf("Expected 42 at offset 15, got %d", memory[15])
+	}
+}
+
+func TestEntryPoint(t *testing.T) {
+	// Skip over a constant kept just after the data region
+	memory := make([]byte, 256)
+	memory[8] = 42
+	copy(memory[9:], assemble(`
+load r1 8
+store r1 0
+halt`))
+
+	if err := NewMachine(memory, WithEntryPoint(9)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if memory[0] != 42 {
+		t.Fatalf("Expected 42 at offset 0, got %d", memory[0])
+	}
+
+	for _, pc := range []int{0, 7, 256} {
+		if err := NewMachine(memory, WithEntryPoint(pc)).Run(); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("Expected entry point %d to be rejected, got %v", pc, err)
+		}
+	}
+	if err := NewMachine(memory, WithDataSize(16), WithEntryPoint(9)).Run(); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Expected entry point in a larger data region to be rejected, got %v", err)
+	}
+}
+
+func TestWideAddresses(t *testing.T) {
+	// Addresses are two bytes, little-endian, so the program can read
+	// and write beyond the first 256 bytes, jump far ahead and branch
+	// back by a negative offset
+	memory := make([]byte, 1024)
+	copy(memory[0x200:], []byte{
+		Load, 1, 0x00, 0x01, // 200: load r1 0x100
+		Addi, 1, 1, // 204: addi r1 1
+		Store, 1, 0xff, 0x01, // 207: store r1 0x1ff
+		Jump, 0x00, 0x03, // 20b: jump 0x300
+	})
+	memory[0x2f0] = Halt
+	copy(memory[0x300:], []byte{
+		LoadCode, 3, 0x00, 0x02, // 300: loadcode r3 0x200
+		Store, 3, 0xfe, 0x01, // 304: store r3 0x1fe
+		Beqz, 2, 256 - (0x30b - 0x2f0), // 308: beqz r2 back to 2f0
+	})
+	memory[0x100] = 41
+
+	m := NewMachine(memory, WithWideAddresses(), WithDataSize(0x200))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if memory[0x1ff] != 42 || memory[0x1fe] != Load {
+		t.Fatalf("Expected 42 and %#x, got %d and %#x", Load, memory[0x1ff], memory[0x1fe])
+	}
+	if m.PC() != 0x2f0 {
+		t.Fatalf("Expected to halt at 0x2f0, halted at %#x", m.PC())
+	}
+
+	// calls push a two byte return address
+	memory = make([]byte, 1024)
+	copy(memory[8:], []byte{Call, 0x00, 0x03, Halt})
+	copy(memory[0x300:], []byte{Ret})
+	m = NewMachine(memory, WithWideAddresses())
+	for i := 0; i < 2; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 && (m.SP() != 1022 || memory[1022] != 11 || memory[1023] != 0) {
+			t.Fatalf("Expected return address 0x000b on the stack, got sp %d", m.SP())
+		}
+	}
+	if m.PC() != 11 || m.SP() != 1024 {
+		t.Fatalf("Expected to return to 11 with an empty stack, got pc %d and sp %d", m.PC(), m.SP())
+	}
+
+	if err := NewMachine(make([]byte, MaxWideMemorySize+1), WithWideAddresses()).Run(); !errors.Is(err, ErrImageSize) {
+		t.Fatalf("Expected an oversized wide image to be rejected, got %v", err)
+	}
+	if err := NewMachine(make([]byte, 1024)).Run(); !errors.Is(err, ErrImageSize) {
+		t.Fatalf("Expected a 1024 byte image to need wide addresses, got %v", err)
+	}
+}
+
+func TestCmpFlags(t *testing.T) {
+	tests := []struct {
+		a, b  byte
+		flags byte
+	}{
+		{5, 5, FlagZero},
+		{3, 5, FlagCarry | FlagNegative},
+		{5, 3, 0},
+		{0x80, 0x01, FlagOverflow},
+		{0x7f, 0xff, FlagCarry | FlagOverflow | FlagNegative},
+	}
+	for _, test := range tests {
+		memory := make([]byte, 256)
+		copy(memory[8:], assemble("li r1 "+strconv.Itoa(int(test.a))+"\nli r2 "+strconv.Itoa(int(test.b))+"\ncmp r1 r2\nhalt"))
+		m := NewMachine(memory)
+		if err := m.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if m.flags != test.flags {
+			t.Errorf("cmp %#x %#x: expected flags %04b, got %04b", test.a, test.b, test.flags, m.flags)
+		}
+		if m.registers[1] != test.a || m.registers[2] != test.b {
+			t.Errorf("cmp %#x %#x clobbered its operands", test.a, test.b)
+		}
+	}
+}
+
+func TestArithmeticFlags(t *testing.T) {
+	tests := []struct {
+		asm   string
+		flags byte
+	}{
+		{"li r1 1\nli r2 2\nadd r1 r2", 0},
+		{"li r1 255\nli r2 1\nadd r1 r2", FlagZero | FlagCarry},
+		{"li r1 255\nli r2 2\nadd r1 r2", FlagCarry},
+		{"li r1 127\nli r2 1\nadd r1 r2", FlagOverflow | FlagNegative},
+		{"li r1 128\nli r2 128\nadd r1 r2", FlagZero | FlagCarry | FlagOverflow},
+		{"li r1 255\nli r2 128\nadd r1 r2", FlagCarry | FlagOverflow},
+		{"li r1 128\nli r2 1\nsub r1 r2", FlagOverflow},
+		{"li r1 127\nli r2 255\nsub r1 r2", FlagCarry | FlagOverflow | FlagNegative},
+		{"li r1 127\naddi r1 1", FlagOverflow | FlagNegative},
+		{"li r1 128\nsubi r1 1", FlagOverflow},
+		{"li r1 128\nsubi r1 128", FlagZero},
+		{"li r1 3\nli r2 3\nsub r1 r2", FlagZero},
+		{"li r1 3\nli r2 4\nsub r1 r2", FlagCarry | FlagNegative},
+		{"li r1 200\naddi r1 100", FlagCarry},
+		{"li r1 1\nsubi r1 1", FlagZero},
+		{"li r1 0\nsubi r1 1", FlagCarry | FlagNegative},
+		{"li r1 255\nli r2 1\nadd r1 r2\nli r1 0\nli r2 0\nadc r1 r2", 0},
+		{"li r1 255\nli r2 1\nadd r1 r2\nli r1 255\nli r2 0\nadc r1 r2", FlagZero | FlagCarry},
+		{"li r1 255\nli r2 1\nadd r1 r2\nli r1 127\nli r2 0\nadc r1 r2", FlagOverflow | FlagNegative},
+		{"li r1 0\nsubi r1 1\nli r1 1\nli r2 0\nsbb r1 r2", FlagZero},
+		{"li r1 0\nsubi r1 1\nli r1 0\nli r2 255\nsbb r1 r2", FlagCarry | FlagZero},
+		{"li r1 0\nsubi r1 1\nli r1 128\nli r2 0\nsbb r1 r2", FlagOverflow},
+		// other instructions leave the flags alone
+		{"li r1 0\nsubi r1 1\nli r2 0\nmov r1 r2", FlagCarry | FlagNegative},
+	}
+	for _, test := range tests {
+		memory := make([]byte, 256)
+		copy(memory[8:], assemble(test.asm+"\nhalt"))
+		m := NewMachine(memory)
+		if err := m.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if m.flags != test.flags {
+			t.Errorf("%q: expected flags %04b, got %04b", test.asm, test.flags, m.flags)
+		}
+	}
+}
+
+// Add the 16 bit numbers x, at 0 and 1, and y, at 2 and 3, each low
+// byte first, into r1 and r2, storing the sum at 4 and 5 and the carry
+// out at 6
+const add16 = `
+load r1 0
+load r2 1
+load r3 2
+load r4 3
+add r1 r3
+adc r2 r4
+li r5 0
+li r6 0
+adc r5 r6
+store r1 4
+store r2 5
+store r5 6
+halt`
+
+func TestAdd16(t *testing.T) {
+	for _, test := range []struct {
+		x, y uint16
+	}{
+		{1, 2},
+		{0x00ff, 0x0001},
+		{0x1234, 0x0fcd},
+		{0xffff, 0x0001},
+		{0x8000, 0x8000},
+		{0xffff, 0xffff},
+	} {
+		memory := make([]byte, 256)
+		copy(memory[8:], assemble(add16))
+		binary.LittleEndian.PutUint16(memory[0:], test.x)
+		binary.LittleEndian.PutUint16(memory[2:], test.y)
+		if err := NewMachine(memory).Run(); err != nil {
+			t.Fatal(err)
+		}
+		sum := uint32(test.x) + uint32(test.y)
+		if got := binary.LittleEndian.Uint16(memory[4:]); got != uint16(sum) || memory[6] != byte(sum>>16) {
+			t.Errorf("%#x + %#x: expected %#x carrying %d, got %#x carrying %d", test.x, test.y, uint16(sum), sum>>16, got, memory[6])
+		}
+	}
+}
+
+func TestRegisters(t *testing.T) {
+	// Give every register a distinct value, then sum them into r1
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+li r1 1
+li r2 2
+li r3 3
+li r4 4
+li r5 5
+li r6 6
+li r7 7
+add r1 r2
+add r1 r3
+add r1 r4
+add r1 r5
+add r1 r6
+add r1 r7
+store r1 0
+halt`))
+	m := NewMachine(memory)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if memory[0] != 28 {
+		t.Fatalf("Expected 1 + ... + 7 = 28, got %d", memory[0])
+	}
+	for n := 2; n <= MaxRegister; n++ {
+		if m.Register(n) != byte(n) {
+			t.Fatalf("Expected r%d = %d, got %d", n, n, m.Register(n))
+		}
+	}
+
+	memory = make([]byte, 256)
+	copy(memory[8:], []byte{Add, 1, MaxRegister + 1, Halt})
+	if err := NewMachine(memory).Run(); !errors.Is(err, ErrBadRegister) {
+		t.Fatalf("Expected r%d to be rejected, got %v", MaxRegister+1, err)
+	}
+}
+
+func TestStack(t *testing.T) {
+	// Reverse three values by pushing them and popping them back
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+li r1 1
+li r2 2
+li r3 3
+push r1
+push r2
+push r3
+pop r1
+pop r2
+pop r3
+halt`))
+	m := NewMachine(memory)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if m.R1() != 3 || m.R2() != 2 || m.Register(3) != 1 {
+		t.Fatalf("Expected r1, r2, r3 = 3, 2, 1, got %d, %d, %d", m.R1(), m.R2(), m.Register(3))
+	}
+	if m.SP() != 256 {
+		t.Fatalf("Expected an empty stack, got sp %#x", m.SP())
+	}
+
+	// a loop that pushes until the stack overflows
+	memory = make([]byte, 256)
+	copy(memory[8:], assemble("push r1\njump 8"))
+	m = NewMachine(memory, WithStackSize(4))
+	if err := m.Run(); !errors.Is(err, ErrStackOverflow) {
+		t.Fatalf("Expected ErrStackOverflow, got %v", err)
+	}
+	if m.SP() != 252 {
+		t.Fatalf("Expected the stack to fill the top 4 bytes, got sp %#x", m.SP())
+	}
+
+	memory = make([]byte, 256)
+	copy(memory[8:], assemble("pop r1\nhalt"))
+	if err := Compute(memory); !errors.Is(err, ErrStackUnderflow) {
+		t.Fatalf("Expected ErrStackUnderflow, got %v", err)
+	}
+
+	if err := NewMachine(memory, WithStackSize(250)).Run(); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Expected a stack overlapping the data region to be rejected, got %v", err)
+	}
+}
+
+func TestSwap(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+li r1 3
+li r2 7
+swap r1 r2
+swap r1 r1
+halt`))
+	m := NewMachine(memory)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if m.registers[1] != 7 || m.registers[2] != 3 {
+		t.Fatalf("Expected r1 = 7 and r2 = 3, got %d and %d", m.registers[1], m.registers[2])
+	}
+	if m.PC() != 20 {
+		t.Fatalf("Expected each swap to advance the pc by 3, halted at %d", m.PC())
+	}
+}
+
+func TestCycles(t *testing.T) {
+	// Count down from the value at 0, reading the cycle counter on each
+	// iteration and branching to a failure store at 37 unless it went
+	// up. The last reading is stored at 2.
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+li r2 0
+cycles r1
+cmp r1 r2
+bz 19
+bc 17
+mov r2 r1
+load r1 0
+subi r1 1
+store r1 0
+beqz r1 9
+jump 11
+li r1 1
+store r1 1
+halt
+store r2 2
+halt`))
+	memory[0] = 3
+
+	if err := NewMachine(memory).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if memory[1] != 0 {
+		t.Fatalf("Cycle count did not increase between iterations")
+	}
+	// li runs first, then each of the first two iterations takes ten
+	// instructions
+	if memory[2] != 21 {
+		t.Fatalf("Expected a final cycle count of 21, got %d", memory[2])
+	}
+}
+
+func TestExit(t *testing.T) {
+	// Exit with the input as the status
+	for _, status := range []byte{0, 1, 255} {
+		memory := make([]byte, 256)
+		copy(memory[8:], assemble("load r1 1\nexit r1\nli r1 9"))
+		memory[1] = status
+		m := NewMachine(memory)
+		err := m.Run()
+		if !m.Halted() || m.ExitCode() != status {
+			t.Errorf("Expected to halt with status %d, got %d", status, m.ExitCode())
+		}
+		if status == 0 {
+			if err != nil {
+				t.Errorf("Expected exit 0 to succeed, got %v", err)
+			}
+			continue
+		}
+		var exit *ExitError
+		if !errors.Is(err, ErrExitStatus) || !errors.As(err, &exit) || exit.Code != status || exit.PC != 11 {
+			t.Errorf("Expected exit status %d at pc 11, got %v", status, err)
+		}
+	}
+}
+
+func TestCycleLimit(t *testing.T) {
+	// Count up forever
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble("addi r1 1\njump 8"))
+	m := NewMachine(memory, WithCycleLimit(101))
+	if err := m.Run(); !errors.Is(err, ErrCycleLimit) {
+		t.Fatalf("Expected ErrCycleLimit, got %v", err)
+	}
+	if m.Cycles() != 101 || m.PC() != 11 || m.Register(1) != 51 {
+		t.Errorf("Expected to stop before the 51st jump, got pc %#x and r1 = %d after %d instructions", m.PC(), m.Register(1), m.Cycles())
+	}
+}
+
+func TestAssert(t *testing.T) {
+	// Assert that the two inputs sum to 42
+	program := assemble(`
+load r1 1
+load r2 2
+add r1 r2
+subi r1 42
+li r2 1
+beqz r1 3
+li r2 0
+assert r2
+halt`)
+	run := func(x, y byte) error {
+		memory := make([]byte, 256)
+		copy(memory[8:], program)
+		memory[1], memory[2] = x, y
+		return NewMachine(memory).Run()
+	}
+
+	if err := run(40, 2); err != nil {
+		t.Fatalf("Expected 40 + 2 to pass, got %v", err)
+	}
+
+	err := run(40, 3)
+	if !errors.Is(err, ErrAssertionFailed) {
+		t.Fatalf("Expected ErrAssertionFailed, got %v", err)
+	}
+	var assertion *AssertionError
+	if !errors.As(err, &assertion) || assertion.PC != 29 || assertion.Reg != 2 {
+		t.Fatalf("Expected the failure to be reported for r2 at pc 29, got %v", err)
+	}
+
+	// a passing assert halts, without running the store after it
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble("li r1 1\nassert r1\nstore r1 0\nhalt"))
+	m := NewMachine(memory)
+	if err := m.Run(); err != nil || !m.Halted() || memory[0] != 0 || m.PC() != 11 {
+		t.Fatalf("Expected to halt at the assert at pc 11, got pc %d, %d stored (%v)", m.PC(), memory[0], err)
+	}
+}
+
+func TestLoadCode(t *testing.T) {
+	// Read the program's own first opcode, and the operand naming it
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+loadcode r1 8
+store r1 0
+loadcode r2 10
+store r2 1
+halt`))
+	if err := NewMachine(memory).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if memory[0] != LoadCode || memory[1] != 8 {
+		t.Fatalf("Expected %#x and 8, got %#x and %d", LoadCode, memory[0], memory[1])
+	}
+
+	memory = make([]byte, 256)
+	copy(memory[8:], assemble("loadcode r1 7\nhalt"))
+	if err := NewMachine(memory).Run(); !errors.Is(err, ErrOutOfBounds) {
+		t.Fatalf("Expected loadcode from the data region to fail, got %v", err)
+	}
+}
+
+func TestStep(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+li r1 5
+li r2 3
+sub r1 r2
+halt`))
+	m := NewMachine(memory)
+
+	expected := []struct {
+		pc     int
+		r1, r2 byte
+	}{
+		{11, 5, 0},
+		{14, 5, 3},
+		{17, 2, 3},
+		{17, 2, 3},
+	}
+	for i, state := range expected {
+		if err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if m.PC() != state.pc || m.R1() != state.r1 || m.R2() != state.r2 {
+			t.Fatalf("After step %d: expected pc %d, r1 %d, r2 %d, got %d, %d, %d",
+				i+1, state.pc, state.r1, state.r2, m.PC(), m.R1(), m.R2())
+		}
+	}
+	if !m.Halted() {
+		t.Fatalf("Expected the machine to have halted")
+	}
+	// stepping a halted machine does nothing
+	if err := m.Step(); err != nil || m.PC() != 17 {
+		t.Fatalf("Expected stepping after halt to do nothing, got pc %d and %v", m.PC(), err)
+	}
+
+	if err := NewMachine(make([]byte, 100)).Step(); !errors.Is(err, ErrImageSize) {
+		t.Fatalf("Expected Step to validate the image, got %v", err)
+	}
+}
+
+func TestComputeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+		err     error
+	}{
+		{"IllegalOpcode", []byte{0xee, Halt}, ErrIllegalOpcode},
+		{"ReadOnly", assemble("store r1 8\nhalt"), ErrReadOnlyWrite},
+		{"ReadOnlyWord", assemble("storew r1 r2 7\nhalt"), ErrReadOnlyWrite},
+		{"OutOfBoundsWord", assemble("loadw r1 r2 255\nhalt"), ErrOutOfBounds},
+		{"ReadOnlyIndirect", assemble("li r2 8\nstorer r1 r2\nhalt"), ErrReadOnlyWrite},
+		{"OutOfBounds", []byte{Jump, 254, Halt}, ErrOutOfBounds},
+		{"DivideByZero", assemble("li r1 1\ndiv r1 r2\nhalt"), ErrDivideByZero},
+		{"ModByZero", assemble("li r1 1\nmod r1 r2\nhalt"), ErrDivideByZero},
+	}
+	for _, test := range tests {
+		memory := make([]byte, 256)
+		copy(memory[8:], test.program)
+		memory[254] = Load // its address operand would be past the end
+		if err := Compute(memory); !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestFaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  string
+		err      error
+		pc       int
+		op       string
+		operands []int
+	}{
+		{"ReadOnly", "li r1 1\nstore r1 200", ErrReadOnlyWrite, 11, "store", []int{1, 200}},
+		{"StackUnderflow", "pop r3", ErrStackUnderflow, 8, "pop", []int{3}},
+		{"BadRegister", "mov r1 r1\nadd r1 r8", ErrBadRegister, 11, "add", nil},
+		{"Assert", "assert r4", ErrAssertionFailed, 8, "assert", []int{4}},
+	}
+	for _, test := range tests {
+		memory := make([]byte, 256)
+		copy(memory[8:], assemble(test.program))
+		err := NewMachine(memory).Run()
+		var fault *Fault
+		if !errors.Is(err, test.err) || !errors.As(err, &fault) {
+			t.Errorf("%s: expected a fault matching %v, got %v", test.name, test.err, err)
+			continue
+		}
+		if fault.PC != test.pc || fault.Op == nil || fault.Op.Mnemonic != test.op {
+			t.Errorf("%s: expected the fault at %s at pc %d, got %+v", test.name, test.op, test.pc, fault)
+		}
+		if len(fault.Operands) != len(test.operands) {
+			t.Errorf("%s: expected operands %v, got %v", test.name, test.operands, fault.Operands)
+			continue
+		}
+		for i := range test.operands {
+			if fault.Operands[i] != test.operands[i] {
+				t.Errorf("%s: expected operands %v, got %v", test.name, test.operands, fault.Operands)
+			}
+		}
+	}
+
+	// an illegal opcode has no instruction to report
+	memory := make([]byte, 256)
+	memory[8] = 0xee
+	var fault *Fault
+	if err := NewMachine(memory).Run(); !errors.As(err, &fault) || fault.PC != 8 || fault.Op != nil || fault.Operands != nil {
+		t.Errorf("Expected an illegal opcode fault at pc 8, got %v", err)
+	}
+
+	// and ErrReadOnlyWrite still matches by its old name
+	copy(memory[8:], assemble("store r1 8"))
+	if err := NewMachine(memory).Run(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly to match a store to the instructions, got %v", err)
+	}
+}
+
+func TestStoreToInstructions(t *testing.T) {
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+store r1 8
+halt`))
+
+	if err := NewMachine(memory).Run(); err == nil {
+		t.Fatalf("Expected store into instruction region to fail")
+	}
+}
+
+func TestFellOffEnd(t *testing.T) {
+	// a program that forgets to halt runs into the zeroed memory after it
+	memory := make([]byte, 256)
+	copy(memory[8:], assemble(`
+load r1 1
+addi r1 1
+store r1 0`))
+	if err := NewMachine(memory).Run(); !errors.Is(err, ErrFellOffEnd) {
+		t.Fatalf("Expected ErrFellOffEnd, got %v", err)
+	}
+	if memory[0] != 1 {
+		t.Fatalf("Expected the program to run before falling off, got %d", memory[0])
+	}
+
+	// one that fills memory runs past the last byte instead of wrapping
+	copy(memory[8:], []byte{Jump, 253})
+	copy(memory[253:], []byte{Mov, 1, 2})
+	if err := NewMachine(memory).Run(); !errors.Is(err, ErrFellOffEnd) {
+		t.Fatalf("Expected ErrFellOffEnd, got %v", err)
+	}
+
+	// garbage in the middle of a program is still an illegal opcode
+	memory = make([]byte, 256)
+	copy(memory[8:], []byte{0, Halt})
+	if err := NewMachine(memory).Run(); !errors.Is(err, ErrIllegalOpcode) {
+		t.Fatalf("Expected ErrIllegalOpcode, got %v", err)
+	}
+}
+
+func TestImageSize(t *testing.T) {
+	for _, size := range []int{100, 300} {
+		memory := make([]byte, size)
+		copy(memory[8:], assemble("halt"))
+		if err := NewMachine(memory).Run(); !errors.Is(err, ErrImageSize) {
+			t.Errorf("Expected ErrImageSize for a %d byte image, got %v", size, err)
+		}
+	}
+}
+
 // Given some assembly code and test cases, construct a program
 // according to the required memory structure, and run in each
 // case through the virtual machine
+// Far more instructions than any of the test programs need, so that a
+// broken one fails rather than hanging
+const testCycleLimit = 100000
+
 func testCompute(t *testing.T, test vmTest) {
 	// assemble code and load into memory
 	memory := make([]byte, 256)
@@ -148,7 +1271,9 @@ func testCompute(t *testing.T, test vmTest) {
 		memory[1] = c.x
 		memory[2] = c.y
 
-		compute(memory)
+		if err := NewMachine(memory, WithCycleLimit(testCycleLimit)).Run(); err != nil {
+			t.Fatalf("f(%d, %d): %v", c.x, c.y, err)
+		}
 
 		actual := memory[0]
 		if actual != c.out {
@@ -164,6 +1289,11 @@ func reg(s string) (b byte) {
 	return map[string]byte{
 		"r1": 0x01,
 		"r2": 0x02,
+		"r3": 0x03,
+		"r4": 0x04,
+		"r5": 0x05,
+		"r6": 0x06,
+		"r7": 0x07,
 	}[s]
 }
 
@@ -206,8 +1336,53 @@ func assemble(asm string) []byte {
 		case "halt":
 			mc = append(mc, 0xff)
 		default:
-			panic("Invalid operation: " + parts[0])
+			// fall back on the opcode metadata for extensions
+			op, ok := LookupMnemonic(parts[0])
+			if !ok || len(parts)-1 != len(op.Operands) {
+				panic("Invalid operation: " + parts[0])
+			}
+			mc = append(mc, op.Code)
+			for i, kind := range op.Operands {
+				if kind == RegOperand {
+					mc = append(mc, reg(parts[i+1]))
+				} else {
+					mc = append(mc, imm(parts[i+1]))
+				}
+			}
 		}
 	}
 	return mc
 }
+
+// Nested countdown loops: the inner loop sums 1..x, and the outer loop
+// repeats it y times. With x = y = 255 this executes roughly a quarter
+// of a million instructions per run.
+const benchmarkAsm = `
+load r1 1
+beqz r1 8
+add r2 r1
+subi r1 1
+jump 11
+load r1 2
+beqz r1 8
+subi r1 1
+store r1 2
+jump 8
+store r2 0
+halt`
+
+// The default configuration, through Compute; see BenchmarkDispatch for
+// a comparison of the dispatch strategies.
+func BenchmarkCompute(b *testing.B) {
+	program := make([]byte, 256)
+	copy(program[8:], assemble(benchmarkAsm))
+	program[1] = 255
+	program[2] = 255
+
+	memory := make([]byte, 256)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		copy(memory, program)
+		compute(memory)
+	}
+}